@@ -0,0 +1,178 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"leaderboard/encryption"
+	"leaderboard/models"
+	"leaderboard/services"
+	"leaderboard/snapshot"
+)
+
+// Backups are snapshot files named after the UTC time they were taken, so
+// they sort oldest first and carry the snapshot checksum.
+const (
+	filePrefix = "backup-"
+	fileSuffix = ".snap"
+	timeLayout = "20060102T150405Z"
+)
+
+// ErrNotEmpty is returned when restoring into a service that has users
+var ErrNotEmpty = errors.New("backup: restore needs an empty leaderboard")
+
+// Retention is how many backups survive a prune: the newest in each of the
+// last Hourly hours and each of the last Daily days. The newest backup is
+// always kept.
+type Retention struct {
+	Hourly int
+	Daily  int
+}
+
+// DefaultRetention keeps a day of hourly backups and a week of daily ones
+var DefaultRetention = Retention{Hourly: 24, Daily: 7}
+
+// Info describes one backup file
+type Info struct {
+	Path  string
+	Time  time.Time
+	Seq   uint64
+	Users uint64
+	Size  int64
+}
+
+// FileName is the name of a backup taken at t
+func FileName(t time.Time) string {
+	return filePrefix + t.UTC().Format(timeLayout) + fileSuffix
+}
+
+// Create writes a backup of the service to dir, taken at now and encrypted
+// when keys is set. Writers only wait while the board is copied in memory.
+func Create(dir string, keys *encryption.Keyring, service *services.LeaderboardService, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, FileName(now))
+	version, users := service.Copy()
+	if err := snapshot.Write(path, keys, version, len(users), snapshot.Users(users)); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	return path, nil
+}
+
+// List returns the backups in dir, newest first. The checksum is not
+// verified; Restore does that. Seq and Users stay zero for backups keys
+// cannot decrypt.
+func List(dir string, keys *encryption.Keyring) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Info
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		t, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+
+		info := Info{Path: filepath.Join(dir, name), Time: t}
+		if fi, err := e.Info(); err == nil {
+			info.Size = fi.Size()
+		}
+		if header, err := snapshot.ReadHeader(info.Path, keys); err == nil {
+			info.Seq, info.Users = header.Seq, header.Count
+		}
+		backups = append(backups, info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// Prune deletes the backups in dir that retention does not keep and returns
+// the paths it removed
+func Prune(dir string, retention Retention) ([]string, error) {
+	backups, err := List(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := retained(backups, retention)
+	var removed []string
+	for _, b := range backups {
+		if keep[b.Path] {
+			continue
+		}
+		if err := os.Remove(b.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.Path)
+	}
+	return removed, nil
+}
+
+// retained picks the newest backup of each hour and day, for as many of the
+// most recent hours and days as retention allows. backups is newest first.
+func retained(backups []Info, retention Retention) map[string]bool {
+	keep := make(map[string]bool)
+	if len(backups) > 0 {
+		keep[backups[0].Path] = true
+	}
+
+	slots := func(n int, period func(time.Time) time.Time) {
+		seen := make(map[time.Time]bool)
+		for _, b := range backups {
+			if len(seen) >= n {
+				return
+			}
+			if p := period(b.Time); !seen[p] {
+				seen[p] = true
+				keep[b.Path] = true
+			}
+		}
+	}
+	slots(retention.Hourly, func(t time.Time) time.Time { return t.Truncate(time.Hour) })
+	slots(retention.Daily, func(t time.Time) time.Time {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	})
+	return keep
+}
+
+// Restore rebuilds an empty service from the backup at path and returns the
+// version it was taken at. The whole file is verified first, so a damaged
+// backup, or one keys cannot decrypt, is refused before anything is loaded.
+func Restore(path string, keys *encryption.Keyring, service *services.LeaderboardService) (uint64, error) {
+	if service.GetUserCount() != 0 {
+		return 0, ErrNotEmpty
+	}
+	if _, err := snapshot.Verify(path, keys); err != nil {
+		if encryption.IsKeyError(err) {
+			return 0, fmt.Errorf("backup %s cannot be decrypted: %w", filepath.Base(path), err)
+		}
+		return 0, fmt.Errorf("backup %s is damaged: %w", filepath.Base(path), err)
+	}
+
+	seq, err := snapshot.Read(path, keys, func(u models.User) error {
+		return service.Apply(models.Mutation{Op: models.MutationAdd, ID: u.ID, Username: u.Username, Rating: u.Rating})
+	})
+	if err != nil {
+		return 0, err
+	}
+	service.SetVersion(seq)
+	return seq, nil
+}
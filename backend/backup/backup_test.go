@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"errors"
+	"leaderboard/encryption"
+	"leaderboard/models"
+	"leaderboard/services"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sampleService() *services.LeaderboardService {
+	service := services.NewLeaderboardService()
+	service.AddUser(&models.User{ID: "1", Username: "alice", Rating: 3000})
+	service.AddUser(&models.User{ID: "2", Username: "bob", Rating: 1500})
+	service.UpdateRating("bob", 1700)
+	return service
+}
+
+func TestCreateListRestore(t *testing.T) {
+	dir := t.TempDir()
+	taken := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+
+	path, err := Create(dir, nil, sampleService(), taken)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if filepath.Base(path) != "backup-20261018T093000Z.snap" {
+		t.Errorf("Unexpected backup name %s", path)
+	}
+
+	backups, err := List(dir, nil)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %+v %v", backups, err)
+	}
+	if b := backups[0]; !b.Time.Equal(taken) || b.Seq != 3 || b.Users != 2 || b.Size == 0 {
+		t.Errorf("Unexpected backup info %+v", b)
+	}
+
+	restored := services.NewLeaderboardService()
+	seq, err := Restore(path, nil, restored)
+	if err != nil || seq != 3 || restored.Version() != 3 {
+		t.Fatalf("Expected restore at version 3, got %d %d %v", seq, restored.Version(), err)
+	}
+	if u, _ := restored.GetUserRank("bob"); u == nil || u.Rating != 1700 || u.Rank != 2 {
+		t.Errorf("Expected bob at 1700 ranked 2, got %+v", u)
+	}
+
+	// Restoring over existing users is refused
+	if _, err := Restore(path, nil, restored); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("Expected ErrNotEmpty, got %v", err)
+	}
+}
+
+func TestRestoreRefusesDamage(t *testing.T) {
+	dir := t.TempDir()
+	path, _ := Create(dir, nil, sampleService(), time.Now())
+
+	data, _ := os.ReadFile(path)
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	restored := services.NewLeaderboardService()
+	if _, err := Restore(path, nil, restored); err == nil {
+		t.Fatal("Expected damaged backup to be refused")
+	}
+	if restored.GetUserCount() != 0 {
+		t.Errorf("Nothing should be loaded from a damaged backup, got %d users", restored.GetUserCount())
+	}
+
+	if _, err := Restore(filepath.Join(dir, "missing.snap"), nil, restored); err == nil {
+		t.Error("Expected error for missing backup")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	service := sampleService()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// Every 20 minutes for three days
+	for i := 0; i < 3*24*3; i++ {
+		if _, err := Create(dir, nil, service, now.Add(-time.Duration(i)*20*time.Minute)); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	removed, err := Prune(dir, Retention{Hourly: 6, Daily: 3})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	backups, _ := List(dir, nil)
+	if len(removed)+len(backups) != 3*24*3 {
+		t.Errorf("Expected removed and kept to add up, got %d and %d", len(removed), len(backups))
+	}
+
+	// Newest of each of the last 6 hours, plus the newest of the two earlier days
+	want := []time.Time{
+		now,
+		now.Add(-20 * time.Minute),
+		now.Add(-80 * time.Minute),
+		now.Add(-140 * time.Minute),
+		now.Add(-200 * time.Minute),
+		now.Add(-260 * time.Minute),
+		time.Date(2026, 10, 17, 23, 40, 0, 0, time.UTC),
+		time.Date(2026, 10, 16, 23, 40, 0, 0, time.UTC),
+	}
+	if len(backups) != len(want) {
+		t.Fatalf("Expected %d backups kept, got %d", len(want), len(backups))
+	}
+	for i := range want {
+		if !backups[i].Time.Equal(want[i]) {
+			t.Errorf("Backup %d: got %s want %s", i, backups[i].Time, want[i])
+		}
+	}
+
+	// Zero retention still keeps the newest
+	Prune(dir, Retention{})
+	if backups, _ := List(dir, nil); len(backups) != 1 || !backups[0].Time.Equal(now) {
+		t.Errorf("Expected only the newest backup, got %+v", backups)
+	}
+}
+
+func TestListIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "backup-notatime.snap"), nil, 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644)
+
+	if backups, err := List(dir, nil); err != nil || len(backups) != 0 {
+		t.Errorf("Expected no backups, got %+v %v", backups, err)
+	}
+	if backups, err := List(filepath.Join(dir, "missing"), nil); err != nil || backups != nil {
+		t.Errorf("Expected nothing for a missing dir, got %+v %v", backups, err)
+	}
+}
+
+func TestEncryptedBackup(t *testing.T) {
+	dir := t.TempDir()
+	entry, _ := encryption.GenerateKey("k1")
+	keys, _ := encryption.ParseKeyring(entry)
+
+	path, err := Create(dir, keys, sampleService(), time.Now())
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if backups, err := List(dir, keys); err != nil || len(backups) != 1 || backups[0].Users != 2 {
+		t.Errorf("Expected 1 backup of 2 users, got %+v %v", backups, err)
+	}
+
+	// Without the right key the backup is refused as undecryptable, not damaged
+	other, _ := encryption.GenerateKey("k1")
+	wrong, _ := encryption.ParseKeyring(other)
+	restored := services.NewLeaderboardService()
+	if _, err := Restore(path, wrong, restored); !errors.Is(err, encryption.ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+
+	if seq, err := Restore(path, keys, restored); err != nil || seq != 3 || restored.GetUserCount() != 2 {
+		t.Errorf("Expected 2 users restored at seq 3, got %d %d %v", seq, restored.GetUserCount(), err)
+	}
+}
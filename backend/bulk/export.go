@@ -0,0 +1,65 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// ContentType is the MIME type of a format
+func (f Format) ContentType() string {
+	if f == NDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// Export streams every user with rank, rating and tier to w in rank order,
+// from one consistent snapshot of the board. It returns how many users were
+// written and the board version they came from.
+func Export(w io.Writer, service *services.LeaderboardService, format Format) (int, uint64, error) {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	record := make([]string, 4)
+
+	var write func(models.UserWithRank) error
+	switch format {
+	case CSV:
+		if err := cw.Write([]string{"rank", "username", "rating", "tier"}); err != nil {
+			return 0, 0, err
+		}
+		write = func(u models.UserWithRank) error {
+			record[0] = strconv.Itoa(u.Rank)
+			record[1] = u.Username
+			record[2] = strconv.Itoa(u.Rating)
+			record[3] = u.Tier
+			return cw.Write(record)
+		}
+	case NDJSON:
+		write = func(u models.UserWithRank) error { return enc.Encode(u) }
+	default:
+		return 0, 0, fmt.Errorf("unknown format %q", format)
+	}
+
+	count := 0
+	version, err := service.ExportUsers(func(u models.UserWithRank) error {
+		count++
+		return write(u)
+	})
+	if err != nil {
+		return count, version, err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return count, version, err
+	}
+	return count, version, bw.Flush()
+}
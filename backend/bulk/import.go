@@ -0,0 +1,259 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// Format is a bulk file encoding
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// ParseFormat reads "csv" or "ndjson"
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "csv":
+		return CSV, nil
+	case "ndjson", "jsonl":
+		return NDJSON, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected csv or ndjson", s)
+	}
+}
+
+// Mode decides what happens to rows whose username already exists
+type Mode string
+
+const (
+	// Insert rejects existing usernames
+	Insert Mode = "insert"
+	// Upsert updates the rating of existing usernames
+	Upsert Mode = "upsert"
+)
+
+// ParseMode reads "insert" or "upsert", defaulting to insert
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "insert", "":
+		return Insert, nil
+	case "upsert":
+		return Upsert, nil
+	default:
+		return "", fmt.Errorf("unknown mode %q, expected insert or upsert", s)
+	}
+}
+
+const (
+	DefaultBatchSize = 10000
+	DefaultMaxErrors = 1000
+)
+
+type ImportOptions struct {
+	Format    Format
+	Mode      Mode
+	BatchSize int // rows applied per lock, defaults to DefaultBatchSize
+	MaxErrors int // line errors kept in the result, defaults to DefaultMaxErrors
+}
+
+// row is a parsed user and the line it came from
+type row struct {
+	line int
+	user models.User
+}
+
+// Import streams users from r into the service. Bad rows are reported by line
+// and skipped; the rest are applied in batches of opts.BatchSize. The error
+// is only set when r itself cannot be read.
+func Import(r io.Reader, service *services.LeaderboardService, opts ImportOptions) (*models.ImportResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.MaxErrors <= 0 {
+		opts.MaxErrors = DefaultMaxErrors
+	}
+
+	result := &models.ImportResult{Errors: []models.ImportError{}}
+	fail := func(line int, err error) {
+		result.Failed++
+		if len(result.Errors) < opts.MaxErrors {
+			result.Errors = append(result.Errors, models.ImportError{Line: line, Error: err.Error()})
+		}
+	}
+
+	batch := make([]row, 0, opts.BatchSize)
+	users := make([]models.User, 0, opts.BatchSize)
+	flush := func() {
+		users = users[:0]
+		for _, rw := range batch {
+			users = append(users, rw.user)
+		}
+		added, updated, errs := service.ImportUsers(users, opts.Mode == Upsert)
+		result.Added += added
+		result.Updated += updated
+		for i, err := range errs {
+			if err != nil {
+				fail(batch[i].line, err)
+			}
+		}
+		batch = batch[:0]
+	}
+
+	emit := func(line int, user models.User, err error) {
+		result.Rows++
+		if err == nil {
+			err = validate(user)
+		}
+		if err != nil {
+			fail(line, err)
+			return
+		}
+		batch = append(batch, row{line: line, user: user})
+		if len(batch) == opts.BatchSize {
+			flush()
+		}
+	}
+
+	var err error
+	switch opts.Format {
+	case CSV:
+		err = readCSV(r, emit)
+	case NDJSON:
+		err = readNDJSON(r, emit)
+	default:
+		return nil, fmt.Errorf("unknown format %q", opts.Format)
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+
+	// Batch failures are found after later parse failures
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Line < result.Errors[j].Line })
+	return result, err
+}
+
+func validate(user models.User) error {
+	if user.Username == "" {
+		return errors.New("username is required")
+	}
+	if user.Rating < 100 || user.Rating > 5000 {
+		return &services.InvalidRatingError{Rating: user.Rating}
+	}
+	return nil
+}
+
+// readCSV parses id,username,rating rows. A header row naming the columns
+// may reorder them; without one the columns are taken in that order.
+func readCSV(r io.Reader, emit func(line int, user models.User, err error)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+
+	cols := map[string]int{"id": 0, "username": 1, "rating": 2}
+	first := true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			emit(parseErr.Line, models.User{}, parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+
+		if first {
+			first = false
+			if header, ok := csvHeader(record); ok {
+				cols = header
+				continue
+			}
+		}
+
+		user, err := parseCSVRecord(record, cols)
+		emit(line, user, err)
+	}
+}
+
+// csvHeader maps column names to positions if record is a header row
+func csvHeader(record []string) (map[string]int, bool) {
+	cols := make(map[string]int, len(record))
+	for i, name := range record {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["username"]; !ok {
+		return nil, false
+	}
+	if _, ok := cols["rating"]; !ok {
+		return nil, false
+	}
+	return cols, true
+}
+
+func parseCSVRecord(record []string, cols map[string]int) (models.User, error) {
+	field := func(name string) (string, bool) {
+		i, ok := cols[name]
+		if !ok || i >= len(record) {
+			return "", false
+		}
+		return strings.TrimSpace(record[i]), true
+	}
+
+	var user models.User
+	user.ID, _ = field("id")
+	user.Username, _ = field("username")
+	ratingStr, ok := field("rating")
+	if !ok {
+		return user, fmt.Errorf("expected %d columns, got %d", len(cols), len(record))
+	}
+	rating, err := strconv.Atoi(ratingStr)
+	if err != nil {
+		return user, fmt.Errorf("invalid rating %q", ratingStr)
+	}
+	user.Rating = rating
+	return user, nil
+}
+
+// maxLineSize bounds a single NDJSON line
+const maxLineSize = 1 << 20
+
+// readNDJSON parses one JSON user object per line, skipping blank lines
+func readNDJSON(r io.Reader, emit func(line int, user models.User, err error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var user models.User
+		if err := json.Unmarshal([]byte(text), &user); err != nil {
+			emit(line, user, fmt.Errorf("invalid JSON: %w", err))
+			continue
+		}
+		emit(line, user, nil)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,123 @@
+package bulk
+
+import (
+	"fmt"
+	"leaderboard/services"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	service := services.NewLeaderboardService()
+	input := strings.Join([]string{
+		"username,rating,id",
+		"alice,1500,id1",
+		"bob,notanumber,id2",
+		"carol,9000,id3",
+		",1200,id4",
+		`"dave",  4000,id5`,
+		"alice,1600,id6",
+		"erin",
+	}, "\n")
+
+	result, err := Import(strings.NewReader(input), service, ImportOptions{Format: CSV, Mode: Insert, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Rows != 7 || result.Added != 2 || result.Failed != 5 {
+		t.Errorf("Expected 7 rows, 2 added, 5 failed, got %+v", result)
+	}
+
+	wantLines := []int{3, 4, 5, 7, 8}
+	if len(result.Errors) != len(wantLines) {
+		t.Fatalf("Expected %d line errors, got %+v", len(wantLines), result.Errors)
+	}
+	for i, line := range wantLines {
+		if result.Errors[i].Line != line {
+			t.Errorf("Error %d: expected line %d, got %+v", i, line, result.Errors[i])
+		}
+	}
+
+	if u, _ := service.GetUserRank("dave"); u == nil || u.Rating != 4000 {
+		t.Errorf("Expected dave at 4000, got %+v", u)
+	}
+	if u, _ := service.GetUserRank("alice"); u == nil || u.Rating != 1500 {
+		t.Errorf("Insert mode should keep alice at 1500, got %+v", u)
+	}
+}
+
+func TestImportCSVWithoutHeader(t *testing.T) {
+	service := services.NewLeaderboardService()
+	result, err := Import(strings.NewReader("id1,alice,1500\nid2,bob,1200\n"), service, ImportOptions{Format: CSV})
+	if err != nil || result.Added != 2 {
+		t.Fatalf("Expected 2 added, got %+v %v", result, err)
+	}
+}
+
+func TestImportNDJSONUpsert(t *testing.T) {
+	service := services.NewLeaderboardService()
+	Import(strings.NewReader(`{"id":"id1","username":"alice","rating":1500}`), service, ImportOptions{Format: NDJSON})
+
+	input := strings.Join([]string{
+		`{"id":"id1","username":"alice","rating":2500}`,
+		``,
+		`{"id":"id2","username":"bob","rating":1200}`,
+		`{"username": broken`,
+		`{"id":"id3","username":"carol","rating":50}`,
+	}, "\n")
+	result, err := Import(strings.NewReader(input), service, ImportOptions{Format: NDJSON, Mode: Upsert})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Rows != 4 || result.Added != 1 || result.Updated != 1 || result.Failed != 2 {
+		t.Errorf("Expected 4 rows, 1 added, 1 updated, 2 failed, got %+v", result)
+	}
+	if len(result.Errors) != 2 || result.Errors[0].Line != 4 || result.Errors[1].Line != 5 {
+		t.Errorf("Expected errors on lines 4 and 5, got %+v", result.Errors)
+	}
+	if u, _ := service.GetUserRank("alice"); u == nil || u.Rating != 2500 {
+		t.Errorf("Upsert should move alice to 2500, got %+v", u)
+	}
+}
+
+func TestImportMaxErrors(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&b, "id%d,user%d,1\n", i, i)
+	}
+	result, _ := Import(strings.NewReader(b.String()), services.NewLeaderboardService(), ImportOptions{Format: CSV, MaxErrors: 10})
+	if result.Failed != 50 || len(result.Errors) != 10 {
+		t.Errorf("Expected 50 failures with 10 reported, got %d and %d", result.Failed, len(result.Errors))
+	}
+}
+
+func TestParseFormatAndMode(t *testing.T) {
+	if f, err := ParseFormat("JSONL"); err != nil || f != NDJSON {
+		t.Errorf("Expected ndjson, got %q %v", f, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	if m, err := ParseMode(""); err != nil || m != Insert {
+		t.Errorf("Expected insert by default, got %q %v", m, err)
+	}
+	if _, err := ParseMode("replace"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+func BenchmarkImportCSV(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&sb, "id%d,user%d,%d\n", i, i, 100+i%4901)
+	}
+	input := sb.String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service := services.NewLeaderboardService()
+		if _, err := Import(strings.NewReader(input), service, ImportOptions{Format: CSV}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"leaderboard/backup"
+	"leaderboard/bulk"
+	"leaderboard/encryption"
+	"leaderboard/services"
+	"leaderboard/store"
+)
+
+// runCommand runs one of the command line tools against the store configured
+// by STORE and DATA_DIR
+func runCommand(name string, args []string) error {
+	switch name {
+	case "import":
+		return importCommand(args)
+	case "export":
+		return exportCommand(args)
+	case "backup":
+		return backupCommand(args)
+	case "keygen":
+		return keygenCommand(args)
+	default:
+		return fmt.Errorf("unknown command %q, expected import, export, backup or keygen", name)
+	}
+}
+
+// openFileStore loads the configured file store into a fresh service. The
+// in-memory store would lose whatever a command does, so it is refused.
+// The store is locked while open, so commands fail with store.ErrLocked
+// rather than write under a running server.
+func openFileStore() (*services.LeaderboardService, store.Store, error) {
+	keys, err := loadKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := openStore(os.Getenv("STORE"), os.Getenv("DATA_DIR"), os.Getenv("WAL_SYNC"), os.Getenv("WAL_SYNC_INTERVAL"), keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := st.(*store.File); !ok {
+		return nil, nil, errors.New("commands need a durable store, set STORE=file")
+	}
+
+	service := services.NewLeaderboardService()
+	if err := loadStore(service, st); err != nil {
+		st.Close()
+		return nil, nil, err
+	}
+	return service, st, nil
+}
+
+// importCommand loads users from a CSV or NDJSON file into the store:
+//
+//	leaderboard import [-format csv|ndjson] [-mode insert|upsert] [-batch N] FILE
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	formatStr := fs.String("format", "", "csv or ndjson, guessed from the file extension by default")
+	modeStr := fs.String("mode", "insert", "insert rejects existing usernames, upsert updates their rating")
+	batch := fs.Int("batch", bulk.DefaultBatchSize, "rows applied per lock")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [-format csv|ndjson] [-mode insert|upsert] [-batch N] FILE")
+	}
+	path := fs.Arg(0)
+
+	if *formatStr == "" {
+		*formatStr = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	format, err := bulk.ParseFormat(*formatStr)
+	if err != nil {
+		return err
+	}
+	mode, err := bulk.ParseMode(*modeStr)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	service, st, err := openFileStore()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	result, err := bulk.Import(f, service, bulk.ImportOptions{Format: format, Mode: mode, BatchSize: *batch})
+	if err != nil {
+		return err
+	}
+	for _, e := range result.Errors {
+		fmt.Printf("  line %d: %s\n", e.Line, e.Error)
+	}
+	if hidden := result.Failed - len(result.Errors); hidden > 0 {
+		fmt.Printf("  ... and %d more errors\n", hidden)
+	}
+	fmt.Printf("Imported %d rows from %s: %d added, %d updated, %d failed\n",
+		result.Rows, path, result.Added, result.Updated, result.Failed)
+
+	// Fold the import into a snapshot so the next boot need not replay it
+	return checkpoint(service, st)
+}
+
+// exportCommand writes the ranked board from the store to a CSV or NDJSON file:
+//
+//	leaderboard export [-format csv|ndjson] FILE
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatStr := fs.String("format", "", "csv or ndjson, guessed from the file extension by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: export [-format csv|ndjson] FILE")
+	}
+	path := fs.Arg(0)
+
+	if *formatStr == "" {
+		*formatStr = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	format, err := bulk.ParseFormat(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	service, st, err := openFileStore()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// Write beside the target and rename, so a failed export leaves no partial file
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	count, version, err := bulk.Export(f, service, format)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d users at version %d to %s\n", count, version, path)
+	return nil
+}
+
+// backupCommand manages backups of the store, kept in BACKUP_DIR or ./backups:
+//
+//	leaderboard backup create
+//	leaderboard backup list
+//	leaderboard backup restore FILE
+func backupCommand(args []string) error {
+	usage := errors.New("usage: backup create | backup list | backup restore FILE")
+	if len(args) == 0 {
+		return usage
+	}
+
+	dir := os.Getenv("BACKUP_DIR")
+	if dir == "" {
+		dir = "backups"
+	}
+	keys, err := loadKeys()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "create" && len(args) == 1:
+		_, retention, err := backupConfig()
+		if err != nil {
+			return err
+		}
+		service, st, err := openFileStore()
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+
+		path, err := backup.Create(dir, keys, service, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backed up %d users to %s\n", service.GetUserCount(), path)
+
+		removed, err := backup.Prune(dir, retention)
+		for _, path := range removed {
+			fmt.Printf("  Removed %s\n", path)
+		}
+		return err
+
+	case args[0] == "list" && len(args) == 1:
+		backups, err := backup.List(dir, keys)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			fmt.Printf("No backups in %s\n", dir)
+			return nil
+		}
+		fmt.Printf("%-36s %-20s %10s %10s %12s\n", "FILE", "TAKEN", "VERSION", "USERS", "BYTES")
+		for _, b := range backups {
+			fmt.Printf("%-36s %-20s %10d %10d %12d\n",
+				filepath.Base(b.Path), b.Time.Format(time.RFC3339), b.Seq, b.Users, b.Size)
+		}
+		return nil
+
+	case args[0] == "restore" && len(args) == 2:
+		return restoreBackup(dir, args[1], keys)
+
+	default:
+		return usage
+	}
+}
+
+// restoreBackup replaces the store's board with a backup. The restored board
+// is written as a snapshot newer than anything in the store, so records still
+// in the WAL are skipped and a crash part way leaves the old board intact.
+func restoreBackup(dir, name string, keys *encryption.Keyring) error {
+	path := name
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		path = filepath.Join(dir, name)
+	}
+
+	restored := services.NewLeaderboardService()
+	seq, err := backup.Restore(path, keys, restored)
+	if err != nil {
+		return err
+	}
+
+	current, st, err := openFileStore()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	restored.SetVersion(max(current.Version(), seq) + 1)
+	if err := checkpoint(restored, st); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d users from %s (version %d)\n", restored.GetUserCount(), path, seq)
+	return nil
+}
+
+// keygenCommand prints a new encryption key entry. To rotate, put it first
+// in ENCRYPTION_KEY or the key file and keep the old entries after it:
+//
+//	leaderboard keygen ID
+func keygenCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: keygen ID")
+	}
+	entry, err := encryption.GenerateKey(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(entry)
+	return nil
+}
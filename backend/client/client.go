@@ -0,0 +1,343 @@
+// Package client is a typed Go client for the leaderboard HTTP API. It
+// speaks the models package's types, so callers need not declare their own,
+// retries requests the server failed with a 5xx when that is safe, and pages
+// through long results with iterators.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"leaderboard/models"
+)
+
+// Defaults for a Client's retry fields
+const (
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+// Client calls one leaderboard server. The zero value is not usable; make
+// one with New and change its fields before the first call.
+type Client struct {
+	// BaseURL is the server, e.g. "http://localhost:5001"
+	BaseURL string
+
+	HTTPClient *http.Client
+
+	// Token is sent as a bearer token, which the server's admin routes
+	// such as Import need
+	Token string
+
+	// MaxRetries is how many times a request failing with a 5xx is sent
+	// again. Requests are retried only when their body can be sent again,
+	// and only when sending them twice does no harm: reads and
+	// UpdateUserScore, which sets an absolute rating.
+	MaxRetries int
+
+	// RetryUnsafe also retries UpdateScores and Import, which may then be
+	// applied twice when the server failed after making the change
+	RetryUnsafe bool
+
+	// Backoff is the wait before the first retry, doubling for each one up
+	// to MaxBackoff. A Retry-After header from the server, in seconds or as
+	// an HTTP date, overrides it.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// New returns a client for the server at baseURL with the default retries
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		MaxRetries: DefaultMaxRetries,
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// LeaderboardQuery selects a page of GET /v1/leaderboard. Zero fields are left
+// to the server's defaults.
+type LeaderboardQuery struct {
+	Limit  int
+	Offset int
+	// Cursor resumes after the last row of a previous page, and overrides
+	// Offset
+	Cursor string
+	// Tier only returns users in the named tier. Tier pages have no cursor.
+	Tier string
+	// Consistent pages read from a snapshot of the board taken on the
+	// first page
+	Consistent bool
+}
+
+func (q LeaderboardQuery) values() url.Values {
+	v := url.Values{}
+	setInt(v, "limit", q.Limit)
+	setInt(v, "offset", q.Offset)
+	setString(v, "cursor", q.Cursor)
+	setString(v, "tier", q.Tier)
+	if q.Consistent {
+		v.Set("consistent", "true")
+	}
+	return v
+}
+
+// TierChangesQuery selects events from GET /v1/events/tier-changes
+type TierChangesQuery struct {
+	// Username only returns events for one user
+	Username string
+	// Since only returns events with a greater ID
+	Since int64
+	Limit int
+}
+
+func (q TierChangesQuery) values() url.Values {
+	v := url.Values{}
+	setString(v, "username", q.Username)
+	if q.Since > 0 {
+		v.Set("since", strconv.FormatInt(q.Since, 10))
+	}
+	setInt(v, "limit", q.Limit)
+	return v
+}
+
+// SearchQuery is a GET /v1/search
+type SearchQuery struct {
+	// Prefix of the usernames to find
+	Prefix string
+	Limit  int
+	// Fuzzy also finds usernames a typo or two away from Prefix
+	Fuzzy bool
+}
+
+// UpdateResult is the reply to POST /v1/update-score
+type UpdateResult struct {
+	Message      string `json:"message"`
+	UpdatedUsers int    `json:"updated_users"`
+	TotalUsers   int    `json:"total_users"`
+}
+
+// ImportOptions are the query of POST /v1/admin/import
+type ImportOptions struct {
+	// Format is "csv" or "ndjson"
+	Format string
+	// Mode is "insert", the default, or "upsert"
+	Mode string
+}
+
+// Leaderboard returns one page of the leaderboard
+func (c *Client) Leaderboard(ctx context.Context, q LeaderboardQuery) (*models.LeaderboardResponse, error) {
+	var resp models.LeaderboardResponse
+	if err := c.getJSON(ctx, "/v1/leaderboard", q.values(), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// User returns a user with their rank
+func (c *Client) User(ctx context.Context, username string) (*models.UserWithRank, error) {
+	var user models.UserWithRank
+	if err := c.getJSON(ctx, "/v1/user/"+url.PathEscape(username), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Tiers returns the number of users in each tier
+func (c *Client) Tiers(ctx context.Context) ([]models.TierCount, error) {
+	var resp models.TiersResponse
+	if err := c.getJSON(ctx, "/v1/tiers", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tiers, nil
+}
+
+// TierChanges returns tier promotions and demotions, oldest first
+func (c *Client) TierChanges(ctx context.Context, q TierChangesQuery) ([]models.TierChangeEvent, error) {
+	var resp models.TierChangesResponse
+	if err := c.getJSON(ctx, "/v1/events/tier-changes", q.values(), &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// Search finds users by username prefix
+func (c *Client) Search(ctx context.Context, q SearchQuery) ([]models.UserWithRank, error) {
+	v := url.Values{"q": {q.Prefix}}
+	setInt(v, "limit", q.Limit)
+	if q.Fuzzy {
+		v.Set("fuzzy", "true")
+	}
+	var resp models.LeaderboardResponse
+	if err := c.getJSON(ctx, "/v1/search", v, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+// UpdateUserScore sets a user's rating and returns the user with their new
+// rank
+func (c *Client) UpdateUserScore(ctx context.Context, username string, rating int) (*models.UserWithRank, error) {
+	body, err := json.Marshal(map[string]interface{}{"username": username, "rating": rating})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		User *models.UserWithRank `json:"user"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/update-user-score", nil, "application/json", bytes.NewReader(body), true, &resp); err != nil {
+		return nil, err
+	}
+	if resp.User == nil {
+		return nil, errors.New("client: response has no user")
+	}
+	return resp.User, nil
+}
+
+// UpdateScores asks the server to give random users random ratings, as a
+// load simulation. It is only retried with RetryUnsafe.
+func (c *Client) UpdateScores(ctx context.Context) (*UpdateResult, error) {
+	var resp UpdateResult
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/update-score", nil, "", nil, c.RetryUnsafe, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Import streams users from r into the leaderboard, and needs Token. It is
+// only retried with RetryUnsafe, and then only for readers the standard
+// library can rewind, such as a *bytes.Reader.
+func (c *Client) Import(ctx context.Context, r io.Reader, opts ImportOptions) (*models.ImportResult, error) {
+	v := url.Values{}
+	setString(v, "format", opts.Format)
+	setString(v, "mode", opts.Mode)
+	var resp models.ImportResult
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/admin/import", v, "", r, c.RetryUnsafe, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Export streams the whole ranked leaderboard in format, "csv" or "ndjson".
+// The caller must close the stream.
+func (c *Client) Export(ctx context.Context, format string) (io.ReadCloser, error) {
+	v := url.Values{}
+	setString(v, "format", format)
+	resp, err := c.do(ctx, http.MethodGet, "/v1/export", v, "", nil, true)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	return c.doJSON(ctx, http.MethodGet, path, query, "", nil, true, out)
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader, retry bool, out interface{}) error {
+	resp, err := c.do(ctx, method, path, query, contentType, body, retry)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decoding %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// do sends a request, retrying on 5xx when retry is set, and returns a 2xx
+// response. Any other status is returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader, retry bool) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		apiErr := readError(method, path, resp)
+		canRetry := retry && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+		if resp.StatusCode < 500 || attempt >= c.MaxRetries || !canRetry {
+			return nil, apiErr
+		}
+		if err := sleep(ctx, c.wait(attempt, resp.Header.Get("Retry-After"))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait is the backoff before retry number attempt+1
+func (c *Client) wait(attempt int, retryAfter string) time.Duration {
+	if secs, err := strconv.Atoi(retryAfter); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(retryAfter); err == nil {
+		return max(time.Until(at), 0)
+	}
+	d := c.Backoff << attempt
+	if d <= 0 || (c.MaxBackoff > 0 && d > c.MaxBackoff) {
+		d = c.MaxBackoff
+	}
+	// Jitter keeps clients that failed together from retrying together
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func setInt(v url.Values, key string, n int) {
+	if n != 0 {
+		v.Set(key, strconv.Itoa(n))
+	}
+}
+
+func setString(v url.Values, key, s string) {
+	if s != "" {
+		v.Set(key, s)
+	}
+}
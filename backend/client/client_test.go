@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The endpoints are tested against the real router in package main; these
+// tests cover retries, which need a server that fails on purpose
+
+func flakyServer(t *testing.T, failures int32, status int) (*Client, *int32) {
+	t.Helper()
+	calls := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(calls, 1) <= failures {
+			http.Error(w, "try again", status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			if !strings.Contains(string(body), "alice") {
+				http.Error(w, "body lost", http.StatusBadRequest)
+				return
+			}
+			io.WriteString(w, `{"user":{"rank":1,"username":"alice","rating":1000}}`)
+			return
+		}
+		io.WriteString(w, `{"rank":1,"username":"alice","rating":1000}`)
+	}))
+	t.Cleanup(server.Close)
+	c := New(server.URL)
+	c.Backoff = time.Millisecond
+	return c, calls
+}
+
+func TestRetriesOn5xx(t *testing.T) {
+	c, calls := flakyServer(t, 2, http.StatusServiceUnavailable)
+	user, err := c.User(context.Background(), "alice")
+	if err != nil || user.Username != "alice" {
+		t.Fatalf("Expected success after retries, got %+v, %v", user, err)
+	}
+	if *calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", *calls)
+	}
+
+	// The body is sent again on each retry
+	c, calls = flakyServer(t, 1, http.StatusInternalServerError)
+	user, err = c.UpdateUserScore(context.Background(), "alice", 1000)
+	if err != nil || user.Username != "alice" || *calls != 2 {
+		t.Errorf("Expected the update retried, got %+v, %v after %d calls", user, err, *calls)
+	}
+}
+
+func TestGivesUp(t *testing.T) {
+	c, calls := flakyServer(t, 100, http.StatusBadGateway)
+	c.MaxRetries = 2
+	_, err := c.User(context.Background(), "alice")
+	if !errors.Is(err, ErrServer) || *calls != 3 {
+		t.Errorf("Expected ErrServer after 3 calls, got %v after %d", err, *calls)
+	}
+
+	// 4xx is not retried
+	c, calls = flakyServer(t, 100, http.StatusNotFound)
+	_, err = c.User(context.Background(), "alice")
+	if !errors.Is(err, ErrNotFound) || *calls != 1 {
+		t.Errorf("Expected ErrNotFound after 1 call, got %v after %d", err, *calls)
+	}
+
+	// Nor is a body that cannot be rewound
+	c, calls = flakyServer(t, 100, http.StatusInternalServerError)
+	body := io.MultiReader(strings.NewReader("username,rating\n"))
+	if _, err := c.Import(context.Background(), body, ImportOptions{Format: "csv"}); !errors.Is(err, ErrServer) || *calls != 1 {
+		t.Errorf("Expected ErrServer after 1 call, got %v after %d", err, *calls)
+	}
+}
+
+func TestRetriesOnlySafeRequests(t *testing.T) {
+	// An import may have been applied before the server failed
+	c, calls := flakyServer(t, 1, http.StatusInternalServerError)
+	if _, err := c.Import(context.Background(), strings.NewReader("alice"), ImportOptions{Format: "csv"}); !errors.Is(err, ErrServer) || *calls != 1 {
+		t.Errorf("Expected ErrServer after 1 call, got %v after %d", err, *calls)
+	}
+	c, calls = flakyServer(t, 1, http.StatusInternalServerError)
+	if _, err := c.UpdateScores(context.Background()); !errors.Is(err, ErrServer) || *calls != 1 {
+		t.Errorf("Expected ErrServer after 1 call, got %v after %d", err, *calls)
+	}
+
+	// Unless the caller asks
+	c, calls = flakyServer(t, 1, http.StatusInternalServerError)
+	c.RetryUnsafe = true
+	if _, err := c.Import(context.Background(), strings.NewReader("alice"), ImportOptions{Format: "csv"}); err != nil || *calls != 2 {
+		t.Errorf("Expected the import retried, got %v after %d calls", err, *calls)
+	}
+}
+
+func TestContextStopsRetries(t *testing.T) {
+	c, calls := flakyServer(t, 100, http.StatusServiceUnavailable)
+	c.Backoff = time.Hour
+	c.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.User(ctx, "alice"); !errors.Is(err, context.DeadlineExceeded) || *calls != 1 {
+		t.Errorf("Expected the deadline during the backoff, got %v after %d calls", err, *calls)
+	}
+}
+
+func TestWait(t *testing.T) {
+	c := New("http://localhost")
+	for attempt := 0; attempt < 10; attempt++ {
+		want := min(c.Backoff<<attempt, c.MaxBackoff)
+		if d := c.wait(attempt, ""); d < want/2 || d > want {
+			t.Errorf("Attempt %d: waited %v, want between %v and %v", attempt, d, want/2, want)
+		}
+	}
+	if d := c.wait(0, "2"); d != 2*time.Second {
+		t.Errorf("Expected Retry-After to set the wait, got %v", d)
+	}
+	// HTTP dates have whole seconds
+	at := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if d := c.wait(0, at); d < 8*time.Second || d > 10*time.Second {
+		t.Errorf("Expected a Retry-After date to set the wait, got %v", d)
+	}
+	if d := c.wait(0, "Mon, 02 Jan 2006 15:04:05 GMT"); d != 0 {
+		t.Errorf("Expected no wait for a past Retry-After date, got %v", d)
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"leaderboard/models"
+)
+
+// Sentinels an *Error matches with errors.Is, by status
+var (
+	ErrBadRequest       = errors.New("bad request")          // 400
+	ErrUnauthorized     = errors.New("unauthorized")         // 401
+	ErrNotFound         = errors.New("not found")            // 404
+	ErrMethodNotAllowed = errors.New("method not allowed")   // 405
+	ErrConflict         = errors.New("conflict")             // 409
+	ErrUnprocessable    = errors.New("unprocessable entity") // 422
+	ErrServer           = errors.New("server error")         // 5xx
+)
+
+// Error is a response with a status outside 2xx
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Code is the machine-readable code from the error envelope, one of
+	// the models.Error constants, or empty if the body was not an envelope
+	Code string
+	// Message is the server's explanation
+	Message string
+	// Details says what the error is about, such as the parameter
+	Details map[string]interface{}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches the sentinel for e's status, so callers can write
+// errors.Is(err, client.ErrNotFound)
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrMethodNotAllowed:
+		return e.StatusCode == http.StatusMethodNotAllowed
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnprocessable:
+		return e.StatusCode == http.StatusUnprocessableEntity
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// maxErrorBody bounds how much of an error response is kept
+const maxErrorBody = 4 << 10
+
+// readError reads and closes the body of a failed response. Bodies that are
+// not the JSON envelope, say from a proxy, become the message.
+func readError(method, path string, resp *http.Response) *Error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	io.Copy(io.Discard, resp.Body)
+
+	e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode}
+	var envelope models.ErrorResponse
+	if json.Unmarshal(body, &envelope) == nil && envelope.Code != "" {
+		e.Code, e.Message, e.Details = envelope.Code, envelope.Message, envelope.Details
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+
+	"leaderboard/models"
+)
+
+// Iterator walks a result page by page, fetching the next page when the
+// current one runs out:
+//
+//	it := c.Users(query)
+//	for it.Next(ctx) {
+//		user := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator[T any] struct {
+	// fetch returns the next page, and false once there are no more after it
+	fetch func(ctx context.Context) ([]T, bool, error)
+	page  []T
+	i     int
+	more  bool
+	err   error
+}
+
+func newIterator[T any](fetch func(ctx context.Context) ([]T, bool, error)) *Iterator[T] {
+	return &Iterator[T]{fetch: fetch, i: -1, more: true}
+}
+
+// Next advances to the next value, and reports false at the end or on an
+// error
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	it.i++
+	for it.i >= len(it.page) {
+		if !it.more {
+			return false
+		}
+		page, more, err := it.fetch(ctx)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.i, it.more = page, 0, more
+	}
+	return true
+}
+
+// Value is the current value
+func (it *Iterator[T]) Value() T {
+	return it.page[it.i]
+}
+
+// Err is the error that stopped the iterator, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Users walks the leaderboard from the page q selects, with q.Limit as the
+// page size. Pages follow the server's cursors, so users moving meanwhile
+// are not repeated; tier pages have no cursor and move on by offset.
+func (c *Client) Users(q LeaderboardQuery) *Iterator[models.UserWithRank] {
+	return newIterator(func(ctx context.Context) ([]models.UserWithRank, bool, error) {
+		resp, err := c.Leaderboard(ctx, q)
+		if err != nil {
+			return nil, false, err
+		}
+		if resp.NextCursor != "" {
+			q.Cursor = resp.NextCursor
+			return resp.Users, true, nil
+		}
+		if q.Tier != "" && len(resp.Users) > 0 {
+			q.Offset += len(resp.Users)
+			return resp.Users, true, nil
+		}
+		return resp.Users, false, nil
+	})
+}
+
+// TierChangeEvents walks tier change events oldest first, with q.Limit as
+// the page size. Each page asks for events after the last one seen.
+func (c *Client) TierChangeEvents(q TierChangesQuery) *Iterator[models.TierChangeEvent] {
+	return newIterator(func(ctx context.Context) ([]models.TierChangeEvent, bool, error) {
+		events, err := c.TierChanges(ctx, q)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(events) == 0 {
+			return nil, false, nil
+		}
+		q.Since = events[len(events)-1].ID
+		return events, true, nil
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"leaderboard/client"
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// The client is tested against the real routes, so a change to a handler's
+// shape that the client does not follow fails here
+
+func startAPI(t *testing.T, users int) (*services.LeaderboardService, *client.Client) {
+	t.Helper()
+	service := services.NewLeaderboardService()
+	for i := 0; i < users; i++ {
+		name := fmt.Sprintf("user_%03d", i)
+		service.AddUser(&models.User{ID: name, Username: name, Rating: 100 + i*37%4900})
+	}
+	server := httptest.NewServer(setupRouter(service, testAdminToken))
+	t.Cleanup(server.Close)
+	c := client.New(server.URL)
+	c.Token = testAdminToken
+	return service, c
+}
+
+func TestClientEndpoints(t *testing.T) {
+	service, c := startAPI(t, 50)
+	ctx := context.Background()
+
+	page, err := c.Leaderboard(ctx, client.LeaderboardQuery{Limit: 10, Offset: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := service.GetUsersInRange(5, 10); !reflect.DeepEqual(page.Users, want) {
+		t.Errorf("Leaderboard: got %+v, want %+v", page.Users, want)
+	}
+	if page.NextCursor == "" {
+		t.Error("Expected a cursor for the next page")
+	}
+
+	user, err := c.UpdateUserScore(ctx, "user_007", 4999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Rank != 1 || user.Rating != 4999 {
+		t.Errorf("UpdateUserScore: got %+v", user)
+	}
+	if got, err := c.User(ctx, "user_007"); err != nil || *got != *user {
+		t.Errorf("User: got %+v, %v, want %+v", got, err, user)
+	}
+
+	tiers, err := c.Tiers(ctx)
+	if err != nil || len(tiers) == 0 {
+		t.Fatalf("Tiers: got %+v, %v", tiers, err)
+	}
+	total := 0
+	for _, tier := range tiers {
+		total += tier.Count
+	}
+	if total != 50 {
+		t.Errorf("Tiers count %d users, want 50", total)
+	}
+
+	events, err := c.TierChanges(ctx, client.TierChangesQuery{Username: "user_007"})
+	if err != nil || len(events) != 1 || events[0].NewRating != 4999 {
+		t.Errorf("TierChanges: got %+v, %v", events, err)
+	}
+
+	found, err := c.Search(ctx, client.SearchQuery{Prefix: "user_00", Limit: 5})
+	if err != nil || len(found) != 5 {
+		t.Errorf("Search: got %+v, %v", found, err)
+	}
+
+	result, err := c.UpdateScores(ctx)
+	if err != nil || result.TotalUsers != 50 || result.UpdatedUsers == 0 {
+		t.Errorf("UpdateScores: got %+v, %v", result, err)
+	}
+
+	imported, err := c.Import(ctx, strings.NewReader("username,rating\nnewcomer,1234\n"), client.ImportOptions{Format: "csv"})
+	if err != nil || imported.Added != 1 {
+		t.Errorf("Import: got %+v, %v", imported, err)
+	}
+	c.Token = "wrong"
+	if _, err := c.Import(ctx, strings.NewReader("username,rating\nmallory,1234\n"), client.ImportOptions{Format: "csv"}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Import with the wrong token: got %v", err)
+	}
+	c.Token = testAdminToken
+
+	stream, err := c.Export(ctx, "ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(stream)
+	stream.Close()
+	if lines := strings.Count(string(data), "\n"); lines != 51 {
+		t.Errorf("Export: got %d lines, want 51", lines)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	_, c := startAPI(t, 1)
+	ctx := context.Background()
+
+	_, err := c.User(ctx, "nobody")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 || !strings.Contains(apiErr.Message, "nobody") {
+		t.Errorf("Expected the server's message in the error, got %+v", apiErr)
+	}
+	// Codes and details come from the error envelope
+	if apiErr.Code != models.ErrorNotFound || apiErr.Details["username"] != "nobody" {
+		t.Errorf("Expected the envelope decoded, got %+v", apiErr)
+	}
+
+	if _, err := c.Search(ctx, client.SearchQuery{}); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("Expected ErrBadRequest, got %v", err)
+	}
+	if _, err := c.Leaderboard(ctx, client.LeaderboardQuery{Tier: "Mythic"}); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("Expected ErrBadRequest for an unknown tier, got %v", err)
+	}
+	if _, err := c.UpdateUserScore(ctx, "nobody", 1000); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	_, err = c.UpdateUserScore(ctx, "user_000", 6000)
+	if !errors.Is(err, client.ErrUnprocessable) || !errors.As(err, &apiErr) || apiErr.Code != models.ErrorInvalidRating {
+		t.Errorf("Expected invalid_rating, got %v", err)
+	}
+	_, err = c.Leaderboard(ctx, client.LeaderboardQuery{Offset: -1})
+	if !errors.As(err, &apiErr) || apiErr.Code != models.ErrorInvalidParameter || apiErr.Details["parameter"] != "offset" {
+		t.Errorf("Expected invalid_parameter for offset, got %v", err)
+	}
+}
+
+func TestClientIterators(t *testing.T) {
+	service, c := startAPI(t, 250)
+	ctx := context.Background()
+
+	var users []models.UserWithRank
+	it := c.Users(client.LeaderboardQuery{Limit: 30})
+	for it.Next(ctx) {
+		users = append(users, it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := service.GetUsersInRange(0, 250); !reflect.DeepEqual(users, want) {
+		t.Errorf("Users walked %d users, want all %d in order", len(users), len(want))
+	}
+
+	// Tier pages move on by offset
+	count := 0
+	it = c.Users(client.LeaderboardQuery{Limit: 7, Tier: "Gold"})
+	for it.Next(ctx) {
+		if it.Value().Tier != "Gold" {
+			t.Fatalf("Got %+v in the Gold tier", it.Value())
+		}
+		count++
+	}
+	if want := tierCount(t, c, "Gold"); count != want || it.Err() != nil {
+		t.Errorf("Walked %d Gold users, want %d (%v)", count, want, it.Err())
+	}
+
+	for i := 0; i < 25; i++ {
+		service.UpdateRating(fmt.Sprintf("user_%03d", i), 5000-i)
+	}
+	var events []models.TierChangeEvent
+	eventIt := c.TierChangeEvents(client.TierChangesQuery{Limit: 4})
+	for eventIt.Next(ctx) {
+		events = append(events, eventIt.Value())
+	}
+	if err := eventIt.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := service.GetTierChanges("", 0, 1000); !reflect.DeepEqual(events, want) {
+		t.Errorf("TierChangeEvents walked %d events, want %d", len(events), len(want))
+	}
+}
+
+func tierCount(t *testing.T, c *client.Client, name string) int {
+	tiers, err := c.Tiers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tier := range tiers {
+		if tier.Name == name {
+			return tier.Count
+		}
+	}
+	t.Fatalf("No tier %s", name)
+	return 0
+}
@@ -0,0 +1,263 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"leaderboard/handlers"
+	"leaderboard/models"
+	"leaderboard/router"
+	"leaderboard/services"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// node is one shard process: the public API and the shard endpoints
+type node struct {
+	service *services.LeaderboardService
+	server  *httptest.Server
+}
+
+func startNodes(t *testing.T, n int) []*node {
+	t.Helper()
+	nodes := make([]*node, n)
+	for i := range nodes {
+		service := services.NewLeaderboardService()
+		h := handlers.NewHandler(service)
+		api := router.New()
+		api.HandleFunc(http.MethodGet, "/v1/user/{username}", h.GetUser)
+		api.HandleFunc(http.MethodPost, "/v1/update-user-score", h.UpdateUserScore)
+		mux := http.NewServeMux()
+		mux.Handle("/v1/", api)
+		mux.Handle("/shard/", NewShard(service).Handler())
+
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		nodes[i] = &node{service: service, server: server}
+	}
+	return nodes
+}
+
+func urls(nodes []*node) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.server.URL)
+	}
+	return out
+}
+
+// sampleUsers crowds ratings together so ties span nodes
+func sampleUsers(n int) []models.User {
+	rng := rand.New(rand.NewSource(1))
+	users := make([]models.User, n)
+	for i := range users {
+		users[i] = models.User{ID: fmt.Sprint(i), Username: fmt.Sprintf("user_%04d", i), Rating: 4000 + rng.Intn(1001)}
+	}
+	return users
+}
+
+// assertMatches compares the coordinator's view with a single-node board
+func assertMatches(t *testing.T, c *Coordinator, reference *services.LeaderboardService) {
+	t.Helper()
+	ctx := context.Background()
+
+	total := reference.GetUserCount()
+	for _, page := range [][2]int{{0, 10}, {0, total + 5}, {17, 50}, {total - 3, 10}, {total + 10, 10}} {
+		got, err := c.GetUsersInRange(ctx, page[0], page[1])
+		if err != nil {
+			t.Fatalf("GetUsersInRange%v failed: %v", page, err)
+		}
+		want := reference.GetUsersInRange(page[0], page[1])
+		if len(got) != len(want) {
+			t.Fatalf("Page %v: expected %d users, got %d", page, len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("Page %v row %d: got %+v want %+v", page, i, got[i], want[i])
+			}
+		}
+	}
+
+	for _, username := range []string{"user_0000", "user_0123", "user_0599"} {
+		want, werr := reference.GetUserRank(username)
+		got, err := c.GetUserRank(ctx, username)
+		if werr != nil {
+			continue
+		}
+		if err != nil || *got != *want {
+			t.Errorf("%s: got %+v %v want %+v", username, got, err, want)
+		}
+	}
+}
+
+func TestCoordinatorMatchesSingleNode(t *testing.T) {
+	nodes := startNodes(t, 3)
+	c := NewCoordinator(NewRing(0, urls(nodes)...), nil, nil)
+	ctx := context.Background()
+
+	users := sampleUsers(600)
+	reference := services.NewLeaderboardService()
+	reference.ImportUsers(users, false)
+	if err := c.AddUsers(ctx, users); err != nil {
+		t.Fatalf("AddUsers failed: %v", err)
+	}
+
+	// Every node holds a share
+	for i, n := range nodes {
+		if n.service.GetUserCount() == 0 {
+			t.Errorf("Node %d holds no users", i)
+		}
+	}
+	assertMatches(t, c, reference)
+
+	// Updates reach the owning node
+	if err := c.UpdateRating(ctx, "user_0123", 5000); err != nil {
+		t.Fatalf("UpdateRating failed: %v", err)
+	}
+	reference.UpdateRating("user_0123", 5000)
+	assertMatches(t, c, reference)
+
+	// Node errors come back typed
+	var nodeErr *NodeError
+	if _, err := c.GetUserRank(ctx, "nobody"); !errors.As(err, &nodeErr) || nodeErr.Status != http.StatusNotFound {
+		t.Errorf("Expected a 404 from the owner, got %v", err)
+	}
+	if err := c.UpdateRating(ctx, "user_0001", 9000); !errors.As(err, &nodeErr) {
+		t.Errorf("Expected the owner to reject the rating, got %v", err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	nodes := startNodes(t, 4)
+	c := NewCoordinator(NewRing(0, urls(nodes[:3])...), nil, nil)
+	ctx := context.Background()
+
+	users := sampleUsers(600)
+	reference := services.NewLeaderboardService()
+	reference.ImportUsers(users, false)
+	c.AddUsers(ctx, users)
+
+	// Add a node: only its share moves, onto it
+	grown := NewRing(0, urls(nodes)...)
+	moved, err := c.Rebalance(ctx, grown)
+	if err != nil {
+		t.Fatalf("Rebalance failed: %v", err)
+	}
+	if moved == 0 || moved != nodes[3].service.GetUserCount() {
+		t.Errorf("Expected the moved users on the new node, moved %d, new node has %d", moved, nodes[3].service.GetUserCount())
+	}
+	assertOwned(t, nodes, grown)
+	assertMatches(t, c, reference)
+
+	// Remove a node: its users spread over the rest
+	shrunk := NewRing(0, urls(nodes[1:])...)
+	if _, err := c.Rebalance(ctx, shrunk); err != nil {
+		t.Fatalf("Rebalance failed: %v", err)
+	}
+	if nodes[0].service.GetUserCount() != 0 {
+		t.Errorf("Expected the removed node emptied, it has %d users", nodes[0].service.GetUserCount())
+	}
+	assertOwned(t, nodes[1:], shrunk)
+	assertMatches(t, c, reference)
+
+	// A rebalance onto the same ring moves nothing
+	if moved, err := c.Rebalance(ctx, shrunk); err != nil || moved != 0 {
+		t.Errorf("Expected nothing to move, got %d %v", moved, err)
+	}
+}
+
+// assertOwned checks every user sits on its owner and nowhere else
+func assertOwned(t *testing.T, nodes []*node, ring *Ring) {
+	t.Helper()
+	for _, n := range nodes {
+		for _, username := range n.service.GetAllUsernames() {
+			if owner := ring.Owner(username); owner != n.server.URL {
+				t.Fatalf("%s is on %s but owned by %s", username, n.server.URL, owner)
+			}
+		}
+	}
+}
+
+func TestCoordinatorHandler(t *testing.T) {
+	nodes := startNodes(t, 2)
+	c := NewCoordinator(NewRing(0, urls(nodes)...), nil, nil)
+	c.AddUsers(context.Background(), sampleUsers(50))
+	server := httptest.NewServer(c.Handler())
+	defer server.Close()
+
+	resp, _ := http.Get(server.URL + "/v1/leaderboard?limit=5")
+	var page models.LeaderboardResponse
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if len(page.Users) != 5 || page.Users[0].Rank != 1 {
+		t.Errorf("Expected the top 5, got %+v", page.Users)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"username": "user_0007", "rating": 100})
+	resp, _ = http.Post(server.URL+"/v1/update-user-score", "application/json", bytes.NewReader(body))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected update to succeed, got %d", resp.StatusCode)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/v1/user/user_0007", http.StatusOK, ""},
+		{"/user/user_0007", http.StatusOK, ""},
+		{"/v1/user/nobody", http.StatusNotFound, models.ErrorNotFound},
+		{"/v1/user/", http.StatusBadRequest, models.ErrorInvalidParameter},
+		{"/v1/leaderboard?limit=abc", http.StatusBadRequest, models.ErrorInvalidParameter},
+		{"/v1/leaderboard?limit=0", http.StatusBadRequest, models.ErrorInvalidParameter},
+		{"/leaderboard?offset=-1", http.StatusBadRequest, models.ErrorInvalidParameter},
+		{"/cluster", http.StatusOK, ""},
+		{"/cluster/rebalance", http.StatusMethodNotAllowed, models.ErrorMethodNotAllowed},
+	}
+	for _, tt := range cases {
+		resp, _ := http.Get(server.URL + tt.path)
+		var envelope models.ErrorResponse
+		if tt.code != "" {
+			json.NewDecoder(resp.Body).Decode(&envelope)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || envelope.Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", tt.path, tt.status, tt.code, resp.StatusCode, envelope)
+		}
+	}
+
+	// A node's validation error comes through with its details
+	body, _ = json.Marshal(map[string]interface{}{"username": "user_0007", "rating": 99})
+	resp, _ = http.Post(server.URL+"/v1/update-user-score", "application/json", bytes.NewReader(body))
+	var envelope models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&envelope)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || envelope.Code != models.ErrorInvalidRating || envelope.Details["min"] == nil {
+		t.Errorf("Expected the node's invalid_rating envelope, got %d %+v", resp.StatusCode, envelope)
+	}
+
+	// Rebalance onto one node through the API
+	body, _ = json.Marshal(NodesResponse{Nodes: urls(nodes[:1])})
+	resp, _ = http.Post(server.URL+"/cluster/rebalance", "application/json", bytes.NewReader(body))
+	var result NodesResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if len(result.Nodes) != 1 || nodes[0].service.GetUserCount() != 50 {
+		t.Errorf("Expected all users on one node, got %+v and %d users", result, nodes[0].service.GetUserCount())
+	}
+
+	// An unreachable node is a bad gateway
+	nodes[0].server.Close()
+	resp, _ = http.Get(server.URL + "/v1/leaderboard")
+	envelope = models.ErrorResponse{}
+	json.NewDecoder(resp.Body).Decode(&envelope)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || envelope.Code != models.ErrorBadGateway {
+		t.Errorf("Expected 502 with the node down, got %d %+v", resp.StatusCode, envelope)
+	}
+}
@@ -0,0 +1,373 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// ErrNoNodes means the ring is empty
+var ErrNoNodes = errors.New("cluster: no nodes")
+
+// NodeError is a failed request to a node, with the status it answered
+type NodeError struct {
+	Node    string
+	Status  int // 0 when the node could not be reached
+	Message string
+
+	// From the node's error envelope, when it sent one
+	Code    string
+	Details map[string]interface{}
+}
+
+func (e *NodeError) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("cluster: node %s: %s", e.Node, e.Message)
+	}
+	return fmt.Sprintf("cluster: node %s answered %d: %s", e.Node, e.Status, e.Message)
+}
+
+// Coordinator answers leaderboard queries for users sharded across nodes.
+// Writes go to the node owning the user; reads gather every node's rating
+// counts, from which a global dense rank is the number of occupied ratings
+// above a user on any node.
+type Coordinator struct {
+	mu     sync.RWMutex // held for writing while users move between nodes
+	ring   *Ring
+	tiers  []services.Tier
+	client *http.Client
+}
+
+// NewCoordinator routes over the nodes of ring, each the base URL of a node
+// serving the public API and a Shard. Tiers default to services.DefaultTiers.
+func NewCoordinator(ring *Ring, tiers []services.Tier, client *http.Client) *Coordinator {
+	if tiers == nil {
+		tiers = services.DefaultTiers
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Coordinator{ring: ring, tiers: tiers, client: client}
+}
+
+// Ring returns the current ring
+func (c *Coordinator) Ring() *Ring {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring
+}
+
+// histogram is the number of users at each rating across every node
+type histogram [5001]int
+
+// position returns the dense rank of rating, the users rated above it and
+// the users in total
+func (h *histogram) position(rating int) (rank, above, total int) {
+	rank = 1
+	for r := 5000; r >= 100; r-- {
+		if r > rating && h[r] > 0 {
+			rank++
+			above += h[r]
+		}
+		total += h[r]
+	}
+	return rank, above, total
+}
+
+// gather merges the rating counts of every node
+func (c *Coordinator) gather(ctx context.Context, nodes []string) (*histogram, error) {
+	results := make([]Counts, len(nodes))
+	err := scatter(nodes, func(i int, node string) error {
+		return c.getJSON(ctx, node, "/shard/counts", &results[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := new(histogram)
+	for _, counts := range results {
+		for rating, n := range counts.Counts {
+			if rating >= 100 && rating <= 5000 {
+				h[rating] += n
+			}
+		}
+	}
+	return h, nil
+}
+
+// GetUserRank looks the user up on its node and ranks it across all nodes
+func (c *Coordinator) GetUserRank(ctx context.Context, username string) (*models.UserWithRank, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	owner := c.ring.Owner(username)
+	if owner == "" {
+		return nil, ErrNoNodes
+	}
+	var user models.UserWithRank
+	if err := c.getJSON(ctx, owner, "/v1/user/"+url.PathEscape(username), &user); err != nil {
+		return nil, err
+	}
+
+	h, err := c.gather(ctx, c.ring.Nodes())
+	if err != nil {
+		return nil, err
+	}
+	c.rankLocked(h, &user)
+	return &user, nil
+}
+
+// rankLocked replaces a node's local rank and tier with the global ones
+func (c *Coordinator) rankLocked(h *histogram, user *models.UserWithRank) {
+	rank, above, total := h.position(user.Rating)
+	user.Rank = rank
+	user.Tier = services.TierName(c.tiers, user.Rating, above, total)
+}
+
+// GetUsersInRange returns limit users from offset in global order: rating
+// descending, then username. The counts locate the rating the page starts
+// at, so each node sends at most that rating's users plus limit.
+func (c *Coordinator) GetUsersInRange(ctx context.Context, offset, limit int) ([]models.UserWithRank, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	nodes := c.ring.Nodes()
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+	h, err := c.gather(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the highest rating whose users reach past offset
+	start, skipped := 0, 0
+	for r := 5000; r >= 100; r-- {
+		if skipped+h[r] > offset {
+			start = r
+			break
+		}
+		skipped += h[r]
+	}
+	if start == 0 || limit <= 0 {
+		return []models.UserWithRank{}, nil
+	}
+
+	want := offset - skipped + limit
+	pages := make([][]models.UserWithRank, len(nodes))
+	path := fmt.Sprintf("/shard/top?max_rating=%d&limit=%d", start, want)
+	err = scatter(nodes, func(i int, node string) error {
+		return c.getJSON(ctx, node, path, &pages[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make([]models.UserWithRank, 0, want)
+	for _, page := range pages {
+		merged = append(merged, page...)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Rating != merged[j].Rating {
+			return merged[i].Rating > merged[j].Rating
+		}
+		return merged[i].Username < merged[j].Username
+	})
+
+	from := min(offset-skipped, len(merged))
+	merged = merged[from:min(from+limit, len(merged))]
+	for i := range merged {
+		c.rankLocked(h, &merged[i])
+	}
+	return merged, nil
+}
+
+// UpdateRating sets the rating of an existing user on its node
+func (c *Coordinator) UpdateRating(ctx context.Context, username string, rating int) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	owner := c.ring.Owner(username)
+	if owner == "" {
+		return ErrNoNodes
+	}
+	body, _ := json.Marshal(map[string]interface{}{"username": username, "rating": rating})
+	return c.post(ctx, owner, "/v1/update-user-score", "application/json", body, nil)
+}
+
+// AddUsers upserts users onto their nodes
+func (c *Coordinator) AddUsers(ctx context.Context, users []models.User) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sendLocked(ctx, c.ring, users)
+}
+
+// sendLocked upserts users onto their owners in ring, one batch per node
+func (c *Coordinator) sendLocked(ctx context.Context, ring *Ring, users []models.User) error {
+	batches := make(map[string][]models.User)
+	for _, u := range users {
+		owner := ring.Owner(u.Username)
+		if owner == "" {
+			return ErrNoNodes
+		}
+		batches[owner] = append(batches[owner], u)
+	}
+
+	for node, batch := range batches {
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, u := range batch {
+			enc.Encode(u)
+		}
+		var result models.ImportResult
+		if err := c.post(ctx, node, "/shard/users", "application/x-ndjson", body.Bytes(), &result); err != nil {
+			return err
+		}
+		if result.Failed > 0 {
+			return &NodeError{Node: node, Status: http.StatusOK, Message: result.Errors[0].Error}
+		}
+	}
+	return nil
+}
+
+// Rebalance moves users to their owners on next, typically the current ring
+// with nodes added or removed, then routes by next. Queries wait while users
+// move. Each user is copied before it is removed from its old node, so a
+// failed rebalance leaves duplicates rather than losing users; running it
+// again with the same ring finishes the move.
+func (c *Coordinator) Rebalance(ctx context.Context, next *Ring) (moved int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, node := range c.ring.Nodes() {
+		users, err := c.exportUsers(ctx, node)
+		if err != nil {
+			return moved, err
+		}
+
+		var leaving []models.User
+		var usernames []string
+		for _, u := range users {
+			if next.Owner(u.Username) != node {
+				leaving = append(leaving, u)
+				usernames = append(usernames, u.Username)
+			}
+		}
+		if len(leaving) == 0 {
+			continue
+		}
+
+		if err := c.sendLocked(ctx, next, leaving); err != nil {
+			return moved, err
+		}
+		body, _ := json.Marshal(Removal{Usernames: usernames})
+		if err := c.post(ctx, node, "/shard/remove", "application/json", body, nil); err != nil {
+			return moved, err
+		}
+		moved += len(leaving)
+	}
+
+	c.ring = next
+	return moved, nil
+}
+
+func (c *Coordinator) exportUsers(ctx context.Context, node string) ([]models.User, error) {
+	resp, err := c.do(ctx, http.MethodGet, node, "/shard/users", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var users []models.User
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var u models.User
+		if err := dec.Decode(&u); err == io.EOF {
+			return users, nil
+		} else if err != nil {
+			return nil, &NodeError{Node: node, Message: err.Error()}
+		}
+		users = append(users, u)
+	}
+}
+
+func (c *Coordinator) getJSON(ctx context.Context, node, path string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, node, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &NodeError{Node: node, Status: resp.StatusCode, Message: err.Error()}
+	}
+	return nil
+}
+
+func (c *Coordinator) post(ctx context.Context, node, path, contentType string, body []byte, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodPost, node, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &NodeError{Node: node, Status: resp.StatusCode, Message: err.Error()}
+	}
+	return nil
+}
+
+// do sends a request to node and turns any status but 200 into a NodeError
+func (c *Coordinator) do(ctx context.Context, method, node, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(node, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, &NodeError{Node: node, Message: err.Error()}
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		// Nodes answer with the JSON error envelope; keep it
+		var envelope models.ErrorResponse
+		if json.Unmarshal(msg, &envelope) == nil && envelope.Message != "" {
+			return nil, &NodeError{Node: node, Status: resp.StatusCode, Message: envelope.Message, Code: envelope.Code, Details: envelope.Details}
+		}
+		return nil, &NodeError{Node: node, Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// scatter runs fn for every node at once and returns the first error
+func scatter(nodes []string, fn func(i int, node string) error) error {
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			errs[i] = fn(i, node)
+		}(i, node)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
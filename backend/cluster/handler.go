@@ -0,0 +1,178 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"leaderboard/handlers"
+	"leaderboard/models"
+)
+
+// Handler serves the public read API and score updates across the cluster,
+// under /v1 and at the deprecated unversioned paths, plus its administration:
+//
+//	GET  /v1/leaderboard?limit=N&offset=M - global top users
+//	GET  /v1/user/{username}              - global rank of a user
+//	POST /v1/update-user-score            - update a user on its node
+//	GET  /cluster                         - the nodes on the ring
+//	POST /cluster/rebalance               - move users onto a new set of nodes
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, prefix := range []string{"/v1", ""} {
+		mux.HandleFunc(prefix+"/leaderboard", c.serveLeaderboard)
+		mux.HandleFunc(prefix+"/user/", c.serveUser)
+		mux.HandleFunc(prefix+"/update-user-score", c.serveUpdate)
+	}
+	mux.HandleFunc("/cluster", c.serveNodes)
+	mux.HandleFunc("/cluster/rebalance", c.serveRebalance)
+	return mux
+}
+
+// NodesResponse lists the nodes on the ring
+type NodesResponse struct {
+	Nodes []string `json:"nodes"`
+	Moved int      `json:"moved,omitempty"`
+}
+
+func (c *Coordinator) serveLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if !allowOnly(w, r, http.MethodGet) {
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		val, err := strconv.Atoi(limitStr)
+		if err != nil || val <= 0 {
+			handlers.InvalidParameter(w, "limit", limitStr, "limit must be a positive integer")
+			return
+		}
+		limit = min(val, 1000)
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		val, err := strconv.Atoi(offsetStr)
+		if err != nil || val < 0 {
+			handlers.InvalidParameter(w, "offset", offsetStr, "offset must be a non-negative integer")
+			return
+		}
+		offset = val
+	}
+
+	users, err := c.GetUsersInRange(r.Context(), offset, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, models.LeaderboardResponse{Users: users})
+}
+
+func (c *Coordinator) serveUser(w http.ResponseWriter, r *http.Request) {
+	if !allowOnly(w, r, http.MethodGet) {
+		return
+	}
+
+	username := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1"), "/user/")
+	if username == "" || strings.Contains(username, "/") {
+		handlers.InvalidParameter(w, "username", username, "Invalid URL format. Expected: /user/{username}")
+		return
+	}
+
+	user, err := c.GetUserRank(r.Context(), username)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, user)
+}
+
+func (c *Coordinator) serveUpdate(w http.ResponseWriter, r *http.Request) {
+	if !allowOnly(w, r, http.MethodPost) {
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+		Rating   int    `json:"rating"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid request body", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if input.Username == "" {
+		handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Username is required", map[string]interface{}{"field": "username"})
+		return
+	}
+
+	if err := c.UpdateRating(r.Context(), input.Username, input.Rating); err != nil {
+		writeError(w, err)
+		return
+	}
+	user, err := c.GetUserRank(r.Context(), input.Username)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"message": "User score updated",
+		"user":    user,
+	})
+}
+
+func (c *Coordinator) serveNodes(w http.ResponseWriter, r *http.Request) {
+	if !allowOnly(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, NodesResponse{Nodes: c.Ring().Nodes()})
+}
+
+// serveRebalance takes the full new list of nodes
+func (c *Coordinator) serveRebalance(w http.ResponseWriter, r *http.Request) {
+	if !allowOnly(w, r, http.MethodPost) {
+		return
+	}
+
+	var input NodesResponse
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || len(input.Nodes) == 0 {
+		handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Expected a body listing the new nodes", nil)
+		return
+	}
+
+	moved, err := c.Rebalance(r.Context(), NewRing(0, input.Nodes...))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, NodesResponse{Nodes: c.Ring().Nodes(), Moved: moved})
+}
+
+// allowOnly answers with the 405 envelope unless r uses method
+func allowOnly(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	handlers.MethodNotAllowed(w, r)
+	return false
+}
+
+// writeError passes a node's client errors through, envelope and all, and
+// reports the rest as a bad gateway
+func writeError(w http.ResponseWriter, err error) {
+	var nodeErr *NodeError
+	if errors.As(err, &nodeErr) && nodeErr.Status >= 400 && nodeErr.Status < 500 {
+		code := nodeErr.Code
+		if code == "" {
+			code = models.ErrorInvalidRequest
+		}
+		handlers.WriteError(w, nodeErr.Status, code, nodeErr.Message, nodeErr.Details)
+		return
+	}
+	if errors.Is(err, ErrNoNodes) {
+		handlers.WriteError(w, http.StatusServiceUnavailable, models.ErrorUnavailable, err.Error(), nil)
+		return
+	}
+	handlers.WriteError(w, http.StatusBadGateway, models.ErrorBadGateway, err.Error(), nil)
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is how many points each node gets on the ring. More
+// points spread users more evenly at the cost of a larger ring.
+const DefaultVirtualNodes = 128
+
+// Ring assigns usernames to nodes by consistent hashing, so adding or
+// removing a node only moves the users on its share of the ring. A Ring is
+// immutable; build a new one to change the nodes.
+type Ring struct {
+	nodes  []string
+	points []uint64 // sorted hashes of the virtual nodes
+	owners []string // owners[i] holds points[i]
+}
+
+// NewRing places each node at vnodes points on the ring, DefaultVirtualNodes
+// when vnodes is not positive. Duplicate nodes are ignored.
+func NewRing(vnodes int, nodes ...string) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{}
+	seen := make(map[string]struct{}, len(nodes))
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	var points []point
+	for _, node := range nodes {
+		if _, dup := seen[node]; dup {
+			continue
+		}
+		seen[node] = struct{}{}
+		r.nodes = append(r.nodes, node)
+		for i := 0; i < vnodes; i++ {
+			points = append(points, point{hash: hashKey(node + "#" + strconv.Itoa(i)), owner: node})
+		}
+	}
+
+	// Ties between nodes are broken by name so every coordinator agrees
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+	r.points = make([]uint64, len(points))
+	r.owners = make([]string, len(points))
+	for i, p := range points {
+		r.points[i], r.owners[i] = p.hash, p.owner
+	}
+	return r
+}
+
+// Nodes returns the nodes on the ring in the order they were given
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// Owner returns the node holding username, or "" for an empty ring
+func (r *Ring) Owner(username string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(username)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 // wrap around
+	}
+	return r.owners[i]
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV alone clusters similar keys such as node#1, node#2; mix the bits
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingOwner(t *testing.T) {
+	if owner := NewRing(0).Owner("alice"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, got %q", owner)
+	}
+
+	ring := NewRing(0, "a", "b", "c", "b")
+	if nodes := ring.Nodes(); len(nodes) != 3 {
+		t.Errorf("Expected duplicates dropped, got %v", nodes)
+	}
+
+	// Ownership does not depend on node order and is roughly even
+	reordered := NewRing(0, "c", "b", "a")
+	counts := make(map[string]int)
+	for i := 0; i < 30000; i++ {
+		name := fmt.Sprintf("user_%d", i)
+		owner := ring.Owner(name)
+		if owner != reordered.Owner(name) {
+			t.Fatalf("%s: owner %s changed to %s with the nodes reordered", name, owner, reordered.Owner(name))
+		}
+		counts[owner]++
+	}
+	for node, n := range counts {
+		if n < 7000 || n > 13000 {
+			t.Errorf("Node %s owns %d of 30000 users, expected about 10000", node, n)
+		}
+	}
+}
+
+func TestRingMinimalMovement(t *testing.T) {
+	before := NewRing(0, "a", "b", "c")
+	after := NewRing(0, "a", "b", "c", "d")
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		name := fmt.Sprintf("user_%d", i)
+		from, to := before.Owner(name), after.Owner(name)
+		if from != to {
+			moved++
+			if to != "d" {
+				t.Fatalf("%s moved from %s to %s, only moves to the new node are expected", name, from, to)
+			}
+		}
+	}
+	// About a quarter of the users belong on the new node
+	if moved < 1500 || moved > 3500 {
+		t.Errorf("Expected about 2500 users to move, got %d", moved)
+	}
+}
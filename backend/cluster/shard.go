@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// Counts is a shard's number of users at each occupied rating
+type Counts struct {
+	Version uint64      `json:"version"`
+	Counts  map[int]int `json:"counts"`
+}
+
+// Removal lists users to drop from a shard
+type Removal struct {
+	Usernames []string `json:"usernames"`
+}
+
+// Shard serves one node's part of a sharded board to the coordinator. The
+// node keeps serving its own public API beside it.
+type Shard struct {
+	service *services.LeaderboardService
+}
+
+// NewShard exposes service as a shard
+func NewShard(service *services.LeaderboardService) *Shard {
+	return &Shard{service: service}
+}
+
+// Handler serves the shard endpoints:
+//
+//	GET  /shard/counts                   - users per occupied rating
+//	GET  /shard/top?max_rating=R&limit=N - best users rated R or lower
+//	GET  /shard/users                    - every user as NDJSON
+//	POST /shard/users                    - upsert users from NDJSON
+//	POST /shard/remove                   - remove the users in a Removal
+func (s *Shard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shard/counts", s.serveCounts)
+	mux.HandleFunc("/shard/top", s.serveTop)
+	mux.HandleFunc("/shard/users", s.serveUsers)
+	mux.HandleFunc("/shard/remove", s.serveRemove)
+	return mux
+}
+
+func (s *Shard) serveCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := Counts{Version: s.service.Version(), Counts: make(map[int]int)}
+	for rating, n := range s.service.RatingCounts() {
+		if n > 0 {
+			response.Counts[rating] = n
+		}
+	}
+	writeJSON(w, response)
+}
+
+// serveTop skips the users rated above max_rating using the shard's own
+// counts, so a deep page costs the coordinator no more than a shallow one
+func (s *Shard) serveTop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	maxRating, err := strconv.Atoi(query.Get("max_rating"))
+	if err != nil || maxRating < 100 || maxRating > 5000 {
+		http.Error(w, "max_rating must be between 100 and 5000", http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 0 {
+		http.Error(w, "limit must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+
+	offset := 0
+	for rating, n := range s.service.RatingCounts() {
+		if rating > maxRating {
+			offset += n
+		}
+	}
+	writeJSON(w, s.service.GetUsersInRange(offset, limit))
+}
+
+func (s *Shard) serveUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.exportUsers(w)
+	case http.MethodPost:
+		s.importUsers(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// exportUsers copies the users under the service lock and streams them
+// without it
+func (s *Shard) exportUsers(w http.ResponseWriter) {
+	var users []models.User
+	s.service.WithView(func(v services.View) error {
+		users = make([]models.User, 0, v.Count)
+		return v.Each(func(u models.User) error {
+			users = append(users, u)
+			return nil
+		})
+	})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, u := range users {
+		if err := enc.Encode(u); err != nil {
+			return
+		}
+	}
+	bw.Flush()
+}
+
+// importUsers upserts every user in the body in one batch. A body that does
+// not parse changes nothing.
+func (s *Shard) importUsers(w http.ResponseWriter, r *http.Request) {
+	var users []models.User
+	dec := json.NewDecoder(r.Body)
+	for {
+		var u models.User
+		if err := dec.Decode(&u); err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid user on line %d: %v", len(users)+1, err), http.StatusBadRequest)
+			return
+		}
+		users = append(users, u)
+	}
+
+	added, updated, errs := s.service.ImportUsers(users, true)
+	result := models.ImportResult{Rows: len(users), Added: added, Updated: updated, Errors: []models.ImportError{}}
+	for i, err := range errs {
+		if err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, models.ImportError{Line: i + 1, Error: err.Error()})
+		}
+	}
+	writeJSON(w, result)
+}
+
+func (s *Shard) serveRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input Removal
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Users already gone are skipped, so a retried removal still succeeds
+	removed := 0
+	for _, username := range input.Usernames {
+		if s.service.RemoveUser(username) == nil {
+			removed++
+		}
+	}
+	writeJSON(w, map[string]int{"removed": removed})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,74 @@
+// Package crdt lets several regions take rating updates at once and merge
+// them into identical boards. Each user's rating is a last-writer-wins
+// register ordered by hybrid logical clocks, plus a counter of the
+// increments made on top of it.
+package crdt
+
+import (
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading. Wall is close to real time,
+// Logical orders events within the same wall time, and Node breaks ties
+// between regions, so no two regions ever stamp the same Timestamp.
+type Timestamp struct {
+	Wall    int64  `json:"wall"` // unix nanoseconds
+	Logical uint32 `json:"logical,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+// Less orders timestamps by wall time, then logical time, then node
+func (t Timestamp) Less(o Timestamp) bool {
+	if t.Wall != o.Wall {
+		return t.Wall < o.Wall
+	}
+	if t.Logical != o.Logical {
+		return t.Logical < o.Logical
+	}
+	return t.Node < o.Node
+}
+
+// Clock is a hybrid logical clock. Its readings never go backwards, even when
+// the wall clock does, and are always after every timestamp it has observed
+// from other regions.
+type Clock struct {
+	node string
+	now  func() time.Time
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// NewClock returns a clock for node, reading wall time from now, which
+// defaults to time.Now
+func NewClock(node string, now func() time.Time) *Clock {
+	if now == nil {
+		now = time.Now
+	}
+	return &Clock{node: node, now: now}
+}
+
+// Now returns a timestamp after every one the clock has returned or observed
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wall := c.now().UnixNano(); wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Node = c.node
+	return c.last
+}
+
+// Observe moves the clock past t, a timestamp from another region
+func (c *Clock) Observe(t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.Wall > c.last.Wall || (t.Wall == c.last.Wall && t.Logical > c.last.Logical) {
+		c.last.Wall, c.last.Logical = t.Wall, t.Logical
+	}
+}
@@ -0,0 +1,313 @@
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+func TestClock(t *testing.T) {
+	wall := time.Unix(100, 0)
+	clock := NewClock("a", func() time.Time { return wall })
+
+	first := clock.Now()
+	second := clock.Now()
+	if !first.Less(second) {
+		t.Errorf("Expected %+v before %+v", first, second)
+	}
+
+	// The wall clock going back does not take the clock with it
+	wall = time.Unix(50, 0)
+	if third := clock.Now(); !second.Less(third) {
+		t.Errorf("Expected %+v before %+v", second, third)
+	}
+
+	// Nor does a region far ahead leave it behind
+	remote := Timestamp{Wall: time.Unix(500, 0).UnixNano(), Logical: 7, Node: "b"}
+	clock.Observe(remote)
+	if next := clock.Now(); !remote.Less(next) {
+		t.Errorf("Expected %+v before %+v", remote, next)
+	}
+}
+
+func randomUser(rng *rand.Rand) UserState {
+	clocks := []Timestamp{{}, {Wall: 1, Node: "a"}, {Wall: 1, Node: "b"}, {Wall: 2, Node: "a"}}
+	user := UserState{
+		ID:       fmt.Sprint(rng.Intn(3)),
+		Register: Register{Rating: 100 + rng.Intn(4901), Clock: clocks[rng.Intn(len(clocks))]},
+	}
+	for _, region := range []string{"a", "b", "c"} {
+		if rng.Intn(2) == 0 {
+			continue
+		}
+		if user.Counters == nil {
+			user.Counters = make(map[string]Counter)
+		}
+		user.Counters[region] = Counter{Base: clocks[rng.Intn(len(clocks))], Inc: uint64(rng.Intn(500)), Dec: uint64(rng.Intn(500))}
+	}
+	user.prune()
+	return user
+}
+
+func merged(a, b UserState) UserState {
+	a = a.clone()
+	a.merge(b)
+	return a
+}
+
+func TestMergeLaws(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		a, b, c := randomUser(rng), randomUser(rng), randomUser(rng)
+
+		if ab, ba := merged(a, b), merged(b, a); !reflect.DeepEqual(ab, ba) {
+			t.Fatalf("Not commutative:\n%+v\n%+v", ab, ba)
+		}
+		if left, right := merged(merged(a, b), c), merged(a, merged(b, c)); !reflect.DeepEqual(left, right) {
+			t.Fatalf("Not associative:\n%+v\n%+v", left, right)
+		}
+		if aa := merged(a, a); !reflect.DeepEqual(aa, a) {
+			t.Fatalf("Not idempotent:\n%+v\n%+v", aa, a)
+		}
+	}
+}
+
+func TestIncrementsFromEveryRegionCount(t *testing.T) {
+	user := UserState{Register: Register{Rating: 1000}}
+	a, b := user.clone(), user.clone()
+	a.increment("a", 30)
+	a.increment("a", -10)
+	b.increment("b", 5)
+	if rating := merged(a, b).Rating(); rating != 1025 {
+		t.Errorf("Expected 1025, got %d", rating)
+	}
+
+	// A later set wins over the increments before it
+	b.set(Register{Rating: 2000, Clock: Timestamp{Wall: 1, Node: "b"}})
+	if rating := merged(a, b).Rating(); rating != 2000 {
+		t.Errorf("Expected 2000, got %d", rating)
+	}
+
+	a.increment("a", 10000)
+	if rating := a.Rating(); rating != 5000 {
+		t.Errorf("Expected the rating capped at 5000, got %d", rating)
+	}
+}
+
+// testRegions starts regions holding the same users, with wall clocks that
+// are skewed against each other and move in steps set by the test
+func testRegions(t *testing.T, n, users int, rng *rand.Rand) ([]*Region, []*services.LeaderboardService, *int64) {
+	t.Helper()
+	wall := new(int64)
+	var regions []*Region
+	var boards []*services.LeaderboardService
+	for i := 0; i < n; i++ {
+		service := services.NewLeaderboardService()
+		for u := 0; u < users; u++ {
+			name := fmt.Sprintf("user_%d", u)
+			service.AddUser(&models.User{ID: name, Username: name, Rating: 100 + u*97%4900})
+		}
+		skew := rng.Int63n(1000) - 500
+		clock := NewClock(fmt.Sprintf("r%d", i), func() time.Time { return time.Unix(0, *wall+skew) })
+		region := NewRegion(fmt.Sprintf("r%d", i), service, clock)
+		service.SetMutationLog(region)
+		regions = append(regions, region)
+		boards = append(boards, service)
+	}
+	return regions, boards, wall
+}
+
+// assertConverged checks every board is identical and holds the merged
+// ratings
+func assertConverged(t *testing.T, regions []*Region, boards []*services.LeaderboardService) {
+	t.Helper()
+	want := boards[0].GetUsersInRange(0, boards[0].GetUserCount())
+	for i, board := range boards {
+		got := board.GetUsersInRange(0, board.GetUserCount()+1)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Region %d differs from region 0:\n%+v\n%+v", i, got, want)
+		}
+		ids := boardIDs(t, board)
+		for username, user := range regions[i].State() {
+			if ranked, err := board.GetUserRank(username); err != nil || ranked.Rating != user.Rating() {
+				t.Fatalf("Region %d: %s has %+v on the board, %d merged", i, username, ranked, user.Rating())
+			}
+			if user.ID != "" && ids[username] != user.ID {
+				t.Fatalf("Region %d: %s has ID %q on the board, %q merged", i, username, ids[username], user.ID)
+			}
+		}
+		if want := boardIDs(t, boards[0]); !reflect.DeepEqual(ids, want) {
+			t.Fatalf("Region %d IDs differ from region 0:\n%v\n%v", i, ids, want)
+		}
+	}
+}
+
+// boardIDs maps each username on the board to its ID
+func boardIDs(t *testing.T, board *services.LeaderboardService) map[string]string {
+	t.Helper()
+	ids := make(map[string]string)
+	err := board.WithView(func(v services.View) error {
+		return v.Each(func(u models.User) error {
+			ids[u.Username] = u.ID
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// TestPartitionConvergence runs random sets, increments and adds on regions
+// split into random partitions that only merge within themselves, then heals
+// the partitions and expects identical boards
+func TestPartitionConvergence(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		regions, boards, wall := testRegions(t, 4, 20, rng)
+
+		for round := 0; round < 10; round++ {
+			group := make([]int, len(regions))
+			for i := range group {
+				group[i] = rng.Intn(2)
+			}
+
+			for op := 0; op < 30; op++ {
+				*wall += rng.Int63n(300) // sometimes less than the skew
+				i := rng.Intn(len(regions))
+				username := fmt.Sprintf("user_%d", rng.Intn(25))
+				switch rng.Intn(4) {
+				case 0:
+					boards[i].UpdateRating(username, 100+rng.Intn(4901))
+				case 1:
+					regions[i].IncrementRating(username, rng.Intn(601)-300)
+				case 2:
+					// Regions adding the same user pick different IDs
+					id := fmt.Sprintf("%s@r%d", username, i)
+					boards[i].AddUser(&models.User{ID: id, Username: username, Rating: 100 + rng.Intn(4901)})
+				case 3:
+					j := rng.Intn(len(regions))
+					if group[i] == group[j] {
+						if err := regions[i].Merge(regions[j].State()); err != nil {
+							t.Fatalf("Seed %d: %v", seed, err)
+						}
+					}
+				}
+			}
+		}
+
+		// Heal: one pass spreads every update to region 0 and back
+		for pass := 0; pass < 2; pass++ {
+			for i := range regions {
+				for j := range regions {
+					if err := regions[i].Merge(regions[j].State()); err != nil {
+						t.Fatalf("Seed %d: %v", seed, err)
+					}
+				}
+			}
+		}
+		assertConverged(t, regions, boards)
+	}
+}
+
+func TestRefusesUnmergeableMutations(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	_, boards, _ := testRegions(t, 1, 2, rng)
+	if err := boards[0].RenameUser("user_0", "other"); err == nil {
+		t.Error("Expected a rename to be refused")
+	}
+	if err := boards[0].RemoveUser("user_0"); err == nil {
+		t.Error("Expected a removal to be refused")
+	}
+	if boards[0].GetUserCount() != 2 {
+		t.Errorf("Expected the board unchanged, got %d users", boards[0].GetUserCount())
+	}
+}
+
+func TestSync(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	regions, boards, wall := testRegions(t, 2, 5, rng)
+	*wall = 1000
+	servers := make([]*httptest.Server, len(regions))
+	for i, region := range regions {
+		servers[i] = httptest.NewServer(region.Handler())
+		defer servers[i].Close()
+	}
+
+	boards[0].UpdateRating("user_1", 4000)
+	regions[0].IncrementRating("user_2", 50)
+	regions[1].IncrementRating("user_2", 25)
+	boards[1].AddUser(&models.User{ID: "new", Username: "new", Rating: 2500})
+
+	resp, err := http.Post(servers[1].URL+"/v1/increment-user-score", "application/json", strings.NewReader(`{"username":"user_3","delta":-40}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected increment to succeed, got %d", resp.StatusCode)
+	}
+
+	if err := regions[0].Sync(context.Background(), http.DefaultClient, servers[1].URL); err != nil {
+		t.Fatal(err)
+	}
+	assertConverged(t, regions, boards)
+
+	user, _ := boards[1].GetUserRank("user_2")
+	if want := 100 + 2*97 + 75; user.Rating != want {
+		t.Errorf("Expected both increments to count, got %d want %d", user.Rating, want)
+	}
+}
+
+func TestIncrementErrors(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	regions, boards, _ := testRegions(t, 1, 5, rng)
+	server := httptest.NewServer(regions[0].Handler())
+	defer server.Close()
+	before, _ := boards[0].GetUserRank("user_1")
+
+	tests := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"username":"nobody","delta":5}`, http.StatusNotFound, models.ErrorNotFound},
+		{`{"username":"user_1","delta":10000}`, http.StatusBadRequest, models.ErrorInvalidRequest},
+		{`{"username":"user_1","delta":-9223372036854775808}`, http.StatusBadRequest, models.ErrorInvalidRequest},
+		{`{"username":""}`, http.StatusBadRequest, models.ErrorInvalidRequest},
+		{`{`, http.StatusBadRequest, models.ErrorInvalidRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(server.URL+"/v1/increment-user-score", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var envelope models.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&envelope)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || envelope.Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", tt.body, tt.status, tt.code, resp.StatusCode, envelope)
+		}
+	}
+
+	// The refused increments left the counters alone
+	if rating, err := regions[0].IncrementRating("user_1", 1); err != nil || rating != before.Rating+1 {
+		t.Errorf("Expected %d after the refused increments, got %d, %v", before.Rating+1, rating, err)
+	}
+
+	resp, _ := http.Get(server.URL + "/v1/increment-user-score")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST" {
+		t.Errorf("Expected 405 allowing POST, got %d %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
@@ -0,0 +1,300 @@
+package crdt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"leaderboard/handlers"
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// Region is one active-active replica of the board. It is the service's
+// MutationLog, so ratings set through the service are recorded as registers
+// and reach the other regions on the next exchange. Adds are replicated too;
+// renames and removals cannot be merged and are refused.
+//
+// The state is kept in memory, so a region that restarts gets it back from
+// its peers.
+type Region struct {
+	id      string
+	service *services.LeaderboardService
+	clock   *Clock
+
+	mu    sync.Mutex // taken with the service's write lock held
+	state State
+}
+
+// NewRegion tracks service's ratings as region id. Attach it with
+// service.SetMutationLog. clock defaults to a wall clock for id.
+func NewRegion(id string, service *services.LeaderboardService, clock *Clock) *Region {
+	if clock == nil {
+		clock = NewClock(id, nil)
+	}
+	return &Region{id: id, service: service, clock: clock, state: make(State)}
+}
+
+// ID returns the region's name
+func (r *Region) ID() string {
+	return r.id
+}
+
+// Append records a rating the service is about to apply as the latest
+// write for the user
+func (r *Region) Append(m models.Mutation) error {
+	switch m.Op {
+	case models.MutationAdd, models.MutationUpdate:
+	default:
+		return fmt.Errorf("crdt: %s cannot be merged across regions", m.Op)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.state[m.Username].clone()
+	user.ID = max(user.ID, m.ID)
+	user.set(Register{Rating: m.Rating, Clock: r.clock.Now()})
+	r.state[m.Username] = user
+	return nil
+}
+
+// MaxDelta bounds an increment either way. It is the width of the rating
+// range, so anything larger only grows the counters toward overflow.
+const MaxDelta = 4900
+
+// ErrInvalidDelta is an increment beyond MaxDelta
+var ErrInvalidDelta = errors.New("crdt: delta must be between -4900 and 4900")
+
+// IncrementRating adds delta to a user's rating and returns the new rating.
+// Unlike a set, increments made in different regions at the same time all
+// count.
+func (r *Region) IncrementRating(username string, delta int) (int, error) {
+	if delta < -MaxDelta || delta > MaxDelta {
+		return 0, ErrInvalidDelta
+	}
+
+	var rating int
+	err := r.service.WithTx(func(tx services.Tx) error {
+		current, ok := tx.Get(username)
+		if !ok {
+			return &services.NotFoundError{Username: username}
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		user, seen := r.state[username]
+		if !seen {
+			// The board's rating stands as a register older than any write
+			user = UserState{ID: current.ID, Register: Register{Rating: current.Rating}}
+		}
+		user = user.clone()
+		user.increment(r.id, delta)
+		r.state[username] = user
+
+		rating = user.Rating()
+		return tx.Apply(models.Mutation{Op: models.MutationUpdate, Username: username, Rating: rating})
+	})
+	return rating, err
+}
+
+// State returns a copy of everything the region knows
+func (r *Region) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := make(State, len(r.state))
+	for username, user := range r.state {
+		state[username] = user.clone()
+	}
+	return state
+}
+
+// Merge joins another region's state into this one and applies the users
+// whose rating changed to the board
+func (r *Region) Merge(other State) error {
+	return r.service.WithTx(func(tx services.Tx) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		for username, theirs := range other {
+			r.clock.Observe(theirs.latest())
+
+			user, seen := r.state[username]
+			if seen {
+				user = user.clone()
+				if !user.merge(theirs) {
+					continue
+				}
+			} else {
+				user = theirs.clone()
+				user.prune()
+			}
+			r.state[username] = user
+
+			if err := materialize(tx, username, user); err != nil {
+				return fmt.Errorf("crdt: merging %s: %w", username, err)
+			}
+		}
+		return nil
+	})
+}
+
+// materialize puts a user's merged ID and rating on the board. Updates only
+// carry a rating, so a user whose ID changed is removed and added again. A
+// user only ever set, never added, in the regions has no merged ID and keeps
+// the one on the board.
+func materialize(tx services.Tx, username string, user UserState) error {
+	rating := user.Rating()
+	current, ok := tx.Get(username)
+	if ok && user.ID != "" && current.ID != user.ID {
+		if err := tx.Apply(models.Mutation{Op: models.MutationRemove, Username: username}); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		return tx.Apply(models.Mutation{Op: models.MutationAdd, ID: user.ID, Username: username, Rating: rating})
+	}
+	if current.Rating == rating {
+		return nil
+	}
+	return tx.Apply(models.Mutation{Op: models.MutationUpdate, Username: username, Rating: rating})
+}
+
+// Handler serves the region's side of an exchange, and increments:
+//
+//	GET  /crdt/state              - the region's State
+//	POST /crdt/state              - merge a State, answering with the result
+//	POST /v1/increment-user-score - add delta to a user's rating
+//
+// /increment-user-score is served too, as the deprecated alias the server
+// marks as such.
+func (r *Region) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/crdt/state", r.serveState)
+	mux.HandleFunc("/v1/increment-user-score", r.serveIncrement)
+	mux.HandleFunc("/increment-user-score", r.serveIncrement)
+	return mux
+}
+
+func (r *Region) serveState(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var other State
+		if err := json.NewDecoder(req.Body).Decode(&other); err != nil {
+			handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid request body", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if err := r.Merge(other); err != nil {
+			handlers.WriteError(w, http.StatusInternalServerError, models.ErrorInternal, err.Error(), nil)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		handlers.MethodNotAllowed(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.State())
+}
+
+func (r *Region) serveIncrement(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		handlers.MethodNotAllowed(w, req)
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+		Delta    int    `json:"delta"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid request body", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if input.Username == "" {
+		handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Username is required", map[string]interface{}{"field": "username"})
+		return
+	}
+
+	if _, err := r.IncrementRating(input.Username, input.Delta); errors.Is(err, ErrInvalidDelta) {
+		handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, err.Error(), map[string]interface{}{
+			"field": "delta",
+			"min":   -MaxDelta,
+			"max":   MaxDelta,
+		})
+		return
+	} else if err != nil {
+		handlers.WriteServiceError(w, err)
+		return
+	}
+	user, err := r.service.GetUserRank(input.Username)
+	if err != nil {
+		handlers.WriteServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "User score incremented",
+		"user":    user,
+	})
+}
+
+// Sync exchanges state with the region at peerURL in one round trip: the
+// peer merges this region's state and answers with its own, merged here
+func (r *Region) Sync(ctx context.Context, client *http.Client, peerURL string) error {
+	body, err := json.Marshal(r.State())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(peerURL, "/")+"/crdt/state", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("crdt: peer %s answered %s: %s", peerURL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var other State
+	if err := json.NewDecoder(resp.Body).Decode(&other); err != nil {
+		return fmt.Errorf("crdt: reading state from %s: %w", peerURL, err)
+	}
+	return r.Merge(other)
+}
+
+// Run syncs with every peer each interval until ctx is done. A peer that
+// cannot be reached is retried on the next round; its updates are merged
+// once the partition heals.
+func (r *Region) Run(ctx context.Context, client *http.Client, peers []string, interval time.Duration, onError func(peer string, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, peer := range peers {
+			if err := r.Sync(ctx, client, peer); err != nil && ctx.Err() == nil && onError != nil {
+				onError(peer, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package crdt
+
+// Register is a rating set at Clock. The later write wins; the zero Clock
+// stands for the board as it was before any region changed the user.
+type Register struct {
+	Rating int       `json:"rating"`
+	Clock  Timestamp `json:"clock"`
+}
+
+// newer reports whether r wins over o. Registers with the same clock can
+// only be zero-clock ones, which are ordered by rating.
+func (r Register) newer(o Register) bool {
+	if r.Clock != o.Clock {
+		return o.Clock.Less(r.Clock)
+	}
+	return r.Rating > o.Rating
+}
+
+// Counter is the increments one region made to the register set at Base.
+// Both totals only grow, so merging takes the larger of each.
+type Counter struct {
+	Base Timestamp `json:"base"`
+	Inc  uint64    `json:"inc,omitempty"`
+	Dec  uint64    `json:"dec,omitempty"`
+}
+
+// UserState is everything the regions know about one user's rating.
+// Counters are by region, and only those built on the current register
+// count; a newer register discards the increments made before it.
+type UserState struct {
+	ID       string             `json:"id,omitempty"`
+	Register Register           `json:"register"`
+	Counters map[string]Counter `json:"counters,omitempty"`
+}
+
+// Rating is the register plus its increments, kept between 100 and 5000
+func (u UserState) Rating() int {
+	rating := int64(u.Register.Rating)
+	for _, c := range u.Counters {
+		if c.Base == u.Register.Clock {
+			rating += int64(c.Inc) - int64(c.Dec)
+		}
+	}
+	return int(min(max(rating, 100), 5000))
+}
+
+// increment adds delta to region's counter on the current register
+func (u *UserState) increment(region string, delta int) {
+	c := u.Counters[region]
+	if c.Base != u.Register.Clock {
+		c = Counter{Base: u.Register.Clock}
+	}
+	if delta >= 0 {
+		c.Inc += uint64(delta)
+	} else {
+		c.Dec += uint64(-delta)
+	}
+	if u.Counters == nil {
+		u.Counters = make(map[string]Counter)
+	}
+	u.Counters[region] = c
+}
+
+// set replaces the register, which drops every increment made before it
+func (u *UserState) set(r Register) {
+	u.Register = r
+	u.prune()
+}
+
+// merge joins o into u and reports whether u changed. Merging is
+// commutative, associative and idempotent, so regions that have seen the
+// same updates hold the same state whatever order they merged in.
+func (u *UserState) merge(o UserState) bool {
+	changed := false
+	if o.ID > u.ID {
+		u.ID = o.ID
+		changed = true
+	}
+	if o.Register.newer(u.Register) {
+		u.Register = o.Register
+		changed = true
+	}
+	for region, theirs := range o.Counters {
+		ours, ok := u.Counters[region]
+		switch {
+		case !ok || ours.Base.Less(theirs.Base):
+			ours = theirs
+		case ours.Base == theirs.Base && (theirs.Inc > ours.Inc || theirs.Dec > ours.Dec):
+			ours.Inc, ours.Dec = max(ours.Inc, theirs.Inc), max(ours.Dec, theirs.Dec)
+		default:
+			continue
+		}
+		if u.Counters == nil {
+			u.Counters = make(map[string]Counter)
+		}
+		u.Counters[region] = ours
+		changed = true
+	}
+	u.prune()
+	return changed
+}
+
+// prune drops counters on registers that have been replaced; they no longer
+// count towards the rating
+func (u *UserState) prune() {
+	for region, c := range u.Counters {
+		if c.Base.Less(u.Register.Clock) {
+			delete(u.Counters, region)
+		}
+	}
+	if len(u.Counters) == 0 {
+		u.Counters = nil
+	}
+}
+
+func (u UserState) clone() UserState {
+	counters := u.Counters
+	if counters != nil {
+		u.Counters = make(map[string]Counter, len(counters))
+		for region, c := range counters {
+			u.Counters[region] = c
+		}
+	}
+	return u
+}
+
+// latest returns the latest timestamp in u
+func (u UserState) latest() Timestamp {
+	latest := u.Register.Clock
+	for _, c := range u.Counters {
+		if latest.Less(c.Base) {
+			latest = c.Base
+		}
+	}
+	return latest
+}
+
+// State is what a region knows about every user it has seen change, by
+// username
+type State map[string]UserState
@@ -0,0 +1,217 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeySize is the AES-256 key length in bytes
+const KeySize = 32
+
+// fingerprintSize is the length of the key check value stored beside
+// encrypted data, so a wrong key is told apart from damaged data
+const fingerprintSize = 8
+
+var (
+	// ErrNoKey means the data is encrypted but no keyring is configured
+	ErrNoKey = errors.New("encryption: data is encrypted but no key is configured")
+	// ErrUnknownKey means the data names a key id missing from the keyring
+	ErrUnknownKey = errors.New("encryption: data was encrypted with a key that is not in the keyring")
+	// ErrWrongKey means the keyring holds a different key under the data's key id
+	ErrWrongKey = errors.New("encryption: wrong key for data")
+	// ErrDecrypt means the data failed authentication under the right key
+	ErrDecrypt = errors.New("encryption: decryption failed, data is damaged")
+)
+
+// IsKeyError reports whether err means the configured keys cannot read the
+// data, as opposed to the data being damaged
+func IsKeyError(err error) bool {
+	return errors.Is(err, ErrNoKey) || errors.Is(err, ErrUnknownKey) || errors.Is(err, ErrWrongKey)
+}
+
+type key struct {
+	aead        cipher.AEAD
+	fingerprint []byte
+}
+
+// Keyring holds the AES-GCM keys by id. New data is written with the current
+// key; the others only read data written before a rotation.
+type Keyring struct {
+	current string
+	keys    map[string]key
+}
+
+// ParseKeyring reads "id:base64key" entries separated by commas or newlines.
+// The first entry is the current key. Blank lines and lines starting with #
+// are skipped.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]key)}
+
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("invalid key entry %q, expected id:base64key", entry)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(raw) != KeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes of base64", id, KeySize)
+		}
+		if err := k.add(id, raw); err != nil {
+			return nil, err
+		}
+		if k.current == "" {
+			k.current = id
+		}
+	}
+
+	if k.current == "" {
+		return nil, errors.New("no keys given")
+	}
+	return k, nil
+}
+
+// LoadKeyring reads keys from inline, or from the file at path. With neither
+// set it returns nil, which leaves data unencrypted.
+func LoadKeyring(inline, path string) (*Keyring, error) {
+	switch {
+	case inline != "" && path != "":
+		return nil, errors.New("set either a key or a key file, not both")
+	case inline != "":
+		return ParseKeyring(inline)
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKeyring(string(data))
+	default:
+		return nil, nil
+	}
+}
+
+// GenerateKey returns a new "id:base64key" entry
+func GenerateKey(id string) (string, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+func (k *Keyring) add(id string, raw []byte) error {
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append([]byte("leaderboard key check\x00"), raw...))
+	k.keys[id] = key{aead: aead, fingerprint: sum[:fingerprintSize]}
+	return nil
+}
+
+// CurrentID is the id of the key new data is written with
+func (k *Keyring) CurrentID() string { return k.current }
+
+// lookup finds the key for id and checks it matches fingerprint
+func (k *Keyring) lookup(id string, fingerprint []byte) (key, error) {
+	if k == nil {
+		return key{}, ErrNoKey
+	}
+	kk, ok := k.keys[id]
+	if !ok {
+		return key{}, fmt.Errorf("%w (key id %q)", ErrUnknownKey, id)
+	}
+	if string(kk.fingerprint) != string(fingerprint) {
+		return key{}, fmt.Errorf("%w (key id %q)", ErrWrongKey, id)
+	}
+	return kk, nil
+}
+
+// Check reports whether the keyring can open data sealed under h
+func (k *Keyring) Check(h KeyHeader) error {
+	_, err := k.lookup(h.ID, h.Fingerprint)
+	return err
+}
+
+// KeyHeader identifies the key data was sealed with. It is written in the
+// clear ahead of the data.
+type KeyHeader struct {
+	ID          string
+	Fingerprint []byte
+}
+
+// CurrentHeader is the header for data sealed with the current key
+func (k *Keyring) CurrentHeader() KeyHeader {
+	return KeyHeader{ID: k.current, Fingerprint: k.keys[k.current].fingerprint}
+}
+
+// AppendHeader encodes h as a length-prefixed id and the fingerprint
+func AppendHeader(b []byte, h KeyHeader) []byte {
+	b = append(b, byte(len(h.ID)))
+	b = append(b, h.ID...)
+	return append(b, h.Fingerprint...)
+}
+
+// ReadHeader decodes a header written by AppendHeader
+func ReadHeader(r io.Reader) (KeyHeader, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return KeyHeader{}, err
+	}
+	buf := make([]byte, int(n[0])+fingerprintSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return KeyHeader{}, err
+	}
+	return KeyHeader{ID: string(buf[:n[0]]), Fingerprint: buf[n[0]:]}, nil
+}
+
+// Seal encrypts one message under the key named by h, returning a random
+// nonce followed by the ciphertext
+func (k *Keyring) Seal(h KeyHeader, plaintext, aad []byte) ([]byte, error) {
+	kk, err := k.lookup(h.ID, h.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, kk.aead.NonceSize(), kk.aead.NonceSize()+len(plaintext)+kk.aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	return kk.aead.Seal(out, out, plaintext, aad), nil
+}
+
+// Open decrypts a message from Seal under the key named by h
+func (k *Keyring) Open(h KeyHeader, sealed, aad []byte) ([]byte, error) {
+	kk, err := k.lookup(h.ID, h.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	ns := kk.aead.NonceSize()
+	if len(sealed) < ns+kk.aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := kk.aead.Open(nil, sealed[:ns], sealed[ns:], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,153 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeyring builds a keyring from freshly generated keys, the first current
+func testKeyring(t *testing.T, ids ...string) (*Keyring, []string) {
+	t.Helper()
+	entries := make([]string, len(ids))
+	for i, id := range ids {
+		entry, err := GenerateKey(id)
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+		entries[i] = entry
+	}
+	k, err := ParseKeyring(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	return k, entries
+}
+
+func TestParseKeyring(t *testing.T) {
+	_, entries := testKeyring(t, "new", "old")
+
+	// Newline separated with comments, as in a key file
+	k, err := ParseKeyring("# rotated 2024-05\n" + entries[0] + "\n\n" + entries[1] + "\n")
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	if k.CurrentID() != "new" {
+		t.Errorf("Expected current key new, got %q", k.CurrentID())
+	}
+	if err := k.Check(KeyHeader{ID: "old", Fingerprint: k.keys["old"].fingerprint}); err != nil {
+		t.Errorf("Expected old key to stay readable: %v", err)
+	}
+
+	bad := []string{
+		"",
+		"# only a comment",
+		"nocolon",
+		":" + strings.SplitN(entries[0], ":", 2)[1],
+		"short:c2hvcnQ=",
+		"bad:not base64!",
+		entries[0] + "," + entries[0],
+	}
+	for _, s := range bad {
+		if _, err := ParseKeyring(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	_, entries := testKeyring(t, "k1")
+
+	if k, err := LoadKeyring("", ""); k != nil || err != nil {
+		t.Errorf("Expected no keyring when unset, got %v %v", k, err)
+	}
+	if k, err := LoadKeyring(entries[0], ""); err != nil || k.CurrentID() != "k1" {
+		t.Errorf("Expected inline key, got %v %v", k, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(path, []byte(entries[0]+"\n"), 0o600)
+	if k, err := LoadKeyring("", path); err != nil || k.CurrentID() != "k1" {
+		t.Errorf("Expected key from file, got %v %v", k, err)
+	}
+
+	if _, err := LoadKeyring(entries[0], path); err == nil {
+		t.Error("Expected error with both a key and a key file")
+	}
+	if _, err := LoadKeyring("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error for a missing key file")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+	h := k.CurrentHeader()
+
+	sealed, err := k.Seal(h, []byte("payload"), []byte("aad"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("payload")) {
+		t.Error("Expected sealed data not to contain the plaintext")
+	}
+
+	got, err := k.Open(h, sealed, []byte("aad"))
+	if err != nil || string(got) != "payload" {
+		t.Errorf("Expected payload back, got %q %v", got, err)
+	}
+
+	// Damage and mismatched associated data fail authentication
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := k.Open(h, sealed, []byte("aad")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for damaged data, got %v", err)
+	}
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := k.Open(h, sealed, []byte("other")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for other aad, got %v", err)
+	}
+	if _, err := k.Open(h, sealed[:4], nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for short data, got %v", err)
+	}
+}
+
+func TestKeyErrors(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+	h := k.CurrentHeader()
+
+	// Same id, different key material
+	other, _ := testKeyring(t, "k1")
+	if err := other.Check(h); !errors.Is(err, ErrWrongKey) || !IsKeyError(err) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+
+	rotated, _ := testKeyring(t, "k2")
+	if err := rotated.Check(h); !errors.Is(err, ErrUnknownKey) || !IsKeyError(err) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	var none *Keyring
+	if err := none.Check(h); !errors.Is(err, ErrNoKey) || !IsKeyError(err) {
+		t.Errorf("Expected ErrNoKey, got %v", err)
+	}
+
+	if IsKeyError(ErrDecrypt) {
+		t.Error("Damaged data is not a key error")
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+	h := k.CurrentHeader()
+
+	b := AppendHeader([]byte("x"), h)
+	got, err := ReadHeader(bytes.NewReader(b[1:]))
+	if err != nil || got.ID != h.ID || !bytes.Equal(got.Fingerprint, h.Fingerprint) {
+		t.Errorf("Expected %+v, got %+v %v", h, got, err)
+	}
+	if _, err := ReadHeader(bytes.NewReader(b[1:4])); err == nil {
+		t.Error("Expected error for a short header")
+	}
+}
@@ -0,0 +1,204 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// An encrypted stream is the magic "LBEN", a key header and a random base
+// nonce, followed by chunks of a flag byte, a little-endian uint32 length
+// and up to ChunkSize bytes sealed with AES-GCM. Each chunk's nonce is the
+// base nonce with the chunk number mixed into its last eight bytes, and the
+// flag, set on the last chunk only, is authenticated, so chunks cannot be
+// reordered, dropped or cut off the end unnoticed.
+const (
+	streamMagic = "LBEN"
+	ChunkSize   = 64 * 1024
+	nonceSize   = 12
+)
+
+// IsEncrypted reports whether data starting with prefix is an encrypted stream
+func IsEncrypted(prefix []byte) bool {
+	return len(prefix) >= len(streamMagic) && string(prefix[:len(streamMagic)]) == streamMagic
+}
+
+type writer struct {
+	w       io.Writer
+	key     key
+	nonce   [nonceSize]byte
+	counter uint64
+	buf     []byte
+	out     []byte
+	closed  bool
+}
+
+// NewWriter encrypts everything written to it into w under the current key.
+// Close must be called to write the final chunk; it does not close w.
+func NewWriter(w io.Writer, k *Keyring) (io.WriteCloser, error) {
+	h := k.CurrentHeader()
+	kk, err := k.lookup(h.ID, h.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	sw := &writer{w: w, key: kk, buf: make([]byte, 0, ChunkSize)}
+	if _, err := rand.Read(sw.nonce[:]); err != nil {
+		return nil, err
+	}
+
+	header := AppendHeader([]byte(streamMagic), h)
+	header = append(header, sw.nonce[:]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (sw *writer) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("encryption: write after close")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+		if len(sw.buf) == cap(sw.buf) {
+			if err := sw.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (sw *writer) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.flush(true)
+}
+
+func (sw *writer) flush(final bool) error {
+	flag := []byte{0}
+	if final {
+		flag[0] = 1
+	}
+	nonce := chunkNonce(sw.nonce, sw.counter)
+	sw.counter++
+
+	sw.out = append(sw.out[:0], flag[0], 0, 0, 0, 0)
+	sw.out = sw.key.aead.Seal(sw.out, nonce[:], sw.buf, flag)
+	binary.LittleEndian.PutUint32(sw.out[1:5], uint32(len(sw.out)-5))
+	sw.buf = sw.buf[:0]
+
+	_, err := sw.w.Write(sw.out)
+	return err
+}
+
+func chunkNonce(base [nonceSize]byte, counter uint64) [nonceSize]byte {
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], counter)
+	for i := range c {
+		base[nonceSize-8+i] ^= c[i]
+	}
+	return base
+}
+
+type reader struct {
+	r       *bufio.Reader
+	key     key
+	nonce   [nonceSize]byte
+	counter uint64
+	plain   []byte
+	sealed  []byte
+	done    bool
+}
+
+// StreamHeader reads the start of a stream written by NewWriter and returns
+// the header of the key it is sealed with, leaving r at the base nonce
+func StreamHeader(r io.Reader) (KeyHeader, error) {
+	magic := make([]byte, len(streamMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !IsEncrypted(magic) {
+		return KeyHeader{}, errors.New("encryption: not an encrypted stream")
+	}
+	h, err := ReadHeader(r)
+	if err != nil {
+		return KeyHeader{}, ErrDecrypt
+	}
+	return h, nil
+}
+
+// NewReader decrypts a stream written by NewWriter. A missing or wrong key is
+// reported here; damaged data is reported by Read as ErrDecrypt.
+func NewReader(r io.Reader, k *Keyring) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	h, err := StreamHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	kk, err := k.lookup(h.ID, h.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &reader{r: br, key: kk}
+	if _, err := io.ReadFull(br, sr.nonce[:]); err != nil {
+		return nil, ErrDecrypt
+	}
+	return sr, nil
+}
+
+func (sr *reader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// next decrypts the following chunk
+func (sr *reader) next() error {
+	var head [5]byte
+	if _, err := io.ReadFull(sr.r, head[:]); err != nil {
+		return ErrDecrypt // ended without a final chunk
+	}
+	size := binary.LittleEndian.Uint32(head[1:5])
+	if size > ChunkSize+uint32(sr.key.aead.Overhead()) {
+		return ErrDecrypt
+	}
+	if cap(sr.sealed) < int(size) {
+		sr.sealed = make([]byte, size)
+	}
+	sealed := sr.sealed[:size]
+	if _, err := io.ReadFull(sr.r, sealed); err != nil {
+		return ErrDecrypt
+	}
+
+	nonce := chunkNonce(sr.nonce, sr.counter)
+	sr.counter++
+	plain, err := sr.key.aead.Open(sealed[:0], nonce[:], sealed, head[:1])
+	if err != nil {
+		return ErrDecrypt
+	}
+	sr.plain = plain
+
+	if head[0] == 1 {
+		sr.done = true
+		if _, err := sr.r.ReadByte(); err != io.EOF {
+			return ErrDecrypt // data after the final chunk
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func seal(t *testing.T, k *Keyring, plaintext []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, k)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func unseal(k *Keyring, data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), k)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+
+	// Empty, one partial chunk, exactly one chunk and several chunks
+	for _, size := range []int{0, 100, ChunkSize, 3*ChunkSize + 17} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		data := seal(t, k, plaintext)
+		if !IsEncrypted(data) {
+			t.Errorf("Size %d: expected stream magic, got % x", size, data[:4])
+		}
+		got, err := unseal(k, data)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("Size %d: round trip failed: %v", size, err)
+		}
+	}
+}
+
+func TestStreamDamage(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+	plaintext := make([]byte, 2*ChunkSize+10)
+	rand.Read(plaintext)
+	data := seal(t, k, plaintext)
+
+	// Cut after the first chunk, mid-chunk, and with data appended
+	chunk := 5 + ChunkSize + 16
+	header := len(data) - 3*5 - len(plaintext) - 3*16
+	cases := map[string][]byte{
+		"dropped tail":  data[:header+chunk],
+		"torn chunk":    data[:len(data)-3],
+		"trailing data": append(append([]byte{}, data...), 0),
+	}
+	flipped := append([]byte{}, data...)
+	flipped[header+chunk+10] ^= 0xff
+	cases["flipped byte"] = flipped
+
+	for name, damaged := range cases {
+		if _, err := unseal(k, damaged); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: expected ErrDecrypt, got %v", name, err)
+		}
+	}
+}
+
+func TestStreamKeys(t *testing.T) {
+	k, entries := testKeyring(t, "k1")
+	data := seal(t, k, []byte("board"))
+
+	other, _ := testKeyring(t, "k1")
+	if _, err := unseal(other, data); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+	if _, err := unseal(nil, data); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey, got %v", err)
+	}
+
+	// After a rotation the old key still reads old data
+	_, newer := testKeyring(t, "k2")
+	rotated, err := ParseKeyring(newer[0] + "," + entries[0])
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	if got, err := unseal(rotated, data); err != nil || string(got) != "board" {
+		t.Errorf("Expected old data readable after rotation, got %q %v", got, err)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("LBSN....")), k); err == nil {
+		t.Error("Expected error for a plaintext stream")
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"leaderboard/models"
+)
+
+// RequireToken only lets requests carrying token as a bearer token through
+// to next. An empty token turns the route off, since there is nothing a
+// caller could prove.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			WriteError(w, http.StatusUnauthorized, models.ErrorUnauthorized, "A valid admin token is required", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
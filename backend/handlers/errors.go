@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// WriteError sends the JSON error envelope. Everything that answers an API
+// request with an error uses it, the openapi validator included.
+func WriteError(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{Code: code, Message: message, Details: details})
+}
+
+// InvalidParameter reports a query or path parameter that cannot be used
+func InvalidParameter(w http.ResponseWriter, name, value, message string) {
+	WriteError(w, http.StatusBadRequest, models.ErrorInvalidParameter, message, map[string]interface{}{
+		"parameter": name,
+		"value":     value,
+	})
+}
+
+// WriteServiceError sends a service error with the status for its type
+func WriteServiceError(w http.ResponseWriter, err error) {
+	var notFound *services.NotFoundError
+	var duplicate *services.DuplicateError
+	var rating *services.InvalidRatingError
+	var invalidUser *services.InvalidUserError
+	switch {
+	case errors.As(err, &notFound):
+		WriteError(w, http.StatusNotFound, models.ErrorNotFound, err.Error(), map[string]interface{}{"username": notFound.Username})
+	case errors.As(err, &duplicate):
+		WriteError(w, http.StatusConflict, models.ErrorDuplicate, err.Error(), map[string]interface{}{"username": duplicate.Username})
+	case errors.As(err, &rating):
+		WriteError(w, http.StatusUnprocessableEntity, models.ErrorInvalidRating, err.Error(), map[string]interface{}{
+			"rating": rating.Rating,
+			"min":    100,
+			"max":    5000,
+		})
+	case errors.As(err, &invalidUser):
+		WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, err.Error(), map[string]interface{}{
+			"field": invalidUser.Field,
+			"max":   invalidUser.Max,
+		})
+	default:
+		WriteError(w, http.StatusInternalServerError, models.ErrorInternal, err.Error(), nil)
+	}
+}
+
+// NotFound answers requests for paths the API does not have
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusNotFound, models.ErrorNotFound, "No route for "+r.URL.Path, nil)
+}
+
+// MethodNotAllowed answers requests whose path exists with other methods.
+// The router has already set the Allow header.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusMethodNotAllowed, models.ErrorMethodNotAllowed, "Method not allowed", map[string]interface{}{
+		"allow": w.Header().Get("Allow"),
+	})
+}
@@ -9,7 +9,9 @@ import (
 	"strings"
 	"time"
 
+	"leaderboard/bulk"
 	"leaderboard/models"
+	"leaderboard/router"
 	"leaderboard/services"
 )
 
@@ -27,33 +29,58 @@ func NewHandler(service *services.LeaderboardService) *Handler {
 }
 
 func (h *Handler) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+	query := r.URL.Query()
 
+	// A limit above 1000 is capped rather than refused
 	limit := 100
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if val, err := strconv.Atoi(limitStr); err == nil && val > 0 {
-			limit = val
-			if limit > 1000 {
-				limit = 1000
-			}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		val, err := strconv.Atoi(limitStr)
+		if err != nil || val <= 0 {
+			InvalidParameter(w, "limit", limitStr, "limit must be a positive integer")
+			return
 		}
+		limit = min(val, 1000)
 	}
 
 	offset := 0
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if val, err := strconv.Atoi(offsetStr); err == nil && val >= 0 {
-			offset = val
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		val, err := strconv.Atoi(offsetStr)
+		if err != nil || val < 0 {
+			InvalidParameter(w, "offset", offsetStr, "offset must be a non-negative integer")
+			return
 		}
+		offset = val
 	}
 
-	users := h.service.GetUsersInRange(offset, limit)
+	var users []models.UserWithRank
+	var nextCursor string
+	if tier := query.Get("tier"); tier != "" {
+		tierUsers, err := h.service.GetUsersInTier(tier, offset, limit)
+		if err != nil {
+			InvalidParameter(w, "tier", tier, err.Error())
+			return
+		}
+		users = tierUsers
+	} else {
+		consistentStr := query.Get("consistent")
+		consistent, err := strconv.ParseBool(consistentStr)
+		if err != nil && consistentStr != "" {
+			InvalidParameter(w, "consistent", consistentStr, "consistent must be true or false")
+			return
+		}
+		cursor := query.Get("cursor")
+		page, next, err := h.service.GetUsersPage(cursor, offset, limit, consistent)
+		if err != nil {
+			InvalidParameter(w, "cursor", cursor, err.Error())
+			return
+		}
+		users, nextCursor = page, next
+	}
 
 	// Prepare response
 	response := models.LeaderboardResponse{
-		Users: users,
+		Users:      users,
+		NextCursor: nextCursor,
 	}
 
 	// Send JSON response
@@ -62,29 +89,96 @@ func (h *Handler) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// Returns the user's global rank, username, and rating
-func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
-	// Only allow GET method
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// Returns the number of users in each tier
+func (h *Handler) GetTiers(w http.ResponseWriter, r *http.Request) {
+	response := models.TiersResponse{
+		Tiers: h.service.GetTierSummary(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Returns tier promotion and relegation events, optionally for one user
+func (h *Handler) GetTierChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := 100
+	if limitStr := query.Get("limit"); limitStr != "" {
+		val, err := strconv.Atoi(limitStr)
+		if err != nil || val <= 0 {
+			InvalidParameter(w, "limit", limitStr, "limit must be a positive integer")
+			return
+		}
+		limit = min(val, 1000)
+	}
+
+	var since int64
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		val, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || val < 0 {
+			InvalidParameter(w, "since", sinceStr, "since must be a non-negative integer")
+			return
+		}
+		since = val
+	}
+
+	response := models.TierChangesResponse{
+		Events: h.service.GetTierChanges(query.Get("username"), since, limit),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Searches users by username prefix, optionally tolerating typos
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		InvalidParameter(w, "q", q, "Query parameter q is required")
 		return
 	}
 
-	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(pathParts) != 2 || pathParts[0] != "user" {
-		http.Error(w, "Invalid URL format. Expected: /user/{username}", http.StatusBadRequest)
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		val, err := strconv.Atoi(limitStr)
+		if err != nil || val <= 0 {
+			InvalidParameter(w, "limit", limitStr, "limit must be a positive integer")
+			return
+		}
+		limit = min(val, 100)
+	}
+
+	fuzzyStr := query.Get("fuzzy")
+	fuzzy, err := strconv.ParseBool(fuzzyStr)
+	if err != nil && fuzzyStr != "" {
+		InvalidParameter(w, "fuzzy", fuzzyStr, "fuzzy must be true or false")
 		return
 	}
 
-	username := pathParts[1]
+	response := models.LeaderboardResponse{
+		Users: h.service.SearchUsers(q, limit, fuzzy),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Returns the user's global rank, username, and rating
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	username := router.Param(r, "username")
 	if username == "" {
-		http.Error(w, "Username cannot be empty", http.StatusBadRequest)
+		InvalidParameter(w, "username", username, "Username cannot be empty")
 		return
 	}
 
 	userWithRank, err := h.service.GetUserRank(username)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		WriteServiceError(w, err)
 		return
 	}
 
@@ -96,34 +190,29 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 
 // Updates the rating of a specific user
 func (h *Handler) UpdateUserScore(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var input struct {
 		Username string `json:"username"`
 		Rating   int    `json:"rating"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid request body", map[string]interface{}{"error": err.Error()})
 		return
 	}
 
 	if input.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Username is required", map[string]interface{}{"field": "username"})
 		return
 	}
 
-	if err := h.service.UpdateRating(input.Username, input.Rating); err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	// The new rank comes from the locked board, since the read snapshot
+	// may not show the update yet
+	userWithRank, err := h.service.UpdateRatingRank(input.Username, input.Rating)
+	if err != nil {
+		WriteServiceError(w, err)
 		return
 	}
 
-	// Fetch updated user stats with new rank
-	userWithRank, _ := h.service.GetUserRank(input.Username)
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -134,16 +223,10 @@ func (h *Handler) UpdateUserScore(w http.ResponseWriter, r *http.Request) {
 
 // Randomly updates ratings to simulate score changes
 func (h *Handler) UpdateScore(w http.ResponseWriter, r *http.Request) {
-	// Only allow POST method
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Get all usernames
 	allUsernames := h.service.GetAllUsernames()
 	if len(allUsernames) == 0 {
-		http.Error(w, "No users in the system", http.StatusInternalServerError)
+		WriteError(w, http.StatusInternalServerError, models.ErrorInternal, "No users in the system", nil)
 		return
 	}
 
@@ -187,3 +270,61 @@ func (h *Handler) UpdateScore(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+// Streams users from a CSV or NDJSON body into the leaderboard
+func (h *Handler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	formatStr := query.Get("format")
+	if formatStr == "" {
+		switch strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]) {
+		case "text/csv":
+			formatStr = "csv"
+		case "application/x-ndjson", "application/jsonl":
+			formatStr = "ndjson"
+		}
+	}
+	format, err := bulk.ParseFormat(formatStr)
+	if err != nil {
+		InvalidParameter(w, "format", formatStr, err.Error())
+		return
+	}
+
+	mode, err := bulk.ParseMode(query.Get("mode"))
+	if err != nil {
+		InvalidParameter(w, "mode", query.Get("mode"), err.Error())
+		return
+	}
+
+	result, err := bulk.Import(r.Body, h.service, bulk.ImportOptions{Format: format, Mode: mode})
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, fmt.Sprintf("Failed to read request body: %v", err), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// Streams the whole ranked leaderboard as CSV or NDJSON
+func (h *Handler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	formatStr := r.URL.Query().Get("format")
+	if formatStr == "" {
+		formatStr = "csv"
+	}
+	format, err := bulk.ParseFormat(formatStr)
+	if err != nil {
+		InvalidParameter(w, "format", formatStr, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=leaderboard.%s", format))
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the stream short
+	if _, _, err := bulk.Export(w, h.service, format); err != nil {
+		fmt.Printf("Export stopped: %v\n", err)
+	}
+}
@@ -3,11 +3,15 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"leaderboard/models"
+	"leaderboard/router"
 	"leaderboard/services"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func setupTestHandler() *Handler {
@@ -16,6 +20,24 @@ func setupTestHandler() *Handler {
 	return NewHandler(service)
 }
 
+// routed mounts the handlers as the server does, which is where methods
+// are checked and path parameters come from
+func routed(h *Handler) *router.Router {
+	rt := router.New()
+	rt.NotFound = http.HandlerFunc(NotFound)
+	rt.MethodNotAllowed = http.HandlerFunc(MethodNotAllowed)
+	rt.HandleFunc(http.MethodGet, "/leaderboard", h.GetLeaderboard)
+	rt.HandleFunc(http.MethodGet, "/user/{username}", h.GetUser)
+	rt.HandleFunc(http.MethodGet, "/tiers", h.GetTiers)
+	rt.HandleFunc(http.MethodGet, "/events/tier-changes", h.GetTierChanges)
+	rt.HandleFunc(http.MethodGet, "/search", h.SearchUsers)
+	rt.HandleFunc(http.MethodPost, "/update-score", h.UpdateScore)
+	rt.HandleFunc(http.MethodPost, "/update-user-score", h.UpdateUserScore)
+	rt.HandleFunc(http.MethodPost, "/admin/import", h.ImportUsers)
+	rt.HandleFunc(http.MethodGet, "/export", h.ExportUsers)
+	return rt
+}
+
 func TestGetLeaderboard(t *testing.T) {
 	h := setupTestHandler()
 
@@ -34,7 +56,7 @@ func TestGetLeaderboard(t *testing.T) {
 	// Case 2: Invalid Method
 	reqPost, _ := http.NewRequest("POST", "/leaderboard", nil)
 	rrPost := httptest.NewRecorder()
-	h.GetLeaderboard(rrPost, reqPost)
+	routed(h).ServeHTTP(rrPost, reqPost)
 	if status := rrPost.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("POST returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
 	}
@@ -48,6 +70,172 @@ func TestGetLeaderboard(t *testing.T) {
 	}
 }
 
+func TestGetLeaderboardCursor(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "user1", Rating: 3000})
+	h.service.AddUser(&models.User{Username: "user2", Rating: 2000})
+	h.service.AddUser(&models.User{Username: "user3", Rating: 1000})
+
+	// Case 1: First page returns a cursor
+	req, _ := http.NewRequest("GET", "/leaderboard?limit=2&consistent=true", nil)
+	rr := httptest.NewRecorder()
+	h.GetLeaderboard(rr, req)
+	var first models.LeaderboardResponse
+	json.NewDecoder(rr.Body).Decode(&first)
+	if len(first.Users) != 2 || first.NextCursor == "" {
+		t.Fatalf("Expected 2 users and a cursor, got %+v", first)
+	}
+
+	// Case 2: Next page resumes after the cursor
+	reqNext, _ := http.NewRequest("GET", "/leaderboard?limit=2&cursor="+first.NextCursor, nil)
+	rrNext := httptest.NewRecorder()
+	h.GetLeaderboard(rrNext, reqNext)
+	var second models.LeaderboardResponse
+	json.NewDecoder(rrNext.Body).Decode(&second)
+	if len(second.Users) != 1 || second.Users[0].Username != "user3" || second.NextCursor != "" {
+		t.Errorf("Unexpected second page: %+v", second)
+	}
+
+	// Case 3: Invalid cursor
+	reqBad, _ := http.NewRequest("GET", "/leaderboard?cursor=bad", nil)
+	rrBad := httptest.NewRecorder()
+	h.GetLeaderboard(rrBad, reqBad)
+	if status := rrBad.Code; status != http.StatusBadRequest {
+		t.Errorf("Invalid cursor returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestGetLeaderboardTier(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "gold", Rating: 2500})
+	h.service.AddUser(&models.User{Username: "bronze", Rating: 300})
+
+	// Case 1: Known tier
+	req, _ := http.NewRequest("GET", "/leaderboard?tier=Gold", nil)
+	rr := httptest.NewRecorder()
+	h.GetLeaderboard(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Tier filter returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response models.LeaderboardResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Users) != 1 || response.Users[0].Username != "gold" {
+		t.Errorf("Unexpected tier users: %+v", response.Users)
+	}
+
+	// Case 2: Unknown tier
+	reqBad, _ := http.NewRequest("GET", "/leaderboard?tier=Wood", nil)
+	rrBad := httptest.NewRecorder()
+	h.GetLeaderboard(rrBad, reqBad)
+	if status := rrBad.Code; status != http.StatusBadRequest {
+		t.Errorf("Unknown tier returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestGetTiers(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "gold", Rating: 2500})
+
+	// Case 1: Valid GET
+	req, _ := http.NewRequest("GET", "/tiers", nil)
+	rr := httptest.NewRecorder()
+	h.GetTiers(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("GET returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response models.TiersResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Tiers) == 0 {
+		t.Error("Expected tiers in response")
+	}
+
+	// Case 2: Invalid Method
+	reqPost, _ := http.NewRequest("POST", "/tiers", nil)
+	rrPost := httptest.NewRecorder()
+	routed(h).ServeHTTP(rrPost, reqPost)
+	if status := rrPost.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestGetTierChanges(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "a", Rating: 500})
+	h.service.AddUser(&models.User{Username: "b", Rating: 500})
+	h.service.UpdateRating("a", 2500)
+	h.service.UpdateRating("b", 3500)
+
+	// Case 1: All events
+	req, _ := http.NewRequest("GET", "/events/tier-changes", nil)
+	rr := httptest.NewRecorder()
+	h.GetTierChanges(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("GET returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response models.TierChangesResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Events) != 2 {
+		t.Errorf("Expected 2 events, got %d", len(response.Events))
+	}
+
+	// Case 2: Per user with since
+	reqUser, _ := http.NewRequest("GET", "/events/tier-changes?username=b&since=0&limit=5", nil)
+	rrUser := httptest.NewRecorder()
+	h.GetTierChanges(rrUser, reqUser)
+	var userResponse models.TierChangesResponse
+	json.NewDecoder(rrUser.Body).Decode(&userResponse)
+	if len(userResponse.Events) != 1 || userResponse.Events[0].Username != "b" {
+		t.Errorf("Unexpected user events: %+v", userResponse.Events)
+	}
+
+	// Case 3: Invalid Method
+	reqPost, _ := http.NewRequest("POST", "/events/tier-changes", nil)
+	rrPost := httptest.NewRecorder()
+	routed(h).ServeHTTP(rrPost, reqPost)
+	if status := rrPost.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "ankit", Rating: 2500})
+	h.service.AddUser(&models.User{Username: "bob", Rating: 1500})
+
+	// Case 1: Prefix match
+	req, _ := http.NewRequest("GET", "/search?q=AN&limit=5", nil)
+	rr := httptest.NewRecorder()
+	h.SearchUsers(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("GET returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response models.LeaderboardResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Users) != 1 || response.Users[0].Username != "ankit" || response.Users[0].Rank != 1 {
+		t.Errorf("Unexpected search results: %+v", response.Users)
+	}
+
+	// Case 2: Missing query
+	reqMissing, _ := http.NewRequest("GET", "/search", nil)
+	rrMissing := httptest.NewRecorder()
+	h.SearchUsers(rrMissing, reqMissing)
+	if status := rrMissing.Code; status != http.StatusBadRequest {
+		t.Errorf("Missing query returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Case 3: Invalid Method
+	reqPost, _ := http.NewRequest("POST", "/search?q=a", nil)
+	rrPost := httptest.NewRecorder()
+	routed(h).ServeHTTP(rrPost, reqPost)
+	if status := rrPost.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
 func TestGetUser(t *testing.T) {
 	h := setupTestHandler()
 	h.service.AddUser(&models.User{Username: "target_user", Rating: 1500})
@@ -55,7 +243,7 @@ func TestGetUser(t *testing.T) {
 	// Case 1: Valid User
 	req, _ := http.NewRequest("GET", "/user/target_user", nil)
 	rr := httptest.NewRecorder()
-	h.GetUser(rr, req)
+	routed(h).ServeHTTP(rr, req)
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("Valid user: handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
@@ -63,7 +251,7 @@ func TestGetUser(t *testing.T) {
 	// Case 2: User Not Found
 	reqNotFound, _ := http.NewRequest("GET", "/user/non_existent", nil)
 	rrNotFound := httptest.NewRecorder()
-	h.GetUser(rrNotFound, reqNotFound)
+	routed(h).ServeHTTP(rrNotFound, reqNotFound)
 	if status := rrNotFound.Code; status != http.StatusNotFound {
 		t.Errorf("User not found: handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
 	}
@@ -71,33 +259,35 @@ func TestGetUser(t *testing.T) {
 	// Case 3: Invalid Method
 	reqPost, _ := http.NewRequest("POST", "/user/target_user", nil)
 	rrPost := httptest.NewRecorder()
-	h.GetUser(rrPost, reqPost)
+	routed(h).ServeHTTP(rrPost, reqPost)
 	if status := rrPost.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("POST returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
 	}
 
-	// Case 4: Invalid URL Format
-	reqBad, _ := http.NewRequest("GET", "/user/too/many/parts", nil)
-	rrBad := httptest.NewRecorder()
-	h.GetUser(rrBad, reqBad)
-	if status := rrBad.Code; status != http.StatusBadRequest {
-		t.Errorf("Bad URL returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	// Case 4: Paths without exactly one username segment are not routed
+	for _, path := range []string{"/user/too/many/parts", "/user/", "/wrong/prefix"} {
+		reqBad, _ := http.NewRequest("GET", path, nil)
+		rrBad := httptest.NewRecorder()
+		routed(h).ServeHTTP(rrBad, reqBad)
+		if status := rrBad.Code; status != http.StatusNotFound {
+			t.Errorf("%s returned wrong status code: got %v want %v", path, status, http.StatusNotFound)
+		}
 	}
 
-	// Case 5: Empty Username
-	reqEmpty, _ := http.NewRequest("GET", "/user/", nil)
-	rrEmpty := httptest.NewRecorder()
-	h.GetUser(rrEmpty, reqEmpty)
-	if status := rrEmpty.Code; status != http.StatusBadRequest {
-		t.Errorf("Empty username returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	// Case 5: Called without the router there is no username
+	reqDirect, _ := http.NewRequest("GET", "/user/target_user", nil)
+	rrDirect := httptest.NewRecorder()
+	h.GetUser(rrDirect, reqDirect)
+	if status := rrDirect.Code; status != http.StatusBadRequest {
+		t.Errorf("Unrouted request returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 
-	// Case 6: Wrong Path Prefix (manual call)
-	reqWrong, _ := http.NewRequest("GET", "/wrong/prefix", nil)
-	rrWrong := httptest.NewRecorder()
-	h.GetUser(rrWrong, reqWrong)
-	if status := rrWrong.Code; status != http.StatusBadRequest {
-		t.Errorf("Wrong prefix returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	// Case 6: HEAD is answered like GET
+	reqHead, _ := http.NewRequest("HEAD", "/user/target_user", nil)
+	rrHead := httptest.NewRecorder()
+	routed(h).ServeHTTP(rrHead, reqHead)
+	if status := rrHead.Code; status != http.StatusOK {
+		t.Errorf("HEAD returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
@@ -121,7 +311,7 @@ func TestUpdateUserScore(t *testing.T) {
 	// Case 2: Invalid Method
 	reqGet, _ := http.NewRequest("GET", "/update-user-score", nil)
 	rrGet := httptest.NewRecorder()
-	h.UpdateUserScore(rrGet, reqGet)
+	routed(h).ServeHTTP(rrGet, reqGet)
 	if status := rrGet.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("GET returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
 	}
@@ -149,6 +339,20 @@ func TestUpdateUserScore(t *testing.T) {
 	if status := rrGhost.Code; status != http.StatusNotFound {
 		t.Errorf("Ghost user returned wrong status code: got %v want %v", status, http.StatusNotFound)
 	}
+
+	// Case 6: The writer sees its update before the read snapshot does
+	h.service.EnableSnapshotReads(time.Hour)
+	defer h.service.DisableSnapshotReads()
+	reqSnap, _ := http.NewRequest("POST", "/update-user-score", bytes.NewBufferString(`{"username": "updater", "rating": 3000}`))
+	rrSnap := httptest.NewRecorder()
+	h.UpdateUserScore(rrSnap, reqSnap)
+	var resp struct {
+		User models.UserWithRank `json:"user"`
+	}
+	json.NewDecoder(rrSnap.Body).Decode(&resp)
+	if rrSnap.Code != http.StatusOK || resp.User.Rating != 3000 {
+		t.Errorf("Expected the new rating 3000 back, got %d %+v", rrSnap.Code, resp.User)
+	}
 }
 
 func TestUpdateScore(t *testing.T) {
@@ -175,8 +379,265 @@ func TestUpdateScore(t *testing.T) {
 	// Case 3: Invalid Method
 	reqGet, _ := http.NewRequest("GET", "/update-score", nil)
 	rrGet := httptest.NewRecorder()
-	h.UpdateScore(rrGet, reqGet)
+	routed(h).ServeHTTP(rrGet, reqGet)
 	if status := rrGet.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("GET returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
 	}
 }
+
+func TestImportUsers(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "ankit", Rating: 2500})
+
+	// Case 1: CSV picked from the content type, insert mode rejects ankit
+	body := "id,username,rating\n1,ankit,3000\n2,bob,1500\n3,carol,abc\n"
+	req, _ := http.NewRequest("POST", "/admin/import", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	rr := httptest.NewRecorder()
+	h.ImportUsers(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("POST returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var result models.ImportResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.Added != 1 || result.Failed != 2 || len(result.Errors) != 2 || result.Errors[0].Line != 2 {
+		t.Errorf("Unexpected import result: %+v", result)
+	}
+
+	// Case 2: NDJSON upsert
+	body = `{"username":"ankit","rating":3000}` + "\n"
+	req, _ = http.NewRequest("POST", "/admin/import?format=ndjson&mode=upsert", bytes.NewBufferString(body))
+	rr = httptest.NewRecorder()
+	h.ImportUsers(rr, req)
+	result = models.ImportResult{}
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.Updated != 1 {
+		t.Errorf("Expected 1 updated, got %+v", result)
+	}
+	if u, _ := h.service.GetUserRank("ankit"); u.Rating != 3000 {
+		t.Errorf("Expected ankit at 3000, got %d", u.Rating)
+	}
+
+	// Case 3: Unknown format or mode
+	for _, url := range []string{"/admin/import", "/admin/import?format=xml", "/admin/import?format=csv&mode=merge"} {
+		req, _ = http.NewRequest("POST", url, bytes.NewBufferString(""))
+		rr = httptest.NewRecorder()
+		h.ImportUsers(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s returned wrong status code: got %v want %v", url, status, http.StatusBadRequest)
+		}
+	}
+
+	// Case 4: Invalid Method
+	req, _ = http.NewRequest("GET", "/admin/import?format=csv", nil)
+	rr = httptest.NewRecorder()
+	routed(h).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("GET returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "ankit", Rating: 2500})
+	h.service.AddUser(&models.User{Username: "bob", Rating: 1500})
+
+	// Case 1: CSV by default
+	req, _ := http.NewRequest("GET", "/export", nil)
+	rr := httptest.NewRecorder()
+	h.ExportUsers(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("GET returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Expected text/csv, got %q", ct)
+	}
+	if want := "rank,username,rating,tier\n1,ankit,2500,Gold\n2,bob,1500,Silver\n"; rr.Body.String() != want {
+		t.Errorf("Unexpected CSV export:\n%s", rr.Body.String())
+	}
+
+	// Case 2: NDJSON
+	req, _ = http.NewRequest("GET", "/export?format=ndjson", nil)
+	rr = httptest.NewRecorder()
+	h.ExportUsers(rr, req)
+	var first models.UserWithRank
+	json.NewDecoder(rr.Body).Decode(&first)
+	if rr.Header().Get("Content-Type") != "application/x-ndjson" || first.Username != "ankit" || first.Rank != 1 {
+		t.Errorf("Unexpected NDJSON export: %+v", first)
+	}
+
+	// Case 3: Unknown format
+	req, _ = http.NewRequest("GET", "/export?format=xml", nil)
+	rr = httptest.NewRecorder()
+	h.ExportUsers(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Unknown format returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Case 4: Invalid Method
+	req, _ = http.NewRequest("POST", "/export", nil)
+	rr = httptest.NewRecorder()
+	routed(h).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeError checks a response is the JSON error envelope with status and
+// code
+func decodeError(t *testing.T, rr *httptest.ResponseRecorder, status int, code string) models.ErrorResponse {
+	t.Helper()
+	var resp models.ErrorResponse
+	if rr.Code != status {
+		t.Errorf("Got status %d, want %d: %s", rr.Code, status, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Got Content-Type %q for an error", ct)
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Error body is not JSON: %v", err)
+	}
+	if resp.Code != code || resp.Message == "" {
+		t.Errorf("Got error %+v, want code %s", resp, code)
+	}
+	return resp
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "alice", Rating: 1000})
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/update-user-score", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		h.UpdateUserScore(rr, req)
+		return rr
+	}
+
+	// A bad rating is not a missing user
+	resp := decodeError(t, update(`{"username":"alice","rating":99}`), http.StatusUnprocessableEntity, models.ErrorInvalidRating)
+	if resp.Details["rating"] != float64(99) || resp.Details["max"] != float64(5000) {
+		t.Errorf("Unexpected details %+v", resp.Details)
+	}
+	resp = decodeError(t, update(`{"username":"ghost","rating":1000}`), http.StatusNotFound, models.ErrorNotFound)
+	if resp.Details["username"] != "ghost" {
+		t.Errorf("Unexpected details %+v", resp.Details)
+	}
+	decodeError(t, update(`{`), http.StatusBadRequest, models.ErrorInvalidRequest)
+
+	req, _ := http.NewRequest("GET", "/user/ghost", nil)
+	rr := httptest.NewRecorder()
+	routed(h).ServeHTTP(rr, req)
+	decodeError(t, rr, http.StatusNotFound, models.ErrorNotFound)
+
+	req, _ = http.NewRequest("GET", "/update-user-score", nil)
+	rr = httptest.NewRecorder()
+	routed(h).ServeHTTP(rr, req)
+	decodeError(t, rr, http.StatusMethodNotAllowed, models.ErrorMethodNotAllowed)
+	if allow := rr.Header().Get("Allow"); allow != "OPTIONS, POST" {
+		t.Errorf("Expected Allow: OPTIONS, POST, got %q", allow)
+	}
+}
+
+func TestLeaderboardValidation(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "alice", Rating: 1000})
+
+	for _, query := range []string{"limit=abc", "limit=0", "limit=-5", "offset=abc", "offset=-1", "consistent=maybe", "tier=Mythic", "cursor=%25%25"} {
+		req, _ := http.NewRequest("GET", "/leaderboard?"+query, nil)
+		rr := httptest.NewRecorder()
+		h.GetLeaderboard(rr, req)
+		resp := decodeError(t, rr, http.StatusBadRequest, models.ErrorInvalidParameter)
+		if name := strings.SplitN(query, "=", 2)[0]; resp.Details["parameter"] != name {
+			t.Errorf("%s: expected the parameter named, got %+v", query, resp.Details)
+		}
+	}
+
+	// Limits above the maximum are still capped rather than refused
+	req, _ := http.NewRequest("GET", "/leaderboard?limit=5000&offset=0", nil)
+	rr := httptest.NewRecorder()
+	h.GetLeaderboard(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a large limit, got %d", rr.Code)
+	}
+}
+
+func TestTierChangesAndSearchValidation(t *testing.T) {
+	h := setupTestHandler()
+	h.service.AddUser(&models.User{Username: "alice", Rating: 1000})
+
+	tests := []struct {
+		path, parameter string
+	}{
+		{"/events/tier-changes?limit=abc", "limit"},
+		{"/events/tier-changes?limit=0", "limit"},
+		{"/events/tier-changes?since=abc", "since"},
+		{"/events/tier-changes?since=-1", "since"},
+		{"/search?q=a&limit=abc", "limit"},
+		{"/search?q=a&limit=-5", "limit"},
+		{"/search?q=a&fuzzy=maybe", "fuzzy"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		rr := httptest.NewRecorder()
+		routed(h).ServeHTTP(rr, req)
+		resp := decodeError(t, rr, http.StatusBadRequest, models.ErrorInvalidParameter)
+		if resp.Details["parameter"] != tt.parameter {
+			t.Errorf("%s: expected %s named, got %+v", tt.path, tt.parameter, resp.Details)
+		}
+	}
+
+	// Limits above the maximum are still capped rather than refused
+	for _, path := range []string{"/events/tier-changes?limit=5000", "/search?q=a&limit=5000"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		routed(h).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 for a large limit, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestWriteServiceErrorInvalidUser(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteServiceError(rr, fmt.Errorf("adding: %w", &services.InvalidUserError{Field: "username", Max: 255}))
+	resp := decodeError(t, rr, http.StatusBadRequest, models.ErrorInvalidRequest)
+	if resp.Details["field"] != "username" {
+		t.Errorf("Expected the field named, got %+v", resp.Details)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	tests := []struct {
+		token, header string
+		status        int
+	}{
+		{"secret", "Bearer secret", http.StatusNoContent},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		// No token configured turns the route off
+		{"", "Bearer ", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/admin/import", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rr := httptest.NewRecorder()
+		RequireToken(tt.token, ok).ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("Token %q with %q: got %d, want %d", tt.token, tt.header, rr.Code, tt.status)
+		}
+		if rr.Code == http.StatusUnauthorized {
+			var resp models.ErrorResponse
+			json.NewDecoder(rr.Body).Decode(&resp)
+			if resp.Code != models.ErrorUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Token %q with %q: unexpected refusal %+v", tt.token, tt.header, resp)
+			}
+		}
+	}
+}
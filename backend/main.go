@@ -1,19 +1,46 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"leaderboard/backup"
+	"leaderboard/cluster"
+	"leaderboard/crdt"
+	"leaderboard/encryption"
 	"leaderboard/handlers"
 	"leaderboard/models"
+	"leaderboard/openapi"
+	"leaderboard/raft"
+	"leaderboard/replication"
+	"leaderboard/resp"
+	"leaderboard/router"
 	"leaderboard/services"
+	"leaderboard/snapshot"
+	"leaderboard/store"
+	"leaderboard/wal"
+	"leaderboard/wire"
 )
 
 func main() {
+	// Command line tools, e.g. `leaderboard import players.csv`
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("%s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	if err := run(); err != nil {
 		log.Fatalf("Failed to run app: %v", err)
 	}
@@ -23,17 +50,198 @@ func run() error {
 	// random seed
 	rand.Seed(time.Now().UnixNano())
 
-	// leaderboard service
+	// leaderboard service, COMPACT_STORAGE=true for the low-memory layout,
+	// whose searches scan the whole board
 	leaderboardService := services.NewLeaderboardService()
+	if compact, _ := strconv.ParseBool(os.Getenv("COMPACT_STORAGE")); compact {
+		leaderboardService = services.NewCompactLeaderboardService()
+	}
+
+	// Custom tier thresholds, e.g. TIERS=Bronze:100,Silver:1000,Gold:2000
+	if tiersEnv := os.Getenv("TIERS"); tiersEnv != "" {
+		tiers, err := services.ParseTiers(tiersEnv)
+		if err != nil {
+			return fmt.Errorf("invalid TIERS: %w", err)
+		}
+		if err := leaderboardService.SetTiers(tiers); err != nil {
+			return err
+		}
+	}
+
+	// Encryption at rest, ENCRYPTION_KEY=id:base64key or ENCRYPTION_KEY_FILE
+	keys, err := loadKeys()
+	if err != nil {
+		return err
+	}
+
+	// Replication, ROLE=leader or ROLE=follower with LEADER_URL. Sharding,
+	// ROLE=shard on each node and ROLE=coordinator with CLUSTER_NODES.
+	// Consensus, ROLE=raft with RAFT_ID and RAFT_PEERS. Active-active,
+	// ROLE=region with REGION_ID and REGION_PEERS.
+	role := strings.ToLower(os.Getenv("ROLE"))
+
+	// Admin routes need ADMIN_TOKEN as a bearer token. Nodes of a cluster
+	// share it and send it with every call to each other, so peer endpoints
+	// refuse anyone else.
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	switch role {
+	case roleShard, roleCoordinator, roleRaft, roleRegion:
+		if adminToken == "" {
+			return fmt.Errorf("ROLE=%s needs ADMIN_TOKEN, which the nodes authenticate to each other with", role)
+		}
+	}
+
+	switch role {
+	case "", replication.RoleLeader, replication.RoleFollower, roleShard, roleRaft, roleRegion:
+	case roleCoordinator:
+		return runCoordinator(os.Getenv("CLUSTER_NODES"), leaderboardService.GetTiers(), adminToken)
+	default:
+		return fmt.Errorf("unknown ROLE %q, expected leader, follower, shard, coordinator, raft or region", role)
+	}
+	if role == roleRaft && keys != nil {
+		return errors.New("ENCRYPTION_KEY cannot be used with ROLE=raft, which keeps the board and log in RAFT_DIR unencrypted")
+	}
+
+	// Durable storage, STORE=memory (default) or STORE=file with DATA_DIR.
+	// Followers hold only what they replicate, raft nodes keep their own
+	// log in RAFT_DIR and regions recover from their peers.
+	storeKind := os.Getenv("STORE")
+	if role == replication.RoleFollower || role == roleRaft || role == roleRegion {
+		storeKind = "memory"
+	}
+	st, err := openStore(storeKind, os.Getenv("DATA_DIR"), os.Getenv("WAL_SYNC"), os.Getenv("WAL_SYNC_INTERVAL"), keys)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// Rebuild the board from the store
+	if err := loadStore(leaderboardService, st); err != nil {
+		return err
+	}
 
-	// Seed users
-	seedUsers(leaderboardService, 10000)
+	// The in-memory store starts empty, so fill it with demo users
+	if _, inMemory := st.(*store.Memory); inMemory && (role == "" || role == replication.RoleLeader) {
+		seedUsers(leaderboardService, 10000)
+	}
+
+	// Periodic snapshots let the file store's WAL be truncated, e.g. SNAPSHOT_INTERVAL=5m
+	if _, onDisk := st.(*store.File); onDisk {
+		interval := 5 * time.Minute
+		if env := os.Getenv("SNAPSHOT_INTERVAL"); env != "" {
+			if interval, err = time.ParseDuration(env); err != nil || interval <= 0 {
+				return fmt.Errorf("invalid SNAPSHOT_INTERVAL %q", env)
+			}
+		}
+		stop := startCheckpoints(leaderboardService, st, interval)
+		defer stop()
+	}
+
+	// Periodic point-in-time backups, e.g. BACKUP_DIR=backups BACKUP_INTERVAL=1h
+	if backupDir := os.Getenv("BACKUP_DIR"); backupDir != "" {
+		interval, retention, err := backupConfig()
+		if err != nil {
+			return err
+		}
+		stop := startBackups(leaderboardService, backupDir, keys, interval, retention)
+		defer stop()
+	}
+
+	// Serve reads from published snapshots, e.g. SNAPSHOT_READS=50ms
+	if staleness := os.Getenv("SNAPSHOT_READS"); staleness != "" {
+		d, err := time.ParseDuration(staleness)
+		if err != nil {
+			return fmt.Errorf("invalid SNAPSHOT_READS: %w", err)
+		}
+		leaderboardService.EnableSnapshotReads(d)
+	}
 
 	// setup router
-	mux := setupRouter(leaderboardService)
+	mux := setupRouter(leaderboardService, adminToken)
+	var routes http.Handler = mux
+
+	switch role {
+	case replication.RoleLeader:
+		keep, err := replicationKeep()
+		if err != nil {
+			return err
+		}
+		leader := replication.NewLeader(leaderboardService, st, keep)
+		leaderboardService.SetMutationLog(leader)
+		mux.Handle("/replication/", leader.Handler())
+	case replication.RoleFollower:
+		leaderURL := os.Getenv("LEADER_URL")
+		if leaderURL == "" {
+			return errors.New("ROLE=follower needs LEADER_URL")
+		}
+		follower := replication.NewFollower(leaderURL, leaderboardService, replication.FollowerOptions{})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go follower.Run(ctx)
+		mux.HandleFunc("/replication/status", follower.ServeStatus)
+		routes = replication.ReadOnly(mux)
+		fmt.Printf("  Following %s\n", leaderURL)
+	case roleShard:
+		mux.Handle("/shard/", handlers.RequireToken(adminToken, cluster.NewShard(leaderboardService).Handler()))
+	case roleRaft:
+		node, urls, err := startRaft(leaderboardService, adminToken)
+		if err != nil {
+			return err
+		}
+		defer node.Stop()
+		// Only the status is open, the RPCs come from peers
+		raftHandler := node.Handler()
+		mux.Handle("/raft/", handlers.RequireToken(adminToken, raftHandler))
+		mux.Handle("/raft/status", raftHandler)
+		routes = raft.RedirectWrites(node, urls, mux)
+		fmt.Printf("  Raft node %s of %d\n", node.ID(), len(urls))
+	case roleRegion:
+		region, peers, interval, err := regionConfig(leaderboardService)
+		if err != nil {
+			return err
+		}
+		leaderboardService.SetMutationLog(region)
+		regionHandler := region.Handler()
+		mux.Handle("/crdt/", handlers.RequireToken(adminToken, regionHandler))
+		mux.Handle("/v1/increment-user-score", regionHandler)
+		mux.Handle("/increment-user-score", router.Deprecated(regionHandler, legacyDeprecated, "/v1"))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := peerClient(adminToken, 30*time.Second)
+		go region.Run(ctx, client, peers, interval, func(peer string, err error) {
+			log.Printf("Region sync with %s failed: %v", peer, err)
+		})
+		fmt.Printf("  Region %s syncing with %d peers every %s\n", region.ID(), len(peers), interval)
+	}
+
+	// Redis protocol front-end, e.g. RESP_ADDR=:6379. The key RESP_BOARD,
+	// by default "leaderboard", is this board; other keys are extra boards
+	// held in memory, at most RESP_MAX_BOARDS of them counting this one.
+	if addr := os.Getenv("RESP_ADDR"); addr != "" {
+		if role == replication.RoleFollower {
+			return errors.New("RESP_ADDR cannot be used with ROLE=follower, it would take writes")
+		}
+		stop, err := startRESP(leaderboardService, addr, os.Getenv("RESP_BOARD"))
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	// Binary protocol for game servers, e.g. WIRE_ADDR=:7001
+	if addr := os.Getenv("WIRE_ADDR"); addr != "" {
+		if role == replication.RoleFollower {
+			return errors.New("WIRE_ADDR cannot be used with ROLE=follower, it would take writes")
+		}
+		stop, err := startWire(leaderboardService, addr)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
 
 	// Wrap with CORS middleware
-	handler := corsMiddleware(mux)
+	handler := corsMiddleware(routes)
 
 	// server
 	port := os.Getenv("PORT")
@@ -69,32 +277,454 @@ func corsMiddleware(next http.Handler) http.Handler {
 // printServerInfo prints startup information
 func printServerInfo(port string) {
 	fmt.Printf("\n🚀 Leaderboard server starting on port %s\n", port)
-	fmt.Println("Available endpoints:")
-	fmt.Println("  GET  /leaderboard?limit=N  - Get top N users")
-	fmt.Println("  GET  /user/{username}      - Get user rank")
-	fmt.Println("  POST /update-score         - Update random user scores")
-	fmt.Println("  POST /update-user-score    - Update specific user score")
+	fmt.Println("Available endpoints, also at their old paths without /v1 until removed:")
+	fmt.Println("  GET  /v1/leaderboard?limit=N  - Get top N users")
+	fmt.Println("  GET  /v1/leaderboard?cursor=C - Get the page after cursor C")
+	fmt.Println("  GET  /v1/leaderboard?tier=T   - Get users in tier T")
+	fmt.Println("  GET  /v1/tiers                - Get user count per tier")
+	fmt.Println("  GET  /v1/user/{username}      - Get user rank")
+	fmt.Println("  GET  /v1/search?q=prefix      - Search users by username")
+	fmt.Println("  GET  /v1/events/tier-changes  - Get tier promotions and demotions")
+	fmt.Println("  POST /v1/update-score         - Update random user scores")
+	fmt.Println("  POST /v1/update-user-score    - Update specific user score")
+	fmt.Println("  GET  /v1/export?format=csv    - Stream the full ranked board as CSV or NDJSON")
+	fmt.Println("  POST /v1/admin/import         - Bulk import users from CSV or NDJSON, with ADMIN_TOKEN")
+	fmt.Println("  GET  /openapi.json            - OpenAPI 3 description of the API")
+	fmt.Println("  GET  /replication/status   - Replication role, version and lag")
+	fmt.Println("  GET  /raft/status          - Raft role, term and log positions")
+	fmt.Println("  GET  /crdt/state           - Region state for active-active merges, with ADMIN_TOKEN")
 	fmt.Println()
 }
 
+// Cluster roles, beside the replication ones
+const (
+	roleShard       = "shard"
+	roleCoordinator = "coordinator"
+	roleRaft        = "raft"
+	roleRegion      = "region"
+)
+
+// regionConfig reads REGION_ID, REGION_PEERS, a comma separated list of the
+// other regions' URLs, and REGION_SYNC_INTERVAL, by default 1s
+func regionConfig(service *services.LeaderboardService) (*crdt.Region, []string, time.Duration, error) {
+	id := os.Getenv("REGION_ID")
+	if id == "" {
+		return nil, nil, 0, errors.New("ROLE=region needs REGION_ID")
+	}
+	var peers []string
+	for _, peer := range strings.Split(os.Getenv("REGION_PEERS"), ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+
+	interval := time.Second
+	if env := os.Getenv("REGION_SYNC_INTERVAL"); env != "" {
+		d, err := time.ParseDuration(env)
+		if err != nil || d <= 0 {
+			return nil, nil, 0, fmt.Errorf("invalid REGION_SYNC_INTERVAL %q", env)
+		}
+		interval = d
+	}
+	return crdt.NewRegion(id, service, nil), peers, interval, nil
+}
+
+// startRaft joins the service to a raft cluster. RAFT_PEERS lists every
+// node including this one as id=url pairs, e.g.
+// RAFT_PEERS=n1=http://a:5001,n2=http://b:5001,n3=http://c:5001 with
+// RAFT_ID=n1. The log is kept in RAFT_DIR, by default data/raft, which is
+// not encrypted.
+func startRaft(service *services.LeaderboardService, token string) (*raft.Node, map[string]string, error) {
+	id := os.Getenv("RAFT_ID")
+	urls, err := parseRaftPeers(os.Getenv("RAFT_PEERS"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := urls[id]; !ok {
+		return nil, nil, fmt.Errorf("RAFT_ID %q is not in RAFT_PEERS", id)
+	}
+	var peers []string
+	for peer := range urls {
+		if peer != id {
+			peers = append(peers, peer)
+		}
+	}
+
+	dir := os.Getenv("RAFT_DIR")
+	if dir == "" {
+		dir = filepath.Join("data", "raft")
+	}
+	storage, err := raft.OpenFileStorage(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	node, err := raft.NewNode(raft.Config{
+		ID:        id,
+		Peers:     peers,
+		Service:   service,
+		Storage:   storage,
+		Transport: &raft.HTTPTransport{URLs: urls, Client: peerClient(token, time.Minute)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, urls, nil
+}
+
+// parseRaftPeers parses RAFT_PEERS, comma separated id=url pairs
+func parseRaftPeers(env string) (map[string]string, error) {
+	urls := make(map[string]string)
+	for _, pair := range strings.Split(env, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		id, url, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || url == "" {
+			return nil, fmt.Errorf("invalid RAFT_PEERS entry %q, expected id=url", pair)
+		}
+		if _, dup := urls[id]; dup {
+			return nil, fmt.Errorf("RAFT_PEERS lists %s twice", id)
+		}
+		urls[id] = url
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("ROLE=raft needs RAFT_PEERS")
+	}
+	return urls, nil
+}
+
+// runCoordinator serves the board sharded over nodes, a comma separated list
+// of node URLs, without holding any users itself. Rebalancing needs
+// adminToken, which is also sent to the nodes.
+func runCoordinator(nodes string, tiers []services.Tier, adminToken string) error {
+	var urls []string
+	for _, node := range strings.Split(nodes, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			urls = append(urls, node)
+		}
+	}
+	if len(urls) == 0 {
+		return errors.New("ROLE=coordinator needs CLUSTER_NODES")
+	}
+
+	client := peerClient(adminToken, 10*time.Second)
+	coordinator := cluster.NewCoordinator(cluster.NewRing(0, urls...), tiers, client)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "5001"
+	}
+	if port[0] != ':' {
+		port = ":" + port
+	}
+
+	fmt.Printf("\n🚀 Leaderboard coordinator starting on port %s over %d nodes\n", port, len(urls))
+	fmt.Println("  POST /cluster/rebalance    - Move users onto a new list of nodes, with ADMIN_TOKEN")
+	fmt.Println()
+	routes := coordinator.Handler()
+	mux := http.NewServeMux()
+	mux.Handle("/", routes)
+	for _, path := range []string{"/leaderboard", "/user/", "/update-user-score"} {
+		mux.Handle(path, router.Deprecated(routes, legacyDeprecated, "/v1"))
+	}
+	mux.Handle("/cluster/rebalance", handlers.RequireToken(adminToken, routes))
+	return startServer(port, corsMiddleware(mux))
+}
+
+// bearerTransport sends a bearer token with every request
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(r)
+}
+
+// peerClient is the client a node calls other nodes with, authenticated
+// with token
+func peerClient(token string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &bearerTransport{token: token, next: http.DefaultTransport},
+	}
+}
+
 // startServer starts the HTTP server
 func startServer(port string, handler http.Handler) error {
 	return http.ListenAndServe(port, handler)
 }
 
-// setupRouter initializes the API routes and returns the server mux
-func setupRouter(s *services.LeaderboardService) *http.ServeMux {
-	handler := handlers.NewHandler(s)
-	mux := http.NewServeMux()
+// legacyDeprecated is when the unversioned paths were deprecated for /v1
+var legacyDeprecated = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// apiRoute is an API operation, served under /v1 and at its legacy path
+type apiRoute struct {
+	method, pattern string
+	handler         http.HandlerFunc
+}
+
+// apiRoutes lists the API operations, each of which the OpenAPI document
+// must describe
+func apiRoutes(handler *handlers.Handler) []apiRoute {
+	return []apiRoute{
+		{http.MethodGet, "/leaderboard", handler.GetLeaderboard},
+		{http.MethodGet, "/user/{username}", handler.GetUser},
+		{http.MethodGet, "/tiers", handler.GetTiers},
+		{http.MethodGet, "/events/tier-changes", handler.GetTierChanges},
+		{http.MethodGet, "/search", handler.SearchUsers},
+		{http.MethodPost, "/update-score", handler.UpdateScore},
+		{http.MethodPost, "/update-user-score", handler.UpdateUserScore},
+		{http.MethodPost, "/admin/import", handler.ImportUsers},
+		{http.MethodGet, "/export", handler.ExportUsers},
+	}
+}
+
+// setupRouter initializes the API routes under /v1/, and the unversioned
+// paths as deprecated aliases, and the OpenAPI document at /openapi.json,
+// and returns the server mux. The /admin/ routes need adminToken as a bearer
+// token, and are refused outright without one.
+func setupRouter(s *services.LeaderboardService, adminToken string) *http.ServeMux {
+	// Requests are checked against the OpenAPI document before the
+	// handlers see them
+	spec := openapi.MustLoad()
+
+	v1 := router.New()
+	legacy := router.New()
+	docs := router.New()
+	for _, rt := range []*router.Router{v1, legacy, docs} {
+		rt.NotFound = http.HandlerFunc(handlers.NotFound)
+		rt.MethodNotAllowed = http.HandlerFunc(handlers.MethodNotAllowed)
+	}
+	for _, route := range apiRoutes(handlers.NewHandler(s)) {
+		h := spec.Validate(route.method, "/v1"+route.pattern, route.handler)
+		if strings.HasPrefix(route.pattern, "/admin/") {
+			h = handlers.RequireToken(adminToken, h)
+		}
+		v1.Handle(route.method, "/v1"+route.pattern, h)
+		legacy.Handle(route.method, route.pattern, router.Deprecated(h, legacyDeprecated, "/v1"))
+	}
+	docs.Handle(http.MethodGet, "/openapi.json", spec)
 
-	mux.HandleFunc("/leaderboard", handler.GetLeaderboard)
-	mux.HandleFunc("/user/", handler.GetUser)
-	mux.HandleFunc("/update-score", handler.UpdateScore)
-	mux.HandleFunc("/update-user-score", handler.UpdateUserScore)
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", v1)
+	mux.Handle("/openapi.json", docs)
+	mux.Handle("/", legacy)
 
 	return mux
 }
 
+// startRESP serves the board, and boards created by clients, over the Redis
+// protocol on addr
+func startRESP(service *services.LeaderboardService, addr, board string) (stop func(), err error) {
+	if board == "" {
+		board = "leaderboard"
+	}
+	maxBoards := services.DefaultMaxBoards
+	if env := os.Getenv("RESP_MAX_BOARDS"); env != "" {
+		if maxBoards, err = strconv.Atoi(env); err != nil || maxBoards <= 0 {
+			return nil, fmt.Errorf("invalid RESP_MAX_BOARDS %q", env)
+		}
+	}
+	tiers := service.GetTiers()
+	boards := services.NewBoards(func() *services.LeaderboardService {
+		ls := services.NewLeaderboardService()
+		ls.SetTiers(tiers)
+		return ls
+	})
+	boards.SetMaxBoards(maxBoards)
+	boards.Set(board, service)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("RESP listener: %w", err)
+	}
+	server := resp.NewServer(boards)
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("RESP server stopped: %v", err)
+		}
+	}()
+	fmt.Printf("  Redis protocol on %s, board %q\n", l.Addr(), board)
+	return func() { server.Close() }, nil
+}
+
+// startWire serves the board over the binary protocol on addr
+func startWire(service *services.LeaderboardService, addr string) (stop func(), err error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("wire listener: %w", err)
+	}
+	server := wire.NewServer(service)
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Wire server stopped: %v", err)
+		}
+	}()
+	fmt.Printf("  Binary protocol on %s\n", l.Addr())
+	return func() { server.Close() }, nil
+}
+
+// replicationKeep reads REPLICATION_KEEP, the mutations a leader holds for
+// followers to catch up from
+func replicationKeep() (int, error) {
+	env := os.Getenv("REPLICATION_KEEP")
+	if env == "" {
+		return replication.DefaultKeep, nil
+	}
+	keep, err := strconv.Atoi(env)
+	if err != nil || keep <= 0 {
+		return 0, fmt.Errorf("invalid REPLICATION_KEEP %q", env)
+	}
+	return keep, nil
+}
+
+// loadKeys reads the encryption keyring from ENCRYPTION_KEY or
+// ENCRYPTION_KEY_FILE. With neither set, data is written unencrypted.
+func loadKeys() (*encryption.Keyring, error) {
+	keys, err := encryption.LoadKeyring(os.Getenv("ENCRYPTION_KEY"), os.Getenv("ENCRYPTION_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return keys, nil
+}
+
+// openStore creates the store named by kind. The file store keeps its
+// snapshots and WAL in dir, which defaults to ./data, encrypted when keys is set.
+func openStore(kind, dir, syncPolicy, syncInterval string, keys *encryption.Keyring) (store.Store, error) {
+	switch strings.ToLower(kind) {
+	case "", "memory":
+		return store.NewMemory(), nil
+	case "file":
+	default:
+		return nil, fmt.Errorf("unknown STORE %q, expected memory or file", kind)
+	}
+
+	policy, err := wal.ParseSyncPolicy(syncPolicy)
+	if err != nil {
+		return nil, err
+	}
+	opts := wal.Options{Sync: policy, Keys: keys}
+	if syncInterval != "" {
+		if opts.Interval, err = time.ParseDuration(syncInterval); err != nil {
+			return nil, fmt.Errorf("invalid WAL_SYNC_INTERVAL: %w", err)
+		}
+	}
+
+	if dir == "" {
+		dir = "data"
+	}
+	return store.OpenFile(dir, opts)
+}
+
+// loadStore replays the store into the service, then attaches it so new
+// mutations are recorded
+func loadStore(service *services.LeaderboardService, st store.Store) error {
+	seq, err := st.Load(service.Apply)
+	if err != nil {
+		return err
+	}
+	service.SetVersion(seq)
+	service.SetMutationLog(st)
+
+	if seq > 0 {
+		fmt.Printf("  Restored %d users at version %d\n", service.GetUserCount(), seq)
+	}
+	return nil
+}
+
+// checkpoint compacts the store to the current board. Writers only wait
+// while the board is copied, not while it is written out.
+func checkpoint(service *services.LeaderboardService, st store.Store) error {
+	version, users := service.Copy()
+	return st.Checkpoint(version, len(users), snapshot.Users(users))
+}
+
+// startCheckpoints runs checkpoint every interval until the returned stop
+// function is called, which also takes a final checkpoint
+func startCheckpoints(service *services.LeaderboardService, st store.Store, interval time.Duration) (stop func()) {
+	task := func() {
+		if err := checkpoint(service, st); err != nil {
+			log.Printf("Checkpoint failed: %v", err)
+		}
+	}
+	stopLoop := runEvery(interval, task)
+	return func() {
+		stopLoop()
+		task()
+	}
+}
+
+// startBackups writes a backup to dir every interval and prunes old ones
+// until the returned stop function is called
+func startBackups(service *services.LeaderboardService, dir string, keys *encryption.Keyring, interval time.Duration, retention backup.Retention) (stop func()) {
+	return runEvery(interval, func() {
+		path, err := backup.Create(dir, keys, service, time.Now())
+		if err != nil {
+			log.Printf("Backup failed: %v", err)
+			return
+		}
+		fmt.Printf("  Backed up to %s\n", path)
+		if _, err := backup.Prune(dir, retention); err != nil {
+			log.Printf("Backup prune failed: %v", err)
+		}
+	})
+}
+
+// runEvery calls task every interval in the background. The returned stop
+// function waits for a running task to finish.
+func runEvery(interval time.Duration, task func()) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				task()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// backupConfig reads BACKUP_INTERVAL, BACKUP_KEEP_HOURLY and BACKUP_KEEP_DAILY
+func backupConfig() (time.Duration, backup.Retention, error) {
+	interval := time.Hour
+	retention := backup.DefaultRetention
+
+	var err error
+	if env := os.Getenv("BACKUP_INTERVAL"); env != "" {
+		if interval, err = time.ParseDuration(env); err != nil || interval <= 0 {
+			return 0, retention, fmt.Errorf("invalid BACKUP_INTERVAL %q", env)
+		}
+	}
+	if env := os.Getenv("BACKUP_KEEP_HOURLY"); env != "" {
+		if retention.Hourly, err = strconv.Atoi(env); err != nil || retention.Hourly < 0 {
+			return 0, retention, fmt.Errorf("invalid BACKUP_KEEP_HOURLY %q", env)
+		}
+	}
+	if env := os.Getenv("BACKUP_KEEP_DAILY"); env != "" {
+		if retention.Daily, err = strconv.Atoi(env); err != nil || retention.Daily < 0 {
+			return 0, retention, fmt.Errorf("invalid BACKUP_KEEP_DAILY %q", env)
+		}
+	}
+	return interval, retention, nil
+}
+
 // random users added to leaderboard
 func seedUsers(service *services.LeaderboardService, count int) {
 	for i := 1; i <= count; i++ {
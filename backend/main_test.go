@@ -1,11 +1,22 @@
 package main
 
 import (
+	"encoding/json"
+	"leaderboard/backup"
+	"leaderboard/encryption"
 	"leaderboard/models"
+	"leaderboard/replication"
 	"leaderboard/services"
+	"leaderboard/snapshot"
+	"leaderboard/store"
+	"leaderboard/wal"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSeedUsers(t *testing.T) {
@@ -36,7 +47,7 @@ func TestSeedUsers(t *testing.T) {
 
 func TestSetupRouter(t *testing.T) {
 	service := services.NewLeaderboardService()
-	mux := setupRouter(service)
+	mux := setupRouter(service, testAdminToken)
 
 	// Test a registered route
 	req, _ := http.NewRequest("GET", "/leaderboard", nil)
@@ -55,9 +66,478 @@ func TestSetupRouter(t *testing.T) {
 	if status := rr404.Code; status != http.StatusNotFound {
 		t.Errorf("unregistered route should return 404: got %v want %v", status, http.StatusNotFound)
 	}
+
+	// The versioned API has no deprecation headers
+	service.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1000})
+	reqV1, _ := http.NewRequest("GET", "/v1/user/alice", nil)
+	rrV1 := httptest.NewRecorder()
+	mux.ServeHTTP(rrV1, reqV1)
+	if rrV1.Code != http.StatusOK || rrV1.Header().Get("Deprecation") != "" {
+		t.Errorf("/v1/user/alice: got %d, Deprecation %q", rrV1.Code, rrV1.Header().Get("Deprecation"))
+	}
+
+	// Legacy paths still work, marked deprecated
+	reqOld, _ := http.NewRequest("GET", "/user/alice", nil)
+	rrOld := httptest.NewRecorder()
+	mux.ServeHTTP(rrOld, reqOld)
+	if rrOld.Code != http.StatusOK || rrOld.Header().Get("Deprecation") == "" {
+		t.Errorf("/user/alice: got %d, Deprecation %q", rrOld.Code, rrOld.Header().Get("Deprecation"))
+	}
+	if link := rrOld.Header().Get("Link"); !strings.Contains(link, "</v1/user/alice>") {
+		t.Errorf("Expected a link to the successor, got %q", link)
+	}
+
+	// HEAD is answered wherever GET is
+	for _, path := range []string{"/v1/leaderboard", "/v1/user/alice", "/v1/tiers", "/leaderboard"} {
+		reqHead, _ := http.NewRequest("HEAD", path, nil)
+		rrHead := httptest.NewRecorder()
+		mux.ServeHTTP(rrHead, reqHead)
+		if rrHead.Code != http.StatusOK {
+			t.Errorf("HEAD %s: got %d", path, rrHead.Code)
+		}
+	}
+
+	// The wrong method is refused by the router with the allowed ones
+	reqMethod, _ := http.NewRequest("DELETE", "/v1/update-user-score", nil)
+	rrMethod := httptest.NewRecorder()
+	mux.ServeHTTP(rrMethod, reqMethod)
+	if rrMethod.Code != http.StatusMethodNotAllowed || rrMethod.Header().Get("Allow") != "OPTIONS, POST" {
+		t.Errorf("Expected 405 allowing POST, got %d %q", rrMethod.Code, rrMethod.Header().Get("Allow"))
+	}
+
+	// Routing errors use the JSON error envelope too
+	for _, rr := range []*httptest.ResponseRecorder{rr404, rrMethod} {
+		var envelope models.ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil || envelope.Code == "" {
+			t.Errorf("Expected an error envelope, got %v", err)
+		}
+	}
+
+	// Without an admin token the admin routes are off, even at the old path
+	for _, path := range []string{"/v1/admin/import", "/admin/import"} {
+		req, _ := http.NewRequest("POST", path+"?format=csv", strings.NewReader("username,rating\nmallory,5000\n"))
+		req.Header.Set("Authorization", "Bearer ")
+		rr := httptest.NewRecorder()
+		setupRouter(service, "").ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s without ADMIN_TOKEN: got %d", path, rr.Code)
+		}
+	}
+	if _, err := service.GetUserRank("mallory"); err == nil {
+		t.Error("Expected the refused import to add no one")
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	if st, err := openStore("", "", "", "", nil); err != nil {
+		t.Errorf("Expected memory store by default, got %v", err)
+	} else if _, ok := st.(*store.Memory); !ok {
+		t.Errorf("Expected memory store by default, got %T", st)
+	}
+
+	dir := filepath.Join(t.TempDir(), "data")
+	st, err := openStore("file", dir, "interval", "10ms", nil)
+	if err != nil {
+		t.Fatalf("Failed to open file store: %v", err)
+	}
+	if fs, ok := st.(*store.File); !ok || fs.Dir() != dir {
+		t.Errorf("Expected file store in %s, got %T", dir, st)
+	}
+
+	// Bad configuration
+	if _, err := openStore("tape", dir, "", "", nil); err == nil {
+		t.Error("Expected error for unknown store")
+	}
+	if _, err := openStore("file", dir, "sometimes", "", nil); err == nil {
+		t.Error("Expected error for unknown sync policy")
+	}
+	if _, err := openStore("file", dir, "interval", "soon", nil); err == nil {
+		t.Error("Expected error for bad sync interval")
+	}
+}
+
+func TestLoadStore(t *testing.T) {
+	dir := t.TempDir()
+
+	// First boot starts empty and records everything
+	service := services.NewLeaderboardService()
+	st, _ := openStore("file", dir, "always", "", nil)
+	if err := loadStore(service, st); err != nil {
+		t.Fatalf("Failed to load store: %v", err)
+	}
+	seedUsers(service, 5)
+	if err := checkpoint(service, st); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if n, _ := wal.Replay(filepath.Join(dir, store.WALName), nil, func(models.Mutation) error { return nil }); n != 0 {
+		t.Errorf("Expected wal truncated after checkpoint, got %d records", n)
+	}
+
+	// Mutations after the snapshot land in the wal tail
+	service.UpdateRating("user_1", 4321)
+	service.RemoveUser("user_2")
+	st.Close()
+
+	// Second boot rebuilds the same board
+	restored := services.NewLeaderboardService()
+	st, _ = openStore("file", dir, "always", "", nil)
+	defer st.Close()
+	if err := loadStore(restored, st); err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+
+	if restored.GetUserCount() != 4 {
+		t.Errorf("Expected 4 restored users, got %d", restored.GetUserCount())
+	}
+	if u, _ := restored.GetUserRank("user_1"); u == nil || u.Rating != 4321 {
+		t.Errorf("Expected restored rating 4321, got %+v", u)
+	}
+	if restored.Version() != service.Version() {
+		t.Errorf("Expected version %d, got %d", service.Version(), restored.Version())
+	}
+
+	// Writes after the reload keep being recorded
+	restored.UpdateRating("user_3", 100)
+	stop := startCheckpoints(restored, st, time.Hour)
+	stop()
+	if _, err := os.Stat(filepath.Join(dir, snapshot.FileName(restored.Version()))); err != nil {
+		t.Errorf("Expected final checkpoint on stop: %v", err)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("STORE", "file")
+	t.Setenv("DATA_DIR", filepath.Join(dir, "data"))
+
+	path := filepath.Join(dir, "players.csv")
+	os.WriteFile(path, []byte("id,username,rating\n1,alice,1500\n2,bob,oops\n3,carol,4000\n"), 0o644)
+	if err := runCommand("import", []string{"-mode", "upsert", path}); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	// The import is durable
+	service, st, err := openFileStore()
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	st.Close()
+	if service.GetUserCount() != 2 {
+		t.Errorf("Expected 2 imported users, got %d", service.GetUserCount())
+	}
+
+	// Bad invocations
+	if err := runCommand("import", nil); err == nil {
+		t.Error("Expected usage error without a file")
+	}
+	if err := runCommand("import", []string{filepath.Join(dir, "players.xml")}); err == nil {
+		t.Error("Expected error for unknown extension")
+	}
+	if err := runCommand("frobnicate", nil); err == nil {
+		t.Error("Expected error for unknown command")
+	}
+	t.Setenv("STORE", "memory")
+	if err := runCommand("import", []string{path}); err == nil {
+		t.Error("Expected error importing into the in-memory store")
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("STORE", "file")
+	t.Setenv("DATA_DIR", filepath.Join(dir, "data"))
+
+	service, st, _ := openFileStore()
+	seedUsers(service, 3)
+	st.Close()
+
+	path := filepath.Join(dir, "board.ndjson")
+	if err := runCommand("export", []string{path}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Expected 3 exported users, got %d lines", lines)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected temp file to be gone, got %v", err)
+	}
+
+	if err := runCommand("export", nil); err == nil {
+		t.Error("Expected usage error without a file")
+	}
+	if err := runCommand("export", []string{"-format", "xml", path}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestBackupCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("STORE", "file")
+	t.Setenv("DATA_DIR", filepath.Join(dir, "data"))
+	t.Setenv("BACKUP_DIR", filepath.Join(dir, "backups"))
+
+	service, st, _ := openFileStore()
+	seedUsers(service, 3)
+	service.UpdateRating("user_1", 4000)
+	st.Close()
+
+	if err := runCommand("backup", []string{"create"}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := runCommand("backup", []string{"list"}); err != nil {
+		t.Errorf("List failed: %v", err)
+	}
+	backups, _ := backup.List(filepath.Join(dir, "backups"), nil)
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %d", len(backups))
+	}
+
+	// Change the board, then restore the backup by name
+	service, st, _ = openFileStore()
+	service.UpdateRating("user_1", 200)
+	service.RemoveUser("user_2")
+	st.Close()
+
+	if err := runCommand("backup", []string{"restore", filepath.Base(backups[0].Path)}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	service, st, _ = openFileStore()
+	st.Close()
+	if service.GetUserCount() != 3 {
+		t.Errorf("Expected 3 users after restore, got %d", service.GetUserCount())
+	}
+	if u, _ := service.GetUserRank("user_1"); u == nil || u.Rating != 4000 {
+		t.Errorf("Expected user_1 back at 4000, got %+v", u)
+	}
+
+	// A damaged backup is refused and the store is left alone
+	data, _ := os.ReadFile(backups[0].Path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(backups[0].Path, data, 0o644)
+	if err := runCommand("backup", []string{"restore", backups[0].Path}); err == nil {
+		t.Error("Expected damaged backup to be refused")
+	}
+
+	for _, args := range [][]string{nil, {"list", "extra"}, {"restore"}, {"rotate"}} {
+		if err := runCommand("backup", args); err == nil {
+			t.Errorf("Expected usage error for %v", args)
+		}
+	}
+}
+
+func TestBackupConfig(t *testing.T) {
+	interval, retention, err := backupConfig()
+	if err != nil || interval != time.Hour || retention != backup.DefaultRetention {
+		t.Errorf("Unexpected defaults %v %+v %v", interval, retention, err)
+	}
+
+	t.Setenv("BACKUP_INTERVAL", "15m")
+	t.Setenv("BACKUP_KEEP_HOURLY", "12")
+	t.Setenv("BACKUP_KEEP_DAILY", "30")
+	interval, retention, err = backupConfig()
+	if err != nil || interval != 15*time.Minute || retention != (backup.Retention{Hourly: 12, Daily: 30}) {
+		t.Errorf("Unexpected config %v %+v %v", interval, retention, err)
+	}
+
+	for _, env := range []string{"BACKUP_INTERVAL", "BACKUP_KEEP_HOURLY", "BACKUP_KEEP_DAILY"} {
+		t.Setenv(env, "-1")
+		if _, _, err := backupConfig(); err == nil {
+			t.Errorf("Expected error for negative %s", env)
+		}
+		t.Setenv(env, "1")
+	}
+}
+
+func TestStartBackups(t *testing.T) {
+	dir := t.TempDir()
+	service := services.NewLeaderboardService()
+	seedUsers(service, 3)
+
+	stop := startBackups(service, dir, nil, 10*time.Millisecond, backup.Retention{})
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	// Retention of zero prunes down to the newest
+	if backups, _ := backup.List(dir, nil); len(backups) != 1 {
+		t.Errorf("Expected 1 backup after pruning, got %d", len(backups))
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	dir := t.TempDir()
+	entry, _ := encryption.GenerateKey("k1")
+	t.Setenv("STORE", "file")
+	t.Setenv("DATA_DIR", filepath.Join(dir, "data"))
+	t.Setenv("ENCRYPTION_KEY", entry)
+
+	service, st, err := openFileStore()
+	if err != nil {
+		t.Fatalf("Failed to open encrypted store: %v", err)
+	}
+	seedUsers(service, 3)
+	checkpoint(service, st)
+	st.Close()
+
+	if service, st, err = openFileStore(); err != nil || service.GetUserCount() != 3 {
+		t.Fatalf("Expected 3 users from the encrypted store, got %v", err)
+	}
+	st.Close()
+
+	// The same data under a key file, then without any key
+	keyFile := filepath.Join(dir, "keys")
+	os.WriteFile(keyFile, []byte(entry+"\n"), 0o600)
+	t.Setenv("ENCRYPTION_KEY", "")
+	t.Setenv("ENCRYPTION_KEY_FILE", keyFile)
+	if service, st, err = openFileStore(); err != nil || service.GetUserCount() != 3 {
+		t.Fatalf("Expected 3 users using the key file, got %v", err)
+	}
+	st.Close()
+
+	t.Setenv("ENCRYPTION_KEY_FILE", "")
+	if _, _, err := openFileStore(); !encryption.IsKeyError(err) {
+		t.Errorf("Expected a key error without a key, got %v", err)
+	}
+	t.Setenv("ENCRYPTION_KEY", "k1:short")
+	if _, _, err := openFileStore(); err == nil {
+		t.Error("Expected error for a malformed key")
+	}
+}
+
+func TestKeygenCommand(t *testing.T) {
+	if err := runCommand("keygen", []string{"k1"}); err != nil {
+		t.Errorf("Keygen failed: %v", err)
+	}
+	if err := runCommand("keygen", nil); err == nil {
+		t.Error("Expected usage error without an id")
+	}
+}
+
+func TestReplicationKeep(t *testing.T) {
+	if keep, err := replicationKeep(); err != nil || keep != replication.DefaultKeep {
+		t.Errorf("Expected default keep, got %d %v", keep, err)
+	}
+	t.Setenv("REPLICATION_KEEP", "500")
+	if keep, err := replicationKeep(); err != nil || keep != 500 {
+		t.Errorf("Expected keep 500, got %d %v", keep, err)
+	}
+	t.Setenv("REPLICATION_KEEP", "0")
+	if _, err := replicationKeep(); err == nil {
+		t.Error("Expected error for zero keep")
+	}
+}
+
+func TestParseRaftPeers(t *testing.T) {
+	urls, err := parseRaftPeers("n1=http://a:5001, n2=http://b:5001,n3=http://c:5001")
+	if err != nil || len(urls) != 3 || urls["n2"] != "http://b:5001" {
+		t.Errorf("Expected three peers, got %v %v", urls, err)
+	}
+	for _, env := range []string{"", "n1", "n1=http://a,n1=http://b", "=http://a"} {
+		if _, err := parseRaftPeers(env); err == nil {
+			t.Errorf("Expected error for %q", env)
+		}
+	}
+}
+
+func TestRegionConfig(t *testing.T) {
+	service := services.NewLeaderboardService()
+	if _, _, _, err := regionConfig(service); err == nil {
+		t.Error("Expected error without REGION_ID")
+	}
+
+	t.Setenv("REGION_ID", "eu")
+	t.Setenv("REGION_PEERS", "http://us:5001, http://ap:5001")
+	region, peers, interval, err := regionConfig(service)
+	if err != nil || region.ID() != "eu" || len(peers) != 2 || interval != time.Second {
+		t.Errorf("Expected region eu with 2 peers every 1s, got %v %v %v", peers, interval, err)
+	}
+
+	t.Setenv("REGION_SYNC_INTERVAL", "0s")
+	if _, _, _, err := regionConfig(service); err == nil {
+		t.Error("Expected error for a zero interval")
+	}
+}
+
+func TestStartRESP(t *testing.T) {
+	service := services.NewLeaderboardService()
+	service.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1200})
+	stop, err := startRESP(service, "127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+
+	if _, err := startRESP(service, "not an address", ""); err == nil {
+		t.Error("Expected error for a bad address")
+	}
+}
+
+func TestStartWire(t *testing.T) {
+	service := services.NewLeaderboardService()
+	service.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1200})
+	stop, err := startWire(service, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+
+	if _, err := startWire(service, "not an address"); err == nil {
+		t.Error("Expected error for a bad address")
+	}
 }
 
 func TestPrintServerInfo(t *testing.T) {
 	// Just call it to ensure no crashes and cover the lines
 	printServerInfo(":8080")
 }
+
+func TestRaftRefusesEncryption(t *testing.T) {
+	key, err := encryption.GenerateKey("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENCRYPTION_KEY", key)
+	t.Setenv("ROLE", "raft")
+	t.Setenv("ADMIN_TOKEN", testAdminToken)
+	if err := run(); err == nil || !strings.Contains(err.Error(), "ENCRYPTION_KEY cannot be used with ROLE=raft") {
+		t.Errorf("Expected raft with an encryption key refused, got %v", err)
+	}
+}
+
+func TestSnapshotIntervalMustBePositive(t *testing.T) {
+	t.Setenv("STORE", "file")
+	t.Setenv("DATA_DIR", t.TempDir())
+	for _, env := range []string{"0", "-1m", "soon"} {
+		t.Setenv("SNAPSHOT_INTERVAL", env)
+		if err := run(); err == nil || !strings.Contains(err.Error(), "SNAPSHOT_INTERVAL") {
+			t.Errorf("Expected SNAPSHOT_INTERVAL=%s refused, got %v", env, err)
+		}
+	}
+}
+
+func TestClusterRolesNeedAdminToken(t *testing.T) {
+	for _, role := range []string{"shard", "coordinator", "raft", "region"} {
+		t.Setenv("ROLE", role)
+		t.Setenv("ADMIN_TOKEN", "")
+		if err := run(); err == nil || !strings.Contains(err.Error(), "needs ADMIN_TOKEN") {
+			t.Errorf("Expected ROLE=%s without ADMIN_TOKEN refused, got %v", role, err)
+		}
+	}
+}
+
+func TestPeerClientSendsToken(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	resp, err := peerClient("secret", time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "Bearer secret" {
+		t.Errorf("Expected the token sent, got %q", got)
+	}
+}
@@ -0,0 +1,17 @@
+package models
+
+// ImportError is a row that could not be imported
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResult summarises a bulk import. Errors holds the first failures
+// only; Failed counts all of them.
+type ImportResult struct {
+	Rows    int           `json:"rows"`
+	Added   int           `json:"added"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Errors  []ImportError `json:"errors"`
+}
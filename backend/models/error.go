@@ -0,0 +1,24 @@
+package models
+
+// Error codes, each sent with one status
+const (
+	ErrorInvalidRequest   = "invalid_request"    // 400, a body that cannot be read or used
+	ErrorInvalidParameter = "invalid_parameter"  // 400, a bad query or path parameter
+	ErrorUnauthorized     = "unauthorized"       // 401
+	ErrorReadOnly         = "read_only"          // 403, a write sent to a read-only replica
+	ErrorNotFound         = "not_found"          // 404
+	ErrorMethodNotAllowed = "method_not_allowed" // 405
+	ErrorDuplicate        = "duplicate"          // 409
+	ErrorInvalidRating    = "invalid_rating"     // 422
+	ErrorInternal         = "internal"           // 500
+	ErrorBadGateway       = "bad_gateway"        // 502, a node behind a coordinator failed
+	ErrorUnavailable      = "unavailable"        // 503
+)
+
+// ErrorResponse is the body of every error the API returns. Details holds
+// what the code is about, such as the parameter or username.
+type ErrorResponse struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// Mutation operations
+const (
+	MutationAdd    = "add"
+	MutationUpdate = "update"
+	MutationRename = "rename"
+	MutationRemove = "remove"
+)
+
+// Mutation is one change to the leaderboard, as written to the log
+type Mutation struct {
+	Seq         uint64 `json:"seq"`
+	Op          string `json:"op"`
+	ID          string `json:"id,omitempty"`
+	Username    string `json:"username"`
+	Rating      int    `json:"rating,omitempty"`
+	NewUsername string `json:"new_username,omitempty"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
@@ -10,8 +12,36 @@ type UserWithRank struct {
 	Rank     int    `json:"rank"`
 	Username string `json:"username"`
 	Rating   int    `json:"rating"`
+	Tier     string `json:"tier,omitempty"`
 }
 
 type LeaderboardResponse struct {
-	Users []UserWithRank `json:"users"`
+	Users      []UserWithRank `json:"users"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type TierCount struct {
+	Name       string  `json:"name"`
+	MinRating  int     `json:"min_rating"`
+	TopPercent float64 `json:"top_percent,omitempty"`
+	Count      int     `json:"count"`
+}
+
+type TiersResponse struct {
+	Tiers []TierCount `json:"tiers"`
+}
+
+type TierChangeEvent struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Type      string    `json:"type"` // promoted or demoted
+	FromTier  string    `json:"from_tier"`
+	ToTier    string    `json:"to_tier"`
+	OldRating int       `json:"old_rating"`
+	NewRating int       `json:"new_rating"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type TierChangesResponse struct {
+	Events []TierChangeEvent `json:"events"`
 }
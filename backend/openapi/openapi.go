@@ -0,0 +1,239 @@
+// Package openapi holds the OpenAPI 3 document describing the HTTP API,
+// serves it, validates requests against it, and checks responses against it
+// so tests notice when a handler drifts from the contract.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Document is the parts of an OpenAPI document the checks need
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+
+	raw []byte
+}
+
+// Operation is one method on one path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+	Deprecated  bool                 `json:"deprecated"`
+}
+
+// Parameter is a query or path parameter
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody lists the bodies an operation accepts, by media type
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is a response for one status, or a reference to a shared one
+type Response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*MediaType `json:"content"`
+}
+
+// MediaType holds the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema the document uses
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	Items                *Schema            `json:"items"`
+	Nullable             bool               `json:"nullable"`
+	AdditionalProperties interface{}        `json:"additionalProperties"`
+}
+
+// legacySuffix is added to the operation IDs of the deprecated aliases
+const legacySuffix = "Legacy"
+
+// Load parses the embedded document. Every /v1 path is also documented
+// without the prefix, as the deprecated alias the server keeps for it.
+func Load() (*Document, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	paths, ok := doc["paths"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("openapi: document has no paths")
+	}
+	for path, item := range paths {
+		if !strings.HasPrefix(path, "/v1/") {
+			continue
+		}
+		alias, err := deprecatedCopy(item)
+		if err != nil {
+			return nil, fmt.Errorf("openapi: %s: %w", path, err)
+		}
+		paths[strings.TrimPrefix(path, "/v1")] = alias
+	}
+
+	raw, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	d := &Document{raw: raw}
+	if err := json.Unmarshal(raw, d); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	return d, nil
+}
+
+// MustLoad is Load for a document that is known to parse, as the embedded
+// one is once its tests pass
+func MustLoad() *Document {
+	d, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// deprecatedCopy copies a path item with each operation marked deprecated
+func deprecatedCopy(item interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var alias map[string]interface{}
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return nil, err
+	}
+	for _, op := range alias {
+		op, ok := op.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation is not an object")
+		}
+		op["deprecated"] = true
+		if id, ok := op["operationId"].(string); ok {
+			op["operationId"] = id + legacySuffix
+		}
+	}
+	return alias, nil
+}
+
+// Operation returns the operation for method on the documented path, or nil
+func (d *Document) Operation(method, path string) *Operation {
+	return d.Paths[path][strings.ToLower(method)]
+}
+
+// ServeHTTP serves the document as JSON
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d.raw)
+}
+
+// CheckResponse reports how a response to method on the documented path
+// differs from the document: an undocumented status or content type, or a
+// body that does not match the schema. JSON bodies must not carry
+// properties the schema leaves out. NDJSON bodies are checked line by line.
+func (d *Document) CheckResponse(method, path string, status int, contentType string, body []byte) error {
+	op := d.Operation(method, path)
+	if op == nil {
+		return fmt.Errorf("%s %s is not documented", method, path)
+	}
+	resp := op.Responses[fmt.Sprint(status)]
+	if resp == nil {
+		resp = op.Responses["default"]
+	}
+	if resp == nil {
+		return fmt.Errorf("%s %s: status %d is not documented", method, path, status)
+	}
+	resp, err := d.resolveResponse(resp)
+	if err != nil {
+		return err
+	}
+	if len(resp.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("%s %s: status %d documents no body, got %d bytes", method, path, status, len(body))
+		}
+		return nil
+	}
+
+	typ := mediaType(contentType)
+	media := resp.Content[typ]
+	if media == nil {
+		return fmt.Errorf("%s %s: status %d: content type %q is not documented", method, path, status, contentType)
+	}
+	v := validator{doc: d, bounds: true, strict: true}
+	switch typ {
+	case "application/json":
+		value, err := decode(body)
+		if err != nil {
+			return fmt.Errorf("%s %s: status %d: %v", method, path, status, err)
+		}
+		if err := v.check(media.Schema, value, ""); err != nil {
+			return fmt.Errorf("%s %s: status %d: %v", method, path, status, err)
+		}
+	case "application/x-ndjson":
+		for i, line := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			value, err := decode([]byte(line))
+			if err != nil {
+				return fmt.Errorf("%s %s: status %d: line %d: %v", method, path, status, i+1, err)
+			}
+			if err := v.check(media.Schema, value, ""); err != nil {
+				return fmt.Errorf("%s %s: status %d: line %d: %v", method, path, status, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Document) resolveResponse(resp *Response) (*Response, error) {
+	if resp.Ref == "" {
+		return resp, nil
+	}
+	name := strings.TrimPrefix(resp.Ref, "#/components/responses/")
+	if shared := d.Components.Responses[name]; shared != nil {
+		return shared, nil
+	}
+	return nil, fmt.Errorf("unknown response %s", resp.Ref)
+}
+
+func (d *Document) resolveSchema(s *Schema) (*Schema, error) {
+	for s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		shared := d.Components.Schemas[name]
+		if shared == nil {
+			return nil, fmt.Errorf("unknown schema %s", s.Ref)
+		}
+		s = shared
+	}
+	return s, nil
+}
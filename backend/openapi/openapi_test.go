@@ -0,0 +1,189 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"leaderboard/models"
+	"leaderboard/router"
+)
+
+func TestLoad(t *testing.T) {
+	d, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := d.Operation("GET", "/v1/leaderboard")
+	if op == nil || op.Deprecated {
+		t.Fatalf("Expected GET /v1/leaderboard, got %+v", op)
+	}
+	alias := d.Operation("GET", "/leaderboard")
+	if alias == nil || !alias.Deprecated || alias.OperationID != op.OperationID+legacySuffix {
+		t.Errorf("Expected a deprecated alias, got %+v", alias)
+	}
+	if d.Operation("GET", "/openapi") != nil || d.Operation("POST", "/v1/leaderboard") != nil {
+		t.Error("Expected no operation for undocumented routes")
+	}
+
+	// Every reference resolves
+	for path, item := range d.Paths {
+		for method, op := range item {
+			for status, resp := range op.Responses {
+				if _, err := d.resolveResponse(resp); err != nil {
+					t.Errorf("%s %s %s: %v", method, path, status, err)
+				}
+			}
+		}
+	}
+	for name, s := range d.Components.Schemas {
+		for prop, p := range s.Properties {
+			if _, err := d.resolveSchema(p); err != nil {
+				t.Errorf("%s.%s: %v", name, prop, err)
+			}
+		}
+	}
+}
+
+// validated routes a few operations through Validate to a handler that
+// echoes the body
+func validated() http.Handler {
+	d := MustLoad()
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	rt := router.New()
+	rt.Handle("GET", "/v1/leaderboard", d.Validate("GET", "/v1/leaderboard", echo))
+	rt.Handle("GET", "/v1/search", d.Validate("GET", "/v1/search", echo))
+	rt.Handle("POST", "/v1/update-user-score", d.Validate("POST", "/v1/update-user-score", echo))
+	rt.Handle("GET", "/v1/export", d.Validate("GET", "/v1/export", echo))
+	return rt
+}
+
+func TestValidateParameters(t *testing.T) {
+	h := validated()
+	tests := []struct {
+		target    string
+		parameter string // refused, or empty if accepted
+	}{
+		{"/v1/leaderboard", ""},
+		{"/v1/leaderboard?limit=5&offset=0&consistent=true&tier=Gold", ""},
+		{"/v1/leaderboard?limit=5000", ""},
+		{"/v1/leaderboard?limit=abc", "limit"},
+		{"/v1/leaderboard?limit=0", "limit"},
+		{"/v1/leaderboard?limit=1.5", "limit"},
+		{"/v1/leaderboard?offset=-1", "offset"},
+		{"/v1/leaderboard?consistent=maybe", "consistent"},
+		{"/v1/search", "q"},
+		{"/v1/search?q=", "q"},
+		{"/v1/search?q=al", ""},
+		{"/v1/export?format=ndjson", ""},
+		{"/v1/export?format=xml", "format"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", tt.target, nil))
+		if tt.parameter == "" {
+			if rr.Code != http.StatusOK {
+				t.Errorf("%s: got %d %s", tt.target, rr.Code, rr.Body.String())
+			}
+			continue
+		}
+		var envelope models.ErrorResponse
+		json.NewDecoder(rr.Body).Decode(&envelope)
+		if rr.Code != http.StatusBadRequest || envelope.Code != models.ErrorInvalidParameter || envelope.Details["parameter"] != tt.parameter {
+			t.Errorf("%s: expected %s refused, got %d %+v", tt.target, tt.parameter, rr.Code, envelope)
+		}
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	h := validated()
+	tests := []struct {
+		body  string
+		field string // refused, or empty if accepted
+		ok    bool
+	}{
+		{`{"username":"alice","rating":1500}`, "", true},
+		// Bounds are left to the handler
+		{`{"username":"alice","rating":6000}`, "", true},
+		{`{"username":"","rating":1500}`, "", true},
+		// Unknown fields are ignored
+		{`{"username":"alice","rating":1500,"note":"x"}`, "", true},
+		{`{"username":"alice"}`, "rating", false},
+		{`{"rating":1500}`, "username", false},
+		{`{"username":"alice","rating":"high"}`, "rating", false},
+		{`{"username":"alice","rating":1500.5}`, "rating", false},
+		{`{"username":7,"rating":1500}`, "username", false},
+		{`[1,2]`, "", false},
+		{`{"username":"alice"`, "", false},
+		{``, "", false},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/update-user-score", strings.NewReader(tt.body)))
+		if tt.ok {
+			// The handler still gets the whole body
+			if rr.Code != http.StatusOK || rr.Body.String() != tt.body {
+				t.Errorf("%s: got %d %s", tt.body, rr.Code, rr.Body.String())
+			}
+			continue
+		}
+		var envelope models.ErrorResponse
+		json.NewDecoder(rr.Body).Decode(&envelope)
+		if rr.Code != http.StatusBadRequest || envelope.Code != models.ErrorInvalidRequest {
+			t.Errorf("%s: expected invalid_request, got %d %+v", tt.body, rr.Code, envelope)
+		}
+		if tt.field != "" && envelope.Details["field"] != tt.field {
+			t.Errorf("%s: expected field %s, got %+v", tt.body, tt.field, envelope.Details)
+		}
+	}
+}
+
+func TestValidateUndocumented(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for an undocumented operation")
+		}
+	}()
+	MustLoad().Validate("DELETE", "/v1/leaderboard", http.NotFoundHandler())
+}
+
+func TestCheckResponse(t *testing.T) {
+	d := MustLoad()
+	tests := []struct {
+		status      int
+		contentType string
+		body        string
+		ok          bool
+	}{
+		{200, "application/json", `{"rank":1,"username":"alice","rating":1500,"tier":"Silver"}`, true},
+		{200, "application/json; charset=utf-8", `{"rank":1,"username":"alice","rating":1500}`, true},
+		{200, "text/plain", `{"rank":1,"username":"alice","rating":1500}`, false},
+		{200, "application/json", `{"rank":1,"username":"alice"}`, false},
+		{200, "application/json", `{"rank":1,"username":"alice","rating":9000}`, false},
+		{200, "application/json", `{"rank":"1","username":"alice","rating":1500}`, false},
+		{200, "application/json", `{"rank":1,"username":"alice","rating":1500,"score":3}`, false},
+		{200, "application/json", `{"rank":1,"username":"alice","rating":1500`, false},
+		{404, "application/json", `{"code":"not_found","message":"User not found","details":{"username":"bob"}}`, true},
+		{404, "application/json", `{"code":"missing","message":"User not found"}`, false},
+		{500, "application/json", `{"code":"internal","message":"boom"}`, false},
+	}
+	for _, tt := range tests {
+		err := d.CheckResponse("GET", "/v1/user/{username}", tt.status, tt.contentType, []byte(tt.body))
+		if (err == nil) != tt.ok {
+			t.Errorf("%d %s %s: got %v", tt.status, tt.contentType, tt.body, err)
+		}
+	}
+
+	ndjson := "{\"rank\":1,\"username\":\"a\",\"rating\":200}\n{\"rank\":2,\"username\":\"b\",\"rating\":100}\n"
+	if err := d.CheckResponse("GET", "/v1/export", 200, "application/x-ndjson", []byte(ndjson)); err != nil {
+		t.Errorf("Expected the NDJSON export to match, got %v", err)
+	}
+	if err := d.CheckResponse("GET", "/v1/export", 200, "application/x-ndjson", []byte(ndjson+"{\"rank\":3}\n")); err == nil {
+		t.Error("Expected a bad NDJSON line to be reported")
+	}
+}
@@ -0,0 +1,320 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"leaderboard/handlers"
+	"leaderboard/models"
+	"leaderboard/router"
+)
+
+// maxBody bounds the JSON request bodies read for validation
+const maxBody = 1 << 20
+
+// Validate checks requests for method on the documented path before next
+// sees them. Parameters must have the documented type and fall within its
+// bounds, or the request is refused with invalid_parameter. JSON bodies
+// must parse and have the documented fields and types, or the request is
+// refused with invalid_request; bounds on body fields are left to next,
+// which answers them with more specific codes, such as invalid_rating.
+// It panics if the operation is not documented.
+func (d *Document) Validate(method, path string, next http.Handler) http.Handler {
+	op := d.Operation(method, path)
+	if op == nil {
+		panic(fmt.Sprintf("openapi: %s %s is not documented", method, path))
+	}
+	var bodySchema *Schema
+	if op.RequestBody != nil {
+		if media := op.RequestBody.Content["application/json"]; media != nil {
+			bodySchema = media.Schema
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, p := range op.Parameters {
+			var value string
+			var present bool
+			switch p.In {
+			case "query":
+				present = query.Has(p.Name)
+				value = query.Get(p.Name)
+			case "path":
+				// Requests that did not come through the router are left
+				// to next
+				value = router.Param(r, p.Name)
+				present = value != ""
+				if !present {
+					continue
+				}
+			default:
+				continue
+			}
+			if !present {
+				if p.Required {
+					handlers.InvalidParameter(w, p.Name, value, fmt.Sprintf("%s is required", p.Name))
+					return
+				}
+				continue
+			}
+			if err := d.checkParam(p, value); err != nil {
+				handlers.InvalidParameter(w, p.Name, value, err.Error())
+				return
+			}
+		}
+
+		if bodySchema != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+			if err != nil {
+				handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid request body", map[string]interface{}{"error": err.Error()})
+				return
+			}
+			if len(body) > maxBody {
+				handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Request body too large", map[string]interface{}{"limit": maxBody})
+				return
+			}
+			if len(bytes.TrimSpace(body)) == 0 && !op.RequestBody.Required {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				next.ServeHTTP(w, r)
+				return
+			}
+			value, err := decode(body)
+			if err != nil {
+				handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid request body", map[string]interface{}{"error": err.Error()})
+				return
+			}
+			v := validator{doc: d}
+			if err := v.check(bodySchema, value, ""); err != nil {
+				var details map[string]interface{}
+				if fe, ok := err.(*fieldError); ok && fe.field != "" {
+					details = map[string]interface{}{"field": fe.field}
+				}
+				handlers.WriteError(w, http.StatusBadRequest, models.ErrorInvalidRequest, err.Error(), details)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkParam parses a parameter as its schema's type and checks the value
+func (d *Document) checkParam(p *Parameter, value string) error {
+	s, err := d.resolveSchema(p.Schema)
+	if err != nil {
+		return err
+	}
+	var parsed interface{} = value
+	switch s.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", p.Name)
+		}
+		parsed = json.Number(strconv.FormatInt(n, 10))
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s must be a number", p.Name)
+		}
+		parsed = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", p.Name)
+		}
+		parsed = b
+	}
+	v := validator{doc: d, bounds: true}
+	if err := v.check(s, parsed, p.Name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// decode parses JSON keeping numbers exact, so integers can be told apart
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// fieldError is a value that does not match its schema
+type fieldError struct {
+	field   string // dotted path to the value, empty for the whole body
+	message string
+}
+
+func (e *fieldError) Error() string {
+	if e.field == "" {
+		return "body " + e.message
+	}
+	return e.field + " " + e.message
+}
+
+// validator checks decoded JSON against schemas. Types, required fields and
+// enums are always checked. With bounds it also checks minimums, maximums,
+// lengths and formats; strict refuses properties the schema does not list.
+type validator struct {
+	doc    *Document
+	bounds bool
+	strict bool
+}
+
+func (v validator) check(s *Schema, value interface{}, field string) error {
+	s, err := v.doc.resolveSchema(s)
+	if err != nil {
+		return err
+	}
+	fail := func(format string, args ...interface{}) error {
+		return &fieldError{field: field, message: fmt.Sprintf(format, args...)}
+	}
+
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fail("must not be null")
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return &fieldError{field: join(field, name), message: "is required"}
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop := s.Properties[name]
+			if prop == nil {
+				if v.strict && s.Properties != nil && !allowsAdditional(s.AdditionalProperties) {
+					return &fieldError{field: join(field, name), message: "is not documented"}
+				}
+				continue
+			}
+			if err := v.check(prop, obj[name], join(field, name)); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				if err := v.check(s.Items, item, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if v.bounds {
+			if s.MinLength != nil && len(str) < *s.MinLength {
+				return fail("must be at least %d characters", *s.MinLength)
+			}
+			if s.Format == "date-time" {
+				if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+					return fail("must be an RFC 3339 date-time")
+				}
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fail("must be a number")
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return fail("must be a number")
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil || f != math.Trunc(f) {
+				return fail("must be an integer")
+			}
+		}
+		if v.bounds {
+			if s.Minimum != nil && f < *s.Minimum {
+				return fail("must be at least %g", *s.Minimum)
+			}
+			if s.Maximum != nil && f > *s.Maximum {
+				return fail("must be at most %g", *s.Maximum)
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("must be true or false")
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			allowed[i] = fmt.Sprint(e)
+		}
+		got := fmt.Sprint(value)
+		for _, a := range allowed {
+			if a == got {
+				return nil
+			}
+		}
+		return fail("must be one of %s", strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+func allowsAdditional(additional interface{}) bool {
+	switch a := additional.(type) {
+	case bool:
+		return a
+	case map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// mediaType is the media type of a Content-Type header, without parameters
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return t
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"leaderboard/handlers"
+	"leaderboard/models"
+	"leaderboard/openapi"
+	"leaderboard/services"
+)
+
+// testAdminToken is the admin token of the servers under test
+const testAdminToken = "test-admin-token"
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := openapi.MustLoad()
+
+	// Every route is documented, at /v1 and as a deprecated alias
+	routed := map[string]bool{"GET /openapi.json": true}
+	for _, route := range apiRoutes(handlers.NewHandler(services.NewLeaderboardService())) {
+		for _, path := range []string{"/v1" + route.pattern, route.pattern} {
+			routed[route.method+" "+path] = true
+			op := spec.Operation(route.method, path)
+			if op == nil {
+				t.Errorf("%s %s is routed but not documented", route.method, path)
+				continue
+			}
+			if op.Deprecated != (path == route.pattern) {
+				t.Errorf("%s %s: deprecated is %v", route.method, path, op.Deprecated)
+			}
+		}
+	}
+
+	// and nothing else is
+	for path, item := range spec.Paths {
+		for method := range item {
+			if !routed[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is documented but not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	mux := setupRouter(services.NewLeaderboardService(), testAdminToken)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected the document, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || doc.Paths["/v1/leaderboard"] == nil || doc.Paths["/leaderboard"] == nil {
+		t.Errorf("Unexpected document: openapi %q with %d paths", doc.OpenAPI, len(doc.Paths))
+	}
+}
+
+// TestOpenAPIDrift sends requests for every documented status of every
+// operation, at /v1 and at the legacy path, and checks each response
+// against the document
+func TestOpenAPIDrift(t *testing.T) {
+	spec := openapi.MustLoad()
+
+	tests := []struct {
+		method, path, target string
+		contentType, body    string
+		empty                bool // served from a board with no users
+		status               int
+	}{
+		{"GET", "/v1/leaderboard", "/v1/leaderboard?limit=5", "", "", false, 200},
+		{"GET", "/v1/leaderboard", "/v1/leaderboard?tier=Gold&offset=1&consistent=true", "", "", false, 200},
+		{"GET", "/v1/leaderboard", "/v1/leaderboard?limit=abc", "", "", false, 400},
+		{"GET", "/v1/leaderboard", "/v1/leaderboard?tier=Mythic", "", "", false, 400},
+		{"DELETE", "/v1/leaderboard", "/v1/leaderboard", "", "", false, 405},
+
+		{"GET", "/v1/user/{username}", "/v1/user/user_007", "", "", false, 200},
+		{"GET", "/v1/user/{username}", "/v1/user/nobody", "", "", false, 404},
+		{"POST", "/v1/user/{username}", "/v1/user/user_007", "", "", false, 405},
+
+		{"GET", "/v1/tiers", "/v1/tiers", "", "", false, 200},
+		{"PUT", "/v1/tiers", "/v1/tiers", "", "", false, 405},
+
+		{"GET", "/v1/events/tier-changes", "/v1/events/tier-changes?limit=10", "", "", false, 200},
+		{"GET", "/v1/events/tier-changes", "/v1/events/tier-changes?since=-1", "", "", false, 400},
+		{"POST", "/v1/events/tier-changes", "/v1/events/tier-changes", "", "", false, 405},
+
+		{"GET", "/v1/search", "/v1/search?q=user_0&fuzzy=true", "", "", false, 200},
+		{"GET", "/v1/search", "/v1/search", "", "", false, 400},
+		{"POST", "/v1/search", "/v1/search?q=user", "", "", false, 405},
+
+		{"POST", "/v1/update-score", "/v1/update-score", "", "", false, 200},
+		{"POST", "/v1/update-score", "/v1/update-score", "", "", true, 500},
+		{"GET", "/v1/update-score", "/v1/update-score", "", "", false, 405},
+
+		{"POST", "/v1/update-user-score", "/v1/update-user-score", "application/json", `{"username":"user_001","rating":4321}`, false, 200},
+		{"POST", "/v1/update-user-score", "/v1/update-user-score", "application/json", `{"username":"user_001"`, false, 400},
+		{"POST", "/v1/update-user-score", "/v1/update-user-score", "application/json", `{"username":"nobody","rating":1000}`, false, 404},
+		{"POST", "/v1/update-user-score", "/v1/update-user-score", "application/json", `{"username":"user_001","rating":6000}`, false, 422},
+		{"GET", "/v1/update-user-score", "/v1/update-user-score", "", "", false, 405},
+
+		{"POST", "/v1/admin/import", "/v1/admin/import?mode=upsert", "text/csv", "username,rating\nnewcomer,1234\nbroken,abc\n", false, 200},
+		{"POST", "/v1/admin/import", "/v1/admin/import?format=xml", "", "", false, 400},
+		{"POST", "/v1/admin/import", "/v1/admin/import?format=csv", "", "", false, 401},
+		{"GET", "/v1/admin/import", "/v1/admin/import", "", "", false, 405},
+
+		{"GET", "/v1/export", "/v1/export?format=ndjson", "", "", false, 200},
+		{"GET", "/v1/export", "/v1/export", "", "", false, 200},
+		{"GET", "/v1/export", "/v1/export?format=xml", "", "", false, 400},
+		{"POST", "/v1/export", "/v1/export", "", "", false, 405},
+
+		{"GET", "/openapi.json", "/openapi.json", "", "", false, 200},
+		{"POST", "/openapi.json", "/openapi.json", "", "", false, 405},
+	}
+
+	seen := make(map[string]bool)
+	for _, tt := range tests {
+		targets := map[string]string{tt.path: tt.target}
+		if strings.HasPrefix(tt.path, "/v1/") {
+			targets[strings.TrimPrefix(tt.path, "/v1")] = strings.TrimPrefix(tt.target, "/v1")
+		}
+		for path, target := range targets {
+			service := services.NewLeaderboardService()
+			if !tt.empty {
+				for i := 0; i < 50; i++ {
+					name := fmt.Sprintf("user_%03d", i)
+					service.AddUser(&models.User{ID: name, Username: name, Rating: 100 + i*97%4900})
+				}
+				// A promotion, so there are tier changes to list
+				service.UpdateRating("user_000", 4900)
+			}
+			mux := setupRouter(service, testAdminToken)
+
+			req := httptest.NewRequest(tt.method, target, strings.NewReader(tt.body))
+			if tt.status != http.StatusUnauthorized {
+				req.Header.Set("Authorization", "Bearer "+testAdminToken)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("%s %s: got %d, want %d: %s", tt.method, target, rr.Code, tt.status, rr.Body.String())
+				continue
+			}
+			if tt.status == http.StatusOK && path != tt.path && rr.Header().Get("Deprecation") == "" {
+				t.Errorf("%s %s: expected a Deprecation header", tt.method, target)
+			}
+
+			// Methods the router refuses are checked against the operations
+			// the path does have
+			method := tt.method
+			if rr.Code == http.StatusMethodNotAllowed {
+				for _, allowed := range strings.Split(rr.Header().Get("Allow"), ", ") {
+					if spec.Operation(allowed, path) != nil {
+						method = allowed
+						break
+					}
+				}
+			}
+			if err := spec.CheckResponse(method, path, rr.Code, rr.Header().Get("Content-Type"), rr.Body.Bytes()); err != nil {
+				t.Errorf("%s %s: %v", tt.method, target, err)
+			}
+			seen[fmt.Sprintf("%s %s %d", strings.ToUpper(method), path, rr.Code)] = true
+		}
+	}
+
+	// Every documented status is exercised, so a status the handlers no
+	// longer send is noticed too
+	var missing []string
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			for status := range op.Responses {
+				if key := fmt.Sprintf("%s %s %s", strings.ToUpper(method), path, status); !seen[key] {
+					missing = append(missing, key)
+				}
+			}
+		}
+	}
+	sort.Strings(missing)
+	for _, key := range missing {
+		t.Errorf("No response checked for %s", key)
+	}
+}
@@ -0,0 +1,158 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPTransport sends requests to the Handler of each peer
+type HTTPTransport struct {
+	URLs   map[string]string // base URL of each node by ID
+	Client *http.Client      // defaults to http.DefaultClient
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error) {
+	var resp VoteResponse
+	err := t.post(ctx, peer, "/raft/vote", req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error) {
+	var resp AppendResponse
+	err := t.post(ctx, peer, "/raft/append", req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	var resp SnapshotResponse
+	err := t.post(ctx, peer, "/raft/snapshot", req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) post(ctx context.Context, peer, path string, req, resp interface{}) error {
+	base, ok := t.URLs[peer]
+	if !ok {
+		return fmt.Errorf("raft: unknown peer %s", peer)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(base, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1024))
+		return fmt.Errorf("raft: peer %s answered %s: %s", peer, httpResp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// Handler serves the node's side of HTTPTransport, and its status:
+//
+//	POST /raft/vote     - VoteRequest
+//	POST /raft/append   - AppendRequest
+//	POST /raft/snapshot - SnapshotRequest
+//	GET  /raft/status   - the node's Status
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/vote", rpcHandler(n.HandleVote))
+	mux.HandleFunc("/raft/append", rpcHandler(n.HandleAppend))
+	mux.HandleFunc("/raft/snapshot", rpcHandler(n.HandleSnapshot))
+	mux.HandleFunc("/raft/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(n.Status())
+	})
+	return mux
+}
+
+func rpcHandler[Req, Resp any](handle func(Req) Resp) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handle(req))
+	}
+}
+
+// RedirectWrites sends every request that could change the board to the
+// leader, found in urls by ID, with a 307 so the method and body are kept.
+// On the leader itself, a write waits briefly for a new leader to catch up
+// with the log. Requests under /raft/ pass through.
+func RedirectWrites(node *Node, urls map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/raft/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leader := node.Leader()
+		if leader == node.ID() {
+			if !waitReady(r.Context(), node) {
+				http.Error(w, "Leader is not ready, try again", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		base, ok := urls[leader]
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "No leader is elected, try again", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, strings.TrimRight(base, "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
+
+// waitReady waits up to an election timeout for the node to be ready to
+// take writes
+func waitReady(ctx context.Context, node *Node) bool {
+	deadline := time.Now().Add(node.cfg.ElectionTimeout)
+	for !node.Ready() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(node.cfg.HeartbeatInterval / 5):
+		}
+	}
+	return true
+}
@@ -0,0 +1,749 @@
+// Package raft replicates the leaderboard's mutations over a Raft log. Every
+// node holds a full board; the elected leader takes writes, and a mutation
+// is only applied once a majority of nodes have stored it.
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"leaderboard/models"
+	"leaderboard/services"
+	"leaderboard/snapshot"
+)
+
+// Roles a node can be in
+const (
+	RoleFollower  = "follower"
+	RoleCandidate = "candidate"
+	RoleLeader    = "leader"
+)
+
+// Defaults for the zero values of Config
+const (
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+	DefaultElectionTimeout   = 500 * time.Millisecond
+	DefaultSnapshotThreshold = 10000
+	DefaultCommitTimeout     = 5 * time.Second
+)
+
+// maxEntries caps the entries sent to a follower in one request
+const maxEntries = 1000
+
+// snapshotTimeout bounds sending a snapshot, which can be far larger than
+// any other request
+const snapshotTimeout = time.Minute
+
+var (
+	// ErrNotReady means a new leader has not yet applied the entries it
+	// inherited, so it cannot tell which version the next mutation gets
+	ErrNotReady = errors.New("raft: leader is still applying earlier entries")
+
+	// ErrLeadershipLost means the node stopped leading before the entry was
+	// committed. The entry may still be committed by the next leader.
+	ErrLeadershipLost = errors.New("raft: leadership lost before the entry was committed")
+
+	// ErrTimeout means a majority did not store the entry in time. As with
+	// ErrLeadershipLost it may still be committed later.
+	ErrTimeout = errors.New("raft: timed out waiting for the entry to be committed")
+
+	// ErrStopped means the node was stopped
+	ErrStopped = errors.New("raft: node stopped")
+)
+
+// NotLeaderError rejects a write sent to a node that is not the leader
+type NotLeaderError struct {
+	Leader string // the leader's ID, "" while none is known
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "raft: not the leader, no leader is known"
+	}
+	return fmt.Sprintf("raft: not the leader, the leader is %s", e.Leader)
+}
+
+// Entry is one slot of the replicated log. Mutation is nil for the no-op a
+// new leader appends to commit the entries of earlier terms.
+type Entry struct {
+	Index    uint64           `json:"index"`
+	Term     uint64           `json:"term"`
+	Mutation *models.Mutation `json:"mutation,omitempty"`
+}
+
+// HardState is what a node must remember across restarts to vote safely
+type HardState struct {
+	Term uint64 `json:"term"`
+	Vote string `json:"vote,omitempty"`
+}
+
+// Snapshot replaces the log up to Index. Data is the board encoded by
+// snapshot.Encode; it may include mutations past Index, which are skipped by
+// seq when their entries are applied.
+type Snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// Status describes a node's view of the cluster
+type Status struct {
+	ID            string `json:"id"`
+	Role          string `json:"role"`
+	Term          uint64 `json:"term"`
+	Leader        string `json:"leader,omitempty"`
+	Commit        uint64 `json:"commit"`
+	Applied       uint64 `json:"applied"`
+	LastIndex     uint64 `json:"last_index"`
+	SnapshotIndex uint64 `json:"snapshot_index"`
+	Version       uint64 `json:"version"`
+}
+
+// Config sets up a Node. ID, Peers, Service, Storage and Transport are
+// required; the durations and threshold have defaults.
+type Config struct {
+	ID        string
+	Peers     []string // IDs of the other nodes
+	Service   *services.LeaderboardService
+	Storage   Storage
+	Transport Transport
+
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration // each wait is randomized up to twice this
+	SnapshotThreshold uint64        // applied entries between snapshots
+	CommitTimeout     time.Duration // how long a write waits for a majority
+}
+
+type waiter struct {
+	term uint64
+	done chan error
+}
+
+// Node runs one member of a Raft cluster around a LeaderboardService. It is
+// the service's MutationLog: on the leader a mutation is applied once it is
+// committed, and every node applies committed entries from other leaders in
+// the background.
+type Node struct {
+	cfg     Config
+	quorum  int
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	applyCh chan struct{}
+
+	applyMu sync.Mutex // held while changing the service from the log; taken before mu
+
+	mu       sync.Mutex
+	role     string
+	term     uint64
+	vote     string
+	leader   string
+	snap     Snapshot
+	log      []Entry // entries after snap.Index
+	commit   uint64
+	applied  uint64
+	deadline time.Time // when a follower starts an election
+	next     map[string]uint64
+	match    map[string]uint64
+	acked    map[string]time.Time
+	inflight map[string]bool
+	waiters  map[uint64]waiter
+}
+
+// NewNode restores the node's state from cfg.Storage into cfg.Service, which
+// must be empty, attaches itself as the service's mutation log and starts
+// taking part in elections. Stop it to leave the cluster.
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.ID == "" || cfg.Service == nil || cfg.Storage == nil || cfg.Transport == nil {
+		return nil, errors.New("raft: ID, Service, Storage and Transport are required")
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if cfg.CommitTimeout <= 0 {
+		cfg.CommitTimeout = DefaultCommitTimeout
+	}
+
+	state, snap, entries, err := cfg.Storage.Load()
+	if err != nil {
+		return nil, fmt.Errorf("raft: loading state: %w", err)
+	}
+	if snap.Index > 0 {
+		if err := restore(cfg.Service, snap.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	n := &Node{
+		cfg:      cfg,
+		quorum:   (len(cfg.Peers)+1)/2 + 1,
+		applyCh:  make(chan struct{}, 1),
+		role:     RoleFollower,
+		term:     state.Term,
+		vote:     state.Vote,
+		snap:     snap,
+		log:      entries,
+		commit:   snap.Index,
+		applied:  snap.Index,
+		next:     make(map[string]uint64),
+		match:    make(map[string]uint64),
+		acked:    make(map[string]time.Time),
+		inflight: make(map[string]bool),
+		waiters:  make(map[uint64]waiter),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.resetDeadlineLocked()
+	cfg.Service.SetMutationLog(n)
+
+	n.wg.Add(2)
+	go n.run()
+	go n.applyLoop()
+	return n, nil
+}
+
+// Stop leaves the cluster. Writes waiting on the node fail with ErrStopped.
+func (n *Node) Stop() {
+	n.cancel()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.failWaitersLocked(ErrStopped)
+	n.role = RoleFollower
+	n.leader = ""
+}
+
+// ID returns the node's ID
+func (n *Node) ID() string {
+	return n.cfg.ID
+}
+
+// Leader returns the ID of the current leader, "" while none is known
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Status reports the node's role, term and log positions
+func (n *Node) Status() Status {
+	version := n.cfg.Service.Version()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:            n.cfg.ID,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leader,
+		Commit:        n.commit,
+		Applied:       n.applied,
+		LastIndex:     n.lastIndexLocked(),
+		SnapshotIndex: n.snap.Index,
+		Version:       version,
+	}
+}
+
+// Ready reports whether the node is the leader and its service has caught up
+// with the log, so writes to it will be accepted
+func (n *Node) Ready() bool {
+	n.mu.Lock()
+	ready := n.role == RoleLeader && n.termAtLocked(n.commit) == n.term
+	lastSeq := n.lastSeqLocked()
+	n.mu.Unlock()
+
+	// The service is read without holding mu, as a writer appending holds
+	// the service's writer lock and may be waiting on mu
+	return ready && n.cfg.Service.Version() >= lastSeq
+}
+
+// Remote marks the node as a services.RemoteLog, so the service serves
+// reads while Append waits for a majority
+func (n *Node) Remote() {}
+
+// Append proposes m and waits until a majority of nodes have stored it. The
+// service calls it holding its writer lock but not its read lock, so the
+// mutation is applied locally right after, the next one gets the following
+// seq, and reads are not held up by the round trip.
+func (n *Node) Append(m models.Mutation) error {
+	_, err := n.AppendBatch([]models.Mutation{m})
+	return err
+}
+
+// AppendBatch proposes ms as consecutive entries and waits, as Append does,
+// until a majority has stored them all, so a batch costs one round trip.
+// It returns len(ms) once they are committed and 0 otherwise.
+func (n *Node) AppendBatch(ms []models.Mutation) (int, error) {
+	if len(ms) == 0 {
+		return 0, nil
+	}
+
+	n.mu.Lock()
+	if n.role != RoleLeader {
+		leader := n.leader
+		n.mu.Unlock()
+		return 0, &NotLeaderError{Leader: leader}
+	}
+	if n.lastSeqLocked() >= ms[0].Seq {
+		n.mu.Unlock()
+		return 0, ErrNotReady
+	}
+
+	entries := make([]Entry, len(ms))
+	for i := range ms {
+		m := ms[i]
+		entries[i] = Entry{Index: n.lastIndexLocked() + 1 + uint64(i), Term: n.term, Mutation: &m}
+	}
+	if err := n.appendLocked(entries...); err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+	// The entries commit in order, so the last one stands for them all
+	last := entries[len(entries)-1].Index
+	done := make(chan error, 1)
+	n.waiters[last] = waiter{term: n.term, done: done}
+	n.broadcastLocked()
+	n.advanceCommitLocked() // a single node is its own majority
+	n.mu.Unlock()
+
+	timer := time.NewTimer(n.cfg.CommitTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return committed(len(ms), err)
+	case <-timer.C:
+	case <-n.ctx.Done():
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.waiters, last)
+	select {
+	case err := <-done: // resolved while we took the lock
+		return committed(len(ms), err)
+	default:
+	}
+	if n.ctx.Err() != nil {
+		return 0, ErrStopped
+	}
+	return 0, ErrTimeout
+}
+
+// committed is what AppendBatch returns for a batch of n once its waiter
+// resolves with err
+func committed(n int, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// run drives heartbeats and elections
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		switch {
+		case n.role == RoleLeader && !n.hasQuorumLocked():
+			// Cut off from the majority; let them elect someone reachable
+			n.becomeFollowerLocked(n.term, "")
+		case n.role == RoleLeader:
+			n.broadcastLocked()
+		case time.Now().After(n.deadline):
+			n.campaignLocked()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// hasQuorumLocked reports whether a majority answered the leader within an
+// election timeout
+func (n *Node) hasQuorumLocked() bool {
+	reached := 1
+	for _, peer := range n.cfg.Peers {
+		if time.Since(n.acked[peer]) < n.cfg.ElectionTimeout {
+			reached++
+		}
+	}
+	return reached >= n.quorum
+}
+
+func (n *Node) resetDeadlineLocked() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// campaignLocked starts an election for the next term
+func (n *Node) campaignLocked() {
+	n.resetDeadlineLocked()
+	if err := n.cfg.Storage.SaveState(HardState{Term: n.term + 1, Vote: n.cfg.ID}); err != nil {
+		log.Printf("raft %s: saving state: %v", n.cfg.ID, err)
+		return
+	}
+	n.term++
+	n.vote = n.cfg.ID
+	n.role = RoleCandidate
+	n.leader = ""
+
+	votes := 1
+	if votes >= n.quorum {
+		n.becomeLeaderLocked()
+		return
+	}
+
+	term := n.term
+	lastIndex := n.lastIndexLocked()
+	req := VoteRequest{Term: term, Candidate: n.cfg.ID, LastIndex: lastIndex, LastTerm: n.termAtLocked(lastIndex)}
+	for _, peer := range n.cfg.Peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(n.ctx, n.cfg.ElectionTimeout)
+			defer cancel()
+			resp, err := n.cfg.Transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.becomeFollowerLocked(resp.Term, "")
+				return
+			}
+			if n.role != RoleCandidate || n.term != term || !resp.Granted {
+				return
+			}
+			if votes++; votes >= n.quorum {
+				n.becomeLeaderLocked()
+			}
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeaderLocked() {
+	n.role = RoleLeader
+	n.leader = n.cfg.ID
+	now := time.Now()
+	for _, peer := range n.cfg.Peers {
+		n.next[peer] = n.lastIndexLocked() + 1
+		n.match[peer] = 0
+		n.acked[peer] = now
+	}
+
+	// Entries from earlier terms only commit along with one of this term
+	if err := n.appendLocked(Entry{Index: n.lastIndexLocked() + 1, Term: n.term}); err != nil {
+		log.Printf("raft %s: appending no-op: %v", n.cfg.ID, err)
+		n.becomeFollowerLocked(n.term, "")
+		return
+	}
+	n.broadcastLocked()
+	n.advanceCommitLocked()
+}
+
+// becomeFollowerLocked moves to term, which must not be behind the current
+// one, following leader if known
+func (n *Node) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.term {
+		if err := n.cfg.Storage.SaveState(HardState{Term: term}); err != nil {
+			log.Printf("raft %s: saving state: %v", n.cfg.ID, err)
+		}
+		n.term = term
+		n.vote = ""
+	}
+	if n.role == RoleLeader {
+		n.failWaitersLocked(ErrLeadershipLost)
+	}
+	n.role = RoleFollower
+	n.leader = leader
+}
+
+func (n *Node) failWaitersLocked(err error) {
+	for index, w := range n.waiters {
+		w.done <- err
+		delete(n.waiters, index)
+	}
+}
+
+// appendLocked stores entries after the last one
+func (n *Node) appendLocked(entries ...Entry) error {
+	if err := n.cfg.Storage.SaveEntries(n.lastIndexLocked()+1, entries); err != nil {
+		return fmt.Errorf("raft: saving entries: %w", err)
+	}
+	n.log = append(n.log, entries...)
+	return nil
+}
+
+// broadcastLocked brings every follower up to date, or sends a heartbeat
+func (n *Node) broadcastLocked() {
+	for _, peer := range n.cfg.Peers {
+		n.sendLocked(peer)
+	}
+}
+
+// sendLocked sends peer the entries after its next index, or the snapshot
+// when those have been compacted. Only one request per peer is in flight;
+// its response sends whatever was appended meanwhile.
+func (n *Node) sendLocked(peer string) {
+	if n.inflight[peer] {
+		return
+	}
+	n.inflight[peer] = true
+	term := n.term
+
+	next := n.next[peer]
+	if next <= n.snap.Index {
+		req := SnapshotRequest{Term: term, Leader: n.cfg.ID, Snapshot: n.snap}
+		go func() {
+			ctx, cancel := context.WithTimeout(n.ctx, snapshotTimeout)
+			defer cancel()
+			resp, err := n.cfg.Transport.InstallSnapshot(ctx, peer, req)
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			n.inflight[peer] = false
+			if err != nil || !n.answeredLocked(peer, term, resp.Term) {
+				return
+			}
+			// A follower that failed to install it is sent it again on the
+			// next heartbeat
+			if !resp.Success {
+				return
+			}
+			n.match[peer] = max(n.match[peer], req.Snapshot.Index)
+			n.next[peer] = n.match[peer] + 1
+			n.advanceCommitLocked()
+			n.sendLocked(peer)
+		}()
+		return
+	}
+
+	prev := next - 1
+	from := int(next - n.snap.Index - 1)
+	to := min(len(n.log), from+maxEntries)
+	req := AppendRequest{
+		Term:      term,
+		Leader:    n.cfg.ID,
+		PrevIndex: prev,
+		PrevTerm:  n.termAtLocked(prev),
+		Entries:   append([]Entry(nil), n.log[from:to]...),
+		Commit:    n.commit,
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(n.ctx, n.cfg.ElectionTimeout)
+		defer cancel()
+		resp, err := n.cfg.Transport.AppendEntries(ctx, peer, req)
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.inflight[peer] = false
+		if err != nil || !n.answeredLocked(peer, term, resp.Term) {
+			return
+		}
+		if resp.Success {
+			n.match[peer] = max(n.match[peer], req.PrevIndex+uint64(len(req.Entries)))
+			n.next[peer] = n.match[peer] + 1
+			n.advanceCommitLocked()
+			if n.next[peer] <= n.lastIndexLocked() {
+				n.sendLocked(peer)
+			}
+			return
+		}
+		// Back up to where the follower's log may agree and try again
+		n.next[peer] = max(min(resp.ConflictIndex, req.PrevIndex), n.match[peer]+1, 1)
+		n.sendLocked(peer)
+	}()
+}
+
+// answeredLocked handles the term of a response to a request sent in term,
+// reporting whether the node is still leading it
+func (n *Node) answeredLocked(peer string, term, respTerm uint64) bool {
+	if respTerm > n.term {
+		n.becomeFollowerLocked(respTerm, "")
+		return false
+	}
+	if n.role != RoleLeader || n.term != term {
+		return false
+	}
+	n.acked[peer] = time.Now()
+	return true
+}
+
+// advanceCommitLocked commits the last entry of the current term stored on a
+// majority, and with it everything before
+func (n *Node) advanceCommitLocked() {
+	if n.role != RoleLeader {
+		return
+	}
+	for index := n.lastIndexLocked(); index > n.commit; index-- {
+		if n.termAtLocked(index) != n.term {
+			return
+		}
+		stored := 1
+		for _, peer := range n.cfg.Peers {
+			if n.match[peer] >= index {
+				stored++
+			}
+		}
+		if stored >= n.quorum {
+			n.commitLocked(index)
+			return
+		}
+	}
+}
+
+// commitLocked marks entries up to index committed, releases their writers
+// and wakes the apply loop
+func (n *Node) commitLocked(index uint64) {
+	n.commit = index
+	for i, w := range n.waiters {
+		if i > index {
+			continue
+		}
+		if n.termAtLocked(i) == w.term {
+			w.done <- nil
+		} else {
+			w.done <- ErrLeadershipLost
+		}
+		delete(n.waiters, i)
+	}
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) lastIndexLocked() uint64 {
+	return n.snap.Index + uint64(len(n.log))
+}
+
+// termAtLocked returns the term of the entry at index, 0 when it is not held
+func (n *Node) termAtLocked(index uint64) uint64 {
+	if index == n.snap.Index {
+		return n.snap.Term
+	}
+	if index < n.snap.Index || index > n.lastIndexLocked() {
+		return 0
+	}
+	return n.log[index-n.snap.Index-1].Term
+}
+
+// lastSeqLocked returns the seq of the last mutation in the log
+func (n *Node) lastSeqLocked() uint64 {
+	for i := len(n.log) - 1; i >= 0; i-- {
+		if m := n.log[i].Mutation; m != nil {
+			return m.Seq
+		}
+	}
+	return 0
+}
+
+// applyLoop applies committed entries as they are committed
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.applyCh:
+		}
+		n.applyCommitted()
+	}
+}
+
+// applyCommitted applies the committed entries the service lacks, then
+// compacts the log once enough have been applied. On the leader the service
+// already applied its own entries as they were committed, so those are
+// skipped by seq.
+func (n *Node) applyCommitted() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	from, to := n.applied, n.commit
+	entries := append([]Entry(nil), n.log[from-n.snap.Index:to-n.snap.Index]...)
+	n.mu.Unlock()
+
+	for _, e := range entries {
+		if e.Mutation == nil {
+			continue
+		}
+		// The leader checked the mutation against the same board, so
+		// this only fails if the log was damaged
+		if _, err := n.cfg.Service.ApplyIfNewer(*e.Mutation); err != nil {
+			log.Printf("raft %s: applying entry %d: %v", n.cfg.ID, e.Index, err)
+		}
+	}
+
+	n.mu.Lock()
+	n.applied = to
+	compact := n.applied-n.snap.Index >= n.cfg.SnapshotThreshold
+	n.mu.Unlock()
+
+	if compact {
+		if err := n.compact(); err != nil {
+			log.Printf("raft %s: compacting: %v", n.cfg.ID, err)
+		}
+	}
+}
+
+// compact replaces the applied part of the log with a snapshot of the board.
+// The caller holds applyMu.
+func (n *Node) compact() error {
+	n.mu.Lock()
+	index := n.applied
+	term := n.termAtLocked(index)
+	n.mu.Unlock()
+
+	var buf bytes.Buffer
+	err := n.cfg.Service.WithView(func(v services.View) error {
+		return snapshot.Encode(&buf, v.Version, v.Count, v.Each)
+	})
+	if err != nil {
+		return err
+	}
+	snap := Snapshot{Index: index, Term: term, Data: buf.Bytes()}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.cfg.Storage.SaveSnapshot(snap); err != nil {
+		return err
+	}
+	n.log = append([]Entry(nil), n.log[index-n.snap.Index:]...)
+	n.snap = snap
+	return nil
+}
+
+// restore loads a snapshot's board into service
+func restore(service *services.LeaderboardService, data []byte) error {
+	var users []models.User
+	seq, err := snapshot.Decode(bytes.NewReader(data), func(u models.User) error {
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("raft: reading snapshot: %w", err)
+	}
+	if err := service.Replace(seq, users); err != nil {
+		return fmt.Errorf("raft: restoring snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,603 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// network connects nodes in memory. Nodes can be taken off it, to kill
+// them, or cut off from each other.
+type network struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	cut   map[string]bool
+}
+
+func (net *network) transport(from string) Transport {
+	return &localTransport{net: net, from: from}
+}
+
+func (net *network) peer(from, to string) (*Node, error) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	node := net.nodes[to]
+	if node == nil || net.cut[from] || net.cut[to] {
+		return nil, errUnreachable
+	}
+	return node, nil
+}
+
+type localTransport struct {
+	net  *network
+	from string
+}
+
+func (t *localTransport) RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error) {
+	node, err := t.net.peer(t.from, peer)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+	return node.HandleVote(req), nil
+}
+
+func (t *localTransport) AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error) {
+	node, err := t.net.peer(t.from, peer)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+	return node.HandleAppend(req), nil
+}
+
+func (t *localTransport) InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	node, err := t.net.peer(t.from, peer)
+	if err != nil {
+		return SnapshotResponse{}, err
+	}
+	return node.HandleSnapshot(req), nil
+}
+
+// testCluster runs nodes n1..nN over a network. Each node keeps its storage
+// when killed, so it restarts from it with a fresh service.
+type testCluster struct {
+	t         *testing.T
+	ids       []string
+	net       *network
+	storage   map[string]Storage
+	services  map[string]*services.LeaderboardService
+	threshold uint64
+}
+
+func startCluster(t *testing.T, size int, threshold uint64) *testCluster {
+	t.Helper()
+	c := &testCluster{
+		t:         t,
+		net:       &network{nodes: make(map[string]*Node), cut: make(map[string]bool)},
+		storage:   make(map[string]Storage),
+		services:  make(map[string]*services.LeaderboardService),
+		threshold: threshold,
+	}
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		c.ids = append(c.ids, id)
+		c.storage[id] = NewMemoryStorage()
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for _, id := range c.ids {
+			c.kill(id)
+		}
+	})
+	return c
+}
+
+func (c *testCluster) start(id string) {
+	c.t.Helper()
+	var peers []string
+	for _, other := range c.ids {
+		if other != id {
+			peers = append(peers, other)
+		}
+	}
+	service := services.NewLeaderboardService()
+	node, err := NewNode(Config{
+		ID:                id,
+		Peers:             peers,
+		Service:           service,
+		Storage:           c.storage[id],
+		Transport:         c.net.transport(id),
+		HeartbeatInterval: 10 * time.Millisecond,
+		ElectionTimeout:   60 * time.Millisecond,
+		SnapshotThreshold: c.threshold,
+		CommitTimeout:     time.Second,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	c.net.mu.Lock()
+	c.net.nodes[id] = node
+	c.net.mu.Unlock()
+	c.services[id] = service
+}
+
+func (c *testCluster) kill(id string) {
+	c.net.mu.Lock()
+	node := c.net.nodes[id]
+	delete(c.net.nodes, id)
+	c.net.mu.Unlock()
+	if node != nil {
+		node.Stop()
+	}
+}
+
+func (c *testCluster) node(id string) *Node {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+	return c.net.nodes[id]
+}
+
+func (c *testCluster) setCut(id string, cut bool) {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+	c.net.cut[id] = cut
+}
+
+// leader waits for a ready leader among the running nodes that are not
+// cut off
+func (c *testCluster) leader() string {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, id := range c.ids {
+			c.net.mu.Lock()
+			cut := c.net.cut[id]
+			c.net.mu.Unlock()
+			if node := c.node(id); node != nil && !cut && node.Ready() {
+				return id
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.t.Fatal("No leader was elected")
+	return ""
+}
+
+// write adds users on the leader, retrying when leadership moves
+func (c *testCluster) write(from, to int) {
+	c.t.Helper()
+	for i := from; i < to; i++ {
+		user := &models.User{ID: fmt.Sprint(i), Username: fmt.Sprintf("user_%d", i), Rating: 100 + i*37%4900}
+		for attempt := 0; ; attempt++ {
+			err := c.services[c.leader()].AddUser(user)
+			if err == nil || strings.Contains(err.Error(), "already exists") {
+				break
+			}
+			if attempt == 20 {
+				c.t.Fatalf("Adding %s: %v", user.Username, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// converge waits for the running nodes to hold the same board at the same
+// version, with want users
+func (c *testCluster) converge(want int) {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		diff := c.diff(want)
+		if diff == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatal(diff)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c *testCluster) diff(want int) string {
+	var first *services.LeaderboardService
+	var firstID string
+	for _, id := range c.ids {
+		if c.node(id) == nil {
+			continue
+		}
+		service := c.services[id]
+		if service.GetUserCount() != want {
+			return fmt.Sprintf("%s has %d users, want %d", id, service.GetUserCount(), want)
+		}
+		if first == nil {
+			first, firstID = service, id
+			continue
+		}
+		if service.Version() != first.Version() {
+			return fmt.Sprintf("%s is at version %d, %s at %d", id, service.Version(), firstID, first.Version())
+		}
+		a, b := first.GetUsersInRange(0, want), service.GetUsersInRange(0, want)
+		for i := range a {
+			if a[i] != b[i] {
+				return fmt.Sprintf("Row %d: %s has %+v, %s has %+v", i, firstID, a[i], id, b[i])
+			}
+		}
+	}
+	return ""
+}
+
+func TestElection(t *testing.T) {
+	c := startCluster(t, 3, 0)
+	leader := c.leader()
+
+	// Everyone follows the one leader in its term
+	term := c.node(leader).Status().Term
+	deadline := time.Now().Add(2 * time.Second)
+	for _, id := range c.ids {
+		for {
+			status := c.node(id).Status()
+			if status.Leader == leader && status.Term == term {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s follows %q in term %d, want %s in %d", id, status.Leader, status.Term, leader, term)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if role := c.node(id).Status().Role; (id == leader) != (role == RoleLeader) {
+			t.Errorf("%s is a %s", id, role)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	c := startCluster(t, 3, 0)
+	c.write(0, 100)
+
+	leader := c.services[c.leader()]
+	if err := leader.UpdateRating("user_1", 4999); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.RenameUser("user_2", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.RemoveUser("user_3"); err != nil {
+		t.Fatal(err)
+	}
+	c.converge(99)
+
+	for _, id := range c.ids {
+		if user, err := c.services[id].GetUserRank("user_1"); err != nil || user.Rank != 1 {
+			t.Errorf("%s: expected user_1 ranked first, got %+v %v", id, user, err)
+		}
+	}
+}
+
+func TestReplicateImport(t *testing.T) {
+	c := startCluster(t, 3, 0)
+	leader := c.leader()
+
+	users := make([]models.User, 50)
+	for i := range users {
+		users[i] = models.User{ID: fmt.Sprint(i), Username: fmt.Sprintf("user_%d", i), Rating: 100 + i*37}
+	}
+	before := c.node(leader).Status().LastIndex
+	if added, _, errs := c.services[leader].ImportUsers(users, false); added != len(users) {
+		t.Fatalf("Expected every user added, got %d: %v", added, errs)
+	}
+	if got := c.node(leader).Status().LastIndex - before; got != uint64(len(users)) {
+		t.Errorf("Expected an entry per user, got %d", got)
+	}
+	c.converge(len(users))
+}
+
+func TestFollowerRejectsWrites(t *testing.T) {
+	c := startCluster(t, 3, 0)
+	leader := c.leader()
+	c.write(0, 10)
+	c.converge(10)
+
+	for _, id := range c.ids {
+		if id == leader {
+			continue
+		}
+		err := c.services[id].UpdateRating("user_1", 4000)
+		var notLeader *NotLeaderError
+		if !errors.As(err, &notLeader) || notLeader.Leader != leader {
+			t.Errorf("%s: expected a NotLeaderError naming %s, got %v", id, leader, err)
+		}
+		if user, _ := c.services[id].GetUserRank("user_1"); user.Rating == 4000 {
+			t.Errorf("%s applied a rejected write", id)
+		}
+	}
+}
+
+func TestKillFollower(t *testing.T) {
+	c := startCluster(t, 3, 0)
+	leader := c.leader()
+	c.write(0, 20)
+
+	follower := c.ids[0]
+	if follower == leader {
+		follower = c.ids[1]
+	}
+	c.kill(follower)
+	c.write(20, 50) // two of three nodes are still a majority
+	c.start(follower)
+	c.converge(50)
+}
+
+func TestKillLeader(t *testing.T) {
+	c := startCluster(t, 5, 0)
+	c.write(0, 20)
+
+	old := c.leader()
+	c.kill(old)
+	if next := c.leader(); next == old {
+		t.Fatal("The killed leader is still leading")
+	}
+	c.write(20, 40)
+
+	// A second failure still leaves three of five
+	c.kill(c.leader())
+	c.write(40, 60)
+
+	c.start(old)
+	c.converge(60)
+	if role := c.node(old).Status().Role; role == RoleLeader {
+		t.Logf("%s took the lead back after restarting", old)
+	}
+	c.write(60, 70)
+	c.converge(70)
+}
+
+func TestRestartAll(t *testing.T) {
+	c := startCluster(t, 3, 20)
+	c.write(0, 50)
+	c.converge(50)
+	for _, id := range c.ids {
+		c.kill(id)
+	}
+
+	// Every node rebuilds its board from its snapshot and log
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	c.leader()
+	c.converge(50)
+	c.write(50, 60)
+	c.converge(60)
+}
+
+func TestSnapshotCatchUp(t *testing.T) {
+	c := startCluster(t, 3, 10)
+	leader := c.leader()
+	follower := c.ids[0]
+	if follower == leader {
+		follower = c.ids[1]
+	}
+
+	c.kill(follower)
+	c.storage[follower] = NewMemoryStorage() // lost its disk
+	c.write(0, 100)
+	deadline := time.Now().Add(2 * time.Second)
+	for status := c.node(leader).Status(); status.SnapshotIndex == 0; status = c.node(leader).Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the leader to compact its log, got %+v", status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	c.start(follower)
+	c.converge(100)
+	if status := c.node(follower).Status(); status.SnapshotIndex == 0 {
+		t.Errorf("Expected the follower to catch up from a snapshot, got %+v", status)
+	}
+}
+
+// failingStorage refuses snapshots while fail is set
+type failingStorage struct {
+	Storage
+	mu   sync.Mutex
+	fail bool
+}
+
+func (s *failingStorage) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.Storage.SaveSnapshot(snap)
+}
+
+func (s *failingStorage) setFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func TestSnapshotFailureNotCounted(t *testing.T) {
+	c := startCluster(t, 3, 10)
+	leader := c.leader()
+	follower := c.ids[0]
+	if follower == leader {
+		follower = c.ids[1]
+	}
+
+	c.kill(follower)
+	storage := &failingStorage{Storage: NewMemoryStorage(), fail: true}
+	c.storage[follower] = storage
+	c.write(0, 100)
+	deadline := time.Now().Add(2 * time.Second)
+	for c.node(leader).Status().SnapshotIndex == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the leader to compact its log")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	snapIndex := c.node(leader).Status().SnapshotIndex
+
+	// The follower keeps failing to save the snapshot, so the leader must
+	// not think it holds it
+	c.start(follower)
+	time.Sleep(200 * time.Millisecond)
+	node := c.node(leader)
+	node.mu.Lock()
+	match := node.match[follower]
+	node.mu.Unlock()
+	if match >= snapIndex {
+		t.Errorf("Expected no match for a follower that failed to install the snapshot, got %d of %d", match, snapIndex)
+	}
+
+	// It catches up once it can
+	storage.setFail(false)
+	c.converge(100)
+}
+
+func TestPartitionedLeader(t *testing.T) {
+	c := startCluster(t, 3, 0)
+	c.write(0, 10)
+	old := c.leader()
+
+	c.setCut(old, true)
+	// Writes on the cut off leader cannot commit
+	if err := c.services[old].UpdateRating("user_1", 4000); err == nil {
+		t.Error("Expected a write without a majority to fail")
+	}
+	c.write(10, 20) // the other two elect a leader and go on
+
+	// The old leader steps down, and takes the log of the new term when
+	// it is back
+	deadline := time.Now().Add(2 * time.Second)
+	for c.node(old).Status().Role == RoleLeader {
+		if time.Now().After(deadline) {
+			t.Fatal("The cut off leader did not step down")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.setCut(old, false)
+	c.converge(20)
+	if user, _ := c.services[old].GetUserRank("user_1"); user.Rating == 4000 {
+		t.Error("An uncommitted write was applied")
+	}
+}
+
+func TestRedirectWrites(t *testing.T) {
+	// Three nodes over HTTP, each serving a write and the raft endpoints
+	ids := []string{"a", "b", "c"}
+	urls := make(map[string]string)
+	handlers := make(map[string]*http.ServeMux)
+	for _, id := range ids {
+		mux := http.NewServeMux()
+		handlers[id] = mux
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		urls[id] = server.URL
+	}
+
+	nodes := make(map[string]*Node)
+	for _, id := range ids {
+		var peers []string
+		for _, other := range ids {
+			if other != id {
+				peers = append(peers, other)
+			}
+		}
+		service := services.NewLeaderboardService()
+		node, err := NewNode(Config{
+			ID:                id,
+			Peers:             peers,
+			Service:           service,
+			Storage:           NewMemoryStorage(),
+			Transport:         &HTTPTransport{URLs: urls},
+			HeartbeatInterval: 10 * time.Millisecond,
+			ElectionTimeout:   100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(node.Stop)
+		nodes[id] = node
+
+		app := http.NewServeMux()
+		app.Handle("/raft/", node.Handler())
+		app.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+			if err := service.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1000}); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			fmt.Fprint(w, node.ID())
+		})
+		handlers[id].Handle("/", RedirectWrites(node, urls, app))
+	}
+
+	var leader string
+	deadline := time.Now().Add(5 * time.Second)
+	for leader == "" {
+		for _, id := range ids {
+			if nodes[id].Ready() {
+				leader = id
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("No leader was elected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	follower := ids[0]
+	if follower == leader {
+		follower = ids[1]
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Post(urls[follower]+"/add?x=1", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != urls[leader]+"/add?x=1" {
+		t.Fatalf("Expected a redirect to %s, got %d %s", urls[leader], resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// Followed, the write lands on the leader and reaches every node
+	resp, err = http.Post(urls[follower]+"/add", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the redirected write to succeed, got %d", resp.StatusCode)
+	}
+	for _, id := range ids {
+		for nodes[id].Status().Version != 1 {
+			if time.Now().After(deadline.Add(5 * time.Second)) {
+				t.Fatalf("%s did not apply the write: %+v", id, nodes[id].Status())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// Reads are served locally
+	resp, err = noRedirect.Get(urls[follower] + "/raft/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status to be served by the follower, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,210 @@
+package raft
+
+import (
+	"context"
+	"log"
+)
+
+// VoteRequest asks for a node's vote in an election
+type VoteRequest struct {
+	Term      uint64 `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+}
+
+// VoteResponse answers a VoteRequest
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendRequest carries the leader's entries after PrevIndex, and serves as
+// its heartbeat when there are none
+type AppendRequest struct {
+	Term      uint64  `json:"term"`
+	Leader    string  `json:"leader"`
+	PrevIndex uint64  `json:"prev_index"`
+	PrevTerm  uint64  `json:"prev_term"`
+	Entries   []Entry `json:"entries,omitempty"`
+	Commit    uint64  `json:"commit"`
+}
+
+// AppendResponse answers an AppendRequest. On failure ConflictIndex is where
+// the leader should resume, skipping a whole mismatched term at once.
+type AppendResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// SnapshotRequest sends a follower the leader's snapshot, when the entries
+// it needs have been compacted
+type SnapshotRequest struct {
+	Term     uint64   `json:"term"`
+	Leader   string   `json:"leader"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+// SnapshotResponse answers a SnapshotRequest. Success is false when the
+// request was refused or the follower could not install the snapshot, so
+// the leader must not count it as holding that state.
+type SnapshotResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+}
+
+// Transport carries requests to the other nodes, by ID
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error)
+}
+
+// HandleVote answers a candidate. The vote goes to the first candidate of a
+// term whose log is at least as up to date as this node's.
+func (n *Node) HandleVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.term {
+		n.becomeFollowerLocked(req.Term, "")
+	}
+	resp := VoteResponse{Term: n.term}
+	if req.Term < n.term || (n.vote != "" && n.vote != req.Candidate) {
+		return resp
+	}
+
+	lastIndex := n.lastIndexLocked()
+	lastTerm := n.termAtLocked(lastIndex)
+	if req.LastTerm < lastTerm || (req.LastTerm == lastTerm && req.LastIndex < lastIndex) {
+		return resp
+	}
+
+	if err := n.cfg.Storage.SaveState(HardState{Term: n.term, Vote: req.Candidate}); err != nil {
+		log.Printf("raft %s: saving state: %v", n.cfg.ID, err)
+		return resp
+	}
+	n.vote = req.Candidate
+	n.resetDeadlineLocked()
+	resp.Granted = true
+	return resp
+}
+
+// HandleAppend stores the leader's entries once the log before them matches
+// the leader's
+func (n *Node) HandleAppend(req AppendRequest) AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := AppendResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp
+	}
+	if req.Term > n.term || n.role != RoleFollower || n.leader != req.Leader {
+		n.becomeFollowerLocked(req.Term, req.Leader)
+	}
+	resp.Term = n.term
+	n.resetDeadlineLocked()
+
+	// Entries already in the snapshot are committed, so they match
+	prevIndex, prevTerm, entries := req.PrevIndex, req.PrevTerm, req.Entries
+	if prevIndex < n.snap.Index {
+		skip := min(n.snap.Index-prevIndex, uint64(len(entries)))
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.snap.Index, n.snap.Term
+	}
+
+	lastIndex := n.lastIndexLocked()
+	if prevIndex > lastIndex {
+		resp.ConflictIndex = lastIndex + 1
+		return resp
+	}
+	if term := n.termAtLocked(prevIndex); term != prevTerm {
+		index := prevIndex
+		for index > n.snap.Index+1 && n.termAtLocked(index-1) == term {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp
+	}
+
+	// Only a conflicting entry truncates the log; a stale request must not
+	// drop entries a newer one already stored
+	for i, e := range entries {
+		if e.Index <= lastIndex && n.termAtLocked(e.Index) == e.Term {
+			continue
+		}
+		if err := n.cfg.Storage.SaveEntries(e.Index, entries[i:]); err != nil {
+			log.Printf("raft %s: saving entries: %v", n.cfg.ID, err)
+			resp.ConflictIndex = e.Index
+			return resp
+		}
+		n.log = append(n.log[:e.Index-n.snap.Index-1], entries[i:]...)
+		break
+	}
+
+	if last := prevIndex + uint64(len(entries)); req.Commit > n.commit && last > n.commit {
+		n.commitLocked(min(req.Commit, last))
+	}
+	resp.Success = true
+	return resp
+}
+
+// HandleSnapshot replaces the board and the log with the leader's snapshot,
+// unless the node already has everything it covers
+func (n *Node) HandleSnapshot(req SnapshotRequest) SnapshotResponse {
+	// Step down first, so a write waiting on this node releases the
+	// service before the board is replaced
+	n.mu.Lock()
+	if req.Term > n.term || (req.Term == n.term && n.role != RoleFollower) {
+		n.becomeFollowerLocked(req.Term, req.Leader)
+	}
+	n.mu.Unlock()
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	resp := SnapshotResponse{Term: n.term}
+	if req.Term < n.term {
+		n.mu.Unlock()
+		return resp
+	}
+	n.leader = req.Leader
+	n.resetDeadlineLocked()
+	snap := req.Snapshot
+	if snap.Index <= n.commit {
+		// Everything it holds is committed here already
+		n.mu.Unlock()
+		resp.Success = true
+		return resp
+	}
+	n.mu.Unlock()
+
+	if err := restore(n.cfg.Service, snap.Data); err != nil {
+		log.Printf("raft %s: installing snapshot: %v", n.cfg.ID, err)
+		return resp
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.cfg.Storage.SaveSnapshot(snap); err != nil {
+		log.Printf("raft %s: saving snapshot: %v", n.cfg.ID, err)
+		return resp
+	}
+	// Entries past the snapshot are kept if the log agrees with it there
+	if snap.Index < n.lastIndexLocked() && n.termAtLocked(snap.Index) == snap.Term {
+		n.log = append([]Entry(nil), n.log[snap.Index-n.snap.Index:]...)
+	} else {
+		if err := n.cfg.Storage.SaveEntries(snap.Index+1, nil); err != nil {
+			log.Printf("raft %s: saving entries: %v", n.cfg.ID, err)
+		}
+		n.log = nil
+	}
+	n.snap = snap
+	n.commit = max(n.commit, snap.Index)
+	n.applied = snap.Index
+	resp.Success = true
+	return resp
+}
@@ -0,0 +1,330 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage persists a node's term, vote, log and snapshot. Every call must be
+// durable when it returns.
+type Storage interface {
+	// Load returns what was saved, with the entries after the snapshot
+	Load() (HardState, Snapshot, []Entry, error)
+	SaveState(state HardState) error
+	// SaveEntries replaces the entries from index from on with entries
+	SaveEntries(from uint64, entries []Entry) error
+	// SaveSnapshot stores snap and drops the entries it covers
+	SaveSnapshot(snap Snapshot) error
+}
+
+// MemoryStorage keeps everything in memory. It survives a node being
+// stopped and started again, but not the process.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	state   HardState
+	snap    Snapshot
+	entries []Entry
+}
+
+// NewMemoryStorage returns an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.snap, append([]Entry(nil), s.entries...), nil
+}
+
+func (s *MemoryStorage) SaveState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+func (s *MemoryStorage) SaveEntries(from uint64, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept, err := keep(s.snap.Index, s.entries, from)
+	if err != nil {
+		return err
+	}
+	s.entries = append(kept, entries...)
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = after(s.snap.Index, s.entries, snap.Index)
+	s.snap = snap
+	return nil
+}
+
+// keep returns the entries before index from, of a log starting after base
+func keep(base uint64, entries []Entry, from uint64) ([]Entry, error) {
+	if from <= base || from > base+uint64(len(entries))+1 {
+		return nil, fmt.Errorf("raft: cannot save entries from %d after %d of %d", from, base, base+uint64(len(entries)))
+	}
+	return append([]Entry(nil), entries[:from-base-1]...), nil
+}
+
+// after returns the entries past index, of a log starting after base
+func after(base uint64, entries []Entry, index uint64) []Entry {
+	if index < base {
+		return entries
+	}
+	if skip := index - base; skip < uint64(len(entries)) {
+		return append([]Entry(nil), entries[skip:]...)
+	}
+	return nil
+}
+
+// File names in a FileStorage directory
+const (
+	stateFile    = "state.json"
+	snapshotFile = "snapshot"
+	logFile      = "log.jsonl"
+)
+
+// FileStorage keeps a node's state in a directory: the term and vote in
+// state.json, the snapshot, and the log as one JSON entry per line. New
+// entries are appended to the log; truncating or compacting it rewrites the
+// file, which is done whole and renamed into place.
+type FileStorage struct {
+	dir string
+
+	mu      sync.Mutex
+	log     *os.File
+	snap    Snapshot
+	entries []Entry // a copy of the log, for rewriting it
+}
+
+// OpenFileStorage opens the storage in dir, creating it if needed
+func OpenFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileStorage{dir: dir}
+
+	var err error
+	if s.snap, err = readSnapshot(filepath.Join(dir, snapshotFile)); err != nil {
+		return nil, err
+	}
+	if s.entries, err = readLog(filepath.Join(dir, logFile), s.snap.Index); err != nil {
+		return nil, err
+	}
+	// Rewrite so a torn last line from a crash is not appended to
+	if err := s.rewrite(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the log file
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.Close()
+}
+
+func (s *FileStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var state HardState
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return state, Snapshot{}, nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return state, Snapshot{}, nil, fmt.Errorf("raft: reading %s: %w", stateFile, err)
+		}
+	}
+	return state, s.snap, append([]Entry(nil), s.entries...), nil
+}
+
+func (s *FileStorage) SaveState(state HardState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFile(filepath.Join(s.dir, stateFile), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (s *FileStorage) SaveEntries(from uint64, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.snap.Index + uint64(len(s.entries))
+	kept, err := keep(s.snap.Index, s.entries, from)
+	if err != nil {
+		return err
+	}
+	s.entries = append(kept, entries...)
+	if from <= last {
+		return s.rewrite()
+	}
+
+	bw := bufio.NewWriter(s.log)
+	enc := json.NewEncoder(bw)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *FileStorage) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := writeFile(filepath.Join(s.dir, snapshotFile), func(w io.Writer) error {
+		if err := json.NewEncoder(w).Encode(Snapshot{Index: snap.Index, Term: snap.Term}); err != nil {
+			return err
+		}
+		_, err := w.Write(snap.Data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.entries = after(s.snap.Index, s.entries, snap.Index)
+	s.snap = snap
+	return s.rewrite()
+}
+
+// rewrite replaces the log file with the entries held
+func (s *FileStorage) rewrite() error {
+	path := filepath.Join(s.dir, logFile)
+	err := writeFile(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, e := range s.entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// readSnapshot reads a snapshot file: a JSON line with its index and term,
+// then the board
+func readSnapshot(path string) (Snapshot, error) {
+	var snap Snapshot
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snap, nil
+	} else if err != nil {
+		return snap, err
+	}
+
+	line, rest, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return snap, fmt.Errorf("raft: %s has no header", path)
+	}
+	if err := json.Unmarshal(line, &snap); err != nil {
+		return snap, fmt.Errorf("raft: reading %s: %w", path, err)
+	}
+	snap.Data = rest
+	return snap, nil
+}
+
+// readLog reads the entries after base. A last line cut short by a crash is
+// dropped; it was never acknowledged.
+func readLog(path string, base uint64) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("raft: reading %s: %w", path, err)
+		}
+		if e.Index <= base {
+			continue
+		}
+		if want := base + uint64(len(entries)) + 1; e.Index != want {
+			return nil, fmt.Errorf("raft: %s has entry %d where %d was expected", path, e.Index, want)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// writeFile writes path through a temporary file, fsynced and renamed into
+// place
+func writeFile(path string, fn func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // no-op once renamed
+
+	bw := bufio.NewWriter(f)
+	if err := fn(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,96 @@
+package raft
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"leaderboard/models"
+)
+
+func entries(from, to, term uint64) []Entry {
+	var out []Entry
+	for i := from; i <= to; i++ {
+		out = append(out, Entry{Index: i, Term: term, Mutation: &models.Mutation{Seq: i, Op: models.MutationUpdate, Username: "alice", Rating: 100 + int(i)}})
+	}
+	return out
+}
+
+func TestStorage(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]Storage{"memory": NewMemoryStorage(), "file": fs} {
+		if err := s.SaveState(HardState{Term: 3, Vote: "n2"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SaveEntries(1, entries(1, 10, 1)); err != nil {
+			t.Fatal(err)
+		}
+		// A conflict from 8 on replaces the tail
+		if err := s.SaveEntries(8, entries(8, 12, 2)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SaveEntries(20, entries(20, 20, 2)); err == nil {
+			t.Errorf("%s: expected a gap in the log to be refused", name)
+		}
+		if err := s.SaveSnapshot(Snapshot{Index: 5, Term: 1, Data: []byte("board")}); err != nil {
+			t.Fatal(err)
+		}
+
+		state, snap, got, err := s.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != (HardState{Term: 3, Vote: "n2"}) {
+			t.Errorf("%s: got state %+v", name, state)
+		}
+		if snap.Index != 5 || snap.Term != 1 || string(snap.Data) != "board" {
+			t.Errorf("%s: got snapshot %+v", name, snap)
+		}
+		want := append(entries(6, 7, 1), entries(8, 12, 2)...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got entries %+v, want %+v", name, got, want)
+		}
+	}
+
+	// Reopened, the file storage has the same, even with a torn last line
+	fs.Close()
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"index":13,"te`)
+	f.Close()
+
+	fs, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	state, snap, got, err := fs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(entries(6, 7, 1), entries(8, 12, 2)...)
+	if state.Term != 3 || snap.Index != 5 || !reflect.DeepEqual(got, want) {
+		t.Errorf("After reopening got %+v %+v %+v", state, snap, got)
+	}
+	if err := fs.SaveEntries(13, entries(13, 13, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot past the log drops all of it
+	if err := fs.SaveSnapshot(Snapshot{Index: 30, Term: 4, Data: []byte("later")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, got, _ := fs.Load(); len(got) != 0 {
+		t.Errorf("Expected no entries after the snapshot, got %d", len(got))
+	}
+	if err := fs.SaveEntries(31, entries(31, 31, 4)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,276 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"leaderboard/handlers"
+	"leaderboard/models"
+	"leaderboard/services"
+	"leaderboard/snapshot"
+)
+
+// Roles reported by the status endpoints
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+)
+
+// Status describes a node's replication state. Lag is how many versions a
+// follower is behind the leader as of LastContact. Epoch is the leader
+// process the board follows.
+type Status struct {
+	Role          string    `json:"role"`
+	Version       uint64    `json:"version"`
+	Epoch         string    `json:"epoch,omitempty"`
+	Leader        string    `json:"leader,omitempty"`
+	LeaderVersion uint64    `json:"leader_version,omitempty"`
+	Lag           uint64    `json:"lag"`
+	Connected     bool      `json:"connected"`
+	LastContact   time.Time `json:"last_contact,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// errReload means the follower must reload the snapshot before the log
+var errReload = errors.New("replication: follower must reload the snapshot")
+
+// FollowerOptions tunes how a follower polls its leader
+type FollowerOptions struct {
+	Client *http.Client  // defaults to a client with a timeout above Wait
+	Wait   time.Duration // how long each log request waits, defaults to 10s
+	Retry  time.Duration // first delay after a failure, doubling to a minute
+}
+
+// Follower applies a leader's mutations to a local service. The service must
+// not take writes of its own; serve it through ReadOnly.
+type Follower struct {
+	leader  string
+	service *services.LeaderboardService
+	opts    FollowerOptions
+
+	mu     sync.Mutex
+	status Status
+	fresh  bool   // the next sync starts from the snapshot
+	epoch  string // of the leader the local board came from
+}
+
+// NewFollower follows the leader at leaderURL, e.g. http://leader:5001
+func NewFollower(leaderURL string, service *services.LeaderboardService, opts FollowerOptions) *Follower {
+	if opts.Wait <= 0 {
+		opts.Wait = 10 * time.Second
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = 500 * time.Millisecond
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: opts.Wait + 10*time.Second}
+	}
+	leaderURL = strings.TrimRight(leaderURL, "/")
+	return &Follower{
+		leader:  leaderURL,
+		service: service,
+		opts:    opts,
+		status:  Status{Role: RoleFollower, Leader: leaderURL},
+		fresh:   true,
+	}
+}
+
+// Run syncs with the leader until ctx is done, backing off while it is
+// unreachable. After a disconnect it resumes from the version it has, or from
+// the snapshot when the leader no longer holds that part of the log.
+func (f *Follower) Run(ctx context.Context) {
+	delay := f.opts.Retry
+	for ctx.Err() == nil {
+		if err := f.Sync(ctx, f.opts.Wait); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			delay = min(2*delay, time.Minute)
+			continue
+		}
+		delay = f.opts.Retry
+	}
+}
+
+// Sync makes one round trip to the leader: it loads the snapshot if needed,
+// then applies the mutations the leader has after the local version, waiting
+// up to wait for some to arrive
+func (f *Follower) Sync(ctx context.Context, wait time.Duration) error {
+	f.mu.Lock()
+	fresh := f.fresh
+	f.mu.Unlock()
+
+	err := errReload
+	if !fresh {
+		err = f.pull(ctx, wait)
+	}
+	if err == errReload {
+		if err = f.reload(ctx); err == nil {
+			err = f.pull(ctx, 0)
+		}
+	}
+	f.record(err)
+	return err
+}
+
+// pull fetches and applies the log after the local version
+func (f *Follower) pull(ctx context.Context, wait time.Duration) error {
+	from := f.service.Version()
+	url := fmt.Sprintf("%s/replication/log?from=%d&wait=%s", f.leader, from, wait)
+	resp, err := f.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errReload
+	default:
+		return fmt.Errorf("replication: leader answered %s", resp.Status)
+	}
+
+	// A restarted leader may reuse versions for other mutations, so its log
+	// cannot be applied to a board from before the restart
+	f.mu.Lock()
+	epoch := f.epoch
+	f.mu.Unlock()
+	if resp.Header.Get(EpochHeader) != epoch {
+		return errReload
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var m models.Mutation
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("replication: reading log: %w", err)
+		}
+		if m.Seq != f.service.Version()+1 {
+			return errReload // a gap, the log cannot be applied in order
+		}
+		if err := f.service.Apply(m); err != nil {
+			return fmt.Errorf("replication: applying seq %d: %w", m.Seq, err)
+		}
+	}
+	f.contact(resp)
+	return nil
+}
+
+// reload replaces the local board with the leader's snapshot
+func (f *Follower) reload(ctx context.Context) error {
+	resp, err := f.get(ctx, f.leader+"/replication/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("replication: leader answered %s", resp.Status)
+	}
+
+	var users []models.User
+	seq, err := snapshot.Decode(resp.Body, func(u models.User) error {
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("replication: reading snapshot: %w", err)
+	}
+	if err := f.service.Replace(seq, users); err != nil {
+		return fmt.Errorf("replication: reloading snapshot: %w", err)
+	}
+
+	f.mu.Lock()
+	f.fresh = false
+	f.epoch = resp.Header.Get(EpochHeader)
+	f.status.Epoch = f.epoch
+	f.mu.Unlock()
+	f.contact(resp)
+	return nil
+}
+
+func (f *Follower) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.opts.Client.Do(req)
+}
+
+// contact notes the leader's version from a successful response
+func (f *Follower) contact(resp *http.Response) {
+	leaderVersion, _ := strconv.ParseUint(resp.Header.Get(VersionHeader), 10, 64)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.LeaderVersion = leaderVersion
+	f.status.LastContact = time.Now().UTC()
+}
+
+// record notes the outcome of a sync
+func (f *Follower) record(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.Connected = err == nil
+	f.status.Error = ""
+	if err != nil {
+		f.status.Error = err.Error()
+	}
+	if err == errReload {
+		f.fresh = true
+	}
+}
+
+// Status reports how far behind the leader the follower is
+func (f *Follower) Status() Status {
+	version := f.service.Version()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := f.status
+	status.Version = version
+	if status.LeaderVersion > version {
+		status.Lag = status.LeaderVersion - version
+	}
+	return status
+}
+
+// ServeStatus serves the follower's Status as JSON
+func (f *Follower) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.Status())
+}
+
+// ReadOnly rejects every request that could change the board, for serving a
+// follower's service
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			handlers.WriteError(w, http.StatusForbidden, models.ErrorReadOnly, "Read-only replica, send writes to the leader", nil)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"leaderboard/models"
+	"leaderboard/services"
+	"leaderboard/snapshot"
+)
+
+// DefaultKeep is how many recent mutations the leader holds for followers to
+// catch up from. A follower further behind reloads the snapshot.
+const DefaultKeep = 100000
+
+// maxBatch bounds the mutations sent in one log response
+const maxBatch = 10000
+
+// maxWait bounds how long a log request waits for new mutations
+const maxWait = 30 * time.Second
+
+// VersionHeader carries the leader's version on every replication response
+const VersionHeader = "X-Leader-Version"
+
+// EpochHeader carries the leader's epoch on every replication response. It
+// is random per leader process, so a follower notices a restarted leader,
+// whose versions may repeat ones it has seen with different mutations.
+const EpochHeader = "X-Leader-Epoch"
+
+// Leader keeps the recent mutation log of a service in memory and serves it,
+// with snapshots of the board, to followers. It is installed as the
+// service's mutation log in front of the durable one.
+type Leader struct {
+	service *services.LeaderboardService
+	next    services.MutationLog
+	epoch   string
+
+	mu     sync.Mutex
+	log    []models.Mutation // recent mutations, oldest first
+	last   uint64            // seq of the newest mutation
+	keep   int
+	notify chan struct{} // closed and replaced on every append
+}
+
+// NewLeader starts a leader at the service's current version. Mutations are
+// passed on to next, which may be nil, before followers can see them. keep
+// defaults to DefaultKeep.
+func NewLeader(service *services.LeaderboardService, next services.MutationLog, keep int) *Leader {
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	return &Leader{
+		service: service,
+		next:    next,
+		epoch:   newEpoch(),
+		last:    service.Version(),
+		keep:    keep,
+		notify:  make(chan struct{}),
+	}
+}
+
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic("replication: no randomness for the epoch: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Epoch identifies this leader process
+func (l *Leader) Epoch() string { return l.epoch }
+
+// Append records m once next has. The service calls it under its write lock,
+// so mutations arrive in seq order.
+func (l *Leader) Append(m models.Mutation) error {
+	_, err := l.AppendBatch([]models.Mutation{m})
+	return err
+}
+
+// AppendBatch records ms once next has, as one batch if next takes them,
+// and returns how many were recorded
+func (l *Leader) AppendBatch(ms []models.Mutation) (int, error) {
+	n, err := len(ms), error(nil)
+	if l.next != nil {
+		n, err = services.AppendAll(l.next, ms)
+	}
+	if n == 0 {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Trim to keep in one copy once twice as many are held
+	if len(l.log) >= 2*l.keep {
+		l.log = append(l.log[:0], l.log[len(l.log)-l.keep:]...)
+	}
+	l.log = append(l.log, ms[:n]...)
+	l.last = ms[n-1].Seq
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return n, err
+}
+
+// Version is the seq of the newest mutation followers can fetch
+func (l *Leader) Version() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// since returns up to limit mutations after from. ok is false when from is
+// older than the log held, or newer than the leader.
+func (l *Leader) since(from uint64, limit int) (batch []models.Mutation, wait <-chan struct{}, last uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if from > l.last {
+		return nil, nil, l.last, false
+	}
+	if from == l.last {
+		return nil, l.notify, l.last, true
+	}
+	if len(l.log) == 0 || l.log[0].Seq > from+1 {
+		return nil, nil, l.last, false
+	}
+
+	start := int(from + 1 - l.log[0].Seq)
+	end := min(len(l.log), start+limit)
+	return append([]models.Mutation(nil), l.log[start:end]...), nil, l.last, true
+}
+
+// Handler serves the replication endpoints:
+//
+//	GET /replication/snapshot            - the board in snapshot format
+//	GET /replication/log?from=N&wait=D   - NDJSON mutations after version N
+//	GET /replication/status              - the leader's version
+func (l *Leader) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/replication/snapshot", l.serveSnapshot)
+	mux.HandleFunc("/replication/log", l.serveLog)
+	mux.HandleFunc("/replication/status", l.serveStatus)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(EpochHeader, l.epoch)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serveSnapshot copies the board under the service lock and streams it
+// without, so a slow follower does not hold up writers
+func (l *Leader) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var version uint64
+	var users []models.User
+	l.service.WithView(func(v services.View) error {
+		version = v.Version
+		users = make([]models.User, 0, v.Count)
+		return v.Each(func(u models.User) error {
+			users = append(users, u)
+			return nil
+		})
+	})
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(VersionHeader, strconv.FormatUint(version, 10))
+	bw := bufio.NewWriterSize(w, 64*1024)
+	snapshot.Encode(bw, version, len(users), func(fn func(models.User) error) error {
+		for _, u := range users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	bw.Flush()
+}
+
+// serveLog answers with the mutations after from, waiting up to wait for the
+// first one. 410 Gone means from is no longer held and the follower must
+// reload the snapshot.
+func (l *Leader) serveLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := strconv.ParseUint(query.Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "from must be a version number", http.StatusBadRequest)
+		return
+	}
+	var wait time.Duration
+	if waitStr := query.Get("wait"); waitStr != "" {
+		if wait, err = time.ParseDuration(waitStr); err != nil || wait < 0 {
+			http.Error(w, "wait must be a duration", http.StatusBadRequest)
+			return
+		}
+		wait = min(wait, maxWait)
+	}
+
+	batch, notify, last, ok := l.since(from, maxBatch)
+	if ok && len(batch) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-notify:
+			batch, _, last, ok = l.since(from, maxBatch)
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+	}
+
+	w.Header().Set(VersionHeader, strconv.FormatUint(last, 10))
+	if !ok {
+		http.Error(w, "version not in the replication log, reload the snapshot", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, m := range batch {
+		if err := enc.Encode(m); err != nil {
+			return
+		}
+	}
+}
+
+func (l *Leader) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Status{Role: RoleLeader, Version: l.Version(), Epoch: l.epoch})
+}
@@ -0,0 +1,282 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"leaderboard/models"
+	"leaderboard/services"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startLeader returns a leader service, and a server for it that can be
+// taken down and brought back
+func startLeader(t *testing.T, keep int) (*services.LeaderboardService, *Leader, *httptest.Server, *atomic.Bool) {
+	t.Helper()
+	service := services.NewLeaderboardService()
+	leader := NewLeader(service, nil, keep)
+	service.SetMutationLog(leader)
+
+	var down atomic.Bool
+	handler := leader.Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return service, leader, server, &down
+}
+
+func seed(t *testing.T, service *services.LeaderboardService, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		user := &models.User{ID: fmt.Sprint(i), Username: fmt.Sprintf("user_%d", i), Rating: 100 + i*37%4900}
+		if err := service.AddUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertSameBoard compares the full ranked boards and versions
+func assertSameBoard(t *testing.T, leader, follower *services.LeaderboardService) {
+	t.Helper()
+	want := leader.GetUsersInRange(0, leader.GetUserCount())
+	got := follower.GetUsersInRange(0, follower.GetUserCount()+1)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d users on the follower, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Row %d: got %+v want %+v", i, got[i], want[i])
+		}
+	}
+	if follower.Version() != leader.Version() {
+		t.Errorf("Expected follower at version %d, got %d", leader.Version(), follower.Version())
+	}
+}
+
+func TestFollowerCatchesUp(t *testing.T) {
+	leaderService, _, server, _ := startLeader(t, 0)
+	seed(t, leaderService, 0, 50)
+
+	replica := services.NewLeaderboardService()
+	follower := NewFollower(server.URL, replica, FollowerOptions{})
+	ctx := context.Background()
+
+	// The first sync loads the snapshot
+	if err := follower.Sync(ctx, 0); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	assertSameBoard(t, leaderService, replica)
+
+	// Later syncs apply every kind of mutation from the log
+	leaderService.UpdateRating("user_1", 4999)
+	leaderService.RenameUser("user_2", "renamed")
+	leaderService.RemoveUser("user_3")
+	seed(t, leaderService, 50, 60)
+	if err := follower.Sync(ctx, 0); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	assertSameBoard(t, leaderService, replica)
+
+	status := follower.Status()
+	if !status.Connected || status.Lag != 0 || status.LeaderVersion != leaderService.Version() || status.LastContact.IsZero() {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestFollowerLongPoll(t *testing.T) {
+	leaderService, _, server, _ := startLeader(t, 0)
+	seed(t, leaderService, 0, 5)
+
+	replica := services.NewLeaderboardService()
+	follower := NewFollower(server.URL, replica, FollowerOptions{})
+	follower.Sync(context.Background(), 0)
+
+	// A waiting request returns as soon as the leader has something new
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		leaderService.UpdateRating("user_1", 3000)
+	}()
+	start := time.Now()
+	if err := follower.Sync(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the wait to end on the update, took %v", elapsed)
+	}
+	assertSameBoard(t, leaderService, replica)
+}
+
+func TestFollowerReconnect(t *testing.T) {
+	leaderService, _, server, down := startLeader(t, 10)
+	seed(t, leaderService, 0, 20)
+
+	replica := services.NewLeaderboardService()
+	follower := NewFollower(server.URL, replica, FollowerOptions{})
+	follower.Sync(context.Background(), 0)
+
+	// While the leader is unreachable the follower reports its lag
+	down.Store(true)
+	leaderService.UpdateRating("user_1", 4000)
+	if err := follower.Sync(context.Background(), 0); err == nil {
+		t.Fatal("Expected sync to fail while the leader is down")
+	}
+	if status := follower.Status(); status.Connected || status.Error == "" {
+		t.Errorf("Expected a disconnected status, got %+v", status)
+	}
+
+	// A short outage resumes from the log
+	down.Store(false)
+	if err := follower.Sync(context.Background(), 0); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	assertSameBoard(t, leaderService, replica)
+
+	// A long one has fallen out of the log and reloads the snapshot
+	down.Store(true)
+	for i := 0; i < 30; i++ {
+		leaderService.UpdateRating(fmt.Sprintf("user_%d", i%20), 200+i)
+	}
+	leaderService.RemoveUser("user_5")
+	leaderService.RenameUser("user_6", "user_5")
+	down.Store(false)
+	if err := follower.Sync(context.Background(), 0); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	assertSameBoard(t, leaderService, replica)
+}
+
+func TestFollowerLeaderRestart(t *testing.T) {
+	var current atomic.Pointer[http.Handler]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(*current.Load()).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	start := func(from int) (*services.LeaderboardService, *Leader) {
+		service := services.NewLeaderboardService()
+		leader := NewLeader(service, nil, 0)
+		service.SetMutationLog(leader)
+		seed(t, service, from, from+20)
+		handler := leader.Handler()
+		current.Store(&handler)
+		return service, leader
+	}
+
+	first, _ := start(0)
+	replica := services.NewLeaderboardService()
+	follower := NewFollower(server.URL, replica, FollowerOptions{})
+	if err := follower.Sync(context.Background(), 0); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	assertSameBoard(t, first, replica)
+
+	// The restarted leader has another board at the same version
+	second, leader := start(100)
+	if second.Version() != first.Version() {
+		t.Fatalf("Expected the restarted leader at version %d, got %d", first.Version(), second.Version())
+	}
+	if err := follower.Sync(context.Background(), 0); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	assertSameBoard(t, second, replica)
+	if status := follower.Status(); status.Epoch != leader.Epoch() || status.Lag != 0 {
+		t.Errorf("Expected the new epoch with no lag, got %+v", status)
+	}
+}
+
+func TestFollowerRun(t *testing.T) {
+	leaderService, _, server, _ := startLeader(t, 0)
+	seed(t, leaderService, 0, 10)
+
+	replica := services.NewLeaderboardService()
+	follower := NewFollower(server.URL, replica, FollowerOptions{Wait: 50 * time.Millisecond, Retry: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		follower.Run(ctx)
+		close(done)
+	}()
+
+	leaderService.UpdateRating("user_4", 4444)
+	deadline := time.Now().Add(5 * time.Second)
+	for replica.Version() != leaderService.Version() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	assertSameBoard(t, leaderService, replica)
+}
+
+func TestLeaderEndpoints(t *testing.T) {
+	leaderService, leader, server, _ := startLeader(t, 0)
+	seed(t, leaderService, 0, 3)
+
+	resp, err := http.Get(server.URL + "/replication/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status Status
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Role != RoleLeader || status.Version != 3 || leader.Version() != 3 {
+		t.Errorf("Unexpected leader status %+v", status)
+	}
+
+	cases := map[string]int{
+		"/replication/log?from=1":           http.StatusOK,
+		"/replication/log?from=9":           http.StatusGone,
+		"/replication/log":                  http.StatusBadRequest,
+		"/replication/log?from=1&wait=soon": http.StatusBadRequest,
+	}
+	for path, want := range cases {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", path, want, resp.StatusCode)
+		}
+		if resp.Header.Get(VersionHeader) != "3" && want != http.StatusBadRequest {
+			t.Errorf("%s: expected leader version header, got %q", path, resp.Header.Get(VersionHeader))
+		}
+	}
+
+	resp, _ = http.Post(server.URL+"/replication/snapshot", "text/plain", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	handler := ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for method, want := range map[string]int{
+		http.MethodGet:    http.StatusOK,
+		http.MethodHead:   http.StatusOK,
+		http.MethodPost:   http.StatusForbidden,
+		http.MethodPut:    http.StatusForbidden,
+		http.MethodDelete: http.StatusForbidden,
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, "/update-user-score", nil))
+		if rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", method, want, rr.Code)
+		}
+		var envelope models.ErrorResponse
+		if want == http.StatusForbidden && (json.NewDecoder(rr.Body).Decode(&envelope) != nil || envelope.Code != models.ErrorReadOnly) {
+			t.Errorf("%s: expected the read_only envelope, got %q", method, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// client is a minimal RESP client, written here rather than taken from a
+// Redis library so the tests check the wire format itself
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// replyError is an error reply
+type replyError string
+
+func startServer(t *testing.T) (*services.Boards, string) {
+	t.Helper()
+	boards := services.NewBoards(nil)
+	server := NewServer(boards)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return boards, l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes commands without waiting for their replies
+func (c *client) send(commands ...[]string) {
+	c.t.Helper()
+	var b strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// do sends one command and reads its reply
+func (c *client) do(args ...string) interface{} {
+	c.t.Helper()
+	c.send(args)
+	return c.read()
+}
+
+// read decodes one reply: string, int64, nil, []interface{} or replyError
+func (c *client) read() interface{} {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		c.t.Fatalf("Reply line %q does not end in CRLF", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body
+	case '-':
+		return replyError(body)
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			c.t.Fatalf("Bad integer reply %q", line)
+		}
+		return n
+	case '$':
+		size, _ := strconv.Atoi(body)
+		if size < 0 {
+			return nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:size])
+	case '*':
+		n, _ := strconv.Atoi(body)
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	}
+	c.t.Fatalf("Unknown reply %q", line)
+	return nil
+}
+
+func expect(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %#v, want %#v", got, want)
+	}
+}
+
+func expectError(t *testing.T, got interface{}, prefix string) {
+	t.Helper()
+	if err, ok := got.(replyError); !ok || !strings.HasPrefix(string(err), prefix) {
+		t.Errorf("Got %#v, want an error starting %q", got, prefix)
+	}
+}
+
+func TestSortedSetCommands(t *testing.T) {
+	boards, addr := startServer(t)
+	c := dial(t, addr)
+
+	expect(t, c.do("PING"), "PONG")
+	expect(t, c.do("ZADD", "weekly", "1000", "alice", "2000", "bob", "1500", "carol"), int64(3))
+	expect(t, c.do("ZADD", "weekly", "1000", "alice", "2500", "bob"), int64(0))
+	expect(t, c.do("ZADD", "weekly", "CH", "1000", "alice", "3000", "bob"), int64(1))
+	expect(t, c.do("ZADD", "weekly", "NX", "4000", "alice", "1200", "dave"), int64(1))
+	expect(t, c.do("ZADD", "weekly", "XX", "1100", "dave", "4000", "erin"), int64(0))
+	expect(t, c.do("ZCARD", "weekly"), int64(4))
+
+	expect(t, c.do("ZSCORE", "weekly", "bob"), "3000")
+	expect(t, c.do("ZSCORE", "weekly", "dave"), "1100")
+	expect(t, c.do("ZSCORE", "weekly", "nobody"), nil)
+	expect(t, c.do("ZINCRBY", "weekly", "250", "alice"), "1250")
+	expect(t, c.do("ZINCRBY", "weekly", "-100", "alice"), "1150")
+	expect(t, c.do("ZINCRBY", "weekly", "700", "frank"), "700")
+
+	expect(t, c.do("ZREVRANK", "weekly", "bob"), int64(0))
+	expect(t, c.do("ZREVRANK", "weekly", "dave"), int64(3))
+	expect(t, c.do("ZREVRANK", "weekly", "nobody"), nil)
+
+	expect(t, c.do("ZREVRANGE", "weekly", "0", "2"), []interface{}{"bob", "carol", "alice"})
+	expect(t, c.do("ZREVRANGE", "weekly", "-2", "-1", "WITHSCORES"), []interface{}{"dave", "1100", "frank", "700"})
+	expect(t, c.do("ZREVRANGE", "weekly", "10", "20"), []interface{}{})
+
+	expect(t, c.do("ZREM", "weekly", "frank", "nobody", "dave"), int64(2))
+	expect(t, c.do("ZCARD", "weekly"), int64(3))
+
+	// Keys are separate boards
+	weekly, ok := boards.Get("weekly")
+	if !ok || weekly.GetUserCount() != 3 {
+		t.Fatal("Expected the weekly board to hold 3 users")
+	}
+	if user, _ := weekly.GetUserRank("alice"); user.Rating != 1150 {
+		t.Errorf("Expected alice at 1150 on the board, got %d", user.Rating)
+	}
+	expect(t, c.do("ZCARD", "daily"), int64(0))
+	expect(t, c.do("ZREVRANGE", "daily", "0", "-1"), []interface{}{})
+	expect(t, c.do("ZREM", "daily", "alice"), int64(0))
+	if _, ok := boards.Get("daily"); ok {
+		t.Error("Reads must not create boards")
+	}
+}
+
+func TestRevRankTies(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	// Tied members share a dense rank but not a position
+	c.do("ZADD", "weekly", "1000", "a", "1000", "b", "900", "c")
+	expect(t, c.do("ZREVRANGE", "weekly", "0", "-1"), []interface{}{"a", "b", "c"})
+	expect(t, c.do("ZREVRANK", "weekly", "a"), int64(0))
+	expect(t, c.do("ZREVRANK", "weekly", "b"), int64(1))
+	expect(t, c.do("ZREVRANK", "weekly", "c"), int64(2))
+}
+
+func TestBoardLimit(t *testing.T) {
+	boards, addr := startServer(t)
+	boards.SetMaxBoards(1)
+	c := dial(t, addr)
+
+	expect(t, c.do("ZADD", "weekly", "1000", "alice"), int64(1))
+	expectError(t, c.do("ZADD", "daily", "1000", "alice"), "ERR too many boards")
+	expectError(t, c.do("ZINCRBY", "daily", "1000", "alice"), "ERR too many boards")
+	expect(t, c.do("ZINCRBY", "weekly", "100", "alice"), "1100")
+}
+
+func TestNamedBoard(t *testing.T) {
+	boards, addr := startServer(t)
+	main := services.NewLeaderboardService()
+	main.AddUser(&models.User{ID: "1", Username: "alice", Rating: 4000})
+	boards.Set("leaderboard", main)
+
+	c := dial(t, addr)
+	expect(t, c.do("ZSCORE", "leaderboard", "alice"), "4000")
+	expect(t, c.do("ZADD", "leaderboard", "4500", "alice"), int64(0))
+	if user, _ := main.GetUserRank("alice"); user.Rating != 4500 {
+		t.Errorf("Expected the write on the named board, got %d", user.Rating)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	expectError(t, c.do("ZADD", "weekly", "99", "alice"), "ERR rating")
+	expectError(t, c.do("ZADD", "weekly", "100.5", "alice"), "ERR rating")
+	expectError(t, c.do("ZADD", "weekly", "abc", "alice"), "ERR value is not a valid float")
+	expectError(t, c.do("ZADD", "weekly", "1000"), "ERR syntax error")
+	expectError(t, c.do("ZADD", "weekly", "NX", "XX", "1000", "alice"), "ERR XX and NX")
+	expectError(t, c.do("ZADD", "weekly", "GT", "1000", "alice"), "ERR GT is not supported")
+	// A bad score anywhere leaves the board untouched
+	expectError(t, c.do("ZADD", "weekly", "1000", "alice", "9000", "bob"), "ERR rating")
+	expect(t, c.do("ZCARD", "weekly"), int64(0))
+
+	c.do("ZADD", "weekly", "4900", "alice")
+	expectError(t, c.do("ZINCRBY", "weekly", "200", "alice"), "ERR rating must be between")
+	expectError(t, c.do("ZINCRBY", "weekly", "50", "bob"), "ERR rating")
+	expectError(t, c.do("ZINCRBY", "weekly", "1.5", "alice"), "ERR increment")
+	expectError(t, c.do("ZSCORE", "weekly"), "ERR wrong number of arguments for 'zscore'")
+	expectError(t, c.do("ZREVRANGE", "weekly", "0", "1", "WITHSCORE"), "ERR syntax error")
+	expectError(t, c.do("FLUSHALL"), "ERR unknown command 'FLUSHALL'")
+
+	// The connection still works after errors
+	expect(t, c.do("ZSCORE", "weekly", "alice"), "4900")
+}
+
+func TestPipelining(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	var commands [][]string
+	for i := 0; i < 500; i++ {
+		commands = append(commands, []string{"ZADD", "bulk", strconv.Itoa(100 + i), fmt.Sprintf("user_%d", i)})
+	}
+	commands = append(commands, []string{"ZCARD", "bulk"})
+	c.send(commands...)
+	for i := 0; i < 500; i++ {
+		if reply := c.read(); reply != int64(1) {
+			t.Fatalf("Reply %d: got %#v", i, reply)
+		}
+	}
+	expect(t, c.read(), int64(500))
+}
+
+func TestInlineAndProtocolErrors(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	// Inline commands, as typed into telnet
+	io.WriteString(c.conn, "PING\r\nZADD weekly 1000 alice\r\n")
+	expect(t, c.read(), "PONG")
+	expect(t, c.read(), int64(1))
+
+	io.WriteString(c.conn, "*1\r\n$4\r\nPINGX\r\n")
+	expectError(t, c.read(), "ERR Protocol error")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection closed after a protocol error, got %v", err)
+	}
+
+	c = dial(t, addr)
+	expect(t, c.do("QUIT"), "OK")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection closed after QUIT, got %v", err)
+	}
+}
@@ -0,0 +1,451 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// Server answers sorted-set commands over RESP:
+//
+//	ZADD key [NX|XX] [CH] score member [score member ...]
+//	ZINCRBY key increment member
+//	ZREVRANK key member
+//	ZREVRANGE key start stop [WITHSCORES]
+//	ZSCORE key member
+//	ZCARD key
+//	ZREM key member [member ...]
+//
+// plus PING, ECHO, QUIT and an empty COMMAND reply for redis-cli. Scores
+// must be whole ratings between 100 and 5000, and ZREVRANK answers the
+// board's dense rank counted from 0, so tied users share a rank.
+type Server struct {
+	boards *services.Boards
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer serves boards, creating a board when a command writes to a key
+// that does not exist yet
+func NewServer(boards *services.Boards) *Server {
+	return &Server{
+		boards:    boards,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on addr, e.g. ":6379", and serves until Close
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and drops every connection
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		var protoErr *ProtocolError
+		if errors.As(err, &protoErr) {
+			w.error("ERR " + protoErr.Error())
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		quit := false
+		if len(args) > 0 {
+			quit = s.dispatch(w, args)
+		}
+		// Answer a pipeline in one write, once every command sent so far
+		// has been read
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// dispatch runs one command and reports whether the client asked to quit
+func (s *Server) dispatch(w writer, args []string) bool {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "PING":
+		if len(args) > 2 {
+			w.error(wrongArgs(name))
+		} else if len(args) == 2 {
+			w.bulk(args[1])
+		} else {
+			w.simple("PONG")
+		}
+	case "ECHO":
+		if len(args) != 2 {
+			w.error(wrongArgs(name))
+		} else {
+			w.bulk(args[1])
+		}
+	case "QUIT":
+		w.simple("OK")
+		return true
+	case "COMMAND":
+		w.array(0)
+	case "ZADD":
+		s.zadd(w, args)
+	case "ZINCRBY":
+		s.zincrby(w, args)
+	case "ZREVRANK":
+		s.zrevrank(w, args)
+	case "ZREVRANGE":
+		s.zrevrange(w, args)
+	case "ZSCORE":
+		s.zscore(w, args)
+	case "ZCARD":
+		s.zcard(w, args)
+	case "ZREM":
+		s.zrem(w, args)
+	default:
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	return false
+}
+
+func wrongArgs(name string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+// parseRating parses a score, which must be a whole rating
+func parseRating(score string) (int, error) {
+	f, err := strconv.ParseFloat(score, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("ERR value is not a valid float")
+	}
+	if f != math.Trunc(f) || f < 100 || f > 5000 {
+		return 0, errors.New("ERR rating must be a whole number between 100 and 5000")
+	}
+	return int(f), nil
+}
+
+// setRating adds member with rating, or updates it, reporting which
+func setRating(board *services.LeaderboardService, member string, rating int, nx, xx bool) (added, changed bool, err error) {
+	for {
+		user, err := board.GetUserRank(member)
+		if err != nil {
+			if xx {
+				return false, false, nil
+			}
+			err = board.AddUser(&models.User{ID: member, Username: member, Rating: rating})
+			if errors.Is(err, services.ErrDuplicate) {
+				continue // added by another client meanwhile
+			}
+			return err == nil, err == nil, err
+		}
+
+		if nx || user.Rating == rating {
+			return false, false, nil
+		}
+		if err := board.UpdateRating(member, rating); err != nil {
+			if errors.Is(err, services.ErrNotFound) {
+				continue // removed by another client meanwhile
+			}
+			return false, false, err
+		}
+		return false, true, nil
+	}
+}
+
+func (s *Server) zadd(w writer, args []string) {
+	var nx, xx, ch bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		case "GT", "LT", "INCR":
+			w.error("ERR " + strings.ToUpper(args[i]) + " is not supported")
+			return
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(args) < 2 || len(pairs) == 0 || len(pairs)%2 != 0 {
+		w.error("ERR syntax error")
+		return
+	}
+	if nx && xx {
+		w.error("ERR XX and NX options at the same time are not compatible")
+		return
+	}
+
+	// Every score is checked before any member is written
+	ratings := make([]int, len(pairs)/2)
+	for j := range ratings {
+		rating, err := parseRating(pairs[2*j])
+		if err != nil {
+			w.error(err.Error())
+			return
+		}
+		ratings[j] = rating
+	}
+
+	board, err := s.boards.GetOrCreate(args[1])
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	count := 0
+	for j, rating := range ratings {
+		added, changed, err := setRating(board, pairs[2*j+1], rating, nx, xx)
+		if err != nil {
+			w.error("ERR " + err.Error())
+			return
+		}
+		if added || (ch && changed) {
+			count++
+		}
+	}
+	w.integer(count)
+}
+
+func (s *Server) zincrby(w writer, args []string) {
+	if len(args) != 4 {
+		w.error(wrongArgs(args[0]))
+		return
+	}
+	delta, err := strconv.Atoi(args[2])
+	if err != nil {
+		w.error("ERR increment must be a whole number")
+		return
+	}
+
+	board, err := s.boards.GetOrCreate(args[1])
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	member := args[3]
+	for {
+		rating, err := board.IncrementRating(member, delta)
+		if err == nil {
+			w.bulk(strconv.Itoa(rating))
+			return
+		}
+		if !errors.Is(err, services.ErrNotFound) {
+			w.error("ERR " + err.Error())
+			return
+		}
+
+		// A missing member starts from 0, as in Redis
+		if delta < 100 || delta > 5000 {
+			w.error("ERR rating must be a whole number between 100 and 5000")
+			return
+		}
+		err = board.AddUser(&models.User{ID: member, Username: member, Rating: delta})
+		if err == nil {
+			w.bulk(strconv.Itoa(delta))
+			return
+		}
+		if !errors.Is(err, services.ErrDuplicate) {
+			w.error("ERR " + err.Error())
+			return
+		}
+	}
+}
+
+func (s *Server) zrevrank(w writer, args []string) {
+	if len(args) != 3 {
+		w.error(wrongArgs(args[0]))
+		return
+	}
+	board, ok := s.boards.Get(args[1])
+	if !ok {
+		w.null()
+		return
+	}
+	position, err := board.GetUserPosition(args[2])
+	if err != nil {
+		w.null()
+		return
+	}
+	w.integer(position)
+}
+
+func (s *Server) zrevrange(w writer, args []string) {
+	withScores := len(args) == 5 && strings.ToUpper(args[4]) == "WITHSCORES"
+	if len(args) != 4 && !withScores {
+		if len(args) == 5 {
+			w.error("ERR syntax error")
+		} else {
+			w.error(wrongArgs(args[0]))
+		}
+		return
+	}
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+
+	var users []models.UserWithRank
+	if board, ok := s.boards.Get(args[1]); ok {
+		// Negative indexes count from the end
+		count := board.GetUserCount()
+		if start < 0 {
+			start = max(count+start, 0)
+		}
+		if stop < 0 {
+			stop = count + stop
+		}
+		stop = min(stop, count-1)
+		if start <= stop {
+			users = board.GetUsersInRange(start, stop-start+1)
+		}
+	}
+
+	if withScores {
+		w.array(2 * len(users))
+	} else {
+		w.array(len(users))
+	}
+	for _, u := range users {
+		w.bulk(u.Username)
+		if withScores {
+			w.bulk(strconv.Itoa(u.Rating))
+		}
+	}
+}
+
+func (s *Server) zscore(w writer, args []string) {
+	if len(args) != 3 {
+		w.error(wrongArgs(args[0]))
+		return
+	}
+	board, ok := s.boards.Get(args[1])
+	if !ok {
+		w.null()
+		return
+	}
+	user, err := board.GetUserRank(args[2])
+	if err != nil {
+		w.null()
+		return
+	}
+	w.bulk(strconv.Itoa(user.Rating))
+}
+
+func (s *Server) zcard(w writer, args []string) {
+	if len(args) != 2 {
+		w.error(wrongArgs(args[0]))
+		return
+	}
+	count := 0
+	if board, ok := s.boards.Get(args[1]); ok {
+		count = board.GetUserCount()
+	}
+	w.integer(count)
+}
+
+func (s *Server) zrem(w writer, args []string) {
+	if len(args) < 3 {
+		w.error(wrongArgs(args[0]))
+		return
+	}
+	board, ok := s.boards.Get(args[1])
+	if !ok {
+		w.integer(0)
+		return
+	}
+	removed := 0
+	for _, member := range args[2:] {
+		err := board.RemoveUser(member)
+		if err == nil {
+			removed++
+		} else if !errors.Is(err, services.ErrNotFound) {
+			w.error("ERR " + err.Error())
+			return
+		}
+	}
+	w.integer(removed)
+}
@@ -0,0 +1,180 @@
+// Package router matches requests on method and path, with named path
+// parameters, and answers 405 with an Allow header when only the method is
+// wrong. It is what http.ServeMux does from Go 1.22, for the Go this
+// module builds with.
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Router dispatches to the first route registered whose method and path
+// match
+type Router struct {
+	routes []route
+
+	// NotFound answers paths no route has, by default with http.NotFound
+	NotFound http.Handler
+	// MethodNotAllowed answers paths routed only for other methods, after
+	// the Allow header is set. By default it sends a plain 405.
+	MethodNotAllowed http.Handler
+}
+
+type route struct {
+	method   string
+	segments []string // literal, or a parameter written {name}
+	handler  http.Handler
+}
+
+// New returns an empty router, which answers 404 to everything
+func New() *Router {
+	return &Router{}
+}
+
+// Handle routes method requests for pattern to h. The pattern is a path
+// whose segments may be parameters, as in "/user/{username}", which match
+// any one non-empty segment. GET routes also answer HEAD.
+func (rt *Router) Handle(method, pattern string, h http.Handler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("router: pattern must start with /: " + pattern)
+	}
+	rt.routes = append(rt.routes, route{method: method, segments: strings.Split(pattern[1:], "/"), handler: h})
+}
+
+// HandleFunc routes method requests for pattern to f
+func (rt *Router) HandleFunc(method, pattern string, f http.HandlerFunc) {
+	rt.Handle(method, pattern, f)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments, ok := splitPath(r.URL.EscapedPath())
+	if !ok {
+		rt.notFound(w, r)
+		return
+	}
+
+	var allowed []string
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.method == r.Method || (route.method == http.MethodGet && r.Method == http.MethodHead) {
+			if len(params) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+			}
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+		allowed = append(allowed, route.method)
+		if route.method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+
+	if len(allowed) == 0 {
+		rt.notFound(w, r)
+		return
+	}
+	w.Header().Set("Allow", allow(allowed))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if rt.MethodNotAllowed != nil {
+		rt.MethodNotAllowed.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (rt *Router) notFound(w http.ResponseWriter, r *http.Request) {
+	if rt.NotFound != nil {
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// match returns the parameters if segments fit the route
+func (r route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, want := range r.segments {
+		if name, ok := paramName(want); ok {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = segments[i]
+		} else if segments[i] != want {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// splitPath splits an escaped path into unescaped segments, so an escaped
+// slash stays inside its segment
+func splitPath(path string) ([]string, bool) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, s := range segments {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, false
+		}
+		segments[i] = unescaped
+	}
+	return segments, true
+}
+
+// allow formats methods, sorted and without repeats, for an Allow header
+func allow(methods []string) string {
+	methods = append(methods, http.MethodOptions)
+	sort.Strings(methods)
+	unique := methods[:0]
+	for i, m := range methods {
+		if i == 0 || m != methods[i-1] {
+			unique = append(unique, m)
+		}
+	}
+	return strings.Join(unique, ", ")
+}
+
+type paramsKey struct{}
+
+// Param returns the path parameter name of a routed request, or "" when the
+// request did not come through a route with that parameter
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// Deprecated marks responses from next as coming from a deprecated path,
+// deprecated since the given time. The successor is the same request under
+// prefix.
+func Deprecated(next http.Handler, since time.Time, prefix string) http.Handler {
+	deprecation := "@" + strconv.FormatInt(since.Unix(), 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation)
+		w.Header().Set("Link", "<"+prefix+r.URL.RequestURI()+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
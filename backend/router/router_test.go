@@ -0,0 +1,111 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testRouter() *Router {
+	rt := New()
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + Param(r, "username") + Param(r, "board")))
+	}
+	rt.HandleFunc(http.MethodGet, "/user/{username}", echo)
+	rt.HandleFunc(http.MethodDelete, "/user/{username}", echo)
+	rt.HandleFunc(http.MethodGet, "/boards/{board}/user/{username}", echo)
+	rt.HandleFunc(http.MethodPost, "/update", echo)
+	return rt
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+	return rr
+}
+
+func TestRouting(t *testing.T) {
+	rt := testRouter()
+	tests := []struct {
+		method, target string
+		status         int
+		body           string
+	}{
+		{"GET", "/user/alice", 200, "GET alice"},
+		{"DELETE", "/user/alice", 200, "DELETE alice"},
+		{"GET", "/user/a%2Fb", 200, "GET a/b"},
+		{"GET", "/boards/weekly/user/bob", 200, "GET bobweekly"},
+		{"POST", "/update", 200, "POST "},
+		{"HEAD", "/user/alice", 200, ""},
+		{"GET", "/user/", 404, ""},
+		{"GET", "/user/alice/extra", 404, ""},
+		{"GET", "/update/", 404, ""},
+		{"GET", "/nowhere", 404, ""},
+	}
+	for _, tt := range tests {
+		rr := serve(rt, tt.method, tt.target)
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.target, rr.Code, tt.status)
+		}
+		if tt.status == 200 && tt.method != "HEAD" && rr.Body.String() != tt.body {
+			t.Errorf("%s %s: got body %q, want %q", tt.method, tt.target, rr.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rt := testRouter()
+
+	rr := serve(rt, "PUT", "/user/alice")
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rr.Code)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS" {
+		t.Errorf("Unexpected Allow header %q", allow)
+	}
+
+	rr = serve(rt, "GET", "/update")
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "OPTIONS, POST" {
+		t.Errorf("Expected 405 allowing POST, got %d %q", rr.Code, rr.Header().Get("Allow"))
+	}
+
+	rr = serve(rt, "OPTIONS", "/update")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Allow") != "OPTIONS, POST" {
+		t.Errorf("Expected OPTIONS to list the methods, got %d %q", rr.Code, rr.Header().Get("Allow"))
+	}
+}
+
+func TestCustomErrors(t *testing.T) {
+	rt := testRouter()
+	rt.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("custom 404"))
+	})
+	rt.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("custom 405 " + w.Header().Get("Allow")))
+	})
+
+	if rr := serve(rt, "GET", "/nowhere"); rr.Code != 404 || rr.Body.String() != "custom 404" {
+		t.Errorf("Expected the custom 404, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := serve(rt, "GET", "/update"); rr.Code != 405 || rr.Body.String() != "custom 405 OPTIONS, POST" {
+		t.Errorf("Expected the custom 405, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	since := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	h := Deprecated(testRouter(), since, "/v1")
+	rr := serve(h, "GET", "/user/alice?x=1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the request served, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Deprecation"); got != "@1792281600" {
+		t.Errorf("Unexpected Deprecation header %q", got)
+	}
+	if got := rr.Header().Get("Link"); got != `</v1/user/alice?x=1>; rel="successor-version"` {
+		t.Errorf("Unexpected Link header %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// DefaultMaxBoards is how many boards a Boards holds unless SetMaxBoards
+// says otherwise
+const DefaultMaxBoards = 16
+
+// ErrTooManyBoards is returned instead of creating a board past the limit
+var ErrTooManyBoards = errors.New("too many boards")
+
+// Boards holds independent leaderboards by name, for front-ends that address
+// several boards at once. A board is created the first time it is written,
+// up to a limit, so clients cannot fill memory with boards.
+type Boards struct {
+	mu     sync.RWMutex
+	boards map[string]*LeaderboardService
+	create func() *LeaderboardService
+	max    int
+}
+
+// NewBoards returns an empty set of boards, made by create when first
+// written, NewLeaderboardService if create is nil
+func NewBoards(create func() *LeaderboardService) *Boards {
+	if create == nil {
+		create = NewLeaderboardService
+	}
+	return &Boards{boards: make(map[string]*LeaderboardService), create: create, max: DefaultMaxBoards}
+}
+
+// SetMaxBoards limits how many boards there can be, counting those named
+// with Set. Boards already held are kept.
+func (b *Boards) SetMaxBoards(max int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.max = max
+}
+
+// Set names an existing board, e.g. the one served over HTTP
+func (b *Boards) Set(name string, ls *LeaderboardService) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.boards[name] = ls
+}
+
+// Get returns the board called name, if it exists
+func (b *Boards) Get(name string) (*LeaderboardService, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ls, ok := b.boards[name]
+	return ls, ok
+}
+
+// GetOrCreate returns the board called name, creating it if needed. It
+// returns ErrTooManyBoards rather than create one past the limit.
+func (b *Boards) GetOrCreate(name string) (*LeaderboardService, error) {
+	if ls, ok := b.Get(name); ok {
+		return ls, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ls, ok := b.boards[name]; ok {
+		return ls, nil
+	}
+	if len(b.boards) >= b.max {
+		return nil, ErrTooManyBoards
+	}
+	ls := b.create()
+	b.boards[name] = ls
+	return ls, nil
+}
+
+// Names returns the names of every board, sorted
+func (b *Boards) Names() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.boards))
+	for name := range b.boards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
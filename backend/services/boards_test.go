@@ -0,0 +1,37 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBoards(t *testing.T) {
+	main := NewLeaderboardService()
+	boards := NewBoards(nil)
+	boards.Set("main", main)
+
+	if ls, ok := boards.Get("main"); !ok || ls != main {
+		t.Error("Expected the named board back")
+	}
+	if _, ok := boards.Get("weekly"); ok {
+		t.Error("Expected no weekly board before it is written")
+	}
+
+	weekly, err := boards.GetOrCreate("weekly")
+	if again, _ := boards.GetOrCreate("weekly"); err != nil || weekly == nil || weekly == main || again != weekly {
+		t.Error("Expected one new weekly board")
+	}
+	if names := boards.Names(); !reflect.DeepEqual(names, []string{"main", "weekly"}) {
+		t.Errorf("Expected main and weekly, got %v", names)
+	}
+
+	// Past the limit existing boards are still returned but none are made
+	boards.SetMaxBoards(2)
+	if _, err := boards.GetOrCreate("daily"); !errors.Is(err, ErrTooManyBoards) {
+		t.Errorf("Expected ErrTooManyBoards, got %v", err)
+	}
+	if ls, err := boards.GetOrCreate("weekly"); err != nil || ls != weekly {
+		t.Errorf("Expected the weekly board past the limit, got %v", err)
+	}
+}
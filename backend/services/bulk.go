@@ -0,0 +1,68 @@
+package services
+
+import "leaderboard/models"
+
+// ImportUsers adds a batch of users under a single lock. With upsert, users
+// that already exist get their rating updated instead of failing. errs lines
+// up with users and is nil where a user went in. Every row is checked first
+// and the changes are logged together, so the batch costs one log write or
+// round trip rather than one per row.
+func (ls *LeaderboardService) ImportUsers(users []models.User, upsert bool) (added, updated int, errs []error) {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+
+	// Rows are checked against the board as the rows before them leave it
+	errs = make([]error, len(users))
+	ratings := make(map[string]int) // of users added or updated by earlier rows
+	var ms []models.Mutation
+	var rows []int
+	for i, user := range users {
+		rating, exists := ratings[user.Username]
+		if !exists {
+			var current models.User
+			current, exists = ls.storage.get(user.Username)
+			rating = current.Rating
+		}
+
+		var m models.Mutation
+		switch {
+		case exists && upsert:
+			if errs[i] = checkRating(user.Rating); errs[i] != nil || rating == user.Rating {
+				continue
+			}
+			m = models.Mutation{Op: models.MutationUpdate, Username: user.Username, Rating: user.Rating}
+		case exists:
+			errs[i] = &DuplicateError{Username: user.Username}
+			continue
+		default:
+			if errs[i] = ls.checkUserLocked(&user); errs[i] != nil {
+				continue
+			}
+			m = models.Mutation{Op: models.MutationAdd, ID: user.ID, Username: user.Username, Rating: user.Rating}
+		}
+		ratings[user.Username] = user.Rating
+		ms = append(ms, m)
+		rows = append(rows, i)
+	}
+
+	logged, err := ls.logBatchLocked(ms)
+	for j, m := range ms {
+		i := rows[j]
+		if j >= logged {
+			errs[i] = err
+			continue
+		}
+		if m.Op == models.MutationUpdate {
+			if errs[i] = ls.updateRatingLocked(m.Username, m.Rating, false); errs[i] == nil {
+				updated++
+			}
+			continue
+		}
+		// Storage may keep the pointer, so every user needs its own copy
+		user := models.User{ID: m.ID, Username: m.Username, Rating: m.Rating}
+		if errs[i] = ls.addUserLocked(&user, false); errs[i] == nil {
+			added++
+		}
+	}
+	return added, updated, errs
+}
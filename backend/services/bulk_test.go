@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"leaderboard/models"
+	"testing"
+)
+
+func TestImportUsers(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1500})
+
+	users := []models.User{
+		{ID: "1", Username: "alice", Rating: 2000},
+		{ID: "2", Username: "bob", Rating: 1200},
+		{ID: "3", Username: "carol", Rating: 20},
+		{ID: "4", Username: "dave", Rating: 3000},
+	}
+
+	// Insert-only leaves existing users alone
+	added, updated, errs := ls.ImportUsers(users, false)
+	if added != 2 || updated != 0 || errs[0] == nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("Insert: got added=%d updated=%d errs=%v", added, updated, errs)
+	}
+
+	// Upsert updates existing users, including those added just before
+	users[1].Rating = 1300
+	added, updated, errs = ls.ImportUsers(users, true)
+	if added != 0 || updated != 2 || errs[2] == nil {
+		t.Errorf("Upsert: got added=%d updated=%d errs=%v", added, updated, errs)
+	}
+	if u, _ := ls.GetUserRank("alice"); u.Rating != 2000 || u.Rank != 2 {
+		t.Errorf("Expected alice at 2000 ranked 2, got %+v", u)
+	}
+
+	// Each user keeps its own record
+	users[3].Rating = 4000
+	if u, _ := ls.GetUserRank("dave"); u.Rating != 3000 {
+		t.Errorf("Caller's slice should not alias stored users, got %+v", u)
+	}
+}
+
+// batchLog records the batches it is given
+type batchLog struct {
+	batches [][]models.Mutation
+}
+
+func (l *batchLog) Append(m models.Mutation) error {
+	_, err := l.AppendBatch([]models.Mutation{m})
+	return err
+}
+
+func (l *batchLog) AppendBatch(ms []models.Mutation) (int, error) {
+	l.batches = append(l.batches, append([]models.Mutation(nil), ms...))
+	return len(ms), nil
+}
+
+// singleLog takes one mutation at a time and fails after limit of them
+type singleLog struct {
+	logged []models.Mutation
+	limit  int
+}
+
+func (l *singleLog) Append(m models.Mutation) error {
+	if len(l.logged) == l.limit {
+		return errors.New("log full")
+	}
+	l.logged = append(l.logged, m)
+	return nil
+}
+
+func TestImportUsersLogsOneBatch(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1500})
+	log := &batchLog{}
+	ls.SetMutationLog(log)
+
+	users := []models.User{
+		{ID: "2", Username: "bob", Rating: 1200},
+		{ID: "1", Username: "alice", Rating: 2000},
+		{ID: "3", Username: "carol", Rating: 20},
+		{ID: "2", Username: "bob", Rating: 1300},
+		{ID: "2", Username: "bob", Rating: 1300},
+	}
+	added, updated, errs := ls.ImportUsers(users, true)
+	if added != 1 || updated != 2 || errs[2] == nil {
+		t.Errorf("Got added=%d updated=%d errs=%v", added, updated, errs)
+	}
+	if len(log.batches) != 1 || len(log.batches[0]) != 3 {
+		t.Fatalf("Expected one batch of 3 mutations, got %+v", log.batches)
+	}
+	for i, m := range log.batches[0] {
+		if m.Seq != uint64(2+i) {
+			t.Errorf("Mutation %d: expected seq %d, got %+v", i, 2+i, m)
+		}
+	}
+	if ls.Version() != 4 {
+		t.Errorf("Expected version 4, got %d", ls.Version())
+	}
+
+	// Rows repeating one in the same batch are duplicates without upsert
+	_, _, errs = ls.ImportUsers([]models.User{{ID: "4", Username: "dave", Rating: 1000}, {ID: "4", Username: "dave", Rating: 1000}}, false)
+	if errs[0] != nil || !errors.Is(errs[1], ErrDuplicate) {
+		t.Errorf("Expected the repeated row refused, got %v", errs)
+	}
+}
+
+func TestImportUsersPartlyLogged(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.SetMutationLog(&singleLog{limit: 2})
+
+	users := []models.User{
+		{ID: "1", Username: "alice", Rating: 1500},
+		{ID: "2", Username: "bob", Rating: 1200},
+		{ID: "3", Username: "carol", Rating: 1000},
+	}
+	added, _, errs := ls.ImportUsers(users, false)
+	if added != 2 || errs[1] != nil || errs[2] == nil {
+		t.Errorf("Expected the logged rows alone added, got added=%d errs=%v", added, errs)
+	}
+	if _, err := ls.GetUserRank("carol"); err == nil {
+		t.Error("Expected the row that was not logged to be left out")
+	}
+}
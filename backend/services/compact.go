@@ -0,0 +1,324 @@
+package services
+
+import (
+	"sort"
+
+	"leaderboard/models"
+)
+
+// compactStorage packs users into flat slices indexed by a dense int32 user
+// index, so the per-user cost is a few dozen bytes and a handful of pointers
+// for the whole board instead of several heap objects per user.
+//
+// Username and ID bytes are interned once in a shared arena. An open
+// addressing table of indices replaces the username map and buckets hold
+// indices sorted by username.
+type compactStorage struct {
+	arena   []byte   // username bytes followed by ID bytes for every record
+	offsets []uint32 // start of each record in arena
+	nameLen []uint16
+	idLen   []uint16
+	ratings []uint16 // zero marks a free index
+
+	slots   []int32 // index+1 of each user by username hash, zero is empty
+	buckets [5001][]int32
+	free    []int32
+	live    int
+	garbage int // arena bytes held by removed or renamed records
+}
+
+// compactMaxField is the longest username or ID the layout can hold
+const compactMaxField = 1<<16 - 1
+
+func newCompactStorage() *compactStorage {
+	return &compactStorage{slots: make([]int32, 1024)}
+}
+
+func (s *compactStorage) nameBytes(idx int32) []byte {
+	off := s.offsets[idx]
+	return s.arena[off : off+uint32(s.nameLen[idx])]
+}
+
+func (s *compactStorage) idBytes(idx int32) []byte {
+	off := s.offsets[idx] + uint32(s.nameLen[idx])
+	return s.arena[off : off+uint32(s.idLen[idx])]
+}
+
+func (s *compactStorage) name(idx int32) string { return string(s.nameBytes(idx)) }
+
+// fnv1a hashes a username without converting it
+func fnv1a(b []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range b {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+func fnv1aString(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// slotOf finds the table position holding username, or -1
+func (s *compactStorage) slotOf(username string) int {
+	mask := uint32(len(s.slots) - 1)
+	for p := fnv1aString(username) & mask; ; p = (p + 1) & mask {
+		v := s.slots[p]
+		if v == 0 {
+			return -1
+		}
+		if string(s.nameBytes(v-1)) == username {
+			return int(p)
+		}
+	}
+}
+
+func (s *compactStorage) lookup(username string) (int32, bool) {
+	p := s.slotOf(username)
+	if p < 0 {
+		return 0, false
+	}
+	return s.slots[p] - 1, true
+}
+
+func (s *compactStorage) insertSlot(idx int32) {
+	// Keep the load factor under 3/4
+	if (s.live+1)*4 > len(s.slots)*3 {
+		s.growSlots()
+	}
+	mask := uint32(len(s.slots) - 1)
+	p := fnv1a(s.nameBytes(idx)) & mask
+	for s.slots[p] != 0 {
+		p = (p + 1) & mask
+	}
+	s.slots[p] = idx + 1
+}
+
+func (s *compactStorage) growSlots() {
+	old := s.slots
+	s.slots = make([]int32, len(old)*2)
+	mask := uint32(len(s.slots) - 1)
+	for _, v := range old {
+		if v == 0 {
+			continue
+		}
+		p := fnv1a(s.nameBytes(v-1)) & mask
+		for s.slots[p] != 0 {
+			p = (p + 1) & mask
+		}
+		s.slots[p] = v
+	}
+}
+
+// deleteSlot empties position p and shifts later entries of the probe run
+// back so lookups never stop early
+func (s *compactStorage) deleteSlot(p int) {
+	mask := len(s.slots) - 1
+	s.slots[p] = 0
+	for j := (p + 1) & mask; s.slots[j] != 0; j = (j + 1) & mask {
+		home := int(fnv1a(s.nameBytes(s.slots[j]-1))) & mask
+		// Entries whose home lies cyclically in (p, j] are already reachable
+		if (p < j && p < home && home <= j) || (p > j && (p < home || home <= j)) {
+			continue
+		}
+		s.slots[p] = s.slots[j]
+		s.slots[j] = 0
+		p = j
+	}
+}
+
+// writeRecord appends a username and ID to the arena for idx
+func (s *compactStorage) writeRecord(idx int32, username string, id []byte) {
+	s.offsets[idx] = uint32(len(s.arena))
+	s.nameLen[idx] = uint16(len(username))
+	s.idLen[idx] = uint16(len(id))
+	s.arena = append(s.arena, username...)
+	s.arena = append(s.arena, id...)
+}
+
+func (s *compactStorage) recordLen(idx int32) int {
+	return int(s.nameLen[idx]) + int(s.idLen[idx])
+}
+
+// compactArena drops dead records once they make up half the arena
+func (s *compactStorage) compactArena() {
+	if s.garbage < 4096 || s.garbage*2 < len(s.arena) {
+		return
+	}
+
+	arena := make([]byte, 0, len(s.arena)-s.garbage)
+	for idx := range s.ratings {
+		if s.ratings[idx] == 0 {
+			continue
+		}
+		off := s.offsets[idx]
+		s.offsets[idx] = uint32(len(arena))
+		arena = append(arena, s.arena[off:off+uint32(s.recordLen(int32(idx)))]...)
+	}
+	s.arena = arena
+	s.garbage = 0
+}
+
+func (s *compactStorage) bucketIndex(rating int, username string) int {
+	bucket := s.buckets[rating]
+	return sort.Search(len(bucket), func(i int) bool { return string(s.nameBytes(bucket[i])) >= username })
+}
+
+func (s *compactStorage) bucketInsert(rating int, idx int32) {
+	i := s.bucketIndex(rating, s.name(idx))
+	bucket := append(s.buckets[rating], 0)
+	copy(bucket[i+1:], bucket[i:])
+	bucket[i] = idx
+	s.buckets[rating] = bucket
+}
+
+func (s *compactStorage) bucketRemove(rating int, idx int32) {
+	bucket := s.buckets[rating]
+	i := s.bucketIndex(rating, s.name(idx))
+	if i < len(bucket) && bucket[i] == idx {
+		s.buckets[rating] = append(bucket[:i], bucket[i+1:]...)
+	}
+}
+
+func (s *compactStorage) check(user *models.User) error {
+	if len(user.Username) > compactMaxField {
+		return &InvalidUserError{Field: "username", Max: compactMaxField}
+	}
+	if len(user.ID) > compactMaxField {
+		return &InvalidUserError{Field: "id", Max: compactMaxField}
+	}
+	return nil
+}
+
+func (s *compactStorage) get(username string) (models.User, bool) {
+	idx, ok := s.lookup(username)
+	if !ok {
+		return models.User{}, false
+	}
+	return models.User{
+		ID:       string(s.idBytes(idx)),
+		Username: username,
+		Rating:   int(s.ratings[idx]),
+	}, true
+}
+
+func (s *compactStorage) add(user *models.User) {
+	var idx int32
+	if n := len(s.free); n > 0 {
+		idx = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		idx = int32(len(s.ratings))
+		s.offsets = append(s.offsets, 0)
+		s.nameLen = append(s.nameLen, 0)
+		s.idLen = append(s.idLen, 0)
+		s.ratings = append(s.ratings, 0)
+	}
+
+	s.writeRecord(idx, user.Username, []byte(user.ID))
+	s.ratings[idx] = uint16(user.Rating)
+	s.insertSlot(idx)
+	s.live++
+	s.bucketInsert(user.Rating, idx)
+}
+
+func (s *compactStorage) setRating(username string, oldRating, newRating int) {
+	idx, _ := s.lookup(username)
+	s.bucketRemove(oldRating, idx)
+	s.ratings[idx] = uint16(newRating)
+	s.bucketInsert(newRating, idx)
+}
+
+func (s *compactStorage) rename(oldUsername, newUsername string) {
+	p := s.slotOf(oldUsername)
+	idx := s.slots[p] - 1
+	rating := int(s.ratings[idx])
+
+	s.bucketRemove(rating, idx)
+	s.deleteSlot(p)
+
+	id := append([]byte(nil), s.idBytes(idx)...)
+	s.garbage += s.recordLen(idx)
+	s.writeRecord(idx, newUsername, id)
+
+	// insertSlot may grow the table, which counts live users
+	s.live--
+	s.insertSlot(idx)
+	s.live++
+	s.bucketInsert(rating, idx)
+	s.compactArena()
+}
+
+func (s *compactStorage) remove(username string) {
+	p := s.slotOf(username)
+	idx := s.slots[p] - 1
+
+	s.bucketRemove(int(s.ratings[idx]), idx)
+	s.deleteSlot(p)
+	s.garbage += s.recordLen(idx)
+	s.ratings[idx] = 0
+	s.nameLen[idx] = 0
+	s.idLen[idx] = 0
+	s.free = append(s.free, idx)
+	s.live--
+	s.compactArena()
+}
+
+func (s *compactStorage) count() int { return s.live }
+
+func (s *compactStorage) bucketSize(rating int) int { return len(s.buckets[rating]) }
+
+func (s *compactStorage) bucketAt(rating, i int) string { return s.name(s.buckets[rating][i]) }
+
+func (s *compactStorage) bucketAfter(rating int, username string) int {
+	bucket := s.buckets[rating]
+	return sort.Search(len(bucket), func(i int) bool { return string(s.nameBytes(bucket[i])) > username })
+}
+
+func (s *compactStorage) appendBucket(dst []string, rating int) []string {
+	for _, idx := range s.buckets[rating] {
+		dst = append(dst, s.name(idx))
+	}
+	return dst
+}
+
+func (s *compactStorage) usernames() []string {
+	usernames := make([]string, 0, s.live)
+	for idx, rating := range s.ratings {
+		if rating != 0 {
+			usernames = append(usernames, s.name(int32(idx)))
+		}
+	}
+	return usernames
+}
+
+func (s *compactStorage) forEach(fn func(username string, rating int)) {
+	for idx, rating := range s.ratings {
+		if rating != 0 {
+			fn(s.name(int32(idx)), int(rating))
+		}
+	}
+}
+
+func (s *compactStorage) each(fn func(models.User) error) error {
+	for idx, rating := range s.ratings {
+		if rating == 0 {
+			continue
+		}
+		user := models.User{
+			ID:       string(s.idBytes(int32(idx))),
+			Username: s.name(int32(idx)),
+			Rating:   int(rating),
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"leaderboard/models"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestCompactMatchesMapStorage drives both layouts through the same random
+// operations and compares everything the public API can see
+func TestCompactMatchesMapStorage(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	regular := NewLeaderboardService()
+	compact := NewCompactLeaderboardService()
+
+	names := make([]string, 0)
+	next := 0
+	for step := 0; step < 5000; step++ {
+		switch op := rng.Intn(10); {
+		case op < 4 || len(names) == 0:
+			u := models.User{ID: fmt.Sprintf("id_%d", next), Username: fmt.Sprintf("User_%d", next), Rating: 100 + rng.Intn(200)}
+			next++
+			a, b := u, u
+			errA, errB := regular.AddUser(&a), compact.AddUser(&b)
+			if (errA == nil) != (errB == nil) {
+				t.Fatalf("AddUser mismatch: %v vs %v", errA, errB)
+			}
+			names = append(names, u.Username)
+		case op < 8:
+			name := names[rng.Intn(len(names))]
+			rating := 100 + rng.Intn(200)
+			if (regular.UpdateRating(name, rating) == nil) != (compact.UpdateRating(name, rating) == nil) {
+				t.Fatalf("UpdateRating mismatch for %s", name)
+			}
+		case op < 9:
+			i := rng.Intn(len(names))
+			renamed := fmt.Sprintf("renamed_%d", step)
+			if (regular.RenameUser(names[i], renamed) == nil) != (compact.RenameUser(names[i], renamed) == nil) {
+				t.Fatalf("RenameUser mismatch for %s", names[i])
+			}
+			names[i] = renamed
+		default:
+			i := rng.Intn(len(names))
+			if (regular.RemoveUser(names[i]) == nil) != (compact.RemoveUser(names[i]) == nil) {
+				t.Fatalf("RemoveUser mismatch for %s", names[i])
+			}
+			names = append(names[:i], names[i+1:]...)
+		}
+	}
+
+	if regular.GetUserCount() != compact.GetUserCount() {
+		t.Fatalf("Count mismatch: %d vs %d", regular.GetUserCount(), compact.GetUserCount())
+	}
+
+	a, b := regular.GetUsersInRange(0, 100000), compact.GetUsersInRange(0, 100000)
+	if len(a) != len(b) {
+		t.Fatalf("Range length mismatch: %d vs %d", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("Row %d mismatch: %+v vs %+v", i, a[i], b[i])
+		}
+	}
+
+	for _, name := range names[:20] {
+		ra, _ := regular.GetUserRank(name)
+		rb, _ := compact.GetUserRank(name)
+		if *ra != *rb {
+			t.Errorf("Rank mismatch for %s: %+v vs %+v", name, ra, rb)
+		}
+	}
+
+	ua, ub := regular.GetAllUsernames(), compact.GetAllUsernames()
+	sort.Strings(ua)
+	sort.Strings(ub)
+	if strings.Join(ua, ",") != strings.Join(ub, ",") {
+		t.Error("Usernames mismatch")
+	}
+
+	for _, q := range []string{"user_1", "REN", "usr_2"} {
+		sa := usernamesOf(regular.SearchUsers(q, 15, true))
+		sb := usernamesOf(compact.SearchUsers(q, 15, true))
+		if strings.Join(sa, ",") != strings.Join(sb, ",") {
+			t.Errorf("Search %q mismatch:\n%v\n%v", q, sa, sb)
+		}
+	}
+
+	// Cursor paging walks the same rows
+	pa, _, _ := regular.GetUsersPage("", 10, 50, false)
+	pb, _, _ := compact.GetUsersPage("", 10, 50, false)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			t.Fatalf("Page row %d mismatch: %+v vs %+v", i, pa[i], pb[i])
+		}
+	}
+}
+
+func TestCompactStorageRecords(t *testing.T) {
+	s := newCompactStorage()
+	s.add(&models.User{ID: "id1", Username: "alice", Rating: 1500})
+	s.add(&models.User{ID: "id2", Username: "bob", Rating: 1500})
+
+	u, ok := s.get("alice")
+	if !ok || u.ID != "id1" || u.Rating != 1500 {
+		t.Errorf("Unexpected record: %+v %v", u, ok)
+	}
+	if _, ok := s.get("carol"); ok {
+		t.Error("Expected missing user")
+	}
+
+	// Removed slots are reused
+	s.remove("alice")
+	s.add(&models.User{ID: "id3", Username: "carol", Rating: 200})
+	if len(s.ratings) != 2 {
+		t.Errorf("Expected freed index to be reused, have %d indices", len(s.ratings))
+	}
+
+	// Renames keep ID and rating
+	s.rename("bob", "robert")
+	if u, ok := s.get("robert"); !ok || u.ID != "id2" || u.Rating != 1500 {
+		t.Errorf("Unexpected renamed record: %+v %v", u, ok)
+	}
+	if _, ok := s.get("bob"); ok {
+		t.Error("Old username should be gone after rename")
+	}
+
+	if err := s.check(&models.User{Username: strings.Repeat("x", compactMaxField+1)}); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("Expected an invalid user error for an oversized username, got %v", err)
+	}
+	var invalid *InvalidUserError
+	if err := s.check(&models.User{ID: strings.Repeat("x", compactMaxField+1), Username: "ok"}); !errors.As(err, &invalid) || invalid.Field != "id" {
+		t.Errorf("Expected the oversized id named, got %v", err)
+	}
+}
+
+func TestCompactStorageGrowAndCompact(t *testing.T) {
+	s := newCompactStorage()
+	for i := 0; i < 5000; i++ {
+		s.add(&models.User{ID: fmt.Sprintf("id_%d", i), Username: fmt.Sprintf("name_%d", i), Rating: 100 + i%50})
+	}
+	for i := 0; i < 5000; i += 2 {
+		s.remove(fmt.Sprintf("name_%d", i))
+	}
+
+	if s.garbage*2 >= len(s.arena) && s.garbage >= 4096 {
+		t.Errorf("Expected arena to be compacted, garbage %d of %d", s.garbage, len(s.arena))
+	}
+	for i := 1; i < 5000; i += 2 {
+		u, ok := s.get(fmt.Sprintf("name_%d", i))
+		if !ok || u.ID != fmt.Sprintf("id_%d", i) {
+			t.Fatalf("Lost user name_%d after compaction: %+v", i, u)
+		}
+	}
+	if s.count() != 2500 {
+		t.Errorf("Expected 2500 users, got %d", s.count())
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// benchmarkMemoryPerUser reports the heap held per user by each layout
+func benchmarkMemoryPerUser(b *testing.B, newService func() *LeaderboardService) {
+	const users = 200000
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		ls := newService()
+		for j := 0; j < users; j++ {
+			ls.AddUser(&models.User{
+				ID:       fmt.Sprintf("user_id_%d", j),
+				Username: fmt.Sprintf("user_%d", j),
+				Rating:   100 + j%4901,
+			})
+		}
+		after := heapInUse()
+		b.ReportMetric(float64(after-before)/users, "bytes/user")
+		runtime.KeepAlive(ls)
+	}
+}
+
+func BenchmarkMemoryPerUserMap(b *testing.B) { benchmarkMemoryPerUser(b, NewLeaderboardService) }
+func BenchmarkMemoryPerUserCompact(b *testing.B) {
+	benchmarkMemoryPerUser(b, NewCompactLeaderboardService)
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinels matching the typed errors below with errors.Is
+var (
+	ErrNotFound      = errors.New("user not found")
+	ErrDuplicate     = errors.New("user already exists")
+	ErrInvalidRating = errors.New("invalid rating")
+	ErrInvalidUser   = errors.New("invalid user")
+)
+
+// NotFoundError is a username that is not on the board
+type NotFoundError struct {
+	Username string
+}
+
+func (e *NotFoundError) Error() string {
+	return "user not found: " + e.Username
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// DuplicateError is a username that is already taken
+type DuplicateError struct {
+	Username string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("user with username %s already exists", e.Username)
+}
+
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// InvalidRatingError is a rating outside 100 to 5000
+type InvalidRatingError struct {
+	Rating int
+}
+
+func (e *InvalidRatingError) Error() string {
+	return fmt.Sprintf("rating must be between 100 and 5000, got %d", e.Rating)
+}
+
+func (e *InvalidRatingError) Is(target error) bool {
+	return target == ErrInvalidRating
+}
+
+// InvalidUserError is a user the storage cannot hold, such as a username
+// longer than the compact layout allows
+type InvalidUserError struct {
+	Field string // username or id
+	Max   int    // most bytes the field may have
+}
+
+func (e *InvalidUserError) Error() string {
+	return fmt.Sprintf("%s must be at most %d bytes", e.Field, e.Max)
+}
+
+func (e *InvalidUserError) Is(target error) bool {
+	return target == ErrInvalidUser
+}
+
+// checkRating returns an *InvalidRatingError for ratings out of range
+func checkRating(rating int) error {
+	if rating < 100 || rating > 5000 {
+		return &InvalidRatingError{Rating: rating}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"leaderboard/models"
+)
+
+func TestTypedErrors(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1000})
+
+	var notFound *NotFoundError
+	if err := ls.UpdateRating("bob", 1000); !errors.As(err, &notFound) || notFound.Username != "bob" || !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a NotFoundError for bob, got %v", err)
+	}
+	if _, err := ls.GetUserRank("bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := ls.RemoveUser("bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	var duplicate *DuplicateError
+	if err := ls.AddUser(&models.User{ID: "2", Username: "alice", Rating: 1000}); !errors.As(err, &duplicate) || duplicate.Username != "alice" {
+		t.Errorf("Expected a DuplicateError for alice, got %v", err)
+	}
+	ls.AddUser(&models.User{ID: "3", Username: "carol", Rating: 1000})
+	if err := ls.RenameUser("carol", "alice"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate, got %v", err)
+	}
+
+	var rating *InvalidRatingError
+	if err := ls.UpdateRating("alice", 5001); !errors.As(err, &rating) || rating.Rating != 5001 {
+		t.Errorf("Expected an InvalidRatingError for 5001, got %v", err)
+	}
+	if _, err := ls.IncrementRating("alice", -1000); !errors.Is(err, ErrInvalidRating) {
+		t.Errorf("Expected ErrInvalidRating, got %v", err)
+	}
+
+	// The messages are unchanged from the untyped errors
+	if err := ls.UpdateRating("alice", 99); err.Error() != "rating must be between 100 and 5000, got 99" {
+		t.Errorf("Unexpected message %q", err)
+	}
+	if err := ls.UpdateRating("bob", 1000); err.Error() != "user not found: bob" {
+		t.Errorf("Unexpected message %q", err)
+	}
+}
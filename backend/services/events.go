@@ -0,0 +1,105 @@
+package services
+
+import (
+	"time"
+
+	"leaderboard/models"
+)
+
+// maxTierEvents bounds how many tier change events are retained in memory
+const maxTierEvents = 10000
+
+const (
+	EventPromoted = "promoted"
+	EventDemoted  = "demoted"
+)
+
+// firstEventID is where event IDs count up from. Events are not persisted,
+// so IDs start at the boot time in microseconds rather than at 1, which
+// keeps them past any a client saw before a restart and its since cursor
+// from skipping the new ones.
+func firstEventID() int64 {
+	return time.Now().UnixMicro()
+}
+
+// recordTierChangeLocked emits an event when a rating change moved the user
+// into another tier. Must be called with the write lock held.
+func (ls *LeaderboardService) recordTierChangeLocked(username string, oldRating, newRating, fromTier, toTier int) {
+	if fromTier == toTier || ls.replaying {
+		return
+	}
+
+	eventType := EventPromoted
+	if toTier < fromTier {
+		eventType = EventDemoted
+	}
+
+	ls.nextEventID++
+	event := models.TierChangeEvent{
+		ID:        ls.nextEventID,
+		Username:  username,
+		Type:      eventType,
+		FromTier:  ls.tiers[fromTier].Name,
+		ToTier:    ls.tiers[toTier].Name,
+		OldRating: oldRating,
+		NewRating: newRating,
+		Timestamp: time.Now().UTC(),
+	}
+
+	// Drop the oldest tenth at once so trimming stays cheap during bursts
+	if len(ls.tierEvents) >= maxTierEvents {
+		ls.tierEvents = append(ls.tierEvents[:0], ls.tierEvents[maxTierEvents/10:]...)
+	}
+	ls.tierEvents = append(ls.tierEvents, event)
+
+	// Subscribers that are not keeping up miss events rather than block writers
+	for ch := range ls.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// GetTierChanges returns up to limit events with an ID greater than since,
+// oldest first. An empty username returns events for every user.
+func (ls *LeaderboardService) GetTierChanges(username string, since int64, limit int) []models.TierChangeEvent {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	result := make([]models.TierChangeEvent, 0)
+	for _, event := range ls.tierEvents {
+		if len(result) >= limit {
+			break
+		}
+		if event.ID <= since {
+			continue
+		}
+		if username != "" && event.Username != username {
+			continue
+		}
+		result = append(result, event)
+	}
+	return result
+}
+
+// SubscribeTierChanges returns a channel receiving new tier change events and
+// a function to cancel the subscription. Events are dropped when the buffer
+// is full.
+func (ls *LeaderboardService) SubscribeTierChanges(buffer int) (<-chan models.TierChangeEvent, func()) {
+	ch := make(chan models.TierChangeEvent, buffer)
+
+	ls.mu.Lock()
+	ls.subscribers[ch] = struct{}{}
+	ls.mu.Unlock()
+
+	cancel := func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		if _, ok := ls.subscribers[ch]; ok {
+			delete(ls.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
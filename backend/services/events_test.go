@@ -0,0 +1,120 @@
+package services
+
+import (
+	"leaderboard/models"
+	"testing"
+	"time"
+)
+
+func TestTierChangeEvents(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "climber", Rating: 900})
+	ls.AddUser(&models.User{Username: "other", Rating: 1500})
+
+	// Same tier, no event
+	ls.UpdateRating("climber", 950)
+	if events := ls.GetTierChanges("", 0, 10); len(events) != 0 {
+		t.Fatalf("Expected no events, got %+v", events)
+	}
+
+	ls.UpdateRating("climber", 2100) // Bronze -> Gold
+	ls.UpdateRating("other", 500)    // Silver -> Bronze
+	ls.UpdateRating("climber", 1200) // Gold -> Silver
+
+	events := ls.GetTierChanges("", 0, 10)
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	first := events[0]
+	if first.Type != EventPromoted || first.FromTier != "Bronze" || first.ToTier != "Gold" || first.NewRating != 2100 {
+		t.Errorf("Unexpected promotion event: %+v", first)
+	}
+	if events[1].Type != EventDemoted || events[1].Username != "other" {
+		t.Errorf("Unexpected demotion event: %+v", events[1])
+	}
+
+	// Per user
+	userEvents := ls.GetTierChanges("climber", 0, 10)
+	if len(userEvents) != 2 {
+		t.Errorf("Expected 2 climber events, got %d", len(userEvents))
+	}
+
+	// Since and limit
+	later := ls.GetTierChanges("", first.ID, 1)
+	if len(later) != 1 || later[0].ID != events[1].ID {
+		t.Errorf("Unexpected events after %d: %+v", first.ID, later)
+	}
+}
+
+func TestTierChangeEventsBounded(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "yoyo", Rating: 500})
+	first := ls.nextEventID + 1
+
+	for i := 0; i < maxTierEvents+10; i++ {
+		if i%2 == 0 {
+			ls.UpdateRating("yoyo", 2500)
+		} else {
+			ls.UpdateRating("yoyo", 500)
+		}
+	}
+
+	events := ls.GetTierChanges("", 0, maxTierEvents*2)
+	if len(events) > maxTierEvents {
+		t.Errorf("Expected at most %d events, got %d", maxTierEvents, len(events))
+	}
+	if last := events[len(events)-1]; last.ID != first+maxTierEvents+9 {
+		t.Errorf("Expected newest event to be kept, got ID %d", last.ID)
+	}
+}
+
+func TestTierChangeEventIDsSurviveRestart(t *testing.T) {
+	before := NewLeaderboardService()
+	before.AddUser(&models.User{Username: "climber", Rating: 900})
+	before.UpdateRating("climber", 2100)
+	seen := before.GetTierChanges("", 0, 10)
+
+	time.Sleep(time.Millisecond)
+	after := NewLeaderboardService()
+	after.AddUser(&models.User{Username: "climber", Rating: 900})
+	after.UpdateRating("climber", 2100)
+	if events := after.GetTierChanges("", seen[0].ID, 10); len(events) != 1 {
+		t.Errorf("Expected the event after a restart past the last one seen, got %+v", events)
+	}
+}
+
+func TestReplayEmitsNoTierChanges(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.Apply(models.Mutation{Seq: 1, Op: models.MutationAdd, ID: "id1", Username: "climber", Rating: 900})
+	ls.Apply(models.Mutation{Seq: 2, Op: models.MutationUpdate, Username: "climber", Rating: 2100})
+	ls.ApplyIfNewer(models.Mutation{Seq: 3, Op: models.MutationUpdate, Username: "climber", Rating: 500})
+	ls.Replace(4, []models.User{{ID: "id1", Username: "climber", Rating: 3500}})
+	if events := ls.GetTierChanges("", 0, 10); len(events) != 0 {
+		t.Errorf("Expected no events from replayed changes, got %+v", events)
+	}
+
+	ls.UpdateRating("climber", 900)
+	if events := ls.GetTierChanges("", 0, 10); len(events) != 1 {
+		t.Errorf("Expected a live change to emit again, got %+v", events)
+	}
+}
+
+func TestSubscribeTierChanges(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "sub", Rating: 500})
+
+	ch, cancel := ls.SubscribeTierChanges(1)
+	ls.UpdateRating("sub", 3500)
+	ls.UpdateRating("sub", 500) // Dropped, buffer is full
+
+	event := <-ch
+	if event.ToTier != "Platinum" {
+		t.Errorf("Expected promotion to Platinum, got %+v", event)
+	}
+
+	cancel()
+	cancel() // Safe to call twice
+	if _, ok := <-ch; ok {
+		t.Error("Expected channel to be closed after cancel")
+	}
+}
@@ -0,0 +1,37 @@
+package services
+
+import "leaderboard/models"
+
+// ExportUsers calls fn for every user in rank order, all read from one
+// snapshot of the board. The lock is only held while the snapshot is taken,
+// so writers carry on while fn runs. It returns the version exported.
+func (ls *LeaderboardService) ExportUsers(fn func(models.UserWithRank) error) (uint64, error) {
+	// Share the published read snapshot when it is current
+	ls.mu.RLock()
+	var board *boardSnapshot
+	if rs := ls.readSnapshot.Load(); rs != nil && rs.version == ls.version {
+		board = rs.boardSnapshot
+	} else {
+		board = ls.copyBoardLocked()
+	}
+	ls.mu.RUnlock()
+
+	rank, above := 1, 0
+	for rating := 5000; rating >= 100; rating-- {
+		bucket := board.buckets[rating]
+		if len(bucket) == 0 {
+			continue
+		}
+
+		tier := board.tierName(rating, above)
+		for _, username := range bucket {
+			user := models.UserWithRank{Rank: rank, Username: username, Rating: rating, Tier: tier}
+			if err := fn(user); err != nil {
+				return board.version, err
+			}
+		}
+		rank++
+		above += len(bucket)
+	}
+	return board.version, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"leaderboard/models"
+	"testing"
+	"time"
+)
+
+func TestExportUsers(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "carol", Rating: 1500})
+	ls.AddUser(&models.User{Username: "alice", Rating: 3000})
+	ls.AddUser(&models.User{Username: "bob", Rating: 1500})
+
+	// Writes made while exporting are not seen
+	var got []models.UserWithRank
+	version, err := ls.ExportUsers(func(u models.UserWithRank) error {
+		if len(got) == 0 {
+			ls.UpdateRating("carol", 5000)
+			ls.AddUser(&models.User{Username: "dave", Rating: 4000})
+		}
+		got = append(got, u)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if version != 3 {
+		t.Errorf("Expected version 3, got %d", version)
+	}
+
+	want := []models.UserWithRank{
+		{Rank: 1, Username: "alice", Rating: 3000, Tier: "Platinum"},
+		{Rank: 2, Username: "bob", Rating: 1500, Tier: "Silver"},
+		{Rank: 2, Username: "carol", Rating: 1500, Tier: "Silver"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d users, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Row %d: got %+v want %+v", i, got[i], want[i])
+		}
+	}
+
+	// An error from fn stops the export
+	stop := errors.New("stop")
+	rows := 0
+	if _, err := ls.ExportUsers(func(models.UserWithRank) error { rows++; return stop }); err != stop || rows != 1 {
+		t.Errorf("Expected export to stop after 1 row, got %d %v", rows, err)
+	}
+
+	// The published read snapshot is reused when current
+	ls.EnableSnapshotReads(time.Hour)
+	rows = 0
+	ls.ExportUsers(func(models.UserWithRank) error { rows++; return nil })
+	if rows != 4 {
+		t.Errorf("Expected 4 users from the read snapshot, got %d", rows)
+	}
+}
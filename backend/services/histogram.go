@@ -0,0 +1,21 @@
+package services
+
+// RatingCounts returns how many users hold each rating, indexed by rating.
+// Summed across boards it gives both the dense rank and the users above a
+// rating, which is all a sharded board needs to rank globally.
+func (ls *LeaderboardService) RatingCounts() []int {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	counts := make([]int, 5001)
+	for rating := 100; rating <= 5000; rating++ {
+		counts[rating] = ls.storage.bucketSize(rating)
+	}
+	return counts
+}
+
+// TierName names the tier of a rating on a board of total users, above of
+// whom are rated higher
+func TierName(tiers []Tier, rating, above, total int) string {
+	return tiers[tierIndex(tiers, rating, above, total)].Name
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+
+	"leaderboard/models"
+)
+
+func TestRatingCounts(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "alice", Rating: 1500})
+	ls.AddUser(&models.User{Username: "bob", Rating: 1500})
+	ls.AddUser(&models.User{Username: "carol", Rating: 5000})
+
+	counts := ls.RatingCounts()
+	if len(counts) != 5001 || counts[1500] != 2 || counts[5000] != 1 || counts[100] != 0 {
+		t.Errorf("Unexpected counts at 1500, 5000 and 100: %d %d %d", counts[1500], counts[5000], counts[100])
+	}
+}
+
+func TestTierName(t *testing.T) {
+	ls := NewLeaderboardService()
+	for i := 0; i < 200; i++ {
+		ls.AddUser(&models.User{Username: string(rune('a'+i%26)) + string(rune('a'+i/26)), Rating: 4500 + i})
+	}
+
+	// The service and TierName agree for every user
+	counts := ls.RatingCounts()
+	for _, u := range ls.GetUsersInRange(0, 200) {
+		above := 0
+		for r := u.Rating + 1; r <= 5000; r++ {
+			above += counts[r]
+		}
+		if got := TierName(DefaultTiers, u.Rating, above, 200); got != u.Tier {
+			t.Fatalf("Rating %d: expected %s, got %s", u.Rating, u.Tier, got)
+		}
+	}
+}
@@ -1,70 +1,145 @@
 package services
 
 import (
-	"fmt"
-	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"leaderboard/models"
 )
 
 type LeaderboardService struct {
-	mu            sync.RWMutex
-	users         map[string]*models.User
-	ratingBuckets [5001]map[string]struct{}
-	allUsernames  []string
+	// writeMu makes writers go one at a time, and is taken before mu. A
+	// writer holds it for the whole mutation but lets go of mu while a
+	// RemoteLog append is in flight, so reads carry on meanwhile.
+	writeMu     sync.Mutex
+	mu          sync.RWMutex
+	storage     userStorage
+	tiers       []Tier
+	tierEvents  []models.TierChangeEvent
+	nextEventID int64
+	subscribers map[chan models.TierChangeEvent]struct{}
+	index       *usernameTrie
+	mutationLog MutationLog
+
+	// replaying is set while mutations that happened before, elsewhere or
+	// earlier, are applied, so they do not emit tier events again
+	replaying bool
+
+	// version counts mutations so cursors and snapshots can name a board state
+	version         uint64
+	pageSnapshots   map[uint64]*pageSnapshot
+	nextSnapshotID  uint64
+	pageSnapshotTTL time.Duration
+
+	// Lock-free read path, see EnableSnapshotReads
+	readSnapshot   atomic.Pointer[readSnapshot]
+	snapshotReads  atomic.Bool
+	snapMu         sync.Mutex
+	snapStaleness  time.Duration
+	publishPending bool
+	dirtyRatings   map[int]struct{}
+	dirtyUsers     map[string]struct{}
 }
 
 /* NewLeaderboardService */
 func NewLeaderboardService() *LeaderboardService {
-	ls := &LeaderboardService{
-		users:        make(map[string]*models.User),
-		allUsernames: make([]string, 0),
-	}
-	// buckets
-	for i := 0; i < 5001; i++ {
-		ls.ratingBuckets[i] = make(map[string]struct{})
+	return newLeaderboardService(newMapStorage(), newUsernameTrie())
+}
+
+// NewCompactLeaderboardService stores users in the compact layout meant for
+// boards with millions of users. It keeps no search index, which would cost
+// more than the layout saves, so SearchUsers scans every username under the
+// read lock. Results are the same as with the index, but each search takes
+// time in proportion to the board.
+func NewCompactLeaderboardService() *LeaderboardService {
+	return newLeaderboardService(newCompactStorage(), nil)
+}
+
+func newLeaderboardService(storage userStorage, index *usernameTrie) *LeaderboardService {
+	return &LeaderboardService{
+		storage:     storage,
+		tiers:       append([]Tier(nil), DefaultTiers...),
+		nextEventID: firstEventID(),
+		subscribers: make(map[chan models.TierChangeEvent]struct{}),
+		index:       index,
+
+		pageSnapshots:   make(map[uint64]*pageSnapshot),
+		pageSnapshotTTL: DefaultPageSnapshotTTL,
 	}
-	return ls
 }
 
 // adds a new user to the leaderboard
 func (ls *LeaderboardService) AddUser(user *models.User) error {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	return ls.addUserLocked(user, true)
+}
 
-	if _, exists := ls.users[user.Username]; exists {
-		return fmt.Errorf("user with username %s already exists", user.Username)
+func (ls *LeaderboardService) addUserLocked(user *models.User, logged bool) error {
+	if _, exists := ls.storage.get(user.Username); exists {
+		return &DuplicateError{Username: user.Username}
 	}
-
-	if user.Rating < 100 || user.Rating > 5000 {
-		return fmt.Errorf("rating must be between 100 and 5000, got %d", user.Rating)
+	if err := ls.checkUserLocked(user); err != nil {
+		return err
 	}
 
-	// Add to main
-	ls.users[user.Username] = user
+	if logged {
+		m := models.Mutation{Op: models.MutationAdd, ID: user.ID, Username: user.Username, Rating: user.Rating}
+		if err := ls.logLocked(m); err != nil {
+			return err
+		}
+	}
 
-	// Add to bucket
-	ls.ratingBuckets[user.Rating][user.Username] = struct{}{}
+	// Add to main and bucket
+	ls.storage.add(user)
 
 	// Add username
-	ls.allUsernames = append(ls.allUsernames, user.Username)
+	if ls.index != nil {
+		ls.index.insert(user.Username)
+	}
+	ls.version++
+	ls.markDirtyLocked([]string{user.Username}, user.Rating)
 
 	return nil
 }
 
+// checkUserLocked reports whether user could be stored, duplicates aside
+func (ls *LeaderboardService) checkUserLocked(user *models.User) error {
+	if err := checkRating(user.Rating); err != nil {
+		return err
+	}
+	return ls.storage.check(user)
+}
+
 // UpdateRating of users
 func (ls *LeaderboardService) UpdateRating(username string, newRating int) error {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	return ls.updateRatingLocked(username, newRating, true)
+}
+
+// UpdateRatingRank sets a user's rating like UpdateRating and returns the
+// user with their new rank. The rank is read from the board under the same
+// lock, so the writer sees its write even when snapshot reads have not
+// published it yet.
+func (ls *LeaderboardService) UpdateRatingRank(username string, newRating int) (*models.UserWithRank, error) {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	if err := ls.updateRatingLocked(username, newRating, true); err != nil {
+		return nil, err
+	}
+	return ls.userWithRankLocked(username, newRating), nil
+}
 
-	user, exists := ls.users[username]
+func (ls *LeaderboardService) updateRatingLocked(username string, newRating int, logged bool) error {
+	user, exists := ls.storage.get(username)
 	if !exists {
-		return fmt.Errorf("user not found: %s", username)
+		return &NotFoundError{Username: username}
 	}
 
-	if newRating < 100 || newRating > 5000 {
-		return fmt.Errorf("rating must be between 100 and 5000, got %d", newRating)
+	if err := checkRating(newRating); err != nil {
+		return err
 	}
 
 	oldRating := user.Rating
@@ -72,86 +147,164 @@ func (ls *LeaderboardService) UpdateRating(username string, newRating int) error
 		return nil
 	}
 
-	delete(ls.ratingBuckets[oldRating], username)
-	ls.ratingBuckets[newRating][username] = struct{}{}
+	if logged {
+		m := models.Mutation{Op: models.MutationUpdate, Username: username, Rating: newRating}
+		if err := ls.logLocked(m); err != nil {
+			return err
+		}
+	}
+
+	fromTier := ls.tierOfLocked(oldRating)
 
-	user.Rating = newRating
+	ls.storage.setRating(username, oldRating, newRating)
+	ls.version++
+	ls.markDirtyLocked([]string{username}, oldRating, newRating)
+
+	ls.recordTierChangeLocked(username, oldRating, newRating, fromTier, ls.tierOfLocked(newRating))
 
 	return nil
 }
 
+// IncrementRating adds delta to a user's rating and returns the new rating.
+// The result must stay between 100 and 5000.
+func (ls *LeaderboardService) IncrementRating(username string, delta int) (int, error) {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+
+	user, exists := ls.storage.get(username)
+	if !exists {
+		return 0, &NotFoundError{Username: username}
+	}
+	rating := user.Rating + delta
+	if err := ls.updateRatingLocked(username, rating, true); err != nil {
+		return user.Rating, err
+	}
+	return rating, nil
+}
+
 func (ls *LeaderboardService) GetUserRank(username string) (*models.UserWithRank, error) {
+	if snap := ls.readSnapshot.Load(); snap != nil {
+		return snap.userRank(username)
+	}
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	user, exists := ls.storage.get(username)
+	if !exists {
+		return nil, &NotFoundError{Username: username}
+	}
+
+	return ls.userWithRankLocked(user.Username, user.Rating), nil
+}
+
+// GetUserPosition returns the 0-based position of a user in GetUsersInRange
+// order: how many users come before them. Unlike the dense rank it counts
+// every user ahead, including those tied on rating but sorting first by name.
+func (ls *LeaderboardService) GetUserPosition(username string) (int, error) {
+	if snap := ls.readSnapshot.Load(); snap != nil {
+		return snap.position(username)
+	}
+
 	ls.mu.RLock()
 	defer ls.mu.RUnlock()
 
-	user, exists := ls.users[username]
+	user, exists := ls.storage.get(username)
 	if !exists {
-		return nil, fmt.Errorf("user not found: %s", username)
+		return 0, &NotFoundError{Username: username}
 	}
 
+	above := 0
+	for r := 5000; r > user.Rating; r-- {
+		above += ls.storage.bucketSize(r)
+	}
+	return above + ls.storage.bucketAfter(user.Rating, user.Username) - 1, nil
+}
+
+// userWithRankLocked builds the ranked view of a single user
+func (ls *LeaderboardService) userWithRankLocked(username string, rating int) *models.UserWithRank {
 	// Calculate rank
 	rank := 1
-	for rating := 5000; rating > user.Rating; rating-- {
-		if len(ls.ratingBuckets[rating]) > 0 {
+	above := 0
+	for r := 5000; r > rating; r-- {
+		if size := ls.storage.bucketSize(r); size > 0 {
 			rank++
+			above += size
 		}
 	}
 
 	return &models.UserWithRank{
 		Rank:     rank,
-		Username: user.Username,
-		Rating:   user.Rating,
-	}, nil
+		Username: username,
+		Rating:   rating,
+		Tier:     ls.tiers[ls.tierIndexLocked(rating, above, ls.storage.count())].Name,
+	}
 }
 
 // GetUsersInRange returns a slice of users
 func (ls *LeaderboardService) GetUsersInRange(offset, limit int) []models.UserWithRank {
+	if snap := ls.readSnapshot.Load(); snap != nil {
+		return snap.usersInRange(offset, limit)
+	}
+
 	ls.mu.RLock()
 	defer ls.mu.RUnlock()
 
+	return ls.collectLocked(offset, limit, nil)
+}
+
+// collectLocked walks the buckets from the top and returns up to limit users
+// after skipping offset. When keep is set only users whose tier index it
+// accepts are counted towards offset and limit.
+func (ls *LeaderboardService) collectLocked(offset, limit int, keep func(tier int) bool) []models.UserWithRank {
 	if limit <= 0 {
 		return []models.UserWithRank{}
 	}
 
 	result := make([]models.UserWithRank, 0, limit)
+	total := ls.storage.count()
 	rank := 1
+	above := 0
 	skipped := 0
 	collected := 0
 
 	for rating := 5000; rating >= 100 && collected < limit; rating-- {
-		bucket := ls.ratingBuckets[rating]
-		bucketSize := len(bucket)
+		bucketSize := ls.storage.bucketSize(rating)
 
 		if bucketSize == 0 {
 			continue
 		}
 
+		tier := ls.tierIndexLocked(rating, above, total)
+		above += bucketSize
+
+		if keep != nil && !keep(tier) {
+			rank++
+			continue
+		}
+
 		if skipped+bucketSize <= offset {
 			skipped += bucketSize
 			rank++
 			continue
 		}
 
-		usernames := make([]string, 0, bucketSize)
-		for u := range bucket {
-			usernames = append(usernames, u)
+		from := 0
+		if skipped < offset {
+			from = offset - skipped
+			skipped = offset
 		}
-		sort.Strings(usernames)
-
-		for _, username := range usernames {
-			if skipped < offset {
-				skipped++
-				continue
-			}
 
+		for i := from; i < bucketSize; i++ {
 			if collected >= limit {
 				break
 			}
 
 			result = append(result, models.UserWithRank{
 				Rank:     rank,
-				Username: username,
+				Username: ls.storage.bucketAt(rating, i),
 				Rating:   rating,
+				Tier:     ls.tiers[tier].Name,
 			})
 			collected++
 		}
@@ -166,13 +319,11 @@ func (ls *LeaderboardService) GetUsersInRange(offset, limit int) []models.UserWi
 func (ls *LeaderboardService) GetUserCount() int {
 	ls.mu.RLock()
 	defer ls.mu.RUnlock()
-	return len(ls.users)
+	return ls.storage.count()
 }
 
 func (ls *LeaderboardService) GetAllUsernames() []string {
 	ls.mu.RLock()
 	defer ls.mu.RUnlock()
-	usernames := make([]string, len(ls.allUsernames))
-	copy(usernames, ls.allUsernames)
-	return usernames
+	return ls.storage.usernames()
 }
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"fmt"
 	"leaderboard/models"
+	"sort"
 	"testing"
 )
 
@@ -61,6 +63,26 @@ func TestUpdateRating(t *testing.T) {
 	}
 }
 
+func TestIncrementRating(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "test1", Rating: 1000})
+
+	if rating, err := ls.IncrementRating("test1", 250); err != nil || rating != 1250 {
+		t.Errorf("Expected rating 1250, got %d %v", rating, err)
+	}
+	if rating, err := ls.IncrementRating("test1", -50); err != nil || rating != 1200 {
+		t.Errorf("Expected rating 1200, got %d %v", rating, err)
+	}
+
+	// Leaving the rating range changes nothing
+	if rating, err := ls.IncrementRating("test1", 4000); err == nil || rating != 1200 {
+		t.Errorf("Expected error and rating 1200, got %d %v", rating, err)
+	}
+	if _, err := ls.IncrementRating("ghost", 1); err == nil {
+		t.Error("Expected error for non-existent user")
+	}
+}
+
 func TestGetUserRank_DenseRanking(t *testing.T) {
 	ls := NewLeaderboardService()
 	ls.AddUser(&models.User{Username: "u1", Rating: 5000}) // Rank 1
@@ -145,3 +167,103 @@ func TestGetCounts(t *testing.T) {
 		t.Errorf("expected 1 username, got %d", len(ls.GetAllUsernames()))
 	}
 }
+
+func TestBucketsStaySorted(t *testing.T) {
+	ls := NewLeaderboardService()
+	for _, name := range []string{"m", "c", "x", "a", "k"} {
+		ls.AddUser(&models.User{Username: name, Rating: 1000})
+	}
+	ls.UpdateRating("x", 2000)
+	ls.UpdateRating("x", 1000)
+	ls.UpdateRating("c", 900)
+
+	bucket := ls.storage.(*mapStorage).ratingBuckets[1000]
+	want := []string{"a", "k", "m", "x"}
+	if len(bucket) != len(want) {
+		t.Fatalf("Expected bucket %v, got %v", want, bucket)
+	}
+	for i := range want {
+		if bucket[i] != want[i] {
+			t.Errorf("Expected bucket %v, got %v", want, bucket)
+			break
+		}
+	}
+
+	// Offset landing inside a bucket
+	users := ls.GetUsersInRange(2, 10)
+	if len(users) != 3 || users[0].Username != "m" || users[2].Username != "c" {
+		t.Errorf("Unexpected page: %+v", users)
+	}
+}
+
+// popularBoard puts 100k users on 50 ratings so every bucket holds thousands
+func popularBoard() *LeaderboardService {
+	ls := NewLeaderboardService()
+	for i := 0; i < 100000; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("user_%d", i), Rating: 2000 + i%50})
+	}
+	return ls
+}
+
+func BenchmarkGetUsersInRangeSortedBuckets(b *testing.B) {
+	ls := popularBoard()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ls.GetUsersInRange(5000, 100)
+	}
+}
+
+// BenchmarkGetUsersInRangeMapBuckets pages the same board the way it was done
+// before buckets were kept sorted: copy each bucket map and sort it per call
+func BenchmarkGetUsersInRangeMapBuckets(b *testing.B) {
+	ls := popularBoard()
+	var buckets [5001]map[string]struct{}
+	for rating, names := range ls.storage.(*mapStorage).ratingBuckets {
+		buckets[rating] = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			buckets[rating][name] = struct{}{}
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		offset, limit, skipped := 5000, 100, 0
+		result := make([]models.UserWithRank, 0, limit)
+		for rating := 5000; rating >= 100 && len(result) < limit; rating-- {
+			bucket := buckets[rating]
+			if len(bucket) == 0 {
+				continue
+			}
+			if skipped+len(bucket) <= offset {
+				skipped += len(bucket)
+				continue
+			}
+			usernames := make([]string, 0, len(bucket))
+			for u := range bucket {
+				usernames = append(usernames, u)
+			}
+			sort.Strings(usernames)
+			for _, u := range usernames {
+				if skipped < offset {
+					skipped++
+					continue
+				}
+				if len(result) >= limit {
+					break
+				}
+				result = append(result, models.UserWithRank{Username: u, Rating: rating})
+			}
+		}
+	}
+}
+
+func BenchmarkUpdateRating(b *testing.B) {
+	ls := popularBoard()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ls.UpdateRating(fmt.Sprintf("user_%d", i%100000), 2000+(i*7)%50)
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"fmt"
+
+	"leaderboard/models"
+)
+
+// MutationLog durably records mutations before they are applied
+type MutationLog interface {
+	Append(m models.Mutation) error
+}
+
+// RemoteLog is a MutationLog whose Append waits on other nodes, as a Raft
+// log waits for a majority. The service lets readers in while such an
+// append is in flight; writers still go one at a time, so the board is as
+// the mutation was checked against when it is applied.
+type RemoteLog interface {
+	MutationLog
+	Remote()
+}
+
+// BatchLog is a MutationLog that records several mutations with one write,
+// or one round trip, for the lot. AppendBatch returns how many of ms, in
+// order, were recorded; only those may be applied.
+type BatchLog interface {
+	MutationLog
+	AppendBatch(ms []models.Mutation) (int, error)
+}
+
+// AppendAll records ms in log, as one batch when it is a BatchLog and one at
+// a time otherwise, and returns how many were recorded
+func AppendAll(log MutationLog, ms []models.Mutation) (int, error) {
+	if batch, ok := log.(BatchLog); ok {
+		return batch.AppendBatch(ms)
+	}
+	for i, m := range ms {
+		if err := log.Append(m); err != nil {
+			return i, err
+		}
+	}
+	return len(ms), nil
+}
+
+// lockWriter takes the locks a mutation needs, see writeMu
+func (ls *LeaderboardService) lockWriter() {
+	ls.writeMu.Lock()
+	ls.mu.Lock()
+}
+
+func (ls *LeaderboardService) unlockWriter() {
+	ls.mu.Unlock()
+	ls.writeMu.Unlock()
+}
+
+// SetMutationLog makes every later mutation get appended to log first. A
+// mutation that cannot be logged is not applied.
+func (ls *LeaderboardService) SetMutationLog(log MutationLog) {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	ls.mutationLog = log
+}
+
+// Version is the sequence number of the last applied mutation
+func (ls *LeaderboardService) Version() uint64 {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return ls.version
+}
+
+// SetVersion sets the sequence number of the last applied mutation, used after
+// restoring users from a snapshot taken at that point
+func (ls *LeaderboardService) SetVersion(version uint64) {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	ls.version = version
+}
+
+// View is a consistent read-only view of every user. It is only valid inside
+// the WithView callback.
+type View struct {
+	Version uint64
+	Count   int
+	ls      *LeaderboardService
+}
+
+// Each calls fn for every user, in no particular order
+func (v View) Each(fn func(models.User) error) error {
+	return v.ls.storage.each(fn)
+}
+
+// WithView runs fn while writers are held off, so nothing is applied or
+// logged until it returns
+func (ls *LeaderboardService) WithView(fn func(View) error) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return fn(View{Version: ls.version, Count: ls.storage.count(), ls: ls})
+}
+
+// Copy returns every user and the version they are at. The users are copied
+// while writers are held off, so they can be written out after without
+// holding writers off for as long as the write takes.
+func (ls *LeaderboardService) Copy() (uint64, []models.User) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	users := make([]models.User, 0, ls.storage.count())
+	ls.storage.each(func(u models.User) error {
+		users = append(users, u)
+		return nil
+	})
+	return ls.version, users
+}
+
+// Tx gives a WithTx callback access to the board while writers are held off
+type Tx struct {
+	ls *LeaderboardService
+}
+
+// Get returns the user named username
+func (tx Tx) Get(username string) (models.User, bool) {
+	return tx.ls.storage.get(username)
+}
+
+// Apply performs m without logging it, as Apply does
+func (tx Tx) Apply(m models.Mutation) error {
+	return tx.ls.applyLocked(m)
+}
+
+// WithTx runs fn with the write lock held, for changes that depend on the
+// board as it is. Mutations applied through the Tx are not logged.
+func (ls *LeaderboardService) WithTx(fn func(Tx) error) error {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	return fn(Tx{ls: ls})
+}
+
+// logLocked stamps m with the sequence number it will get once applied.
+// The caller holds the writer locks; mu is let go during a RemoteLog append.
+func (ls *LeaderboardService) logLocked(m models.Mutation) error {
+	if ls.mutationLog == nil {
+		return nil
+	}
+	m.Seq = ls.version + 1
+	log := ls.mutationLog
+	if _, remote := log.(RemoteLog); remote {
+		ls.mu.Unlock()
+		defer ls.mu.Lock()
+	}
+	if err := log.Append(m); err != nil {
+		return fmt.Errorf("failed to log mutation: %w", err)
+	}
+	return nil
+}
+
+// logBatchLocked stamps ms with consecutive sequence numbers and logs them
+// as logLocked does, returning how many were logged
+func (ls *LeaderboardService) logBatchLocked(ms []models.Mutation) (int, error) {
+	if ls.mutationLog == nil {
+		return len(ms), nil
+	}
+	for i := range ms {
+		ms[i].Seq = ls.version + 1 + uint64(i)
+	}
+	log := ls.mutationLog
+	if _, remote := log.(RemoteLog); remote {
+		ls.mu.Unlock()
+		defer ls.mu.Lock()
+	}
+	n, err := AppendAll(log, ms)
+	if err != nil {
+		return n, fmt.Errorf("failed to log mutation: %w", err)
+	}
+	return n, nil
+}
+
+// Apply performs a mutation read back from a log without logging it again.
+// It emits no tier events, which were emitted when it first happened.
+func (ls *LeaderboardService) Apply(m models.Mutation) error {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	defer ls.replay()()
+	return ls.applyLocked(m)
+}
+
+// replay silences tier events until the returned function is called. The
+// caller holds the writer locks.
+func (ls *LeaderboardService) replay() func() {
+	ls.replaying = true
+	return func() { ls.replaying = false }
+}
+
+func (ls *LeaderboardService) applyLocked(m models.Mutation) error {
+	switch m.Op {
+	case models.MutationAdd:
+		return ls.addUserLocked(&models.User{ID: m.ID, Username: m.Username, Rating: m.Rating}, false)
+	case models.MutationUpdate:
+		return ls.updateRatingLocked(m.Username, m.Rating, false)
+	case models.MutationRename:
+		return ls.renameUserLocked(m.Username, m.NewUsername, false)
+	case models.MutationRemove:
+		return ls.removeUserLocked(m.Username, false)
+	default:
+		return fmt.Errorf("unknown mutation op: %s", m.Op)
+	}
+}
+
+// ApplyIfNewer applies m unless the board already reflects it, that is its
+// seq is not past the current version. It lets a log that overlaps a
+// snapshot be replayed safely. Like Apply it emits no tier events.
+func (ls *LeaderboardService) ApplyIfNewer(m models.Mutation) (bool, error) {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+
+	if m.Seq <= ls.version {
+		return false, nil
+	}
+	defer ls.replay()()
+	if err := ls.applyLocked(m); err != nil {
+		return false, err
+	}
+	ls.version = m.Seq
+	return true, nil
+}
+
+// Replace makes the board hold exactly users, at version. Users are diffed
+// against the board rather than cleared, so readers never see it empty. The
+// changes were made elsewhere, so they emit no tier events.
+func (ls *LeaderboardService) Replace(version uint64, users []models.User) error {
+	want := make(map[string]models.User, len(users))
+	for _, u := range users {
+		want[u.Username] = u
+	}
+
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	defer ls.replay()()
+
+	var removes, updates []models.Mutation
+	ls.storage.each(func(u models.User) error {
+		target, ok := want[u.Username]
+		switch {
+		case !ok || target.ID != u.ID:
+			removes = append(removes, models.Mutation{Op: models.MutationRemove, Username: u.Username})
+		case target.Rating != u.Rating:
+			updates = append(updates, models.Mutation{Op: models.MutationUpdate, Username: u.Username, Rating: target.Rating})
+			delete(want, u.Username)
+		default:
+			delete(want, u.Username)
+		}
+		return nil
+	})
+
+	// Removes first so renamed users do not clash with their old name
+	for _, m := range append(removes, updates...) {
+		if err := ls.applyLocked(m); err != nil {
+			return fmt.Errorf("replacing %s: %w", m.Username, err)
+		}
+	}
+	for _, u := range users {
+		if _, ok := want[u.Username]; !ok {
+			continue
+		}
+		m := models.Mutation{Op: models.MutationAdd, ID: u.ID, Username: u.Username, Rating: u.Rating}
+		if err := ls.applyLocked(m); err != nil {
+			return fmt.Errorf("replacing %s: %w", u.Username, err)
+		}
+		delete(want, u.Username)
+	}
+	ls.version = version
+	return nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"leaderboard/models"
+)
+
+func TestApplyIfNewer(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "alice", Rating: 1000})
+
+	// Already reflected by the board
+	if applied, err := ls.ApplyIfNewer(models.Mutation{Seq: 1, Op: models.MutationAdd, Username: "alice", Rating: 1000}); applied || err != nil {
+		t.Errorf("Expected seq 1 to be skipped, got %v %v", applied, err)
+	}
+
+	applied, err := ls.ApplyIfNewer(models.Mutation{Seq: 2, Op: models.MutationUpdate, Username: "alice", Rating: 2000})
+	if !applied || err != nil || ls.Version() != 2 {
+		t.Errorf("Expected seq 2 applied at version 2, got %v %v %d", applied, err, ls.Version())
+	}
+
+	// The version follows the seq even across a gap
+	ls.ApplyIfNewer(models.Mutation{Seq: 9, Op: models.MutationUpdate, Username: "alice", Rating: 3000})
+	if ls.Version() != 9 {
+		t.Errorf("Expected version 9, got %d", ls.Version())
+	}
+
+	if _, err := ls.ApplyIfNewer(models.Mutation{Seq: 10, Op: models.MutationUpdate, Username: "nobody", Rating: 3000}); err == nil {
+		t.Error("Expected error for an unknown user")
+	}
+	if ls.Version() != 9 {
+		t.Errorf("A failed mutation must not move the version, got %d", ls.Version())
+	}
+}
+
+func TestReplace(t *testing.T) {
+	for name, ls := range map[string]*LeaderboardService{"map": NewLeaderboardService(), "compact": NewCompactLeaderboardService()} {
+		ls.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1000})
+		ls.AddUser(&models.User{ID: "2", Username: "bob", Rating: 2000})
+		ls.AddUser(&models.User{ID: "3", Username: "carol", Rating: 3000})
+		ls.AddUser(&models.User{ID: "4", Username: "dave", Rating: 4000})
+
+		// bob is unchanged, alice rerated, carol gone, dave renamed to erin
+		// and a new dave took the name
+		users := []models.User{
+			{ID: "1", Username: "alice", Rating: 4500},
+			{ID: "2", Username: "bob", Rating: 2000},
+			{ID: "4", Username: "erin", Rating: 4000},
+			{ID: "5", Username: "dave", Rating: 100},
+		}
+		if err := ls.Replace(42, users); err != nil {
+			t.Fatalf("%s: Replace failed: %v", name, err)
+		}
+
+		if ls.Version() != 42 || ls.GetUserCount() != 4 {
+			t.Errorf("%s: expected 4 users at version 42, got %d at %d", name, ls.GetUserCount(), ls.Version())
+		}
+		var got []models.User
+		ls.WithView(func(v View) error {
+			return v.Each(func(u models.User) error { got = append(got, u); return nil })
+		})
+		byName := make(map[string]models.User)
+		for _, u := range got {
+			byName[u.Username] = u
+		}
+		for _, want := range users {
+			if byName[want.Username] != want {
+				t.Errorf("%s: expected %+v, got %+v", name, want, byName[want.Username])
+			}
+		}
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1000})
+	log := &recordingLog{}
+	ls.SetMutationLog(log)
+
+	err := ls.WithTx(func(tx Tx) error {
+		user, ok := tx.Get("alice")
+		if !ok {
+			t.Fatal("Expected alice on the board")
+		}
+		return tx.Apply(models.Mutation{Op: models.MutationUpdate, Username: "alice", Rating: user.Rating + 500})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := ls.GetUserRank("alice"); user.Rating != 1500 {
+		t.Errorf("Expected rating 1500, got %d", user.Rating)
+	}
+	if len(log.mutations) != 0 {
+		t.Errorf("Expected nothing logged, got %+v", log.mutations)
+	}
+}
+
+type recordingLog struct {
+	mutations []models.Mutation
+}
+
+func (l *recordingLog) Append(m models.Mutation) error {
+	l.mutations = append(l.mutations, m)
+	return nil
+}
+
+// blockingLog is a RemoteLog whose appends wait until released
+type blockingLog struct {
+	started chan models.Mutation
+	release chan error
+}
+
+func (l *blockingLog) Remote() {}
+
+func (l *blockingLog) Append(m models.Mutation) error {
+	l.started <- m
+	return <-l.release
+}
+
+func TestRemoteLogLetsReadsIn(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1000})
+	ls.AddUser(&models.User{ID: "2", Username: "bob", Rating: 2000})
+	log := &blockingLog{started: make(chan models.Mutation), release: make(chan error)}
+	ls.SetMutationLog(log)
+
+	first := make(chan error)
+	go func() { first <- ls.UpdateRating("alice", 3000) }()
+	if m := <-log.started; m.Seq != 3 {
+		t.Fatalf("Expected seq 3 proposed, got %+v", m)
+	}
+
+	// Reads go on while the append waits, and see the board before it
+	if user, err := ls.GetUserRank("alice"); err != nil || user.Rating != 1000 {
+		t.Errorf("Expected alice at 1000 during the append, got %+v %v", user, err)
+	}
+	if users := ls.GetUsersInRange(0, 10); len(users) != 2 || ls.Version() != 2 {
+		t.Errorf("Expected the board at version 2, got %+v at %d", users, ls.Version())
+	}
+
+	// Writers wait their turn, so the next one gets the following seq
+	second := make(chan error)
+	go func() { second <- ls.UpdateRating("bob", 500) }()
+	select {
+	case m := <-log.started:
+		t.Fatalf("Expected the second write to wait, got %+v proposed", m)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	log.release <- nil
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if m := <-log.started; m.Seq != 4 {
+		t.Fatalf("Expected seq 4 proposed next, got %+v", m)
+	}
+	log.release <- errors.New("no majority")
+	if err := <-second; err == nil {
+		t.Error("Expected the failed append to fail the write")
+	}
+	if user, _ := ls.GetUserRank("bob"); user.Rating != 2000 || ls.Version() != 3 {
+		t.Errorf("Expected only the first write applied, got bob %+v at %d", user, ls.Version())
+	}
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"leaderboard/models"
+)
+
+const (
+	// DefaultPageSnapshotTTL is how long a consistent page snapshot is kept
+	DefaultPageSnapshotTTL = 30 * time.Second
+
+	// maxPageSnapshots bounds the memory held by consistent paging
+	maxPageSnapshots = 8
+
+	// pageSnapshotReuse is how long a page snapshot's board is shared with
+	// later consistent requests, so a burst of them copies the board once
+	pageSnapshotReuse = time.Second
+)
+
+// pageCursor marks the last row a client has seen. Version is the version of
+// the board the page came from. Snapshot is zero for cursors that resume on
+// the live board.
+type pageCursor struct {
+	Version  uint64
+	Snapshot uint64
+	Rating   int
+	Username string
+}
+
+func (c pageCursor) encode() string {
+	raw := fmt.Sprintf("%d|%d|%d|%s", c.Version, c.Snapshot, c.Rating, c.Username)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(raw), "|", 4)
+	if len(parts) != 4 {
+		return pageCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	var c pageCursor
+	version, err1 := strconv.ParseUint(parts[0], 10, 64)
+	snapshot, err2 := strconv.ParseUint(parts[1], 10, 64)
+	rating, err3 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil || rating < 100 || rating > 5000 {
+		return pageCursor{}, fmt.Errorf("invalid cursor")
+	}
+	c.Version, c.Snapshot, c.Rating, c.Username = version, snapshot, rating, parts[3]
+	return c, nil
+}
+
+// boardSnapshot is an immutable copy of the board order
+type boardSnapshot struct {
+	version uint64
+	buckets [5001][]string // sorted usernames per rating
+	total   int
+	tiers   []Tier
+}
+
+// pageSnapshot is a board snapshot held for consistent paging
+type pageSnapshot struct {
+	*boardSnapshot
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+// pageSource is what a page walk reads from, either the live board or a snapshot
+type pageSource interface {
+	bucketSize(rating int) int
+	bucketAt(rating, i int) string
+	bucketAfter(rating int, username string) int
+	tierName(rating, above int) string
+}
+
+// livePageSource reads the live storage and is only valid under the lock
+type livePageSource struct{ ls *LeaderboardService }
+
+func (s livePageSource) bucketSize(rating int) int { return s.ls.storage.bucketSize(rating) }
+
+func (s livePageSource) bucketAt(rating, i int) string { return s.ls.storage.bucketAt(rating, i) }
+
+func (s livePageSource) bucketAfter(rating int, username string) int {
+	return s.ls.storage.bucketAfter(rating, username)
+}
+
+func (s livePageSource) tierName(rating, above int) string {
+	return s.ls.tiers[s.ls.tierIndexLocked(rating, above, s.ls.storage.count())].Name
+}
+
+func (p *boardSnapshot) bucketSize(rating int) int     { return len(p.buckets[rating]) }
+func (p *boardSnapshot) bucketAt(rating, i int) string { return p.buckets[rating][i] }
+
+func (p *boardSnapshot) bucketAfter(rating int, username string) int {
+	bucket := p.buckets[rating]
+	return sort.Search(len(bucket), func(i int) bool { return bucket[i] > username })
+}
+
+func (p *boardSnapshot) tierName(rating, above int) string {
+	return p.tiers[tierIndex(p.tiers, rating, above, p.total)].Name
+}
+
+// SetPageSnapshotTTL sets how long consistent paging snapshots stay readable
+func (ls *LeaderboardService) SetPageSnapshotTTL(ttl time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.pageSnapshotTTL = ttl
+}
+
+// GetUsersPage returns a page of the leaderboard and an opaque cursor for the
+// next one. Pages resume strictly after the last row seen, so concurrent
+// updates cannot repeat or skip users that did not move. With consistent set
+// the first page freezes the board and later pages read from that snapshot
+// until it expires, after which they continue on the live board. When
+// snapshot reads are enabled the published read snapshot stands in for the
+// live board in both cases, so the frozen board can lag writes as much as
+// other reads do. A consistent page may also share a board frozen up to
+// pageSnapshotReuse earlier. offset only
+// applies when cursor is empty. The returned cursor is empty on the last page.
+func (ls *LeaderboardService) GetUsersPage(cursor string, offset, limit int, consistent bool) ([]models.UserWithRank, string, error) {
+	var start *pageCursor
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = &c
+	}
+
+	if limit <= 0 {
+		return []models.UserWithRank{}, "", nil
+	}
+
+	if consistent && start == nil {
+		snap, id := ls.createPageSnapshot()
+		return walkPage(snap, nil, offset, limit, snap.version, id)
+	}
+
+	if start != nil && start.Snapshot != 0 {
+		if snap, ok := ls.pageSnapshot(start.Snapshot); ok {
+			if start.Version != snap.version {
+				return nil, "", fmt.Errorf("invalid cursor: version %d does not match its snapshot at %d", start.Version, snap.version)
+			}
+			return walkPage(snap, start, 0, limit, snap.version, start.Snapshot)
+		}
+	}
+
+	// Pages on the live board come from the published read snapshot when
+	// there is one, so they do not wait on writers either. A cursor from a
+	// page snapshot can be ahead of it, and then reads the board itself.
+	if snap := ls.readSnapshot.Load(); snap != nil && (start == nil || start.Version <= snap.version) {
+		return walkPage(snap.boardSnapshot, start, offset, limit, snap.version, 0)
+	}
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return walkPage(livePageSource{ls}, start, offset, limit, ls.version, 0)
+}
+
+// pageSnapshot returns the page snapshot id unless it has expired
+func (ls *LeaderboardService) pageSnapshot(id uint64) (*pageSnapshot, bool) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	snap, ok := ls.pageSnapshots[id]
+	if !ok || !time.Now().Before(snap.expiresAt) {
+		return nil, false
+	}
+	return snap, true
+}
+
+// createPageSnapshot freezes the board order for consistent paging. The
+// board is the published read snapshot when there is one, or that of a page
+// snapshot still at the current version or taken within pageSnapshotReuse,
+// and is only copied when neither is. The copy is made under the read lock,
+// so readers carry on meanwhile; the write lock is only taken to add the
+// snapshot to the table.
+func (ls *LeaderboardService) createPageSnapshot() (*pageSnapshot, uint64) {
+	var board *boardSnapshot
+	if rs := ls.readSnapshot.Load(); rs != nil {
+		board = rs.boardSnapshot
+	} else {
+		ls.mu.RLock()
+		if board = ls.recentPageBoardLocked(); board == nil {
+			board = ls.copyBoardLocked()
+		}
+		ls.mu.RUnlock()
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	now := time.Now()
+	var oldest uint64
+	for id, snap := range ls.pageSnapshots {
+		if !now.Before(snap.expiresAt) {
+			delete(ls.pageSnapshots, id)
+			continue
+		}
+		if oldest == 0 || id < oldest {
+			oldest = id
+		}
+	}
+	if len(ls.pageSnapshots) >= maxPageSnapshots {
+		delete(ls.pageSnapshots, oldest)
+	}
+
+	snap := &pageSnapshot{boardSnapshot: board, createdAt: now, expiresAt: now.Add(ls.pageSnapshotTTL)}
+	ls.nextSnapshotID++
+	ls.pageSnapshots[ls.nextSnapshotID] = snap
+	return snap, ls.nextSnapshotID
+}
+
+// recentPageBoardLocked returns the board of the newest page snapshot that
+// can be shared instead of copying the board again, or nil
+func (ls *LeaderboardService) recentPageBoardLocked() *boardSnapshot {
+	now := time.Now()
+	var newest uint64
+	for id, snap := range ls.pageSnapshots {
+		if id > newest && now.Before(snap.expiresAt) &&
+			(snap.version == ls.version || now.Sub(snap.createdAt) < pageSnapshotReuse) {
+			newest = id
+		}
+	}
+	if newest == 0 {
+		return nil
+	}
+	return ls.pageSnapshots[newest].boardSnapshot
+}
+
+// copyBoardLocked builds a board snapshot from the live buckets
+func (ls *LeaderboardService) copyBoardLocked() *boardSnapshot {
+	board := &boardSnapshot{
+		version: ls.version,
+		total:   ls.storage.count(),
+		tiers:   append([]Tier(nil), ls.tiers...),
+	}
+	for rating := 100; rating <= 5000; rating++ {
+		if size := ls.storage.bucketSize(rating); size > 0 {
+			board.buckets[rating] = ls.storage.appendBucket(make([]string, 0, size), rating)
+		}
+	}
+	return board
+}
+
+// walkPage collects up to limit rows after start (or after skipping offset rows
+// when start is nil) and builds the cursor for the following page
+func walkPage(src pageSource, start *pageCursor, offset, limit int, version, snapshotID uint64) ([]models.UserWithRank, string, error) {
+	// Boards only move forward, so a cursor from a later version was not
+	// issued by this one
+	if start != nil && start.Version > version {
+		return nil, "", fmt.Errorf("invalid cursor: version %d is ahead of the board at %d", start.Version, version)
+	}
+
+	result := make([]models.UserWithRank, 0, limit)
+	rank := 1
+	above := 0
+	skipped := 0
+	more := false
+
+	for rating := 5000; rating >= 100; rating-- {
+		size := src.bucketSize(rating)
+		if size == 0 {
+			continue
+		}
+
+		// Buckets above the cursor only contribute to rank
+		if start != nil && rating > start.Rating {
+			rank++
+			above += size
+			continue
+		}
+
+		if start == nil && skipped+size <= offset {
+			skipped += size
+			rank++
+			above += size
+			continue
+		}
+
+		if len(result) >= limit {
+			more = true
+			break
+		}
+
+		from := 0
+		if start != nil && rating == start.Rating {
+			from = src.bucketAfter(rating, start.Username)
+		} else if start == nil {
+			from = offset - skipped
+			skipped = offset
+		}
+
+		tier := src.tierName(rating, above)
+		for i := from; i < size; i++ {
+			if len(result) >= limit {
+				more = true
+				break
+			}
+			result = append(result, models.UserWithRank{
+				Rank:     rank,
+				Username: src.bucketAt(rating, i),
+				Rating:   rating,
+				Tier:     tier,
+			})
+		}
+		if more {
+			break
+		}
+
+		rank++
+		above += size
+	}
+
+	if !more || len(result) == 0 {
+		return result, "", nil
+	}
+
+	last := result[len(result)-1]
+	next := pageCursor{
+		Version:  version,
+		Snapshot: snapshotID,
+		Rating:   last.Rating,
+		Username: last.Username,
+	}
+	return result, next.encode(), nil
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"fmt"
+	"leaderboard/models"
+	"testing"
+	"time"
+)
+
+func seedPagingUsers(ls *LeaderboardService, count int) {
+	for i := 0; i < count; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("p%03d", i), Rating: 1000 + (i%10)*100})
+	}
+}
+
+func TestGetUsersPageMatchesRange(t *testing.T) {
+	ls := NewLeaderboardService()
+	seedPagingUsers(ls, 50)
+
+	expected := ls.GetUsersInRange(0, 100)
+
+	var got []models.UserWithRank
+	cursor := ""
+	for pages := 0; ; pages++ {
+		page, next, err := ls.GetUsersPage(cursor, 0, 7, false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got = append(got, page...)
+		if next == "" {
+			break
+		}
+		if pages > 20 {
+			t.Fatal("Paging did not terminate")
+		}
+		cursor = next
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d users, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Row %d: expected %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+
+	// Offset on the first page
+	page, _, _ := ls.GetUsersPage("", 12, 3, false)
+	if page[0] != expected[12] {
+		t.Errorf("Offset page mismatch: expected %+v, got %+v", expected[12], page[0])
+	}
+
+	// Limit <= 0
+	if page, next, _ := ls.GetUsersPage("", 0, 0, false); len(page) != 0 || next != "" {
+		t.Error("Expected empty page for limit 0")
+	}
+}
+
+func TestGetUsersPageUnderUpdates(t *testing.T) {
+	ls := NewLeaderboardService()
+	seedPagingUsers(ls, 30)
+
+	first, cursor, _ := ls.GetUsersPage("", 0, 10, false)
+	seen := make(map[string]int)
+	for _, u := range first {
+		seen[u.Username]++
+	}
+
+	// A user from page one falls below the cursor, one from below rises above it
+	ls.UpdateRating(first[0].Username, 100)
+	ls.UpdateRating("p000", 5000)
+
+	rest := []models.UserWithRank{}
+	for cursor != "" {
+		page, next, err := ls.GetUsersPage(cursor, 0, 10, false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		rest = append(rest, page...)
+		cursor = next
+	}
+	for _, u := range rest {
+		seen[u.Username]++
+	}
+
+	// Users that did not move appear exactly once
+	for i := 1; i < 30; i++ {
+		name := fmt.Sprintf("p%03d", i)
+		if name == first[0].Username {
+			continue
+		}
+		if seen[name] != 1 {
+			t.Errorf("Expected %s once, seen %d times", name, seen[name])
+		}
+	}
+}
+
+func TestGetUsersPageConsistent(t *testing.T) {
+	ls := NewLeaderboardService()
+	seedPagingUsers(ls, 20)
+	expected := ls.GetUsersInRange(0, 100)
+
+	page, cursor, _ := ls.GetUsersPage("", 0, 5, true)
+	got := append([]models.UserWithRank{}, page...)
+
+	// Mutations after the snapshot are invisible to later pages
+	for i := 0; i < 20; i++ {
+		ls.UpdateRating(fmt.Sprintf("p%03d", i), 4000+i)
+	}
+	ls.AddUser(&models.User{Username: "late", Rating: 200})
+
+	for cursor != "" {
+		page, next, _ := ls.GetUsersPage(cursor, 0, 5, false)
+		got = append(got, page...)
+		cursor = next
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d users, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Row %d: expected %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestGetUsersPageSnapshotExpiry(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.SetPageSnapshotTTL(time.Millisecond)
+	seedPagingUsers(ls, 10)
+
+	_, cursor, _ := ls.GetUsersPage("", 0, 5, true)
+	ls.AddUser(&models.User{Username: "late", Rating: 200})
+	time.Sleep(5 * time.Millisecond)
+
+	// Falls back to the live board once the snapshot expires
+	page, _, err := ls.GetUsersPage(cursor, 0, 100, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if last := page[len(page)-1]; last.Username != "late" {
+		t.Errorf("Expected live board after expiry, last row %+v", last)
+	}
+
+	// Snapshot table stays bounded
+	for i := 0; i < maxPageSnapshots*2; i++ {
+		ls.GetUsersPage("", 0, 1, true)
+	}
+	if len(ls.pageSnapshots) > maxPageSnapshots {
+		t.Errorf("Expected at most %d snapshots, got %d", maxPageSnapshots, len(ls.pageSnapshots))
+	}
+}
+
+func TestGetUsersPageSharesBoards(t *testing.T) {
+	ls := NewLeaderboardService()
+	seedPagingUsers(ls, 10)
+
+	// A burst of consistent requests copies the board once
+	ls.GetUsersPage("", 0, 5, true)
+	ls.UpdateRating("p000", 4000)
+	ls.GetUsersPage("", 0, 5, true)
+	if len(ls.pageSnapshots) != 2 || ls.pageSnapshots[1].boardSnapshot != ls.pageSnapshots[2].boardSnapshot {
+		t.Errorf("Expected both snapshots to share a board, got %+v", ls.pageSnapshots)
+	}
+
+	// With snapshot reads the published snapshot is frozen as it is
+	ls.EnableSnapshotReads(time.Hour)
+	ls.GetUsersPage("", 0, 5, true)
+	if ls.pageSnapshots[3].boardSnapshot != ls.readSnapshot.Load().boardSnapshot {
+		t.Error("Expected the page snapshot to share the published read snapshot")
+	}
+}
+
+func TestGetUsersPageSnapshotExpiryWithSnapshotReads(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.SetPageSnapshotTTL(time.Millisecond)
+	seedPagingUsers(ls, 10)
+	ls.EnableSnapshotReads(time.Hour)
+
+	_, cursor, _ := ls.GetUsersPage("", 0, 5, true)
+	ls.AddUser(&models.User{Username: "late", Rating: 200})
+	time.Sleep(5 * time.Millisecond)
+
+	// The write is not published yet, so the page after expiry lacks it
+	page, _, err := ls.GetUsersPage(cursor, 0, 100, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(page) != 5 || page[len(page)-1].Username == "late" {
+		t.Errorf("Expected the published read snapshot after expiry, got %+v", page)
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	c := pageCursor{Version: 7, Snapshot: 2, Rating: 1500, Username: "a|b"}
+	decoded, err := decodeCursor(c.encode())
+	if err != nil || decoded != c {
+		t.Errorf("Round trip failed: %+v %v", decoded, err)
+	}
+
+	for _, bad := range []string{"!!!", "bm9wZQ", pageCursor{Rating: 9999}.encode()} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("Expected error decoding %q", bad)
+		}
+	}
+
+	ls := NewLeaderboardService()
+	if _, _, err := ls.GetUsersPage("garbage", 0, 10, false); err == nil {
+		t.Error("Expected error for invalid cursor")
+	}
+}
+
+func TestCursorVersion(t *testing.T) {
+	ls := NewLeaderboardService()
+	for i := 0; i < 5; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("user_%d", i), Rating: 1000 + i})
+	}
+
+	// Live cursors outlive writes, but not a board that never got that far
+	_, next, _ := ls.GetUsersPage("", 0, 2, false)
+	ls.UpdateRating("user_0", 4000)
+	if _, _, err := ls.GetUsersPage(next, 0, 2, false); err != nil {
+		t.Errorf("Expected an older cursor to resume, got %v", err)
+	}
+	ahead := pageCursor{Version: ls.Version() + 1, Rating: 1003, Username: "user_3"}
+	if _, _, err := ls.GetUsersPage(ahead.encode(), 0, 2, false); err == nil {
+		t.Error("Expected a cursor ahead of the board to be refused")
+	}
+
+	// Snapshot cursors must name the version of their snapshot
+	_, next, _ = ls.GetUsersPage("", 0, 2, true)
+	c, _ := decodeCursor(next)
+	if _, _, err := ls.GetUsersPage(next, 0, 2, false); err != nil {
+		t.Errorf("Expected the snapshot cursor to resume, got %v", err)
+	}
+	c.Version--
+	if _, _, err := ls.GetUsersPage(c.encode(), 0, 2, false); err == nil {
+		t.Error("Expected a cursor not matching its snapshot to be refused")
+	}
+}
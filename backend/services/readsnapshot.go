@@ -0,0 +1,206 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"leaderboard/models"
+)
+
+// ratingShards splits the ratings of a read snapshot so a publish copies
+// only the shards holding users written since the last one
+const ratingShards = 64
+
+// readSnapshot is an immutable view of the board that GetUserRank and
+// GetUsersInRange read from without taking the service lock
+type readSnapshot struct {
+	*boardSnapshot
+	ratings     [ratingShards]map[string]int
+	rankAt      [5001]int // dense rank of each rating
+	aboveAt     [5001]int // users rated strictly above each rating
+	publishedAt time.Time
+}
+
+func ratingShard(username string) int {
+	return int(fnv1aString(username) % ratingShards)
+}
+
+// EnableSnapshotReads switches GetUserRank and GetUsersInRange to serve from
+// a published snapshot so they never wait on writers. Writes become visible
+// to those reads within roughly maxStaleness. Buckets and users untouched
+// since the previous snapshot are shared rather than copied.
+func (ls *LeaderboardService) EnableSnapshotReads(maxStaleness time.Duration) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	ls.snapMu.Lock()
+	defer ls.snapMu.Unlock()
+	ls.snapStaleness = maxStaleness
+	ls.dirtyRatings = make(map[int]struct{})
+	ls.dirtyUsers = make(map[string]struct{})
+	ls.snapshotReads.Store(true)
+	ls.readSnapshot.Store(ls.buildReadSnapshotLocked(nil, nil, nil))
+}
+
+// DisableSnapshotReads returns reads to the locked path
+func (ls *LeaderboardService) DisableSnapshotReads() {
+	ls.snapMu.Lock()
+	defer ls.snapMu.Unlock()
+
+	ls.snapshotReads.Store(false)
+	ls.readSnapshot.Store(nil)
+}
+
+// ReadStaleness reports how old the published read snapshot is, or zero when
+// snapshot reads are disabled
+func (ls *LeaderboardService) ReadStaleness() time.Duration {
+	snap := ls.readSnapshot.Load()
+	if snap == nil {
+		return 0
+	}
+	return time.Since(snap.publishedAt)
+}
+
+// markDirtyLocked notes which users and buckets a write touched and
+// schedules the next publish. Must be called with the write lock held.
+func (ls *LeaderboardService) markDirtyLocked(usernames []string, ratings ...int) {
+	if !ls.snapshotReads.Load() {
+		return
+	}
+
+	ls.snapMu.Lock()
+	defer ls.snapMu.Unlock()
+
+	for _, username := range usernames {
+		ls.dirtyUsers[username] = struct{}{}
+	}
+	for _, r := range ratings {
+		ls.dirtyRatings[r] = struct{}{}
+	}
+	if !ls.publishPending {
+		ls.publishPending = true
+		time.AfterFunc(ls.snapStaleness, ls.publishReadSnapshot)
+	}
+}
+
+// publishReadSnapshot rebuilds the snapshot from the live board. Holding the
+// read lock keeps writers out while the dirty sets are consumed, and holding
+// snapMu until it is stored keeps it from landing after DisableSnapshotReads.
+func (ls *LeaderboardService) publishReadSnapshot() {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	ls.snapMu.Lock()
+	defer ls.snapMu.Unlock()
+
+	dirtyRatings, dirtyUsers := ls.dirtyRatings, ls.dirtyUsers
+	ls.dirtyRatings = make(map[int]struct{})
+	ls.dirtyUsers = make(map[string]struct{})
+	ls.publishPending = false
+
+	if !ls.snapshotReads.Load() {
+		return
+	}
+	ls.readSnapshot.Store(ls.buildReadSnapshotLocked(ls.readSnapshot.Load(), dirtyRatings, dirtyUsers))
+}
+
+// buildReadSnapshotLocked copies the dirty buckets and rating shards and
+// reuses the rest from prev
+func (ls *LeaderboardService) buildReadSnapshotLocked(prev *readSnapshot, dirtyRatings map[int]struct{}, dirtyUsers map[string]struct{}) *readSnapshot {
+	board := &boardSnapshot{
+		version: ls.version,
+		total:   ls.storage.count(),
+		tiers:   append([]Tier(nil), ls.tiers...),
+	}
+
+	for rating := 100; rating <= 5000; rating++ {
+		if _, changed := dirtyRatings[rating]; prev != nil && !changed {
+			board.buckets[rating] = prev.buckets[rating]
+			continue
+		}
+		// Live buckets are edited in place, so the snapshot needs its own copy
+		if size := ls.storage.bucketSize(rating); size > 0 {
+			board.buckets[rating] = ls.storage.appendBucket(make([]string, 0, size), rating)
+		}
+	}
+
+	snap := &readSnapshot{
+		boardSnapshot: board,
+		publishedAt:   time.Now(),
+	}
+	if prev == nil {
+		for i := range snap.ratings {
+			snap.ratings[i] = make(map[string]int, ls.storage.count()/ratingShards)
+		}
+		ls.storage.forEach(func(username string, rating int) {
+			snap.ratings[ratingShard(username)][username] = rating
+		})
+	} else {
+		snap.ratings = prev.ratings
+		var copied [ratingShards]bool
+		for username := range dirtyUsers {
+			shard := ratingShard(username)
+			if !copied[shard] {
+				shared := prev.ratings[shard]
+				snap.ratings[shard] = make(map[string]int, len(shared))
+				for k, v := range shared {
+					snap.ratings[shard][k] = v
+				}
+				copied[shard] = true
+			}
+			if user, exists := ls.storage.get(username); exists {
+				snap.ratings[shard][username] = user.Rating
+			} else {
+				delete(snap.ratings[shard], username)
+			}
+		}
+	}
+
+	rank, above := 1, 0
+	for rating := 5000; rating >= 100; rating-- {
+		snap.rankAt[rating] = rank
+		snap.aboveAt[rating] = above
+		if size := len(board.buckets[rating]); size > 0 {
+			rank++
+			above += size
+		}
+	}
+
+	return snap
+}
+
+// rating looks up a user's rating in the snapshot
+func (snap *readSnapshot) rating(username string) (int, bool) {
+	rating, exists := snap.ratings[ratingShard(username)][username]
+	return rating, exists
+}
+
+func (snap *readSnapshot) userRank(username string) (*models.UserWithRank, error) {
+	rating, exists := snap.rating(username)
+	if !exists {
+		return nil, &NotFoundError{Username: username}
+	}
+
+	return &models.UserWithRank{
+		Rank:     snap.rankAt[rating],
+		Username: username,
+		Rating:   rating,
+		Tier:     snap.tierName(rating, snap.aboveAt[rating]),
+	}, nil
+}
+
+func (snap *readSnapshot) position(username string) (int, error) {
+	rating, exists := snap.rating(username)
+	if !exists {
+		return 0, &NotFoundError{Username: username}
+	}
+	return snap.aboveAt[rating] + sort.SearchStrings(snap.buckets[rating], username), nil
+}
+
+func (snap *readSnapshot) usersInRange(offset, limit int) []models.UserWithRank {
+	if limit <= 0 {
+		return []models.UserWithRank{}
+	}
+	users, _, _ := walkPage(snap.boardSnapshot, nil, offset, limit, snap.version, 0)
+	return users
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"fmt"
+	"leaderboard/models"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotReadsMatchLockedReads(t *testing.T) {
+	ls := NewLeaderboardService()
+	for i := 0; i < 200; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("s%03d", i), Rating: 100 + rand.Intn(300)})
+	}
+
+	locked := ls.GetUsersInRange(0, 500)
+	lockedRank, _ := ls.GetUserRank("s042")
+	checkPositions(t, ls, locked)
+
+	ls.EnableSnapshotReads(time.Millisecond)
+	defer ls.DisableSnapshotReads()
+
+	snapshot := ls.GetUsersInRange(0, 500)
+	if len(snapshot) != len(locked) {
+		t.Fatalf("Expected %d users, got %d", len(locked), len(snapshot))
+	}
+	for i := range locked {
+		if locked[i] != snapshot[i] {
+			t.Errorf("Row %d: locked %+v, snapshot %+v", i, locked[i], snapshot[i])
+		}
+	}
+
+	checkPositions(t, ls, locked)
+
+	snapRank, err := ls.GetUserRank("s042")
+	if err != nil || *snapRank != *lockedRank {
+		t.Errorf("Rank mismatch: locked %+v, snapshot %+v (%v)", lockedRank, snapRank, err)
+	}
+	if _, err := ls.GetUserRank("ghost"); err == nil {
+		t.Error("Expected error for non-existent user")
+	}
+	if len(ls.GetUsersInRange(0, 0)) != 0 {
+		t.Error("Expected 0 results for limit 0")
+	}
+}
+
+// checkPositions checks every user's position is their index in users
+func checkPositions(t *testing.T, ls *LeaderboardService, users []models.UserWithRank) {
+	t.Helper()
+	for i, u := range users {
+		if pos, err := ls.GetUserPosition(u.Username); err != nil || pos != i {
+			t.Errorf("Expected %s at position %d, got %d %v", u.Username, i, pos, err)
+		}
+	}
+	if _, err := ls.GetUserPosition("ghost"); err == nil {
+		t.Error("Expected error for non-existent user")
+	}
+}
+
+func TestSnapshotReadsStaleness(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "a", Rating: 1000})
+	ls.AddUser(&models.User{Username: "b", Rating: 2000})
+
+	ls.EnableSnapshotReads(20 * time.Millisecond)
+	defer ls.DisableSnapshotReads()
+
+	ls.UpdateRating("a", 3000)
+
+	// Not yet published
+	if u, _ := ls.GetUserRank("a"); u.Rating != 1000 {
+		t.Errorf("Expected stale rating 1000 before publish, got %d", u.Rating)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		u, _ := ls.GetUserRank("a")
+		if u.Rating == 3000 && u.Rank == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Snapshot was never published")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if top := ls.GetUsersInRange(0, 1); top[0].Username != "a" {
+		t.Errorf("Expected a on top after publish, got %+v", top[0])
+	}
+	if ls.ReadStaleness() <= 0 {
+		t.Error("Expected positive staleness while snapshot reads are enabled")
+	}
+
+	ls.DisableSnapshotReads()
+	ls.UpdateRating("b", 4000)
+	if u, _ := ls.GetUserRank("b"); u.Rating != 4000 {
+		t.Errorf("Expected live read after disable, got %d", u.Rating)
+	}
+	if ls.ReadStaleness() != 0 {
+		t.Error("Expected zero staleness when disabled")
+	}
+}
+
+func TestSnapshotReadsConcurrent(t *testing.T) {
+	ls := NewLeaderboardService()
+	for i := 0; i < 500; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("c%03d", i), Rating: 100 + rand.Intn(4901)})
+	}
+	ls.EnableSnapshotReads(time.Millisecond)
+	defer ls.DisableSnapshotReads()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					ls.UpdateRating(fmt.Sprintf("c%03d", rng.Intn(500)), 100+rng.Intn(4901))
+				}
+			}
+		}(int64(w))
+	}
+
+	for i := 0; i < 200; i++ {
+		users := ls.GetUsersInRange(0, 50)
+		for j := 1; j < len(users); j++ {
+			if users[j].Rating > users[j-1].Rating {
+				t.Fatalf("Snapshot out of order at %d: %+v", j, users)
+			}
+		}
+		ls.GetUserRank("c007")
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestSnapshotReadsCopyDirtyUsers(t *testing.T) {
+	ls := NewLeaderboardService()
+	for i := 0; i < 1000; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("d%03d", i), Rating: 100 + i})
+	}
+	ls.EnableSnapshotReads(time.Hour)
+	defer ls.DisableSnapshotReads()
+	before := ls.readSnapshot.Load()
+
+	ls.UpdateRating("d001", 5000)
+	ls.RenameUser("d002", "renamed")
+	ls.RemoveUser("d003")
+	ls.publishReadSnapshot()
+	after := ls.readSnapshot.Load()
+
+	// Only the shards of the written users are copied
+	dirty := map[int]bool{}
+	for _, name := range []string{"d001", "d002", "renamed", "d003"} {
+		dirty[ratingShard(name)] = true
+	}
+	for i := range after.ratings {
+		shared := reflect.ValueOf(after.ratings[i]).Pointer() == reflect.ValueOf(before.ratings[i]).Pointer()
+		if shared == dirty[i] {
+			t.Errorf("Shard %d: shared %v, dirty %v", i, shared, dirty[i])
+		}
+	}
+
+	if u, err := ls.GetUserRank("d001"); err != nil || u.Rating != 5000 {
+		t.Errorf("Expected d001 at 5000, got %+v %v", u, err)
+	}
+	if u, err := ls.GetUserRank("renamed"); err != nil || u.Rating != 102 {
+		t.Errorf("Expected renamed at 102, got %+v %v", u, err)
+	}
+	for _, gone := range []string{"d002", "d003"} {
+		if _, err := ls.GetUserRank(gone); err == nil {
+			t.Errorf("Expected %s gone from the snapshot", gone)
+		}
+	}
+	if u, err := ls.GetUserRank("d500"); err != nil || u.Rating != 600 {
+		t.Errorf("Expected d500 untouched, got %+v %v", u, err)
+	}
+}
+
+func TestSnapshotReadsDisableWhilePublishing(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "a", Rating: 1000})
+	for i := 0; i < 200; i++ {
+		ls.EnableSnapshotReads(time.Hour)
+		ls.UpdateRating("a", 1000+i%2)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); ls.publishReadSnapshot() }()
+		go func() { defer wg.Done(); ls.DisableSnapshotReads() }()
+		wg.Wait()
+
+		// A publish racing a disable never leaves a snapshot behind
+		if ls.readSnapshot.Load() != nil {
+			t.Fatal("Expected no snapshot after disable")
+		}
+	}
+}
+
+func TestSnapshotPagesSkipLock(t *testing.T) {
+	ls := NewLeaderboardService()
+	for i := 0; i < 10; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("p%d", i), Rating: 1000 + i})
+	}
+	ls.EnableSnapshotReads(time.Hour)
+	defer ls.DisableSnapshotReads()
+
+	// Live pages are read from the snapshot while a writer holds the lock
+	ls.mu.Lock()
+	done := make(chan []models.UserWithRank, 1)
+	go func() {
+		page, cursor, _ := ls.GetUsersPage("", 0, 5, false)
+		next, _, _ := ls.GetUsersPage(cursor, 0, 5, false)
+		done <- append(page, next...)
+	}()
+	select {
+	case users := <-done:
+		if len(users) != 10 || users[0].Username != "p9" || users[9].Username != "p0" {
+			t.Errorf("Unexpected pages: %+v", users)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected pages without waiting for the lock")
+	}
+	ls.mu.Unlock()
+}
+
+// benchmarkReadsDuringWrites measures page reads while a writer keeps
+// updating ratings, as during an /update-score burst
+func benchmarkReadsDuringWrites(b *testing.B, snapshots bool) {
+	ls := NewLeaderboardService()
+	for i := 0; i < 10000; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("user_%d", i), Rating: 100 + rand.Intn(4901)})
+	}
+	if snapshots {
+		ls.EnableSnapshotReads(50 * time.Millisecond)
+		defer ls.DisableSnapshotReads()
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rng := rand.New(rand.NewSource(1))
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				ls.UpdateRating(fmt.Sprintf("user_%d", rng.Intn(10000)), 100+rng.Intn(4901))
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ls.GetUsersInRange(0, 100)
+			ls.GetUserRank("user_42")
+		}
+	})
+	b.StopTimer()
+
+	close(stop)
+	<-done
+}
+
+func BenchmarkReadsDuringWritesLocked(b *testing.B)   { benchmarkReadsDuringWrites(b, false) }
+func BenchmarkReadsDuringWritesSnapshot(b *testing.B) { benchmarkReadsDuringWrites(b, true) }
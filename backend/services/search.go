@@ -0,0 +1,412 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"leaderboard/models"
+)
+
+// trieNode holds the children in rune order so walks come out alphabetical
+type trieNode struct {
+	keys     []rune
+	children []*trieNode
+	names    map[string]struct{} // usernames whose lowercase form ends here
+}
+
+// usernameTrie indexes lowercase usernames for prefix and fuzzy lookups
+type usernameTrie struct {
+	root *trieNode
+}
+
+func newUsernameTrie() *usernameTrie {
+	return &usernameTrie{root: &trieNode{}}
+}
+
+func (n *trieNode) child(r rune) *trieNode {
+	i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] >= r })
+	if i < len(n.keys) && n.keys[i] == r {
+		return n.children[i]
+	}
+	return nil
+}
+
+func (n *trieNode) addChild(r rune) *trieNode {
+	i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] >= r })
+	if i < len(n.keys) && n.keys[i] == r {
+		return n.children[i]
+	}
+
+	node := &trieNode{}
+	n.keys = append(n.keys, 0)
+	n.children = append(n.children, nil)
+	copy(n.keys[i+1:], n.keys[i:])
+	copy(n.children[i+1:], n.children[i:])
+	n.keys[i] = r
+	n.children[i] = node
+	return node
+}
+
+func (n *trieNode) removeChild(r rune) {
+	i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] >= r })
+	if i < len(n.keys) && n.keys[i] == r {
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.children = append(n.children[:i], n.children[i+1:]...)
+	}
+}
+
+func (t *usernameTrie) insert(username string) {
+	node := t.root
+	for _, r := range strings.ToLower(username) {
+		node = node.addChild(r)
+	}
+	if node.names == nil {
+		node.names = make(map[string]struct{})
+	}
+	node.names[username] = struct{}{}
+}
+
+func (t *usernameTrie) remove(username string) {
+	key := []rune(strings.ToLower(username))
+	path := make([]*trieNode, 0, len(key)+1)
+
+	node := t.root
+	path = append(path, node)
+	for _, r := range key {
+		if node = node.child(r); node == nil {
+			return
+		}
+		path = append(path, node)
+	}
+	if _, ok := node.names[username]; !ok {
+		return
+	}
+	delete(node.names, username)
+
+	// Prune nodes that no longer lead anywhere
+	for i := len(key); i > 0; i-- {
+		n := path[i]
+		if len(n.names) > 0 || len(n.keys) > 0 {
+			break
+		}
+		path[i-1].removeChild(key[i-1])
+	}
+}
+
+// prefix returns up to limit usernames starting with q, case-insensitively
+func (t *usernameTrie) prefix(q string, limit int) []string {
+	node := t.root
+	for _, r := range strings.ToLower(q) {
+		if node = node.child(r); node == nil {
+			return nil
+		}
+	}
+
+	result := make([]string, 0, limit)
+	collectNames(node, limit, nil, &result)
+	return result
+}
+
+// fuzzy returns up to limit usernames that have a prefix within maxDist edits
+// of q, skipping any already in seen. Walks are done one distance at a time
+// so closer matches always come first.
+func (t *usernameTrie) fuzzy(q string, maxDist, limit int, seen map[string]struct{}) []string {
+	query := []rune(strings.ToLower(q))
+	result := make([]string, 0, limit)
+	if seen == nil {
+		seen = make(map[string]struct{})
+	}
+
+	// Row zero of the edit distance table, the distance from "" to each query prefix
+	row := make([]int, len(query)+1)
+	for i := range row {
+		row[i] = i
+	}
+
+	for dist := 1; dist <= maxDist && len(result) < limit; dist++ {
+		t.fuzzyWalk(t.root, query, row, row[len(query)], dist, limit, seen, &result)
+	}
+	return result
+}
+
+// fuzzyWalk extends the Levenshtein row one trie level at a time. best is the
+// smallest distance of any prefix on the current path; once it is within dist
+// every name below matches.
+func (t *usernameTrie) fuzzyWalk(node *trieNode, query []rune, row []int, best, dist, limit int, seen map[string]struct{}, result *[]string) {
+	if len(*result) >= limit {
+		return
+	}
+
+	if best <= dist {
+		collectNames(node, limit, seen, result)
+		return
+	}
+
+	// No extension of this path can get back under dist
+	minRow := row[0]
+	for _, v := range row[1:] {
+		if v < minRow {
+			minRow = v
+		}
+	}
+	if minRow > dist {
+		return
+	}
+
+	for i, r := range node.keys {
+		next := make([]int, len(row))
+		next[0] = row[0] + 1
+		for j := 1; j < len(row); j++ {
+			cost := 1
+			if query[j-1] == r {
+				cost = 0
+			}
+			next[j] = min3(next[j-1]+1, row[j]+1, row[j-1]+cost)
+		}
+
+		childBest := best
+		if next[len(query)] < childBest {
+			childBest = next[len(query)]
+		}
+		t.fuzzyWalk(node.children[i], query, next, childBest, dist, limit, seen, result)
+	}
+}
+
+// collectNames appends names under node in alphabetical order
+func collectNames(node *trieNode, limit int, seen map[string]struct{}, result *[]string) {
+	if len(*result) >= limit {
+		return
+	}
+
+	if len(node.names) > 0 {
+		names := make([]string, 0, len(node.names))
+		for name := range node.names {
+			if _, skip := seen[name]; !skip {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if len(*result) >= limit {
+				return
+			}
+			*result = append(*result, name)
+			if seen != nil {
+				seen[name] = struct{}{}
+			}
+		}
+	}
+
+	for _, child := range node.children {
+		collectNames(child, limit, seen, result)
+	}
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// fuzzyDistance is the number of typos tolerated for a query of this length
+func fuzzyDistance(q string) int {
+	switch n := len([]rune(q)); {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// SearchUsers finds users by case-insensitive username prefix. With fuzzy set,
+// remaining slots are filled with usernames within a few typos of the query.
+// Services without an index, like the compact layout, scan every username.
+func (ls *LeaderboardService) SearchUsers(q string, limit int, fuzzy bool) []models.UserWithRank {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if q == "" || limit <= 0 {
+		return []models.UserWithRank{}
+	}
+
+	var names []string
+	if ls.index == nil {
+		names = ls.scanSearchLocked(q, limit, fuzzy)
+	} else {
+		names = ls.index.prefix(q, limit)
+		if fuzzy && len(names) < limit {
+			seen := make(map[string]struct{}, len(names))
+			for _, name := range names {
+				seen[name] = struct{}{}
+			}
+			names = append(names, ls.index.fuzzy(q, fuzzyDistance(q), limit-len(names), seen)...)
+		}
+	}
+
+	result := make([]models.UserWithRank, 0, len(names))
+	for _, name := range names {
+		user, _ := ls.storage.get(name)
+		result = append(result, *ls.userWithRankLocked(user.Username, user.Rating))
+	}
+	return result
+}
+
+// scanSearchLocked is the search used without an index. It checks every
+// username and orders hits the way the trie does: by distance, then name.
+func (ls *LeaderboardService) scanSearchLocked(q string, limit int, fuzzy bool) []string {
+	type hit struct {
+		name, key string
+		dist      int
+	}
+
+	query := []rune(strings.ToLower(q))
+	maxDist := 0
+	if fuzzy {
+		maxDist = fuzzyDistance(q)
+	}
+
+	var hits []hit
+	ls.storage.forEach(func(username string, rating int) {
+		key := strings.ToLower(username)
+		if dist := prefixDistance([]rune(key), query, maxDist); dist <= maxDist {
+			hits = append(hits, hit{name: username, key: key, dist: dist})
+		}
+	})
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].dist != hits[j].dist {
+			return hits[i].dist < hits[j].dist
+		}
+		if hits[i].key != hits[j].key {
+			return hits[i].key < hits[j].key
+		}
+		return hits[i].name < hits[j].name
+	})
+
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	names := make([]string, len(hits))
+	for i, h := range hits {
+		names[i] = h.name
+	}
+	return names
+}
+
+// prefixDistance is the smallest edit distance between query and any prefix
+// of key, or maxDist+1 once it is known to exceed maxDist
+func prefixDistance(key, query []rune, maxDist int) int {
+	row := make([]int, len(query)+1)
+	for i := range row {
+		row[i] = i
+	}
+	best := row[len(query)]
+
+	for _, r := range key {
+		if best == 0 {
+			break
+		}
+		prevDiag := row[0]
+		row[0]++
+		minRow := row[0]
+		for j := 1; j < len(row); j++ {
+			cost := 1
+			if query[j-1] == r {
+				cost = 0
+			}
+			prev := row[j]
+			row[j] = min3(row[j-1]+1, row[j]+1, prevDiag+cost)
+			prevDiag = prev
+			if row[j] < minRow {
+				minRow = row[j]
+			}
+		}
+		if row[len(query)] < best {
+			best = row[len(query)]
+		}
+		if minRow > maxDist {
+			break
+		}
+	}
+
+	if best > maxDist {
+		return maxDist + 1
+	}
+	return best
+}
+
+// RenameUser changes a user's username, keeping their rating
+func (ls *LeaderboardService) RenameUser(oldUsername, newUsername string) error {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	return ls.renameUserLocked(oldUsername, newUsername, true)
+}
+
+func (ls *LeaderboardService) renameUserLocked(oldUsername, newUsername string, logged bool) error {
+	user, exists := ls.storage.get(oldUsername)
+	if !exists {
+		return &NotFoundError{Username: oldUsername}
+	}
+	if newUsername == "" {
+		return fmt.Errorf("username cannot be empty")
+	}
+	if _, taken := ls.storage.get(newUsername); taken {
+		return &DuplicateError{Username: newUsername}
+	}
+	if err := ls.storage.check(&models.User{ID: user.ID, Username: newUsername}); err != nil {
+		return err
+	}
+
+	if logged {
+		m := models.Mutation{Op: models.MutationRename, Username: oldUsername, NewUsername: newUsername}
+		if err := ls.logLocked(m); err != nil {
+			return err
+		}
+	}
+
+	ls.storage.rename(oldUsername, newUsername)
+	if ls.index != nil {
+		ls.index.remove(oldUsername)
+		ls.index.insert(newUsername)
+	}
+	ls.version++
+	ls.markDirtyLocked([]string{oldUsername, newUsername}, user.Rating)
+
+	return nil
+}
+
+// RemoveUser deletes a user from the leaderboard
+func (ls *LeaderboardService) RemoveUser(username string) error {
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	return ls.removeUserLocked(username, true)
+}
+
+func (ls *LeaderboardService) removeUserLocked(username string, logged bool) error {
+	user, exists := ls.storage.get(username)
+	if !exists {
+		return &NotFoundError{Username: username}
+	}
+
+	if logged {
+		if err := ls.logLocked(models.Mutation{Op: models.MutationRemove, Username: username}); err != nil {
+			return err
+		}
+	}
+
+	ls.storage.remove(username)
+	if ls.index != nil {
+		ls.index.remove(username)
+	}
+	ls.version++
+	ls.markDirtyLocked([]string{username}, user.Rating)
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"leaderboard/models"
+	"strings"
+	"testing"
+)
+
+func usernamesOf(users []models.UserWithRank) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Username
+	}
+	return names
+}
+
+// searchLayouts are the services searched through the trie and, for the
+// compact layout which keeps no index, by scanning every username
+var searchLayouts = map[string]func() *LeaderboardService{
+	"map":     NewLeaderboardService,
+	"compact": NewCompactLeaderboardService,
+}
+
+func TestSearchUsersPrefix(t *testing.T) {
+	for name, newService := range searchLayouts {
+		t.Run(name, func(t *testing.T) { testSearchUsersPrefix(t, newService()) })
+	}
+}
+
+func testSearchUsersPrefix(t *testing.T, ls *LeaderboardService) {
+	ls.AddUser(&models.User{Username: "Ankit", Rating: 3000})
+	ls.AddUser(&models.User{Username: "ankur", Rating: 2000})
+	ls.AddUser(&models.User{Username: "ank", Rating: 1000})
+	ls.AddUser(&models.User{Username: "bob", Rating: 4000})
+
+	users := ls.SearchUsers("ANK", 10, false)
+	names := usernamesOf(users)
+	if len(names) != 3 || names[0] != "ank" || names[1] != "Ankit" || names[2] != "ankur" {
+		t.Errorf("Unexpected prefix results: %v", names)
+	}
+	if users[1].Rank != 2 || users[1].Rating != 3000 {
+		t.Errorf("Expected Ankit at rank 2 with rating 3000, got %+v", users[1])
+	}
+
+	if limited := ls.SearchUsers("ank", 2, false); len(limited) != 2 {
+		t.Errorf("Expected 2 results with limit, got %d", len(limited))
+	}
+	if none := ls.SearchUsers("zzz", 10, false); len(none) != 0 {
+		t.Errorf("Expected no results, got %v", usernamesOf(none))
+	}
+	if empty := ls.SearchUsers("", 10, false); len(empty) != 0 {
+		t.Error("Expected no results for empty query")
+	}
+}
+
+func TestSearchUsersFuzzy(t *testing.T) {
+	for name, newService := range searchLayouts {
+		t.Run(name, func(t *testing.T) { testSearchUsersFuzzy(t, newService()) })
+	}
+}
+
+func testSearchUsersFuzzy(t *testing.T, ls *LeaderboardService) {
+	ls.AddUser(&models.User{Username: "ankit", Rating: 3000})
+	ls.AddUser(&models.User{Username: "anikt_fan", Rating: 2000})
+	ls.AddUser(&models.User{Username: "mankind", Rating: 1000})
+	ls.AddUser(&models.User{Username: "zebra", Rating: 1000})
+
+	// Exact prefix only
+	if users := ls.SearchUsers("ankt", 10, false); len(users) != 0 {
+		t.Errorf("Expected no exact matches, got %v", usernamesOf(users))
+	}
+
+	// One typo away
+	names := usernamesOf(ls.SearchUsers("ankt", 10, true))
+	found := make(map[string]bool)
+	for _, n := range names {
+		found[n] = true
+	}
+	if !found["ankit"] {
+		t.Errorf("Expected fuzzy match ankit, got %v", names)
+	}
+	if found["zebra"] {
+		t.Errorf("Did not expect zebra in %v", names)
+	}
+
+	// Prefix matches come before fuzzy ones
+	names = usernamesOf(ls.SearchUsers("ankit", 10, true))
+	if len(names) == 0 || names[0] != "ankit" {
+		t.Errorf("Expected exact prefix first, got %v", names)
+	}
+}
+
+func TestSearchIndexSync(t *testing.T) {
+	for name, newService := range searchLayouts {
+		t.Run(name, func(t *testing.T) { testSearchIndexSync(t, newService()) })
+	}
+}
+
+func testSearchIndexSync(t *testing.T, ls *LeaderboardService) {
+	ls.AddUser(&models.User{Username: "alpha", Rating: 1000})
+	ls.AddUser(&models.User{Username: "alpine", Rating: 2000})
+
+	// Rename
+	if err := ls.RenameUser("alpha", "omega"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if names := usernamesOf(ls.SearchUsers("alp", 10, false)); len(names) != 1 || names[0] != "alpine" {
+		t.Errorf("Expected only alpine after rename, got %v", names)
+	}
+	if names := usernamesOf(ls.SearchUsers("ome", 10, false)); len(names) != 1 {
+		t.Errorf("Expected omega after rename, got %v", names)
+	}
+	if u, err := ls.GetUserRank("omega"); err != nil || u.Rating != 1000 {
+		t.Errorf("Expected renamed user to keep rating, got %+v %v", u, err)
+	}
+	if err := ls.RenameUser("ghost", "x"); err == nil {
+		t.Error("Expected error renaming missing user")
+	}
+	if err := ls.RenameUser("omega", "alpine"); err == nil {
+		t.Error("Expected error renaming onto existing user")
+	}
+	if err := ls.RenameUser("omega", ""); err == nil {
+		t.Error("Expected error renaming to empty username")
+	}
+
+	// Remove
+	if err := ls.RemoveUser("alpine"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if names := usernamesOf(ls.SearchUsers("al", 10, false)); len(names) != 0 {
+		t.Errorf("Expected no results after remove, got %v", names)
+	}
+	if err := ls.RemoveUser("alpine"); err == nil {
+		t.Error("Expected error removing missing user")
+	}
+	if ls.GetUserCount() != 1 || len(ls.GetAllUsernames()) != 1 {
+		t.Errorf("Expected 1 user left, got %d", ls.GetUserCount())
+	}
+	if users := ls.GetUsersInRange(0, 10); len(users) != 1 || users[0].Username != "omega" {
+		t.Errorf("Unexpected board after remove: %+v", users)
+	}
+}
+
+func TestCompactSearchScans(t *testing.T) {
+	ls := NewCompactLeaderboardService()
+	if ls.index != nil {
+		t.Fatal("Expected the compact layout to keep no search index")
+	}
+
+	// Prefix hits come first, then typos by distance, each by name
+	for _, u := range []string{"ankit", "Ankur", "anikt", "ankt_fan", "zebra"} {
+		ls.AddUser(&models.User{Username: u, Rating: 1000})
+	}
+	names := usernamesOf(ls.SearchUsers("ankt", 10, true))
+	if want := "ankt_fan,anikt,ankit,Ankur"; strings.Join(names, ",") != want {
+		t.Errorf("Expected %s, got %v", want, names)
+	}
+	if names := usernamesOf(ls.SearchUsers("ankt", 2, true)); len(names) != 2 || names[0] != "ankt_fan" {
+		t.Errorf("Expected the limit to keep the closest hits, got %v", names)
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"sort"
+
+	"leaderboard/models"
+)
+
+// userStorage holds the users and their rating buckets. Buckets are kept
+// sorted by username. The service lock guards every call.
+type userStorage interface {
+	check(user *models.User) error
+	get(username string) (models.User, bool)
+	add(user *models.User)
+	setRating(username string, oldRating, newRating int)
+	rename(oldUsername, newUsername string)
+	remove(username string)
+	count() int
+
+	bucketSize(rating int) int
+	bucketAt(rating, i int) string
+	// bucketAfter is the index of the first username sorting after username
+	bucketAfter(rating int, username string) int
+	appendBucket(dst []string, rating int) []string
+
+	usernames() []string
+	forEach(fn func(username string, rating int))
+	// each visits full user records and stops at the first error
+	each(fn func(models.User) error) error
+}
+
+// mapStorage is the default layout: a map of user pointers, sorted username
+// slices per rating and a list of usernames for random picks
+type mapStorage struct {
+	users         map[string]*models.User
+	ratingBuckets [5001][]string
+	allUsernames  []string
+}
+
+func newMapStorage() *mapStorage {
+	return &mapStorage{
+		users:        make(map[string]*models.User),
+		allUsernames: make([]string, 0),
+	}
+}
+
+func (s *mapStorage) check(user *models.User) error { return nil }
+
+func (s *mapStorage) get(username string) (models.User, bool) {
+	user, exists := s.users[username]
+	if !exists {
+		return models.User{}, false
+	}
+	return *user, true
+}
+
+func (s *mapStorage) add(user *models.User) {
+	s.users[user.Username] = user
+	s.bucketInsert(user.Rating, user.Username)
+	s.allUsernames = append(s.allUsernames, user.Username)
+}
+
+func (s *mapStorage) setRating(username string, oldRating, newRating int) {
+	s.bucketRemove(oldRating, username)
+	s.bucketInsert(newRating, username)
+	s.users[username].Rating = newRating
+}
+
+func (s *mapStorage) rename(oldUsername, newUsername string) {
+	user := s.users[oldUsername]
+	delete(s.users, oldUsername)
+	s.bucketRemove(user.Rating, oldUsername)
+
+	user.Username = newUsername
+	s.users[newUsername] = user
+	s.bucketInsert(user.Rating, newUsername)
+
+	for i, name := range s.allUsernames {
+		if name == oldUsername {
+			s.allUsernames[i] = newUsername
+			break
+		}
+	}
+}
+
+func (s *mapStorage) remove(username string) {
+	user := s.users[username]
+	delete(s.users, username)
+	s.bucketRemove(user.Rating, username)
+
+	for i, name := range s.allUsernames {
+		if name == username {
+			last := len(s.allUsernames) - 1
+			s.allUsernames[i] = s.allUsernames[last]
+			s.allUsernames = s.allUsernames[:last]
+			break
+		}
+	}
+}
+
+func (s *mapStorage) count() int { return len(s.users) }
+
+func (s *mapStorage) bucketSize(rating int) int { return len(s.ratingBuckets[rating]) }
+
+func (s *mapStorage) bucketAt(rating, i int) string { return s.ratingBuckets[rating][i] }
+
+func (s *mapStorage) bucketAfter(rating int, username string) int {
+	bucket := s.ratingBuckets[rating]
+	return sort.Search(len(bucket), func(i int) bool { return bucket[i] > username })
+}
+
+func (s *mapStorage) appendBucket(dst []string, rating int) []string {
+	return append(dst, s.ratingBuckets[rating]...)
+}
+
+func (s *mapStorage) usernames() []string {
+	usernames := make([]string, len(s.allUsernames))
+	copy(usernames, s.allUsernames)
+	return usernames
+}
+
+func (s *mapStorage) forEach(fn func(username string, rating int)) {
+	for username, user := range s.users {
+		fn(username, user.Rating)
+	}
+}
+
+func (s *mapStorage) each(fn func(models.User) error) error {
+	for _, user := range s.users {
+		if err := fn(*user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucketInsert adds a username to a rating bucket, keeping it sorted
+func (s *mapStorage) bucketInsert(rating int, username string) {
+	bucket := s.ratingBuckets[rating]
+	i := sort.SearchStrings(bucket, username)
+	bucket = append(bucket, "")
+	copy(bucket[i+1:], bucket[i:])
+	bucket[i] = username
+	s.ratingBuckets[rating] = bucket
+}
+
+// bucketRemove deletes a username from a rating bucket
+func (s *mapStorage) bucketRemove(rating int, username string) {
+	bucket := s.ratingBuckets[rating]
+	i := sort.SearchStrings(bucket, username)
+	if i < len(bucket) && bucket[i] == username {
+		copy(bucket[i:], bucket[i+1:])
+		bucket[len(bucket)-1] = ""
+		s.ratingBuckets[rating] = bucket[:len(bucket)-1]
+	}
+}
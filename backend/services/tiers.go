@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"leaderboard/models"
+)
+
+// Tier is one row of the tier threshold table. A user belongs to the highest
+// tier whose MinRating they meet. When TopPercent is set the user must also be
+// within that percentage of the board (by number of users rated above them).
+type Tier struct {
+	Name       string
+	MinRating  int
+	TopPercent float64
+}
+
+// DefaultTiers is the threshold table used by NewLeaderboardService
+var DefaultTiers = []Tier{
+	{Name: "Bronze", MinRating: 100},
+	{Name: "Silver", MinRating: 1000},
+	{Name: "Gold", MinRating: 2000},
+	{Name: "Platinum", MinRating: 3000},
+	{Name: "Diamond", MinRating: 3800},
+	{Name: "Master", MinRating: 4400},
+	{Name: "Grandmaster", MinRating: 4400, TopPercent: 1},
+}
+
+// ValidateTiers checks that a threshold table is usable: ordered by MinRating,
+// unique names, and a lowest tier that covers the minimum rating.
+func ValidateTiers(tiers []Tier) error {
+	if len(tiers) == 0 {
+		return fmt.Errorf("at least one tier is required")
+	}
+	if tiers[0].MinRating > 100 {
+		return fmt.Errorf("lowest tier %s must start at or below rating 100, got %d", tiers[0].Name, tiers[0].MinRating)
+	}
+
+	seen := make(map[string]struct{}, len(tiers))
+	for i, t := range tiers {
+		if t.Name == "" {
+			return fmt.Errorf("tier %d has no name", i)
+		}
+		key := strings.ToLower(t.Name)
+		if _, exists := seen[key]; exists {
+			return fmt.Errorf("duplicate tier name %s", t.Name)
+		}
+		seen[key] = struct{}{}
+
+		if t.TopPercent < 0 || t.TopPercent > 100 {
+			return fmt.Errorf("tier %s: top percent must be between 0 and 100, got %g", t.Name, t.TopPercent)
+		}
+		if i > 0 && t.MinRating < tiers[i-1].MinRating {
+			return fmt.Errorf("tier %s: thresholds must be in ascending order", t.Name)
+		}
+	}
+	return nil
+}
+
+// ParseTiers reads a table in the form "Bronze:100,Silver:1000,Grandmaster:4400:1"
+// where the optional third field is the top percentage.
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid tier %q, expected name:minRating[:topPercent]", part)
+		}
+
+		minRating, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid min rating for tier %s: %v", fields[0], err)
+		}
+
+		tier := Tier{Name: fields[0], MinRating: minRating}
+		if len(fields) == 3 {
+			if tier.TopPercent, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return nil, fmt.Errorf("invalid top percent for tier %s: %v", fields[0], err)
+			}
+		}
+		tiers = append(tiers, tier)
+	}
+
+	if err := ValidateTiers(tiers); err != nil {
+		return nil, err
+	}
+	return tiers, nil
+}
+
+// SetTiers replaces the tier threshold table
+func (ls *LeaderboardService) SetTiers(tiers []Tier) error {
+	if err := ValidateTiers(tiers); err != nil {
+		return err
+	}
+
+	ls.lockWriter()
+	defer ls.unlockWriter()
+	ls.tiers = append([]Tier(nil), tiers...)
+	ls.markDirtyLocked(nil)
+	return nil
+}
+
+// GetTiers returns a copy of the tier threshold table
+func (ls *LeaderboardService) GetTiers() []Tier {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return append([]Tier(nil), ls.tiers...)
+}
+
+// GetTierSummary returns the number of users in each tier, highest tier first
+func (ls *LeaderboardService) GetTierSummary() []models.TierCount {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	counts := make([]int, len(ls.tiers))
+	total := ls.storage.count()
+	above := 0
+	for rating := 5000; rating >= 100; rating-- {
+		size := ls.storage.bucketSize(rating)
+		if size == 0 {
+			continue
+		}
+		counts[ls.tierIndexLocked(rating, above, total)] += size
+		above += size
+	}
+
+	summary := make([]models.TierCount, 0, len(ls.tiers))
+	for i := len(ls.tiers) - 1; i >= 0; i-- {
+		t := ls.tiers[i]
+		summary = append(summary, models.TierCount{
+			Name:       t.Name,
+			MinRating:  t.MinRating,
+			TopPercent: t.TopPercent,
+			Count:      counts[i],
+		})
+	}
+	return summary
+}
+
+// GetUsersInTier pages through the users of a single tier in leaderboard order
+func (ls *LeaderboardService) GetUsersInTier(name string, offset, limit int) ([]models.UserWithRank, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	idx := ls.tierByNameLocked(name)
+	if idx < 0 {
+		return nil, fmt.Errorf("unknown tier: %s", name)
+	}
+
+	return ls.collectLocked(offset, limit, func(tier int) bool { return tier == idx }), nil
+}
+
+// tierIndexLocked picks the tier for a rating given how many users are rated
+// above it
+func (ls *LeaderboardService) tierIndexLocked(rating, above, total int) int {
+	return tierIndex(ls.tiers, rating, above, total)
+}
+
+// tierIndex is monotonic in rating, so every tier is a contiguous rating range
+func tierIndex(tiers []Tier, rating, above, total int) int {
+	for i := len(tiers) - 1; i > 0; i-- {
+		t := tiers[i]
+		if rating < t.MinRating {
+			continue
+		}
+		if t.TopPercent > 0 && float64(above) >= t.TopPercent/100*float64(total) {
+			continue
+		}
+		return i
+	}
+	return 0
+}
+
+// tierOfLocked picks the tier for a rating on the current board, only
+// counting the users above it when a percentile tier could apply
+func (ls *LeaderboardService) tierOfLocked(rating int) int {
+	above := 0
+	for _, t := range ls.tiers {
+		if t.TopPercent > 0 && rating >= t.MinRating {
+			for r := 5000; r > rating; r-- {
+				above += ls.storage.bucketSize(r)
+			}
+			break
+		}
+	}
+	return ls.tierIndexLocked(rating, above, ls.storage.count())
+}
+
+func (ls *LeaderboardService) tierByNameLocked(name string) int {
+	for i, t := range ls.tiers {
+		if strings.EqualFold(t.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
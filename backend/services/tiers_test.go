@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"leaderboard/models"
+	"testing"
+)
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("Bronze:100, Silver:1000,Elite:4000:5")
+	if err != nil {
+		t.Fatalf("Failed to parse tiers: %v", err)
+	}
+	if len(tiers) != 3 || tiers[2].Name != "Elite" || tiers[2].TopPercent != 5 {
+		t.Errorf("Unexpected tiers: %+v", tiers)
+	}
+
+	bad := []string{
+		"",
+		"Bronze",
+		"Bronze:abc",
+		"Bronze:100:x",
+		"Bronze:500",
+		"Bronze:100,Silver:50",
+		"Bronze:100,bronze:200",
+		"Bronze:100,Top:4000:150",
+	}
+	for _, s := range bad {
+		if _, err := ParseTiers(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
+
+func TestTierAssignment(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "bronze", Rating: 500})
+	ls.AddUser(&models.User{Username: "gold", Rating: 2500})
+	ls.AddUser(&models.User{Username: "master", Rating: 4500})
+	ls.AddUser(&models.User{Username: "top", Rating: 4900})
+
+	cases := map[string]string{
+		"bronze": "Bronze",
+		"gold":   "Gold",
+		"master": "Master",
+		"top":    "Grandmaster",
+	}
+	for username, want := range cases {
+		u, _ := ls.GetUserRank(username)
+		if u.Tier != want {
+			t.Errorf("Expected %s in %s, got %s", username, want, u.Tier)
+		}
+	}
+
+	users := ls.GetUsersInRange(0, 10)
+	if users[0].Tier != "Grandmaster" || users[3].Tier != "Bronze" {
+		t.Errorf("Range tiers mismatch: %+v", users)
+	}
+}
+
+func TestTierPercentile(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.SetTiers([]Tier{
+		{Name: "Low", MinRating: 100},
+		{Name: "Top", MinRating: 100, TopPercent: 10},
+	})
+	for i := 0; i < 20; i++ {
+		ls.AddUser(&models.User{Username: fmt.Sprintf("u%02d", i), Rating: 1000 + i})
+	}
+
+	// Only the two highest of 20 users make the top 10%
+	users := ls.GetUsersInRange(0, 3)
+	if users[0].Tier != "Top" || users[1].Tier != "Top" || users[2].Tier != "Low" {
+		t.Errorf("Percentile tiers mismatch: %+v", users)
+	}
+}
+
+func TestGetTierSummary(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "a", Rating: 150})
+	ls.AddUser(&models.User{Username: "b", Rating: 150})
+	ls.AddUser(&models.User{Username: "c", Rating: 2100})
+
+	summary := ls.GetTierSummary()
+	if len(summary) != len(DefaultTiers) {
+		t.Fatalf("Expected %d tiers, got %d", len(DefaultTiers), len(summary))
+	}
+	if summary[0].Name != "Grandmaster" {
+		t.Errorf("Expected highest tier first, got %s", summary[0].Name)
+	}
+
+	counts := make(map[string]int)
+	for _, tc := range summary {
+		counts[tc.Name] = tc.Count
+	}
+	if counts["Bronze"] != 2 || counts["Gold"] != 1 || counts["Silver"] != 0 {
+		t.Errorf("Unexpected counts: %v", counts)
+	}
+}
+
+func TestGetUsersInTier(t *testing.T) {
+	ls := NewLeaderboardService()
+	ls.AddUser(&models.User{Username: "g1", Rating: 2900})
+	ls.AddUser(&models.User{Username: "g2", Rating: 2100})
+	ls.AddUser(&models.User{Username: "g3", Rating: 2100})
+	ls.AddUser(&models.User{Username: "p1", Rating: 3100})
+	ls.AddUser(&models.User{Username: "b1", Rating: 200})
+
+	users, err := ls.GetUsersInTier("gold", 0, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(users) != 3 || users[0].Username != "g1" || users[0].Rank != 2 {
+		t.Errorf("Unexpected gold users: %+v", users)
+	}
+
+	page, _ := ls.GetUsersInTier("Gold", 1, 1)
+	if len(page) != 1 || page[0].Username != "g2" || page[0].Rank != 3 {
+		t.Errorf("Unexpected gold page: %+v", page)
+	}
+
+	if _, err := ls.GetUsersInTier("Wood", 0, 10); err == nil {
+		t.Error("Expected error for unknown tier")
+	}
+}
@@ -0,0 +1,134 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"leaderboard/models"
+)
+
+// format describes how one on-disk version is read. Older versions decode
+// into the record shape they had and are upgraded one step at a time to the
+// current models.User, so a new version only needs its reader and an upgrade
+// from the shape before it. Files are rewritten in the current format at the
+// next snapshot.
+type format struct {
+	headerSize  int
+	parseHeader func(version uint16, rest []byte) Header
+	readUser    func(r *checksumReader) (models.User, error)
+}
+
+var formats = map[uint16]format{
+	1: {headerSize: 24, parseHeader: parseHeaderV1, readUser: readUserV1},
+	2: {headerSize: 32, parseHeader: parseHeaderV2, readUser: readUserV2},
+}
+
+// maxFieldSize guards against reading a garbage length
+const maxFieldSize = 1 << 20
+
+// Format 1: seq and count in the header, then bare records of
+// uvarint-prefixed ID, uvarint-prefixed username and uvarint rating.
+
+// recordV1 is a user as format 1 stored it
+type recordV1 struct {
+	ID       string
+	Username string
+	Rating   uint64
+}
+
+func parseHeaderV1(version uint16, rest []byte) Header {
+	return Header{
+		Format: version,
+		Seq:    binary.LittleEndian.Uint64(rest[0:8]),
+		Count:  binary.LittleEndian.Uint64(rest[8:16]),
+	}
+}
+
+func readUserV1(r *checksumReader) (models.User, error) {
+	var rec recordV1
+	var err error
+	if rec.ID, err = readString(r); err != nil {
+		return models.User{}, err
+	}
+	if rec.Username, err = readString(r); err != nil {
+		return models.User{}, err
+	}
+	if rec.Rating, err = binary.ReadUvarint(r); err != nil {
+		return models.User{}, err
+	}
+	return upgradeV1(rec), nil
+}
+
+// upgradeV1 turns a format 1 record into the current user shape
+func upgradeV1(rec recordV1) models.User {
+	return models.User{ID: rec.ID, Username: rec.Username, Rating: int(rec.Rating)}
+}
+
+// Format 2 adds the creation time to the header and frames each record with
+// its length, so later fields can be appended without breaking readers.
+
+func parseHeaderV2(version uint16, rest []byte) Header {
+	h := parseHeaderV1(version, rest)
+	h.Created = time.Unix(0, int64(binary.LittleEndian.Uint64(rest[16:24]))).UTC()
+	return h
+}
+
+func readUserV2(r *checksumReader) (models.User, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return models.User{}, err
+	}
+	if n > 3*maxFieldSize {
+		return models.User{}, fmt.Errorf("record length %d too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return models.User{}, err
+	}
+
+	var u models.User
+	u.ID, body, err = cutString(body)
+	if err == nil {
+		u.Username, body, err = cutString(body)
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	rating, size := binary.Uvarint(body)
+	if size <= 0 {
+		return models.User{}, errShortRecord
+	}
+	u.Rating = int(rating)
+	// Anything after the rating is a field from a newer writer
+	return u, nil
+}
+
+var errShortRecord = errors.New("record shorter than its fields")
+
+// cutString takes a uvarint-prefixed string off the front of b
+func cutString(b []byte) (string, []byte, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(len(b)-size) {
+		return "", nil, errShortRecord
+	}
+	b = b[size:]
+	return string(b[:n]), b[n:], nil
+}
+
+func readString(r *checksumReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxFieldSize {
+		return "", fmt.Errorf("string length %d too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
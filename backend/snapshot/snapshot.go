@@ -0,0 +1,382 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"leaderboard/encryption"
+	"leaderboard/models"
+)
+
+// File layout of the current format 2, all integers little-endian:
+//
+//	magic "LBSN" | format uint16 | reserved uint16 | seq uint64 | count uint64 | created unix nanos int64
+//	count records of uvarint body length, then a body of uvarint-prefixed ID,
+//	uvarint-prefixed username and uvarint rating
+//	CRC-32 of everything above, uint32
+//
+// Readers skip body bytes they do not know, so fields can be appended to a
+// record. Older formats are described in format.go.
+const (
+	magic         = "LBSN"
+	FormatVersion = 2
+	prefixSize    = 8 // magic, format and reserved, common to every format
+	filePrefix    = "snapshot-"
+	fileSuffix    = ".snap"
+)
+
+var (
+	ErrBadMagic    = errors.New("snapshot: not a snapshot file")
+	ErrBadVersion  = errors.New("snapshot: unsupported format version")
+	ErrBadChecksum = errors.New("snapshot: checksum mismatch")
+	ErrNoSnapshot  = errors.New("snapshot: no valid snapshot found")
+)
+
+// FileName is the name of the snapshot taken at seq. Names sort by seq.
+func FileName(seq uint64) string {
+	return fmt.Sprintf("%s%020d%s", filePrefix, seq, fileSuffix)
+}
+
+// Write stores count users at seq to path. The file is written to a
+// temporary name, fsynced and renamed into place, so path only ever holds a
+// complete snapshot. With keys set the whole file is encrypted under the
+// current key.
+func Write(path string, keys *encryption.Keyring, seq uint64, count int, each func(func(models.User) error) error) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // no-op once renamed
+
+	var w io.Writer = f
+	var sealer io.WriteCloser
+	if keys != nil {
+		if sealer, err = encryption.NewWriter(f, keys); err != nil {
+			f.Close()
+			return err
+		}
+		w = sealer
+	}
+	if err := Encode(w, seq, count, each); err != nil {
+		f.Close()
+		return err
+	}
+	if sealer != nil {
+		if err := sealer.Close(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Users visits users in order, to Write or Encode a copied board
+func Users(users []models.User) func(func(models.User) error) error {
+	return func(fn func(models.User) error) error {
+		for _, u := range users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Encode writes a plaintext snapshot of count users at seq to w, as used to
+// ship a board over the network
+func Encode(w io.Writer, seq uint64, count int, each func(func(models.User) error) error) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriterSize(io.MultiWriter(w, crc), 64*1024)
+
+	header := make([]byte, formats[FormatVersion].headerSize)
+	copy(header, magic)
+	binary.LittleEndian.PutUint16(header[4:6], FormatVersion)
+	binary.LittleEndian.PutUint64(header[8:16], seq)
+	binary.LittleEndian.PutUint64(header[16:24], uint64(count))
+	binary.LittleEndian.PutUint64(header[24:32], uint64(time.Now().UnixNano()))
+	bw.Write(header)
+
+	written := 0
+	body := make([]byte, 0, 128)
+	buf := make([]byte, 0, 128)
+	err := each(func(u models.User) error {
+		body = body[:0]
+		body = binary.AppendUvarint(body, uint64(len(u.ID)))
+		body = append(body, u.ID...)
+		body = binary.AppendUvarint(body, uint64(len(u.Username)))
+		body = append(body, u.Username...)
+		body = binary.AppendUvarint(body, uint64(u.Rating))
+
+		buf = binary.AppendUvarint(buf[:0], uint64(len(body)))
+		buf = append(buf, body...)
+		written++
+		_, err := bw.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if written != count {
+		return fmt.Errorf("snapshot: expected %d users, got %d", count, written)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, crc.Sum32())
+	_, err = w.Write(trailer)
+	return err
+}
+
+// Read calls fn for every user in the snapshot at path and returns its seq.
+// The checksum is only known at the end, so use Verify before applying users
+// somewhere that cannot be rolled back. Unencrypted snapshots are read
+// whether or not keys is set.
+func Read(path string, keys *encryption.Keyring, fn func(models.User) error) (uint64, error) {
+	f, r, err := open(path, keys)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return decode(r, fn)
+}
+
+// Decode reads a snapshot written by Encode from r, calling fn for every user,
+// and returns its seq. As with Read, the checksum is only checked at the end.
+func Decode(r io.Reader, fn func(models.User) error) (uint64, error) {
+	return decode(bufio.NewReaderSize(r, 64*1024), fn)
+}
+
+// Verify checks that the snapshot at path is complete and uncorrupted
+func Verify(path string, keys *encryption.Keyring) (uint64, error) {
+	return Read(path, keys, func(models.User) error { return nil })
+}
+
+// open returns a reader of the plaintext snapshot at path
+func open(path string, keys *encryption.Keyring) (*os.File, *bufio.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	if prefix, _ := r.Peek(prefixSize); encryption.IsEncrypted(prefix) {
+		plain, err := encryption.NewReader(r, keys)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("snapshot %s: %w", filepath.Base(path), err)
+		}
+		r = bufio.NewReaderSize(plain, 64*1024)
+	}
+	return f, r, nil
+}
+
+// KeyID returns the id of the key the snapshot at path is encrypted under,
+// or "" if it is not encrypted. No key is needed to read it.
+func KeyID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if prefix, _ := r.Peek(prefixSize); !encryption.IsEncrypted(prefix) {
+		return "", nil
+	}
+	h, err := encryption.StreamHeader(r)
+	if err != nil {
+		return "", fmt.Errorf("snapshot %s: %w", filepath.Base(path), err)
+	}
+	return h.ID, nil
+}
+
+// Header describes a snapshot without reading its users
+type Header struct {
+	Format  uint16
+	Seq     uint64
+	Count   uint64
+	Created time.Time // zero for formats that did not record it
+}
+
+// ReadHeader reads the header of the snapshot at path. It does not check the
+// checksum; use Verify for that.
+func ReadHeader(path string, keys *encryption.Keyring) (Header, error) {
+	f, r, err := open(path, keys)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+
+	header, _, err := readHeader(r)
+	return header, err
+}
+
+// readHeader reads and parses the header of any known format
+func readHeader(r io.Reader) (Header, format, error) {
+	prefix := make([]byte, prefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[:4]) != magic {
+		return Header{}, format{}, damaged(err, ErrBadMagic)
+	}
+	version := binary.LittleEndian.Uint16(prefix[4:6])
+	f, ok := formats[version]
+	if !ok {
+		return Header{}, format{}, fmt.Errorf("%w %d", ErrBadVersion, version)
+	}
+
+	rest := make([]byte, f.headerSize-prefixSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return Header{}, format{}, damaged(err, ErrBadMagic)
+	}
+	return f.parseHeader(version, rest), f, nil
+}
+
+// damaged keeps a decryption failure from being reported as a format error
+func damaged(err, fallback error) error {
+	if errors.Is(err, encryption.ErrDecrypt) {
+		return err
+	}
+	return fallback
+}
+
+// checksumReader feeds everything read through it into a hash
+type checksumReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+func decode(r *bufio.Reader, fn func(models.User) error) (uint64, error) {
+	cr := &checksumReader{r: r, h: crc32.NewIEEE()}
+
+	header, f, err := readHeader(cr)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := uint64(0); i < header.Count; i++ {
+		u, err := f.readUser(cr)
+		if err != nil {
+			return 0, fmt.Errorf("snapshot: reading user %d: %w", i, err)
+		}
+		if err := fn(u); err != nil {
+			return 0, err
+		}
+	}
+
+	sum := cr.h.Sum32()
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(r, trailer); err != nil {
+		return 0, damaged(err, ErrBadChecksum)
+	}
+	if binary.LittleEndian.Uint32(trailer) != sum {
+		return 0, ErrBadChecksum
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return 0, fmt.Errorf("snapshot: trailing data after checksum")
+	}
+	return header.Seq, nil
+}
+
+// List returns the snapshot files in dir, newest first
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths, nil
+}
+
+// LoadLatest loads the newest snapshot in dir that verifies, skipping damaged
+// ones, and returns its seq. ErrNoSnapshot means there was nothing to load.
+// A snapshot the keys cannot decrypt stops the load rather than falling back
+// to an older board.
+func LoadLatest(dir string, keys *encryption.Keyring, fn func(models.User) error) (uint64, string, error) {
+	paths, err := List(dir)
+	if err != nil {
+		return 0, "", err
+	}
+
+	for _, path := range paths {
+		if _, err := Verify(path, keys); err != nil {
+			if encryption.IsKeyError(err) {
+				return 0, path, err
+			}
+			continue
+		}
+		seq, err := Read(path, keys, fn)
+		return seq, path, err
+	}
+	return 0, "", ErrNoSnapshot
+}
+
+// Prune removes all but the newest keep snapshots in dir
+func Prune(dir string, keep int) error {
+	paths, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(paths); i++ {
+		if err := os.Remove(paths[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,284 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"leaderboard/encryption"
+	"leaderboard/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sampleUsers() []models.User {
+	return []models.User{
+		{ID: "id1", Username: "alice", Rating: 1500},
+		{ID: "id2", Username: "bob", Rating: 100},
+		{ID: "", Username: "carol", Rating: 5000},
+	}
+}
+
+func eachOf(users []models.User) func(func(models.User) error) error {
+	return func(fn func(models.User) error) error {
+		for _, u := range users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func writeSnapshot(t *testing.T, dir string, seq uint64, users []models.User) string {
+	t.Helper()
+	path := filepath.Join(dir, FileName(seq))
+	if err := Write(path, nil, seq, len(users), eachOf(users)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return path
+}
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSnapshot(t, dir, 42, sampleUsers())
+
+	var got []models.User
+	seq, err := Read(path, nil, func(u models.User) error {
+		got = append(got, u)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if seq != 42 {
+		t.Errorf("Expected seq 42, got %d", seq)
+	}
+	want := sampleUsers()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d users, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("User %d: got %+v want %+v", i, got[i], want[i])
+		}
+	}
+
+	// No temp file is left behind
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected temp file to be gone, got %v", err)
+	}
+}
+
+func TestWriteCountMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName(1))
+	if err := Write(path, nil, 1, 5, eachOf(sampleUsers())); err == nil {
+		t.Fatal("Expected error when fewer users are written than counted")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Failed write should leave no snapshot, got %v", err)
+	}
+}
+
+func TestVerifyDamage(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSnapshot(t, dir, 7, sampleUsers())
+	data, _ := os.ReadFile(path)
+
+	// Flipped byte in a record
+	flipped := append([]byte(nil), data...)
+	flipped[formats[FormatVersion].headerSize+2] ^= 0xff
+	os.WriteFile(path, flipped, 0o644)
+	if _, err := Verify(path, nil); err == nil {
+		t.Error("Expected error for flipped byte")
+	}
+
+	// Truncated file
+	os.WriteFile(path, data[:len(data)-3], 0o644)
+	if _, err := Verify(path, nil); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum for truncated file, got %v", err)
+	}
+
+	// Unknown format version
+	future := append([]byte(nil), data...)
+	future[4] = 99
+	os.WriteFile(path, future, 0o644)
+	if _, err := Verify(path, nil); !errors.Is(err, ErrBadVersion) {
+		t.Errorf("Expected ErrBadVersion, got %v", err)
+	}
+
+	// Not a snapshot
+	os.WriteFile(path, []byte("hello"), 0o644)
+	if _, err := Verify(path, nil); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Expected ErrBadMagic, got %v", err)
+	}
+}
+
+func TestLoadLatest(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, _, err := LoadLatest(dir, nil, func(models.User) error { return nil }); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Expected ErrNoSnapshot for empty dir, got %v", err)
+	}
+
+	writeSnapshot(t, dir, 5, sampleUsers()[:1])
+	writeSnapshot(t, dir, 10, sampleUsers()[:2])
+	newest := writeSnapshot(t, dir, 20, sampleUsers())
+
+	count := 0
+	seq, _, err := LoadLatest(dir, nil, func(models.User) error { count++; return nil })
+	if err != nil || seq != 20 || count != 3 {
+		t.Errorf("Expected seq 20 with 3 users, got %d %d %v", seq, count, err)
+	}
+
+	// A damaged newest snapshot falls back to the previous one
+	os.WriteFile(newest, []byte("LBSN garbage"), 0o644)
+	count = 0
+	seq, path, err := LoadLatest(dir, nil, func(models.User) error { count++; return nil })
+	if err != nil || seq != 10 || count != 2 {
+		t.Errorf("Expected fallback to seq 10 with 2 users, got %d %d %v", seq, count, err)
+	}
+	if filepath.Base(path) != FileName(10) {
+		t.Errorf("Expected %s, got %s", FileName(10), path)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	for seq := uint64(1); seq <= 5; seq++ {
+		writeSnapshot(t, dir, seq, sampleUsers())
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644)
+
+	if err := Prune(dir, 2); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	paths, _ := List(dir)
+	if len(paths) != 2 || filepath.Base(paths[0]) != FileName(5) || filepath.Base(paths[1]) != FileName(4) {
+		t.Errorf("Expected the two newest snapshots, got %v", paths)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("Prune should leave other files alone: %v", err)
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	// Every historical format must keep loading into the current user shape
+	for version := uint16(1); version <= FormatVersion; version++ {
+		path := filepath.Join("testdata", fmt.Sprintf("v%d.snap", version))
+
+		header, err := ReadHeader(path, nil)
+		if err != nil || header.Format != version || header.Seq != 42 || header.Count != 3 {
+			t.Errorf("v%d: unexpected header %+v %v", version, header, err)
+		}
+		if version >= 2 && header.Created.IsZero() {
+			t.Errorf("v%d: expected a creation time", version)
+		}
+
+		var got []models.User
+		seq, err := Read(path, nil, func(u models.User) error {
+			got = append(got, u)
+			return nil
+		})
+		if err != nil || seq != 42 {
+			t.Fatalf("v%d: read failed: %d %v", version, seq, err)
+		}
+		want := sampleUsers()
+		if len(got) != len(want) {
+			t.Fatalf("v%d: expected %d users, got %d", version, len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("v%d user %d: got %+v want %+v", version, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestUpgradeRewritesCurrentFormat(t *testing.T) {
+	// Loading an old snapshot and writing it back produces the current format
+	var users []models.User
+	Read(filepath.Join("testdata", "v1.snap"), nil, func(u models.User) error {
+		users = append(users, u)
+		return nil
+	})
+	path := writeSnapshot(t, t.TempDir(), 42, users)
+	if header, _ := ReadHeader(path, nil); header.Format != FormatVersion {
+		t.Errorf("Expected format %d, got %d", FormatVersion, header.Format)
+	}
+}
+
+func TestReadSkipsUnknownFields(t *testing.T) {
+	// A newer writer may append fields to a record body
+	body := []byte{3, 'i', 'd', '1', 5, 'a', 'l', 'i', 'c', 'e', 0xdc, 0x0b, 0x07, 0x01, 0x02}
+	record := append([]byte{byte(len(body))}, body...)
+	r := &checksumReader{r: bufio.NewReader(bytes.NewReader(record)), h: crc32.NewIEEE()}
+
+	u, err := readUserV2(r)
+	if err != nil || u != (models.User{ID: "id1", Username: "alice", Rating: 1500}) {
+		t.Errorf("Expected alice at 1500, got %+v %v", u, err)
+	}
+}
+
+func testKeys(t *testing.T, entries ...string) *encryption.Keyring {
+	t.Helper()
+	keys, err := encryption.ParseKeyring(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	k1, _ := encryption.GenerateKey("k1")
+	keys := testKeys(t, k1)
+
+	path := filepath.Join(dir, FileName(7))
+	if err := Write(path, keys, 7, 3, eachOf(sampleUsers())); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !encryption.IsEncrypted(data) || bytes.Contains(data, []byte("alice")) {
+		t.Error("Expected snapshot to be encrypted")
+	}
+
+	var got []models.User
+	seq, err := Read(path, keys, func(u models.User) error { got = append(got, u); return nil })
+	if err != nil || seq != 7 || len(got) != 3 || got[0] != sampleUsers()[0] {
+		t.Errorf("Expected 3 users at seq 7, got %+v %d %v", got, seq, err)
+	}
+	if header, err := ReadHeader(path, keys); err != nil || header.Count != 3 {
+		t.Errorf("Expected header with 3 users, got %+v %v", header, err)
+	}
+
+	// Missing and wrong keys are reported as such, not as damage
+	if _, err := Verify(path, nil); !errors.Is(err, encryption.ErrNoKey) {
+		t.Errorf("Expected ErrNoKey, got %v", err)
+	}
+	other, _ := encryption.GenerateKey("k1")
+	if _, err := Verify(path, testKeys(t, other)); !errors.Is(err, encryption.ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+
+	// A wrong key stops LoadLatest instead of falling back to an older snapshot
+	writeSnapshot(t, dir, 3, sampleUsers()[:1])
+	if _, _, err := LoadLatest(dir, testKeys(t, other), func(models.User) error { return nil }); !errors.Is(err, encryption.ErrWrongKey) {
+		t.Errorf("Expected LoadLatest to fail with ErrWrongKey, got %v", err)
+	}
+
+	// Plaintext snapshots stay readable once a key is configured
+	if seq, err := Verify(filepath.Join(dir, FileName(3)), keys); err != nil || seq != 3 {
+		t.Errorf("Expected plaintext snapshot readable with keys, got %d %v", seq, err)
+	}
+
+	// Damage inside the ciphertext is a decryption failure
+	data[len(data)-20] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if _, err := Verify(path, keys); !errors.Is(err, encryption.ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"leaderboard/models"
+	"leaderboard/snapshot"
+	"leaderboard/wal"
+)
+
+// WALName is the log file inside a File store's directory
+const WALName = "leaderboard.wal"
+
+// LockName is the file a File store holds locked while it is open
+const LockName = "LOCK"
+
+// ErrLocked means another process, such as a running server, has the
+// store's directory open
+var ErrLocked = errors.New("store: directory is in use by another process")
+
+// ErrGap means the newest snapshot that loads is older than the WAL, so the
+// mutations between them are lost. Restore from a backup rather than
+// serving a board missing them.
+var ErrGap = errors.New("store: mutations missing between snapshot and wal")
+
+// snapshotsKept is how many snapshots survive a checkpoint, so one damaged
+// file still leaves an older one to fall back on
+const snapshotsKept = 2
+
+// File keeps the board in a directory as the latest snapshot plus a WAL of
+// the mutations since
+type File struct {
+	mu   sync.Mutex
+	ckMu sync.Mutex // one checkpoint at a time, without holding up appends
+	dir  string
+	opts wal.Options
+	lock *os.File // held until Close
+	log  *wal.WAL // opened by Load
+}
+
+// OpenFile prepares a store in dir, creating it if needed, and locks it so
+// no other process can write the same files until Close. It fails with
+// ErrLocked when the store is already open elsewhere. Call Load before
+// appending so the WAL is replayed and any torn tail cut off first.
+func OpenFile(dir string, opts wal.Options) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, LockName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", dir, err)
+	}
+	return &File{dir: dir, opts: opts, lock: lock}, nil
+}
+
+// Dir is the directory holding the store's files
+func (s *File) Dir() string { return s.dir }
+
+func (s *File) Append(m models.Mutation) error {
+	s.mu.Lock()
+	log := s.log
+	s.mu.Unlock()
+
+	if log == nil {
+		return errors.New("store: append before load")
+	}
+	return log.Append(m)
+}
+
+// AppendBatch writes ms to the WAL together, all or none
+func (s *File) AppendBatch(ms []models.Mutation) (int, error) {
+	s.mu.Lock()
+	log := s.log
+	s.mu.Unlock()
+
+	if log == nil {
+		return 0, errors.New("store: append before load")
+	}
+	if err := log.AppendBatch(ms); err != nil {
+		return 0, err
+	}
+	return len(ms), nil
+}
+
+// Load replays the newest valid snapshot as adds, then the WAL records it
+// does not already cover
+func (s *File) Load(fn func(models.Mutation) error) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log != nil {
+		return 0, errors.New("store: already loaded")
+	}
+
+	seq, path, err := snapshot.LoadLatest(s.dir, s.opts.Keys, func(u models.User) error {
+		return fn(models.Mutation{Op: models.MutationAdd, ID: u.ID, Username: u.Username, Rating: u.Rating})
+	})
+	if err != nil && !errors.Is(err, snapshot.ErrNoSnapshot) {
+		return 0, fmt.Errorf("failed to load snapshot %s: %w", path, err)
+	}
+
+	// A crash between writing a snapshot and truncating the WAL leaves
+	// records the snapshot already covers. The rest must follow on from the
+	// snapshot: a WAL starting later was truncated at a newer snapshot that
+	// is now damaged, and the mutations between the two are gone.
+	last := seq
+	walPath := filepath.Join(s.dir, WALName)
+	if _, err := wal.Replay(walPath, s.opts.Keys, func(m models.Mutation) error {
+		if m.Seq <= seq {
+			return nil
+		}
+		if last == seq && m.Seq != seq+1 {
+			return fmt.Errorf("%w: the newest readable snapshot ends at seq %d but the wal resumes at %d", ErrGap, seq, m.Seq)
+		}
+		last = m.Seq
+		return fn(m)
+	}); err != nil {
+		return 0, fmt.Errorf("failed to replay %s: %w", walPath, err)
+	}
+
+	if s.log, err = wal.Open(walPath, s.opts); err != nil {
+		return 0, err
+	}
+	return last, nil
+}
+
+// Checkpoint writes a snapshot and, once it is durable, drops the WAL records
+// it covers, keeping any appended since seq. A board already snapshotted at
+// seq under the current key is not written again. Both are rewritten under
+// the current key, which completes a key rotation even on an idle board.
+func (s *File) Checkpoint(seq uint64, count int, each func(func(models.User) error) error) error {
+	s.ckMu.Lock()
+	defer s.ckMu.Unlock()
+
+	s.mu.Lock()
+	log := s.log
+	s.mu.Unlock()
+
+	if log == nil {
+		return errors.New("store: checkpoint before load")
+	}
+	path := filepath.Join(s.dir, snapshot.FileName(seq))
+	if id, err := snapshot.KeyID(path); err == nil && id == s.currentKeyID() {
+		return nil
+	}
+
+	if err := snapshot.Write(path, s.opts.Keys, seq, count, each); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := log.Discard(seq); err != nil {
+		return fmt.Errorf("failed to truncate wal: %w", err)
+	}
+	return snapshot.Prune(s.dir, snapshotsKept)
+}
+
+// currentKeyID is the id new snapshots are encrypted under, "" for plaintext
+func (s *File) currentKeyID() string {
+	if s.opts.Keys == nil {
+		return ""
+	}
+	return s.opts.Keys.CurrentID()
+}
+
+// Close closes the WAL and releases the directory
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.log != nil {
+		err = s.log.Close()
+	}
+	if s.lock != nil {
+		err = errors.Join(err, s.lock.Close())
+		s.lock = nil
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// lockFile is a no-op where flock is not available, leaving the store
+// unguarded against a second process
+func lockFile(f *os.File) error {
+	return nil
+}
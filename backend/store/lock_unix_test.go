@@ -0,0 +1,29 @@
+//go:build unix
+
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"leaderboard/wal"
+)
+
+func TestFileLock(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFile(dir, wal.Options{})
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := OpenFile(dir, wal.Options{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while the store is open, got %v", err)
+	}
+
+	// Closing releases it, loaded or not
+	s.Close()
+	s, err = OpenFile(dir, wal.Options{})
+	if err != nil {
+		t.Fatalf("Expected the store to open after Close, got %v", err)
+	}
+	s.Close()
+}
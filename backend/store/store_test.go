@@ -0,0 +1,307 @@
+package store
+
+import (
+	"errors"
+	"leaderboard/encryption"
+	"leaderboard/models"
+	"leaderboard/snapshot"
+	"leaderboard/wal"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sampleMutations() []models.Mutation {
+	return []models.Mutation{
+		{Seq: 1, Op: models.MutationAdd, ID: "id1", Username: "alice", Rating: 1500},
+		{Seq: 2, Op: models.MutationAdd, ID: "id2", Username: "bob", Rating: 1200},
+		{Seq: 3, Op: models.MutationUpdate, Username: "alice", Rating: 4800},
+	}
+}
+
+func loadAll(t *testing.T, s Store) ([]models.Mutation, uint64) {
+	t.Helper()
+	var got []models.Mutation
+	seq, err := s.Load(func(m models.Mutation) error {
+		got = append(got, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return got, seq
+}
+
+func eachOf(users ...models.User) func(func(models.User) error) error {
+	return func(fn func(models.User) error) error {
+		for _, u := range users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestMemory(t *testing.T) {
+	var s Store = NewMemory()
+	for _, m := range sampleMutations() {
+		if err := s.Append(m); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if got, seq := loadAll(t, s); len(got) != 0 || seq != 0 {
+		t.Errorf("Memory store should load nothing, got %d mutations at %d", len(got), seq)
+	}
+	if err := s.Checkpoint(3, 0, eachOf()); err != nil {
+		t.Errorf("Checkpoint failed: %v", err)
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if err := s.Append(sampleMutations()[0]); err == nil {
+		t.Error("Expected error appending before load")
+	}
+
+	loadAll(t, s)
+	for _, m := range sampleMutations() {
+		s.Append(m)
+	}
+	s.Close()
+
+	s, _ = OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	defer s.Close()
+	got, seq := loadAll(t, s)
+	if seq != 3 || len(got) != 3 || got[2] != sampleMutations()[2] {
+		t.Errorf("Expected the 3 appended mutations at seq 3, got %+v at %d", got, seq)
+	}
+	if _, err := s.Load(func(models.Mutation) error { return nil }); err == nil {
+		t.Error("Expected error loading twice")
+	}
+}
+
+func TestFileCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	loadAll(t, s)
+	for _, m := range sampleMutations() {
+		s.Append(m)
+	}
+
+	board := eachOf(
+		models.User{ID: "id1", Username: "alice", Rating: 4800},
+		models.User{ID: "id2", Username: "bob", Rating: 1200},
+	)
+	if err := s.Checkpoint(3, 2, board); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	s.Append(models.Mutation{Seq: 4, Op: models.MutationRemove, Username: "bob"})
+	s.Close()
+
+	s, _ = OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	defer s.Close()
+	got, seq := loadAll(t, s)
+	if seq != 4 || len(got) != 3 {
+		t.Fatalf("Expected 2 snapshot adds and 1 wal record at seq 4, got %+v at %d", got, seq)
+	}
+	if got[0].Op != models.MutationAdd || got[2].Op != models.MutationRemove {
+		t.Errorf("Expected snapshot adds before the wal tail, got %+v", got)
+	}
+}
+
+func TestFileCheckpointKeepsLaterRecords(t *testing.T) {
+	// Writers carry on while a checkpoint is written, so the wal may already
+	// hold records after the board being snapshotted
+	dir := t.TempDir()
+	k1, _ := encryption.GenerateKey("k1")
+	keys, _ := encryption.ParseKeyring(k1)
+	s, _ := OpenFile(dir, wal.Options{Sync: wal.SyncAlways, Keys: keys})
+	loadAll(t, s)
+	for _, m := range sampleMutations() {
+		s.Append(m)
+	}
+
+	board := eachOf(
+		models.User{ID: "id1", Username: "alice", Rating: 1500},
+		models.User{ID: "id2", Username: "bob", Rating: 1200},
+	)
+	if err := s.Checkpoint(2, 2, board); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	s.Append(models.Mutation{Seq: 4, Op: models.MutationRemove, Username: "bob"})
+	s.Close()
+
+	var seqs []uint64
+	wal.Replay(filepath.Join(dir, WALName), keys, func(m models.Mutation) error {
+		seqs = append(seqs, m.Seq)
+		return nil
+	})
+	if len(seqs) != 2 || seqs[0] != 3 || seqs[1] != 4 {
+		t.Errorf("Expected the wal to keep records 3 and 4, got %v", seqs)
+	}
+
+	s, _ = OpenFile(dir, wal.Options{Sync: wal.SyncAlways, Keys: keys})
+	defer s.Close()
+	got, seq := loadAll(t, s)
+	if seq != 4 || len(got) != 4 || got[2].Seq != 3 || got[2].Rating != 4800 {
+		t.Errorf("Expected 2 snapshot adds then records 3 and 4, got %+v at %d", got, seq)
+	}
+}
+
+func TestFileSkipsCoveredRecords(t *testing.T) {
+	// A crash between writing the snapshot and truncating the wal leaves
+	// records the snapshot already covers; they must not be replayed
+	dir := t.TempDir()
+	s, _ := OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	loadAll(t, s)
+	for _, m := range sampleMutations() {
+		s.Append(m)
+	}
+	s.Close()
+
+	users := eachOf(models.User{ID: "id1", Username: "alice", Rating: 1500})
+	if err := snapshot.Write(filepath.Join(dir, snapshot.FileName(1)), nil, 1, 1, users); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	s, _ = OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	defer s.Close()
+	got, seq := loadAll(t, s)
+	if seq != 3 || len(got) != 3 || got[1].Seq != 2 {
+		t.Errorf("Expected snapshot add then records 2 and 3, got %+v at %d", got, seq)
+	}
+}
+
+func TestFileKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	k1, _ := encryption.GenerateKey("k1")
+	k2, _ := encryption.GenerateKey("k2")
+	open := func(entries ...string) *File {
+		keys, err := encryption.ParseKeyring(strings.Join(entries, ","))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := OpenFile(dir, wal.Options{Sync: wal.SyncAlways, Keys: keys})
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		return s
+	}
+	alice := models.User{ID: "id1", Username: "alice", Rating: 1500}
+
+	s := open(k1)
+	loadAll(t, s)
+	s.Append(sampleMutations()[0])
+	s.Checkpoint(1, 1, eachOf(alice))
+	s.Append(sampleMutations()[1])
+	s.Close()
+
+	// The new key is current but the old one still reads the data on disk
+	s = open(k2, k1)
+	if got, seq := loadAll(t, s); seq != 2 || len(got) != 2 {
+		t.Fatalf("Expected snapshot and wal tail at seq 2, got %+v at %d", got, seq)
+	}
+	s.Checkpoint(2, 2, eachOf(alice, models.User{ID: "id2", Username: "bob", Rating: 1200}))
+	s.Append(sampleMutations()[2])
+	s.Close()
+
+	// The checkpoint re-encrypted the board and the wal under the new key
+	s = open(k2)
+	if got, seq := loadAll(t, s); seq != 3 || len(got) != 3 {
+		t.Errorf("Expected the board under the new key alone at seq 3, got %+v at %d", got, seq)
+	}
+	s.Close()
+
+	// Dropping the current key fails loudly instead of loading an older board
+	s2 := open(k1)
+	defer s2.Close()
+	if _, err := s2.Load(func(models.Mutation) error { return nil }); !encryption.IsKeyError(err) {
+		t.Errorf("Expected a key error, got %v", err)
+	}
+}
+
+func TestFileKeyRotationWhileIdle(t *testing.T) {
+	dir := t.TempDir()
+	k1, _ := encryption.GenerateKey("k1")
+	k2, _ := encryption.GenerateKey("k2")
+	open := func(entries ...string) *File {
+		keys, err := encryption.ParseKeyring(strings.Join(entries, ","))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := OpenFile(dir, wal.Options{Sync: wal.SyncAlways, Keys: keys})
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		return s
+	}
+	alice := models.User{ID: "id1", Username: "alice", Rating: 1500}
+
+	s := open(k1)
+	loadAll(t, s)
+	s.Append(sampleMutations()[0])
+	s.Checkpoint(1, 1, eachOf(alice))
+	s.Close()
+
+	// Nothing was written since, so the checkpoint lands on the same seq
+	s = open(k2, k1)
+	loadAll(t, s)
+	if err := s.Checkpoint(1, 1, eachOf(alice)); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	s.Close()
+
+	if id, err := snapshot.KeyID(filepath.Join(dir, snapshot.FileName(1))); err != nil || id != "k2" {
+		t.Errorf("Expected the snapshot rewritten under k2, got %q, %v", id, err)
+	}
+	s = open(k2)
+	defer s.Close()
+	if got, seq := loadAll(t, s); seq != 1 || len(got) != 1 {
+		t.Errorf("Expected the board under the new key alone at seq 1, got %+v at %d", got, seq)
+	}
+}
+
+func TestFileGapAfterDamagedSnapshot(t *testing.T) {
+	// The wal is truncated at each checkpoint, so falling back past the
+	// newest snapshot leaves a hole that must stop the load
+	dir := t.TempDir()
+	s, _ := OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	loadAll(t, s)
+	muts := sampleMutations()
+	s.Append(muts[0])
+	s.Append(muts[1])
+	s.Checkpoint(2, 2, eachOf(
+		models.User{ID: "id1", Username: "alice", Rating: 1500},
+		models.User{ID: "id2", Username: "bob", Rating: 1200},
+	))
+	s.Append(muts[2])
+	if err := s.Checkpoint(3, 2, eachOf(
+		models.User{ID: "id1", Username: "alice", Rating: 4800},
+		models.User{ID: "id2", Username: "bob", Rating: 1200},
+	)); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	s.Append(models.Mutation{Seq: 4, Op: models.MutationRemove, Username: "bob"})
+	s.Close()
+
+	newest := filepath.Join(dir, snapshot.FileName(3))
+	data, err := os.ReadFile(newest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(newest, data, 0o644)
+
+	s, _ = OpenFile(dir, wal.Options{Sync: wal.SyncAlways})
+	defer s.Close()
+	_, err = s.Load(func(models.Mutation) error { return nil })
+	if !errors.Is(err, ErrGap) {
+		t.Errorf("Expected ErrGap, got %v", err)
+	}
+}
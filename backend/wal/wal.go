@@ -0,0 +1,619 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"leaderboard/encryption"
+	"leaderboard/models"
+)
+
+// SyncPolicy controls when appended records are fsynced
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every record
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in the background every Options.Interval
+	SyncInterval
+	// SyncNone leaves flushing to the operating system
+	SyncNone
+)
+
+// ParseSyncPolicy reads "always", "interval" or "none"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always", "":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "none":
+		return SyncNone, nil
+	default:
+		return 0, fmt.Errorf("unknown sync policy %q, expected always, interval or none", s)
+	}
+}
+
+type Options struct {
+	Sync     SyncPolicy
+	Interval time.Duration // used by SyncInterval, defaults to 100ms
+	// Keys encrypts each record of a new log under the current key. An
+	// existing log keeps the key it was started with until it is truncated.
+	Keys *encryption.Keyring
+}
+
+// recordHeaderSize is the length and checksum in front of every payload
+const recordHeaderSize = 8
+
+// maxRecordSize guards replay against reading a garbage length
+const maxRecordSize = 1 << 20
+
+// ErrCorrupt is returned when a record before the end of the log is damaged
+var ErrCorrupt = errors.New("wal: corrupt record")
+
+// ErrBadVersion is returned for a log written in a format this build cannot read
+var ErrBadVersion = errors.New("wal: unsupported format version")
+
+// Format 2 logs start with an 8-byte header: the magic "LBWL", a little-endian
+// uint16 format version and uint16 flags. Format 1 logs have no header and
+// start straight with a record. Read as a record length the magic is far above
+// maxRecordSize, so the first four bytes tell the two apart. Records are the
+// same in both; a format 1 log becomes format 2 when it is next truncated.
+//
+// With flagEncrypted set the header is followed by the key header, and every
+// record payload is sealed with AES-GCM under that key. The key header and
+// the record's offset in the file are bound in as associated data, so a
+// record moved to another place or another log fails to open.
+const (
+	fileMagic      = "LBWL"
+	FormatVersion  = 2
+	fileHeaderSize = 8
+	flagEncrypted  = 1
+)
+
+// WAL is an append-only file of leaderboard mutations. Each record is a
+// little-endian uint32 payload length, the CRC-32 of the payload and the
+// payload itself.
+type WAL struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	opts   Options
+	format uint16
+	key    *encryption.KeyHeader // nil for a plaintext log
+	size   int64                 // where the next record starts
+	dirty  bool
+	closed bool
+	broken error // a failed write that could not be cut off
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Open opens the log at path for appending, creating it if needed. Call
+// Replay first so a torn final record is cut off before new records follow it.
+func Open(path string, opts Options) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{path: path, f: f, opts: opts}
+	header, err := readFileHeader(f, opts.Keys)
+	if err == errNoHeader {
+		err = w.writeFileHeader()
+	} else if err == nil {
+		w.format, w.key = header.format, header.key
+	}
+	if err == nil {
+		err = w.stat()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		if w.opts.Interval <= 0 {
+			w.opts.Interval = 100 * time.Millisecond
+		}
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// Append writes one mutation. With SyncAlways it is durable on return.
+func (w *WAL) Append(m models.Mutation) error {
+	return w.AppendBatch([]models.Mutation{m})
+}
+
+// AppendBatch writes ms with a single write, and with SyncAlways a single
+// fsync. Either all of them are appended or none are.
+func (w *WAL) AppendBatch(ms []models.Mutation) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("wal: closed")
+	}
+	if w.broken != nil {
+		return fmt.Errorf("wal: unusable after a failed write: %w", w.broken)
+	}
+	if err := w.appendLocked(ms...); err != nil {
+		return err
+	}
+	if w.opts.Sync == SyncAlways {
+		return w.f.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+// writeFile writes a record to the log, and is replaced by tests to fail
+var writeFile = (*os.File).Write
+
+// appendLocked writes records in one write without syncing them
+func (w *WAL) appendLocked(ms ...models.Mutation) error {
+	var records []byte
+	for _, m := range ms {
+		payload := encodePayload(m)
+		if w.key != nil {
+			sealed, err := w.opts.Keys.Seal(*w.key, payload, recordAAD(*w.key, w.size+int64(len(records))))
+			if err != nil {
+				return err
+			}
+			payload = sealed
+		}
+		records = append(records, frameRecord(payload)...)
+	}
+	if _, err := writeFile(w.f, records); err != nil {
+		// Part of the records may have been written. Cut them off, or the
+		// next record would follow garbage that replay reports as corruption.
+		if terr := w.f.Truncate(w.size); terr != nil {
+			w.broken = errors.Join(err, terr)
+		}
+		return err
+	}
+	w.size += int64(len(records))
+	return nil
+}
+
+// Sync forces written records to disk
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+func (w *WAL) syncLocked() error {
+	if w.closed || !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+func (w *WAL) syncLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Sync()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Truncate discards every record, once they are covered by a durable snapshot
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("wal: closed")
+	}
+	return w.truncateLocked()
+}
+
+func (w *WAL) truncateLocked() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	if err := w.writeFileHeader(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+// Discard drops the records up to and including seq, once they are covered
+// by a durable snapshot, and keeps the later ones. The records kept are
+// copied to a new log under the current key, which replaces the old one.
+func (w *WAL) Discard(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("wal: closed")
+	}
+
+	// Read back what is kept from the start of the open log
+	fh, err := readFileHeader(w.f, w.opts.Keys)
+	if err != nil {
+		return err
+	}
+	var kept []models.Mutation
+	offset := fh.size
+	r := bufio.NewReader(io.NewSectionReader(w.f, offset, w.size-offset))
+	header := make([]byte, recordHeaderSize)
+	for offset < w.size {
+		m, n, err := readRecord(r, header, w.opts.Keys, w.key, offset)
+		if err != nil {
+			return fmt.Errorf("%w at offset %d", err, offset)
+		}
+		if m.Seq > seq {
+			kept = append(kept, m)
+		}
+		offset += n
+	}
+	if len(kept) == 0 {
+		return w.truncateLocked()
+	}
+
+	tmp, err := os.OpenFile(w.path+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(w.path + ".tmp") // no-op once renamed
+	next := &WAL{path: w.path, f: tmp, opts: w.opts}
+	err = next.writeFileHeader()
+	for i := 0; err == nil && i < len(kept); i++ {
+		err = next.appendLocked(kept[i])
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(w.path+".tmp", w.path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(w.path))
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	w.f.Close()
+	w.f, w.format, w.key, w.size, w.dirty = tmp, next.format, next.key, next.size, false
+	return nil
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Format reports the format version of the open log
+func (w *WAL) Format() uint16 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.format
+}
+
+// writeFileHeader starts the log in the current format, encrypted under the
+// current key when keys are configured
+func (w *WAL) writeFileHeader() error {
+	header := make([]byte, fileHeaderSize)
+	copy(header, fileMagic)
+	binary.LittleEndian.PutUint16(header[4:6], FormatVersion)
+
+	var key *encryption.KeyHeader
+	if w.opts.Keys != nil {
+		h := w.opts.Keys.CurrentHeader()
+		key = &h
+		binary.LittleEndian.PutUint16(header[6:8], flagEncrypted)
+		header = encryption.AppendHeader(header, h)
+	}
+
+	if _, err := w.f.Write(header); err != nil {
+		return err
+	}
+	w.format, w.key = FormatVersion, key
+	w.size += int64(len(header))
+	return nil
+}
+
+// stat reads where the next record starts from the size of the file
+func (w *WAL) stat() error {
+	info, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	w.size = info.Size()
+	return nil
+}
+
+// recordAAD is the associated data sealed with the record at offset
+func recordAAD(key encryption.KeyHeader, offset int64) []byte {
+	return binary.LittleEndian.AppendUint64(encryption.AppendHeader(nil, key), uint64(offset))
+}
+
+// errNoHeader means the log is empty, or holds only a header torn mid-write
+var errNoHeader = errors.New("wal: no header")
+
+type fileHeader struct {
+	format uint16
+	size   int64
+	key    *encryption.KeyHeader
+}
+
+// readFileHeader reads the header of the log in f from its start and checks
+// keys can decrypt it. Format 1 logs have no header.
+func readFileHeader(f *os.File, keys *encryption.Keyring) (fileHeader, error) {
+	header := make([]byte, fileHeaderSize)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return fileHeader{}, err
+	}
+	if n == 0 {
+		return fileHeader{}, errNoHeader
+	}
+
+	if m := min(n, len(fileMagic)); string(header[:m]) != fileMagic[:m] {
+		return fileHeader{format: 1}, nil // starts straight with a record
+	}
+	if n < fileHeaderSize {
+		return fileHeader{}, errNoHeader // header cut short by a crash
+	}
+
+	h := fileHeader{format: binary.LittleEndian.Uint16(header[4:6]), size: fileHeaderSize}
+	if h.format < 2 || h.format > FormatVersion {
+		return fileHeader{}, fmt.Errorf("%w %d", ErrBadVersion, h.format)
+	}
+	if binary.LittleEndian.Uint16(header[6:8])&flagEncrypted == 0 {
+		return h, nil
+	}
+
+	r := io.NewSectionReader(f, fileHeaderSize, 1<<16)
+	key, err := encryption.ReadHeader(r)
+	if err != nil {
+		return fileHeader{}, errNoHeader
+	}
+	if err := keys.Check(key); err != nil {
+		return fileHeader{}, fmt.Errorf("wal: %w", err)
+	}
+	h.key = &key
+	h.size = fileHeaderSize + int64(len(encryption.AppendHeader(nil, key)))
+	return h, nil
+}
+
+// Close syncs and closes the log
+func (w *WAL) Close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.dirty = true
+	err := w.syncLocked()
+	w.closed = true
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func encodePayload(m models.Mutation) []byte {
+	payload := make([]byte, 0, 32+len(m.ID)+len(m.Username)+len(m.NewUsername))
+	payload = binary.AppendUvarint(payload, m.Seq)
+	payload = append(payload, opCode(m.Op))
+	payload = appendString(payload, m.ID)
+	payload = appendString(payload, m.Username)
+	payload = appendString(payload, m.NewUsername)
+	payload = binary.AppendUvarint(payload, uint64(m.Rating))
+	return payload
+}
+
+// frameRecord puts the length and checksum in front of a payload
+func frameRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+var opCodes = []string{models.MutationAdd, models.MutationUpdate, models.MutationRename, models.MutationRemove}
+
+func opCode(op string) byte {
+	for i, name := range opCodes {
+		if name == op {
+			return byte(i)
+		}
+	}
+	return 0xff
+}
+
+func decodePayload(payload []byte) (models.Mutation, error) {
+	var m models.Mutation
+	r := &payloadReader{b: payload}
+
+	m.Seq = r.uvarint()
+	code := r.byte()
+	m.ID = r.string()
+	m.Username = r.string()
+	m.NewUsername = r.string()
+	m.Rating = int(r.uvarint())
+
+	if r.err != nil || len(r.b) != 0 {
+		return m, ErrCorrupt
+	}
+	if int(code) >= len(opCodes) {
+		return m, fmt.Errorf("%w: unknown op %d", ErrCorrupt, code)
+	}
+	m.Op = opCodes[code]
+	return m, nil
+}
+
+type payloadReader struct {
+	b   []byte
+	err error
+}
+
+func (r *payloadReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = ErrCorrupt
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *payloadReader) byte() byte {
+	if len(r.b) == 0 {
+		r.err = ErrCorrupt
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *payloadReader) string() string {
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.b)) {
+		r.err = ErrCorrupt
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// Replay calls fn for every record in the log at path, in order, and returns
+// how many were replayed. A missing file replays nothing. A record cut short
+// by the end of the file, as left by a crash mid-write, is truncated away.
+// A record that is all there but fails its checksum or does not decode
+// returns ErrCorrupt, and one that fails to decrypt encryption.ErrDecrypt,
+// even the last one, and the log is left as it is.
+func Replay(path string, keys *encryption.Keyring, fn func(models.Mutation) error) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	fh, err := readFileHeader(f, keys)
+	if err == errNoHeader {
+		// Drop a torn header so Open writes a fresh one
+		if err := f.Truncate(0); err != nil {
+			return 0, err
+		}
+		return 0, f.Sync()
+	}
+	if err != nil {
+		return 0, err
+	}
+	offset := fh.size
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	count := 0
+	header := make([]byte, recordHeaderSize)
+
+	for offset < size {
+		m, n, err := readRecord(r, header, keys, fh.key, offset)
+		if err != nil {
+			torn := (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)) && offset+n > size
+			if !torn {
+				return count, fmt.Errorf("%w at offset %d", err, offset)
+			}
+			if err := f.Truncate(offset); err != nil {
+				return count, err
+			}
+			return count, f.Sync()
+		}
+
+		if err := fn(m); err != nil {
+			return count, fmt.Errorf("replaying record %d (seq %d): %w", count, m.Seq, err)
+		}
+		offset += n
+		count++
+	}
+	return count, nil
+}
+
+// readRecord returns the mutation and the bytes the record took up, or
+// claims to when it cannot be read. Records of an encrypted log are opened
+// with key, as the record at offset.
+func readRecord(r io.Reader, header []byte, keys *encryption.Keyring, key *encryption.KeyHeader, offset int64) (models.Mutation, int64, error) {
+	if _, err := io.ReadFull(r, header); err != nil {
+		return models.Mutation{}, recordHeaderSize, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	sum := binary.LittleEndian.Uint32(header[4:8])
+	n := int64(recordHeaderSize) + int64(length)
+	if length > maxRecordSize {
+		return models.Mutation{}, n, ErrCorrupt
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return models.Mutation{}, n, err
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return models.Mutation{}, n, ErrCorrupt
+	}
+	if key != nil {
+		plain, err := keys.Open(*key, payload, recordAAD(*key, offset))
+		if err != nil {
+			return models.Mutation{}, n, err
+		}
+		payload = plain
+	}
+
+	m, err := decodePayload(payload)
+	return m, n, err
+}
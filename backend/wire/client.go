@@ -0,0 +1,207 @@
+package wire
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"leaderboard/models"
+)
+
+// ErrClosed is returned by calls on a closed client
+var ErrClosed = errors.New("wire: client closed")
+
+// Client is a connection to a Server. It is safe for concurrent use, and
+// calls made concurrently are pipelined on the one connection rather than
+// waiting for each other's responses.
+type Client struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	w       *bufio.Writer
+	enc     encoder
+	nextID  uint32
+	pending map[uint32]chan response
+	err     error
+}
+
+type response struct {
+	status byte
+	body   []byte
+}
+
+// Dial connects to a server at addr
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient uses conn, which the client closes on Close
+func NewClient(conn net.Conn) *Client {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+	c := &Client{
+		conn:    conn,
+		w:       bufio.NewWriterSize(conn, 64<<10),
+		pending: make(map[uint32]chan response),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Close closes the connection. Calls waiting on a response return ErrClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return c.conn.Close()
+}
+
+// Update sets a user's rating and returns the user with their new rank
+func (c *Client) Update(ctx context.Context, username string, rating int) (*models.UserWithRank, error) {
+	return c.userCall(ctx, OpUpdate, username, rating)
+}
+
+// Increment adds delta to a user's rating and returns the user with their
+// new rank
+func (c *Client) Increment(ctx context.Context, username string, delta int) (*models.UserWithRank, error) {
+	return c.userCall(ctx, OpIncrement, username, delta)
+}
+
+// Rank returns a user with their rank
+func (c *Client) Rank(ctx context.Context, username string) (*models.UserWithRank, error) {
+	return c.userCall(ctx, OpRank, username, 0)
+}
+
+// Range returns up to limit users from offset in rank order. The server
+// returns at most MaxRange at a time.
+func (c *Client) Range(ctx context.Context, offset, limit int) ([]models.UserWithRank, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("wire: offset and limit must not be negative")
+	}
+	d, err := c.call(ctx, OpRange, func(e *encoder) {
+		e.uint32(uint32(offset))
+		e.uint32(uint32(min(limit, MaxRange)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	n := d.uint32()
+	if n > MaxRange {
+		return nil, fmt.Errorf("wire: range of %d users", n)
+	}
+	users := make([]models.UserWithRank, n)
+	for i := range users {
+		users[i] = d.user()
+	}
+	if err := d.done(); err != nil {
+		return nil, fmt.Errorf("wire: bad response: %w", err)
+	}
+	return users, nil
+}
+
+func (c *Client) userCall(ctx context.Context, op byte, username string, n int) (*models.UserWithRank, error) {
+	d, err := c.call(ctx, op, func(e *encoder) {
+		e.string(username)
+		if op != OpRank {
+			e.int32(int32(n))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	user := d.user()
+	if err := d.done(); err != nil {
+		return nil, fmt.Errorf("wire: bad response: %w", err)
+	}
+	return &user, nil
+}
+
+// call sends a request and waits for its response body
+func (c *Client) call(ctx context.Context, op byte, body func(*encoder)) (*decoder, error) {
+	ch := make(chan response, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.nextID++
+	id := c.nextID
+	c.enc.start(op, id)
+	body(&c.enc)
+	c.pending[id] = ch
+	_, err := c.w.Write(c.enc.frame())
+	if err == nil {
+		err = c.w.Flush()
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.fail(err)
+		c.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			err := c.err
+			c.mu.Unlock()
+			return nil, err
+		}
+		d := &decoder{buf: resp.body}
+		if resp.status != StatusOK {
+			return nil, &Error{Status: resp.status, Message: d.string()}
+		}
+		return d, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop hands each response to the call waiting on its id
+func (c *Client) readLoop() {
+	r := bufio.NewReaderSize(c.conn, 64<<10)
+	for {
+		frame, err := readFrame(r, nil)
+		if err != nil {
+			c.fail(err)
+			c.conn.Close()
+			return
+		}
+		id := binary.BigEndian.Uint32(frame[1:headerSize])
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- response{status: frame[0], body: frame[headerSize:]}
+		}
+	}
+}
+
+// fail stops the client with err, keeping the first error, and wakes every
+// waiting call
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
@@ -0,0 +1,194 @@
+// Package wire is a compact binary protocol over TCP for clients that write
+// at high rates, such as game servers, where HTTP and JSON cost more than
+// the update itself.
+//
+// Every message is a frame: a 4 byte big-endian length, then that many
+// bytes of payload. A request payload is
+//
+//	op (1 byte) | id (4 bytes) | body
+//
+// and its response payload is
+//
+//	status (1 byte) | id (4 bytes) | body
+//
+// carrying the request's id. Clients may send any number of requests
+// without waiting; the server answers them in the order they were sent.
+//
+// Integers in bodies are 4 byte big-endian, ratings and deltas signed, and
+// strings are a 2 byte length followed by UTF-8 bytes. The bodies are:
+//
+//	OpUpdate     username, rating  ->  user
+//	OpIncrement  username, delta   ->  user
+//	OpRank       username          ->  user
+//	OpRange      offset, limit     ->  count, count users
+//
+// where a user is rank, rating, username, tier. A response with a status
+// other than StatusOK has a message string as its body.
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"leaderboard/models"
+)
+
+// Operations
+const (
+	OpUpdate    byte = 1
+	OpIncrement byte = 2
+	OpRank      byte = 3
+	OpRange     byte = 4
+)
+
+// Response statuses
+const (
+	StatusOK         byte = 0
+	StatusNotFound   byte = 1
+	StatusInvalid    byte = 2
+	StatusBadRequest byte = 3
+	StatusError      byte = 4
+)
+
+// MaxFrame is the largest payload either side accepts
+const MaxFrame = 1 << 20
+
+// MaxRange is the most users one OpRange returns, as on /leaderboard
+const MaxRange = 1000
+
+// headerSize is the op or status byte and the id
+const headerSize = 5
+
+// Error is a response with a status other than StatusOK
+type Error struct {
+	Status  byte
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// IsNotFound reports whether err is a StatusNotFound response
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == StatusNotFound
+}
+
+// readFrame reads one payload into buf, growing it if needed
+func readFrame(r *bufio.Reader, buf []byte) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < headerSize || n > MaxFrame {
+		return nil, fmt.Errorf("frame of %d bytes", n)
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// encoder builds a frame. Its first 4 bytes are left for the length, which
+// frame fills in.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) start(kind byte, id uint32) {
+	e.buf = append(e.buf[:0], 0, 0, 0, 0, kind)
+	e.buf = binary.BigEndian.AppendUint32(e.buf, id)
+}
+
+func (e *encoder) uint32(n uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, n)
+}
+
+func (e *encoder) int32(n int32) {
+	e.uint32(uint32(n))
+}
+
+func (e *encoder) string(s string) {
+	if len(s) > math.MaxUint16 {
+		s = s[:math.MaxUint16]
+	}
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) user(u *models.UserWithRank) {
+	e.uint32(uint32(u.Rank))
+	e.int32(int32(u.Rating))
+	e.string(u.Username)
+	e.string(u.Tier)
+}
+
+// frame returns the finished frame
+func (e *encoder) frame() []byte {
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+	return e.buf
+}
+
+// errShort is a body that ends early
+var errShort = errors.New("body too short")
+
+// decoder reads a body. The first read past the end sets err, and every
+// read after it returns zero.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = errShort
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) string() string {
+	b := d.take(2)
+	if b == nil {
+		return ""
+	}
+	return string(d.take(int(binary.BigEndian.Uint16(b))))
+}
+
+func (d *decoder) user() models.UserWithRank {
+	return models.UserWithRank{Rank: int(d.uint32()), Rating: int(d.int32()), Username: d.string(), Tier: d.string()}
+}
+
+// done reports an error if the body was short or has bytes left over
+func (d *decoder) done() error {
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = errors.New("unexpected bytes after body")
+	}
+	return d.err
+}
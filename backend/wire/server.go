@@ -0,0 +1,206 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+// Server answers the protocol for one board
+type Server struct {
+	service *services.LeaderboardService
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer serves service
+func NewServer(service *services.LeaderboardService) *Server {
+	return &Server{
+		service:   service,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on addr, e.g. ":7001", and serves until Close
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and drops every connection
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+
+	r := bufio.NewReaderSize(conn, 64<<10)
+	w := bufio.NewWriterSize(conn, 64<<10)
+	var in []byte
+	var out encoder
+	for {
+		// A bad frame length leaves nothing to resynchronise on, so the
+		// connection is dropped
+		frame, err := readFrame(r, in)
+		if err != nil {
+			w.Flush()
+			return
+		}
+		in = frame
+
+		s.handle(&out, frame[0], binary.BigEndian.Uint32(frame[1:headerSize]), frame[headerSize:])
+		if _, err := w.Write(out.frame()); err != nil {
+			return
+		}
+		// Answer a pipeline in one write, once every request sent so far
+		// has been read
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle runs one request and encodes its response into out
+func (s *Server) handle(out *encoder, op byte, id uint32, body []byte) {
+	d := decoder{buf: body}
+	fail := func(status byte, msg string) {
+		out.start(status, id)
+		out.string(msg)
+	}
+
+	switch op {
+	case OpUpdate, OpIncrement, OpRank:
+		username := d.string()
+		var n int32
+		if op != OpRank {
+			n = d.int32()
+		}
+		if err := d.done(); err != nil {
+			fail(StatusBadRequest, err.Error())
+			return
+		}
+		if username == "" {
+			fail(StatusBadRequest, "username is required")
+			return
+		}
+
+		var err error
+		switch op {
+		case OpUpdate:
+			err = s.service.UpdateRating(username, int(n))
+		case OpIncrement:
+			_, err = s.service.IncrementRating(username, int(n))
+		}
+		if err != nil {
+			fail(statusOf(err), err.Error())
+			return
+		}
+		user, err := s.service.GetUserRank(username)
+		if err != nil {
+			fail(statusOf(err), err.Error())
+			return
+		}
+		out.start(StatusOK, id)
+		out.user(user)
+
+	case OpRange:
+		offset, limit := d.uint32(), d.uint32()
+		if err := d.done(); err != nil {
+			fail(StatusBadRequest, err.Error())
+			return
+		}
+		if offset > 1<<31-1 {
+			fail(StatusBadRequest, "offset out of range")
+			return
+		}
+		var users []models.UserWithRank
+		if limit > 0 {
+			users = s.service.GetUsersInRange(int(offset), int(min(limit, MaxRange)))
+		}
+		out.start(StatusOK, id)
+		out.uint32(uint32(len(users)))
+		for i := range users {
+			out.user(&users[i])
+		}
+
+	default:
+		fail(StatusBadRequest, "unknown op")
+	}
+}
+
+// statusOf maps a service error to a status
+func statusOf(err error) byte {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		return StatusNotFound
+	case errors.Is(err, services.ErrInvalidRating):
+		return StatusInvalid
+	}
+	return StatusError
+}
@@ -0,0 +1,303 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"leaderboard/handlers"
+	"leaderboard/models"
+	"leaderboard/services"
+)
+
+func startServer(tb testing.TB, service *services.LeaderboardService) string {
+	tb.Helper()
+	server := NewServer(service)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go server.Serve(l)
+	tb.Cleanup(func() { server.Close() })
+	return l.Addr().String()
+}
+
+func dial(tb testing.TB, addr string) *Client {
+	tb.Helper()
+	c, err := Dial(context.Background(), addr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testService(users int) *services.LeaderboardService {
+	service := services.NewLeaderboardService()
+	for i := 0; i < users; i++ {
+		name := fmt.Sprintf("user_%d", i)
+		service.AddUser(&models.User{ID: name, Username: name, Rating: 100 + i*37%4900})
+	}
+	return service
+}
+
+func TestOperations(t *testing.T) {
+	service := services.NewLeaderboardService()
+	service.AddUser(&models.User{ID: "1", Username: "alice", Rating: 1000})
+	service.AddUser(&models.User{ID: "2", Username: "bob", Rating: 2000})
+	service.AddUser(&models.User{ID: "3", Username: "carol", Rating: 1500})
+	c := dial(t, startServer(t, service))
+	ctx := context.Background()
+
+	user, err := c.Update(ctx, "alice", 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (models.UserWithRank{Rank: 1, Username: "alice", Rating: 3000, Tier: "Platinum"}); *user != want {
+		t.Errorf("Update: got %+v, want %+v", *user, want)
+	}
+
+	user, err = c.Increment(ctx, "carol", 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (models.UserWithRank{Rank: 2, Username: "carol", Rating: 2100, Tier: "Gold"}); *user != want {
+		t.Errorf("Increment: got %+v, want %+v", *user, want)
+	}
+
+	user, err = c.Rank(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (models.UserWithRank{Rank: 3, Username: "bob", Rating: 2000, Tier: "Gold"}); *user != want {
+		t.Errorf("Rank: got %+v, want %+v", *user, want)
+	}
+
+	users, err := c.Range(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := service.GetUsersInRange(1, 10); !reflect.DeepEqual(users, want) {
+		t.Errorf("Range: got %+v, want %+v", users, want)
+	}
+	if users, err := c.Range(ctx, 10, 10); err != nil || len(users) != 0 {
+		t.Errorf("Range past the end: got %+v, %v", users, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	service := services.NewLeaderboardService()
+	service.AddUser(&models.User{ID: "1", Username: "alice", Rating: 4900})
+	c := dial(t, startServer(t, service))
+	ctx := context.Background()
+
+	if _, err := c.Rank(ctx, "nobody"); !IsNotFound(err) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+	if _, err := c.Update(ctx, "nobody", 1000); !IsNotFound(err) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	var e *Error
+	if _, err := c.Update(ctx, "alice", 99); !asError(err, &e) || e.Status != StatusInvalid {
+		t.Errorf("Expected invalid rating, got %v", err)
+	}
+	if _, err := c.Increment(ctx, "alice", 200); !asError(err, &e) || e.Status != StatusInvalid {
+		t.Errorf("Expected invalid rating, got %v", err)
+	}
+	if _, err := c.Update(ctx, "", 1000); !asError(err, &e) || e.Status != StatusBadRequest {
+		t.Errorf("Expected bad request, got %v", err)
+	}
+
+	// The board is unchanged and the connection still works
+	if user, err := c.Rank(ctx, "alice"); err != nil || user.Rating != 4900 {
+		t.Errorf("Expected alice at 4900, got %+v, %v", user, err)
+	}
+}
+
+func asError(err error, target **Error) bool {
+	return errors.As(err, target)
+}
+
+// rawConn speaks the protocol by hand, to check the framing itself
+type rawConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRaw(t *testing.T, addr string) *rawConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rawConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *rawConn) read() (status byte, id uint32, body []byte) {
+	frame, err := readFrame(c.r, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return frame[0], binary.BigEndian.Uint32(frame[1:headerSize]), frame[headerSize:]
+}
+
+func TestPipelining(t *testing.T) {
+	service := testService(100)
+	addr := startServer(t, service)
+
+	// Many requests written at once are answered in order
+	raw := dialRaw(t, addr)
+	var e encoder
+	var batch []byte
+	for i := 0; i < 500; i++ {
+		e.start(OpIncrement, uint32(i))
+		e.string(fmt.Sprintf("user_%d", i%100))
+		e.int32(1)
+		batch = append(batch, e.frame()...)
+	}
+	if _, err := raw.conn.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if status, id, _ := raw.read(); status != StatusOK || id != uint32(i) {
+			t.Fatalf("Response %d: status %d id %d", i, status, id)
+		}
+	}
+
+	// Concurrent calls on one client share the connection
+	c := dial(t, addr)
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				name := fmt.Sprintf("user_%d", (g*50+i)%100)
+				if _, err := c.Increment(context.Background(), name, 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("user_%d", i)
+		user, _ := service.GetUserRank(name)
+		if want := 100 + i*37%4900 + 15; user.Rating != want {
+			t.Errorf("%s: got %d, want %d", name, user.Rating, want)
+		}
+	}
+}
+
+func TestMalformedRequests(t *testing.T) {
+	addr := startServer(t, testService(1))
+	raw := dialRaw(t, addr)
+
+	// A bad body or op is answered, and the connection carries on
+	var e encoder
+	e.start(OpRank, 1)
+	e.string("user_0")
+	e.uint32(7)
+	raw.conn.Write(e.frame())
+	if status, id, _ := raw.read(); status != StatusBadRequest || id != 1 {
+		t.Errorf("Expected bad request for trailing bytes, got status %d id %d", status, id)
+	}
+	e.start(99, 2)
+	raw.conn.Write(e.frame())
+	if status, _, body := raw.read(); status != StatusBadRequest || !bytes.Contains(body, []byte("unknown op")) {
+		t.Errorf("Expected unknown op, got status %d %q", status, body)
+	}
+
+	// A bad frame length closes it
+	raw.conn.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	if _, err := raw.r.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection closed, got %v", err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	c := dial(t, startServer(t, testService(1)))
+	c.Close()
+	if _, err := c.Rank(context.Background(), "user_0"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = dial(t, startServer(t, testService(1)))
+	if _, err := c.Rank(ctx, "user_0"); err != context.Canceled {
+		// The response may win the race with the cancelled context
+		if err != nil {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	}
+}
+
+// The benchmarks compare score updates over this protocol with the same
+// updates posted to /update-user-score, both from parallel clients
+
+const benchmarkUsers = 10000
+
+func BenchmarkUpdateTCP(b *testing.B) {
+	c := dial(b, startServer(b, testService(benchmarkUsers)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			name := fmt.Sprintf("user_%d", i%benchmarkUsers)
+			if _, err := c.Update(context.Background(), name, 100+i%4900); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkUpdateHTTP(b *testing.B) {
+	h := handlers.NewHandler(testService(benchmarkUsers))
+	server := httptest.NewServer(http.HandlerFunc(h.UpdateUserScore))
+	defer server.Close()
+	client := server.Client()
+	client.Transport.(*http.Transport).MaxIdleConnsPerHost = 100
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			body, _ := json.Marshal(map[string]interface{}{
+				"username": fmt.Sprintf("user_%d", i%benchmarkUsers),
+				"rating":   100 + i%4900,
+			})
+			resp, err := client.Post(server.URL, "application/json", bytes.NewReader(body))
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				b.Errorf("Got %d", resp.StatusCode)
+				return
+			}
+		}
+	})
+}